@@ -0,0 +1,54 @@
+//go:build js && wasm
+
+// Command wasm compiles markdown into Confluence storage format in the
+// browser, e.g. for previews. It registers compileMarkdown function, which
+// takes markdown and returns an object with html, diagnostics and error
+// fields:
+//
+//	GOOS=js GOARCH=wasm go build -o mark.wasm ./examples/wasm
+//
+// The module is loaded with wasm_exec.js shipped with Go.
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+)
+
+func main() {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	js.Global().Set("compileMarkdown", js.FuncOf(
+		func(this js.Value, args []js.Value) interface{} {
+			if len(args) == 0 {
+				return map[string]interface{}{"error": "markdown expected"}
+			}
+
+			result, err := mark.Compile([]byte(args[0].String()), lib, mark.CompileOptions{})
+
+			diagnostics := []interface{}{}
+			for _, diagnostic := range result.Diagnostics {
+				diagnostics = append(diagnostics, diagnostic.String())
+			}
+
+			response := map[string]interface{}{
+				"html":        result.HTML,
+				"diagnostics": diagnostics,
+			}
+
+			if err != nil {
+				response["error"] = err.Error()
+			}
+
+			return response
+		},
+	))
+
+	// functions are called by JavaScript as long as the program runs
+	select {}
+}
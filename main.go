@@ -6,15 +6,17 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/docopt/docopt-go"
 	"github.com/kovetskiy/lorg"
 	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/dialect"
 	"github.com/kovetskiy/mark/pkg/mark/includes"
 	"github.com/kovetskiy/mark/pkg/mark/macro"
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
 	"github.com/kovetskiy/mark/pkg/mark/stdlib"
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/pkg/log"
@@ -40,8 +42,32 @@ type Flags struct {
 	Ci               bool   `docopt:"--ci"`
 	Space            string `docopt:"--space"`
 	PreserveComments bool   `docopt:"--preserve-comments"`
+	Templates        string `docopt:"--templates"`
+	Validate         bool   `docopt:"--validate"`
+	Deterministic    bool   `docopt:"--deterministic"`
+	ChunkSize        int    `docopt:"--chunk-size"`
+	Strict           bool   `docopt:"--strict"`
+	Dialect          string `docopt:"--dialect"`
+	EditorV2         bool   `docopt:"--editor-v2"`
+	DraftBanner      bool   `docopt:"--draft-banner"`
+	GeneratedBanner  bool   `docopt:"--generated-banner"`
+	BannerText       string `docopt:"--banner-text"`
+	RepoURL          string `docopt:"--repo-url"`
+	Commit           string `docopt:"--commit"`
+	HeaderTemplate   string `docopt:"--header-template"`
+	FooterTemplate   string `docopt:"--footer-template"`
+	Permalinks       bool   `docopt:"--permalinks"`
+	PermalinkText    string `docopt:"--permalink-text"`
+	InlineTOC        string `docopt:"--inline-toc"`
+	WikiLinks        bool   `docopt:"--wiki-links"`
+	PageURLs         bool   `docopt:"--page-urls"`
+	Labels           string `docopt:"--labels"`
 }
 
+// envVarPrefix is the prefix of environment variables, which define
+// variables substituted in markdown.
+const envVarPrefix = "MARK_VAR_"
+
 const (
 	version = "8.0"
 	usage   = `mark - a tool for updating Atlassian Confluence pages from markdown.
@@ -73,8 +99,12 @@ Options:
   --space <space>      Use specified space key. If not specified space ley must
                         be set in a page metadata.
   --drop-h1            Don't include H1 headings in Confluence output.
+                        Page title is extracted from the leading H1 heading
+                        unless set in a page metadata.
   --title-from-h1      Extract page title from a leading H1 heading. If no H1 heading
                         on a page then title must be set in a page metadata.
+                        Can be overridden for a page via 'Title-From-H1' header
+                        (keep, drop or keep-and-title).
   --dry-run            Resolve page and ancestry, show resulting HTML and exit.
   --compile-only       Show resulting HTML and don't update Confluence page content.
   --minor-edit         Don't send notifications while updating Confluence page.
@@ -86,6 +116,54 @@ Options:
                         [default: $HOME/.config/mark]
   --ci                 Runs on CI mode. It won't fail if files are not found.
   --preserve-comments  Try to preserve the comment from the Confluence page.
+  --validate           Check that resulting HTML is valid Confluence storage
+                        format before updating Confluence page.
+  --strict             Fail on unresolved links to markdown files, missing
+                        images, unknown admonitions and metadata headers
+                        instead of warning about them. Links between
+                        matched files are checked before publishing.
+  --templates <dir>    Override built-in templates with *.tmpl files from the
+                        specified directory, e.g. ac:code.tmpl.
+  --deterministic      Fail if templates use 'now' or 'uuid' functions, so
+                        resulting HTML is the same for the same input.
+  --dialect <dialect>  Write storage format for the edition of Confluence:
+                        auto, cloud or server. Emoticons, user mentions and
+                        layouts differ between them. [default: auto]
+  --editor-v2          Avoid markup which the new Confluence Cloud editor
+                        rewrites when pages are edited: titles of admonitions
+                        and table heads containing macros.
+  --labels <labels>    Comma-separated labels added to all pages, except for
+                        ones of 'Labels-Remove' headers of the page.
+  --draft-banner       Add warning panel to pages with 'Status: draft' header,
+                        so accidentally published drafts are obvious.
+  --generated-banner   Add info panel to pages telling that they're generated
+                        from markdown files, which must be edited instead.
+                        Can be suppressed via 'Generated-Banner: false' header.
+  --banner-text <text>  Go template of the text of the generated banner, which
+                        gets .Source, .RepoURL and .Commit fields.
+  --repo-url <url>     URL of the repository shown in the generated banner.
+  --commit <sha>       Commit shown in the generated banner.
+  --header-template <file>  Add markdown of the Go template to the top of
+                        pages, after the leading H1 heading. Can be
+                        suppressed via 'Boilerplate: false' header.
+  --footer-template <file>  Add markdown of the Go template to the bottom of
+                        pages. Can be suppressed via 'Boilerplate: false'
+                        header.
+  --permalinks         Add links to anchors of H2 and H3 headings after their
+                        text, except headings inside expand macros.
+  --permalink-text <text>  Text of links added by --permalinks. [default: ¶]
+  --wiki-links         Compile [[Page Title]], [[Page Title|text]] and
+                        [[SPACE:Page Title]] into links to pages. Pages are
+                        looked up in --strict mode, missing ones fail.
+  --page-urls          Compile links to pages of Confluence at --base-url,
+                        like /display/SPACE/Page+Title, into links to pages.
+  --inline-toc <mode>  Handle tables of contents written for readers of the
+                        repository, either between doctoc markers or under
+                        'Table of Contents' heading: keep, strip or macro,
+                        which replaces them with the toc macro.
+                        [default: keep]
+  --chunk-size <bytes>  Compile documents in chunks of at least the specified
+                        size to reduce memory usage for very large documents.
   -h --help            Show this message.
   -v --version         Show version.
 `
@@ -103,6 +181,10 @@ func main() {
 		log.Fatal(err)
 	}
 
+	mark.DefaultLogger = mark.GlobalLogger
+	includes.Logger = mark.GlobalLogger
+	macro.Logger = mark.GlobalLogger
+
 	if flags.Debug {
 		log.SetLevel(lorg.LevelDebug)
 	}
@@ -136,6 +218,8 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	files = skipDefaultsFiles(files)
 	if len(files) == 0 {
 		msg := "No files matched"
 		if flags.Ci {
@@ -145,6 +229,10 @@ func main() {
 		}
 	}
 
+	if flags.Strict {
+		checkLinks(files)
+	}
+
 	// Loop through files matched by glob pattern
 	for _, file := range files {
 		log.Infof(
@@ -165,6 +253,70 @@ func main() {
 	}
 }
 
+// skipDefaultsFiles drops files of default metadata, which are not pages.
+func skipDefaultsFiles(files []string) []string {
+	pages := []string{}
+
+	for _, file := range files {
+		if filepath.Base(file) != mark.DefaultsFile {
+			pages = append(pages, file)
+		}
+	}
+
+	return pages
+}
+
+// checkLinks fails if links between matched files are broken.
+func checkLinks(files []string) {
+	docs := map[string][]byte{}
+
+	for _, file := range files {
+		markdown, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatalf(err, "unable to read markdown file")
+		}
+
+		docs[file] = markdown
+	}
+
+	issues := mark.CheckLinks(docs)
+	if len(issues) == 0 {
+		return
+	}
+
+	for _, issue := range issues {
+		log.Error(issue.String())
+	}
+
+	log.Fatalf(nil, "strict mode: %d broken links found", len(issues))
+}
+
+// checkLinkIssues reports problems of resolved links of the file, which
+// fail in strict mode.
+func checkLinkIssues(file string, links []mark.LinkSubstitution, strict bool) {
+	issues := 0
+
+	for _, link := range links {
+		if link.Issue == "" {
+			continue
+		}
+
+		message := mark.Diagnostic{File: file, Message: link.Issue}.String()
+
+		if strict {
+			log.Error(message)
+		} else {
+			log.Warning(message)
+		}
+
+		issues++
+	}
+
+	if strict && issues > 0 {
+		log.Fatalf(nil, "strict mode: %d broken links found", issues)
+	}
+}
+
 func processFile(
 	file string,
 	api *confluence.API,
@@ -179,32 +331,165 @@ func processFile(
 
 	markdown = bytes.ReplaceAll(markdown, []byte("\r\n"), []byte("\n"))
 
-	meta, markdown, err := mark.ExtractMeta(markdown)
+	sources := sourcemap.New(markdown)
+
+	meta, body, err := mark.ExtractMeta(markdown)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defaults, err := mark.ReadDefaultMeta(filepath.Dir(file), ".")
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	meta = mark.MergeMeta(defaults, meta)
+
+	sources.Replace(markdown, 0, len(markdown)-len(body), nil)
+
+	markdown = body
+
 	switch {
 	case meta.Space == "" && flags.Space == "":
 		log.Fatal(
 			"space is not set ('Space' header is not set and '--space' option is not set)",
 		)
 	case meta.Space == "" && flags.Space != "":
+		err := mark.ValidateSpaceKey(flags.Space)
+		if err != nil {
+			log.Fatalf(err, "invalid '--space' option")
+		}
+
 		meta.Space = flags.Space
 	}
 
-	if meta.Title == "" && flags.TitleFromH1 {
-		meta.Title = mark.ExtractDocumentLeadingH1(markdown)
+	compileOpts := mark.CompileOptions{
+		TitleFromH1: mark.TitleFromH1Keep,
+		Meta:        meta,
+		Debug:       flags.Trace,
+		Validate:    flags.Validate,
+		File:        file,
+		Sources:     sources,
+		FileExists:  mark.FileExistsOnDisk,
+		ChunkSize:   flags.ChunkSize,
+		Strict:      flags.Strict,
+		EditorV2:    flags.EditorV2,
+		DraftBanner: flags.DraftBanner,
+	}
+
+	if flags.GeneratedBanner {
+		compileOpts.GeneratedBanner = &mark.GeneratedBanner{
+			Text:    flags.BannerText,
+			RepoURL: flags.RepoURL,
+			Commit:  flags.Commit,
+		}
+	}
+
+	if flags.Permalinks {
+		compileOpts.Permalinks = &mark.Permalinks{
+			Text:        flags.PermalinkText,
+			SkipExpands: true,
+		}
+	}
+
+	if flags.Labels != "" {
+		compileOpts.Labels = strings.Split(flags.Labels, ",")
+	}
+
+	compileOpts.WikiLinks = flags.WikiLinks
+
+	if flags.PageURLs {
+		compileOpts.ConfluenceURL = api.BaseURL
+	}
+
+	// pages are looked up only if missing ones fail, since every link is
+	// looked up separately
+	if (flags.WikiLinks || flags.PageURLs) && flags.Strict {
+		compileOpts.PageExists = func(space string, title string) bool {
+			page, err := api.FindPage(space, title, "page")
+			if err != nil {
+				log.Fatalf(err, "unable to find page %q in space %q", title, space)
+			}
+
+			return page != nil
+		}
+	}
+
+	compileOpts.InlineTOC, err = mark.ParseInlineTOC(flags.InlineTOC)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	compileOpts.HeaderTemplate = readBoilerplate(flags.HeaderTemplate)
+	compileOpts.FooterTemplate = readBoilerplate(flags.FooterTemplate)
+
+	compileOpts.Vars = getEnvVars(os.Environ())
+
+	// the flag can't be told apart from its default, so it only enables
+	// minor edits
+	if flags.MinorEdit {
+		compileOpts.MinorEdit = &flags.MinorEdit
 	}
 
-	if meta.Title == "" {
+	// placeholders of the version message are filled with variables
+	compileOpts.VersionData = map[string]string{}
+	for _, vars := range []map[string]string{compileOpts.Vars, meta.Vars} {
+		for name, value := range vars {
+			compileOpts.VersionData[name] = value
+		}
+	}
+
+	switch {
+	case flags.DropH1:
+		compileOpts.TitleFromH1 = mark.TitleFromH1Drop
+	case flags.TitleFromH1:
+		compileOpts.TitleFromH1 = mark.TitleFromH1KeepAndTitle
+	}
+
+	if meta.TitleFromH1 != "" {
+		compileOpts.TitleFromH1 = meta.TitleFromH1
+	}
+
+	if compileOpts.TitleFromH1 == mark.TitleFromH1Drop {
+		log.Info(
+			"the leading H1 heading will be excluded from the Confluence output",
+		)
+	}
+
+	// the CLI doesn't fall back to file names, the title is taken from H1
+	// only if it's requested
+	if meta.Title == "" &&
+		(compileOpts.TitleFromH1 == mark.TitleFromH1Keep ||
+			mark.ExtractDocumentLeadingH1(markdown) == "") {
 		log.Fatal(
 			`page title is not set ('Title' header is not set ` +
 				`and '--title-from-h1' option is not set or there is no H1 in the file)`,
 		)
 	}
 
-	stdlib, err := stdlib.New(api)
+	meta.Emoji, meta.EmojiID = mark.ResolveEmoji(markdown, compileOpts)
+	meta.Title = mark.ResolveTitle(markdown, compileOpts)
+
+	stdlibOpts := []stdlib.Option{stdlib.WithLogger(mark.GlobalLogger)}
+	if flags.Templates != "" {
+		stdlibOpts = append(
+			stdlibOpts,
+			stdlib.WithOverrides(os.DirFS(flags.Templates)),
+		)
+	}
+
+	if flags.Deterministic {
+		stdlibOpts = append(stdlibOpts, stdlib.WithDeterministicOutput())
+	}
+
+	dialect, err := dialect.Parse(flags.Dialect)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stdlibOpts = append(stdlibOpts, stdlib.WithDialect(dialect))
+
+	stdlib, err := stdlib.New(api, stdlibOpts...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -218,6 +503,7 @@ func processFile(
 			filepath.Dir(file),
 			markdown,
 			templates,
+			sources,
 		)
 		if err != nil {
 			log.Fatal(err)
@@ -232,6 +518,7 @@ func processFile(
 		filepath.Dir(file),
 		markdown,
 		templates,
+		sources,
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -240,7 +527,7 @@ func processFile(
 	macros = append(macros, stdlib.Macros...)
 
 	for _, macro := range macros {
-		markdown, err = macro.Apply(markdown)
+		markdown, err = macro.Apply(markdown, sources)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -251,6 +538,8 @@ func processFile(
 		log.Fatalf(err, "unable to resolve relative links")
 	}
 
+	checkLinkIssues(file, links, flags.Strict)
+
 	markdown = mark.SubstituteLinks(markdown, links)
 
 	if flags.DryRun {
@@ -262,7 +551,12 @@ func processFile(
 		}
 	}
 
-	fmt.Println(mark.CompileMarkdown(markdown, stdlib))
+	compiled, err := compileMarkdown(markdown, stdlib, compileOpts)
+	if err != nil {
+		log.Fatalf(err, "unable to compile markdown")
+	}
+
+	fmt.Println(compiled.HTML)
 
 	if pageID != "" && meta != nil {
 		log.Warning(
@@ -332,16 +626,26 @@ func processFile(
 			log.Fatalf(err, "unable to pull page")
 		}
 		html := res.Body.View.Value
-		inlineCommentMarker := regexp.MustCompile("<span class=\"inline-comment-marker\" data-ref=\"(?P<commentId>.*)\">(?P<body>.*)</span>")
-		inlineComments := inlineCommentMarker.FindAllStringSubmatch(html, -1)
-		for _, inlineComment := range inlineComments {
-			commentId := inlineComment[1]
-			body := inlineComment[2]
-			html = strings.ReplaceAll(html, inlineComment[0], fmt.Sprintf(`<!--comment_id='%s'-->%s<!---->`, commentId, body))
-		}
 		fmt.Println(html)
 
-		mark.HtmlToMarkdown(html, target.Title+".md")
+		pageMeta := mark.PageMeta{Title: target.Title}
+		if meta != nil {
+			pageMeta.Space = meta.Space
+			pageMeta.Type = meta.Type
+			pageMeta.Parents = meta.Parents
+			pageMeta.Layout = meta.Layout
+			pageMeta.Attachments = meta.Attachments
+			pageMeta.Labels = meta.Labels
+		}
+
+		err = mark.HtmlToMarkdownFile(
+			html,
+			target.Title+".md",
+			mark.ConvertMeta(pageMeta),
+		)
+		if err != nil {
+			log.Fatalf(err, "unable to convert page to markdown")
+		}
 	}
 	attaches, err := mark.ResolveAttachments(
 		api,
@@ -355,14 +659,12 @@ func processFile(
 
 	markdown = mark.CompileAttachmentLinks(markdown, attaches)
 
-	if flags.DropH1 {
-		log.Info(
-			"the leading H1 heading will be excluded from the Confluence output",
-		)
-		markdown = mark.DropDocumentLeadingH1(markdown)
+	compiled, err = compileMarkdown(markdown, stdlib, compileOpts)
+	if err != nil {
+		log.Fatalf(err, "unable to compile markdown")
 	}
 
-	html := mark.CompileMarkdown(markdown, stdlib)
+	html := compiled.HTML
 
 	{
 		var buffer bytes.Buffer
@@ -409,11 +711,33 @@ func processFile(
 		}
 	}
 
-	err = api.UpdatePage(target, html, flags.MinorEdit, meta.Labels)
+	err = api.UpdatePage(
+		target,
+		html,
+		compiled.MinorEdit,
+		compiled.VersionMessage,
+		compiled.Labels,
+	)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	properties := meta.GetProperties()
+
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		err := api.SetContentProperty(target.ID, key, properties[key])
+		if err != nil {
+			log.Fatalf(err, "unable to set %s of %q", key, target.Title)
+		}
+	}
+
 	if flags.EditLock {
 		log.Infof(
 			nil,
@@ -430,3 +754,62 @@ func processFile(
 
 	return target
 }
+
+// getEnvVars returns variables defined by environment variables with
+// MARK_VAR_ prefix, e.g. MARK_VAR_cluster=staging defines ${cluster}. It's
+// nil if there are no such variables.
+func getEnvVars(environ []string) map[string]string {
+	var vars map[string]string
+
+	for _, env := range environ {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(name, envVarPrefix) {
+			continue
+		}
+
+		if vars == nil {
+			vars = map[string]string{}
+		}
+
+		vars[strings.TrimPrefix(name, envVarPrefix)] = value
+	}
+
+	return vars
+}
+
+// compileMarkdown compiles markdown and reports storage format issues by
+// lines of the markdown file, if the output is validated.
+func compileMarkdown(
+	markdown []byte,
+	lib *stdlib.Lib,
+	opts mark.CompileOptions,
+) (*mark.CompileResult, error) {
+	result, err := mark.Compile(markdown, lib, opts)
+
+	for _, diagnostic := range result.Diagnostics {
+		if diagnostic.Severity == mark.SeverityError {
+			log.Error(diagnostic.String())
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// readBoilerplate reads the template of the header or the footer of pages,
+// empty string is returned if the path is not given.
+func readBoilerplate(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	boilerplate, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf(err, "unable to read boilerplate template")
+	}
+
+	return string(boilerplate)
+}
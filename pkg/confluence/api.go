@@ -18,8 +18,13 @@ import (
 	"github.com/reconquest/pkg/log"
 )
 
+// User is the user of Confluence. Confluence Cloud identifies users by
+// account ids, while Confluence Server identifies them by user keys and
+// usernames, so only some of the fields are returned.
 type User struct {
 	AccountID string `json:"accountId"`
+	UserKey   string `json:"userKey"`
+	Username  string `json:"username"`
 }
 
 type API struct {
@@ -111,7 +116,7 @@ func (tracer *tracer) Printf(format string, args ...interface{}) {
 func NewAPI(baseURL string, username string, password string) *API {
 	var auth *gopencils.BasicAuth
 	if username != "" {
-		auth = &gopencils.BasicAuth{username, password}
+		auth = &gopencils.BasicAuth{Username: username, Password: password}
 	}
 	rest := gopencils.Api(baseURL+"/rest/api", auth)
 	if username == "" {
@@ -568,8 +573,14 @@ func (api *API) PullPage(pageID string) (*PageViewInfo, error) {
 	return &result, nil
 }
 
+// UpdatePage uploads the new version of the page, versionMessage is the
+// comment of the version, if it's not empty.
 func (api *API) UpdatePage(
-	page *PageInfo, newContent string, minorEdit bool, newLabels []string,
+	page *PageInfo,
+	newContent string,
+	minorEdit bool,
+	versionMessage string,
+	newLabels []string,
 ) error {
 	nextPageVersion := page.Version.Number + 1
 	oldAncestors := []map[string]interface{}{}
@@ -592,14 +603,20 @@ func (api *API) UpdatePage(
 		}
 	}
 
+	version := map[string]interface{}{
+		"number":    nextPageVersion,
+		"minorEdit": minorEdit,
+	}
+
+	if versionMessage != "" {
+		version["message"] = versionMessage
+	}
+
 	payload := map[string]interface{}{
-		"id":    page.ID,
-		"type":  page.Type,
-		"title": page.Title,
-		"version": map[string]interface{}{
-			"number":    nextPageVersion,
-			"minorEdit": minorEdit,
-		},
+		"id":        page.ID,
+		"type":      page.Type,
+		"title":     page.Title,
+		"version":   version,
 		"ancestors": oldAncestors,
 		"body": map[string]interface{}{
 			"storage": map[string]interface{}{
@@ -626,6 +643,66 @@ func (api *API) UpdatePage(
 	return nil
 }
 
+// SetContentProperty sets the property of the content, the property is
+// created if it doesn't exist and is not updated if it has the same value.
+func (api *API) SetContentProperty(
+	contentID string,
+	key string,
+	value interface{},
+) error {
+	var property struct {
+		Value   interface{} `json:"value"`
+		Version struct {
+			Number int64 `json:"number"`
+		} `json:"version"`
+	}
+
+	request, err := api.rest.Res(
+		"content/"+contentID+"/property/"+key, &property,
+	).Get()
+	if err != nil {
+		return err
+	}
+
+	switch request.Raw.StatusCode {
+	case 404:
+		request, err = api.rest.Res(
+			"content/"+contentID+"/property", &map[string]interface{}{},
+		).Post(map[string]interface{}{
+			"key":   key,
+			"value": value,
+		})
+
+	case 200:
+		if fmt.Sprint(property.Value) == fmt.Sprint(value) {
+			return nil
+		}
+
+		request, err = api.rest.Res(
+			"content/"+contentID+"/property/"+key, &map[string]interface{}{},
+		).Put(map[string]interface{}{
+			"key":   key,
+			"value": value,
+			"version": map[string]interface{}{
+				"number": property.Version.Number + 1,
+			},
+		})
+
+	default:
+		return newErrorStatusNotOK(request)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
+
 func (api *API) GetUserByName(name string) (*User, error) {
 	var response struct {
 		Results []struct {
@@ -0,0 +1,276 @@
+package mark
+
+import (
+	"regexp"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+	bf "github.com/kovetskiy/blackfriday/v2"
+)
+
+// Admonitions are written as GitHub alerts, which are blockquotes starting
+// with the alert type and optional title:
+//
+//	> [!WARNING] Optional title
+//	> Body of the admonition.
+//
+// and are rendered using info, tip, note and warning macros.
+var reAdmonition = regexp.MustCompile(`^\[!([A-Za-z]+)\][ \t]*`)
+
+var reBlankLines = regexp.MustCompile(`\n{3,}`)
+
+// admonitionMacros maps alert types to Confluence macros. Confluence macros
+// are named by their purpose rather than color, so "note" macro is the
+// yellow one, which is what GitHub calls "warning".
+var admonitionMacros = map[string]string{
+	"NOTE":      "info",
+	"INFO":      "info",
+	"IMPORTANT": "info",
+	"TIP":       "tip",
+	"WARNING":   "note",
+	"CAUTION":   "warning",
+}
+
+// admonitionAlerts maps Confluence macros back to alert types.
+var admonitionAlerts = map[string]string{
+	"info":    "NOTE",
+	"tip":     "TIP",
+	"note":    "WARNING",
+	"warning": "CAUTION",
+}
+
+// AdmonitionStyle defines how admonition macros are written in markdown when
+// converting Confluence pages.
+type AdmonitionStyle string
+
+const (
+	// AdmonitionAlert writes admonitions as GitHub alerts, which is the form
+	// CompileMarkdown understands. This is the default.
+	AdmonitionAlert AdmonitionStyle = "alert"
+
+	// AdmonitionContainer writes admonitions as containers used by many
+	// static site generators:
+	//
+	//	:::info Optional title
+	//	Body of the admonition.
+	//	:::
+	AdmonitionContainer AdmonitionStyle = "container"
+)
+
+type admonition struct {
+	Macro string
+	Title string
+}
+
+// markAdmonitions finds blockquotes written as GitHub alerts and strips the
+// alert line from them, so only the admonition body is left. Blockquotes
+// starting with unknown alert types are kept as is and reported using warn.
+func markAdmonitions(
+	document *bf.Node,
+	warn func(node *bf.Node, format string, args ...interface{}),
+) map[*bf.Node]admonition {
+	admonitions := map[*bf.Node]admonition{}
+
+	quotes := []*bf.Node{}
+
+	document.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if entering && node.Type == bf.BlockQuote {
+			quotes = append(quotes, node)
+		}
+
+		return bf.GoToNext
+	})
+
+	// tree can't be modified while walking through it
+	for _, quote := range quotes {
+		for _, quote := range splitAdmonitions(quote) {
+			if admonition, ok := parseAdmonition(quote); ok {
+				admonitions[quote] = admonition
+
+				continue
+			}
+
+			if alert, ok := getUnknownAdmonition(quote); ok {
+				warn(quote.FirstChild.FirstChild, "unknown admonition type %q", alert)
+			}
+		}
+	}
+
+	return admonitions
+}
+
+// splitAdmonitions splits blockquote before every paragraph starting with
+// the alert line, since markdown parser joins blockquotes separated only by
+// blank lines into one.
+func splitAdmonitions(quote *bf.Node) []*bf.Node {
+	quotes := []*bf.Node{quote}
+
+	for node := quote.FirstChild; node != nil; {
+		next := node.Next
+
+		if node != quote.FirstChild && isAdmonitionParagraph(node) {
+			split := bf.NewNode(bf.BlockQuote)
+			if quote.Next != nil {
+				quote.Next.InsertBefore(split)
+			} else {
+				quote.Parent.AppendChild(split)
+			}
+
+			for node != nil {
+				next := node.Next
+
+				node.Unlink()
+				split.AppendChild(node)
+
+				node = next
+			}
+
+			return append(quotes, splitAdmonitions(split)...)
+		}
+
+		node = next
+	}
+
+	return quotes
+}
+
+// getUnknownAdmonition returns alert type of the blockquote starting with
+// the alert line of unknown type.
+func getUnknownAdmonition(quote *bf.Node) (string, bool) {
+	paragraph := quote.FirstChild
+	if paragraph == nil || paragraph.Type != bf.Paragraph ||
+		paragraph.FirstChild == nil || paragraph.FirstChild.Type != bf.Text {
+		return "", false
+	}
+
+	groups := reAdmonition.FindSubmatch(paragraph.FirstChild.Literal)
+	if groups == nil {
+		return "", false
+	}
+
+	return string(groups[1]), true
+}
+
+func isAdmonitionParagraph(node *bf.Node) bool {
+	if node.Type != bf.Paragraph || node.FirstChild == nil ||
+		node.FirstChild.Type != bf.Text {
+		return false
+	}
+
+	groups := reAdmonition.FindSubmatch(node.FirstChild.Literal)
+	if groups == nil {
+		return false
+	}
+
+	_, ok := admonitionMacros[strings.ToUpper(string(groups[1]))]
+
+	return ok
+}
+
+func parseAdmonition(quote *bf.Node) (admonition, bool) {
+	paragraph := quote.FirstChild
+	if paragraph == nil || !isAdmonitionParagraph(paragraph) {
+		return admonition{}, false
+	}
+
+	first := paragraph.FirstChild
+
+	groups := reAdmonition.FindSubmatchIndex(first.Literal)
+
+	macro := admonitionMacros[strings.ToUpper(string(first.Literal[groups[2]:groups[3]]))]
+
+	first.Literal = first.Literal[groups[1]:]
+
+	// everything up to the end of the first line is the title
+	title := bf.NewNode(bf.Paragraph)
+
+	for node := paragraph.FirstChild; node != nil; {
+		next := node.Next
+
+		if node.Type == bf.Softbreak || node.Type == bf.Hardbreak {
+			node.Unlink()
+			break
+		}
+
+		if node.Type == bf.Text {
+			if index := strings.IndexByte(string(node.Literal), '\n'); index >= 0 {
+				text := bf.NewNode(bf.Text)
+				text.Literal = node.Literal[:index]
+				title.AppendChild(text)
+
+				node.Literal = node.Literal[index+1:]
+
+				break
+			}
+		}
+
+		node.Unlink()
+		title.AppendChild(node)
+
+		node = next
+	}
+
+	if isEmptyParagraph(paragraph) {
+		paragraph.Unlink()
+	}
+
+	return admonition{
+		Macro: macro,
+		Title: getPlainText(title),
+	}, true
+}
+
+func isEmptyParagraph(paragraph *bf.Node) bool {
+	for node := paragraph.FirstChild; node != nil; node = node.Next {
+		if node.Type != bf.Text || strings.TrimSpace(string(node.Literal)) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// convertAdmonitionMacro converts info, tip, note and warning macros into
+// admonitions of the configured style.
+func (conversion *conversion) convertAdmonitionMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	name := getMacroName(selec)
+
+	alert, ok := admonitionAlerts[name]
+	if !ok {
+		return nil
+	}
+
+	title := conversion.getMacroParameter(selec, "title")
+
+	body := reBlankLines.ReplaceAllString(strings.TrimSpace(content), "\n\n")
+
+	if conversion.opts.admonitions == AdmonitionContainer {
+		header := ":::" + name
+		if title != "" {
+			header += " " + title
+		}
+
+		return md.String("\n\n" + header + "\n" + body + "\n:::\n\n")
+	}
+
+	header := "[!" + alert + "]"
+	if title != "" {
+		header += " " + title
+	}
+
+	lines := strings.Split(header+"\n"+body, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+
+	return md.String("\n\n" + strings.Join(lines, "\n") + "\n\n")
+}
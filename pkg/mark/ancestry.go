@@ -6,7 +6,6 @@ import (
 
 	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/pkg/log"
 )
 
 func EnsureAncestry(
@@ -33,7 +32,7 @@ func EnsureAncestry(
 			break
 		}
 
-		log.Debugf(nil, "parent page %q exists: %s", title, page.Links.Full)
+		DefaultLogger.Debugf("parent page %q exists: %s", title, page.Links.Full)
 
 		rest = ancestry[i:]
 		parent = page
@@ -57,8 +56,7 @@ func EnsureAncestry(
 		return parent, nil
 	}
 
-	log.Debugf(
-		nil,
+	DefaultLogger.Debugf(
 		"empty pages under %q to be created: %s",
 		parent.Title,
 		strings.Join(rest, ` > `),
@@ -78,8 +76,7 @@ func EnsureAncestry(
 			parent = page
 		}
 	} else {
-		log.Infof(
-			nil,
+		DefaultLogger.Infof(
 			"skipping page creation due to enabled dry-run mode, "+
 				"need to create %d pages: %v",
 			len(rest),
@@ -116,7 +113,7 @@ func ValidateAncestry(
 		}
 
 		if page.ID == homepage.ID {
-			log.Debugf(nil, "page is homepage for space %q", space)
+			DefaultLogger.Debugf("page is homepage for space %q", space)
 			isHomepage = true
 		} else {
 			return nil, fmt.Errorf(`page %q has no parents`, page.Title)
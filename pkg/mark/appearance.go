@@ -0,0 +1,56 @@
+package mark
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Appearance is the content width of the page in Confluence Cloud, which is
+// stored in content properties of the page rather than in its body.
+type Appearance string
+
+const (
+	// AppearanceFullWidth stretches content to the width of the window.
+	AppearanceFullWidth Appearance = "full-width"
+
+	// AppearanceFixedWidth keeps content narrow, which is the default for
+	// new pages.
+	AppearanceFixedWidth Appearance = "fixed-width"
+)
+
+// Content properties which Confluence Cloud reads the appearance of the
+// draft and the published version of the page from.
+const (
+	PropertyAppearanceDraft     = "content-appearance-draft"
+	PropertyAppearancePublished = "content-appearance-published"
+)
+
+// ParseAppearance parses the value of Appearance header.
+func ParseAppearance(value string) (Appearance, error) {
+	switch appearance := Appearance(strings.ToLower(strings.TrimSpace(value))); appearance {
+	case AppearanceFullWidth, AppearanceFixedWidth:
+		return appearance, nil
+	}
+
+	return "", fmt.Errorf(
+		"unexpected appearance %q, expected one of: %s, %s",
+		value,
+		AppearanceFullWidth,
+		AppearanceFixedWidth,
+	)
+}
+
+// GetProperties returns content properties, which set the appearance of
+// both the draft and the published version of the page, so the editor
+// doesn't switch the width back. Nothing is returned if the appearance is
+// not set, so the width of the page is kept as is.
+func (appearance Appearance) GetProperties() map[string]string {
+	if appearance == "" {
+		return nil
+	}
+
+	return map[string]string{
+		PropertyAppearanceDraft:     string(appearance),
+		PropertyAppearancePublished: string(appearance),
+	}
+}
@@ -11,26 +11,38 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 
 	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/pkg/log"
 )
 
 const (
+	// AttachmentChecksumPrefix goes before Attachment.Checksum in comments
+	// of attachments uploaded to Confluence.
 	AttachmentChecksumPrefix = `mark:checksum: `
 )
 
+// <ri:attachment ri:filename="diagram.png"/>
+var reAttachmentReference = regexp.MustCompile(
+	`<ri:attachment\b[^>]*?\bri:filename=(?:"([^"]*)"|'([^']*)')`,
+)
+
 type Attachment struct {
 	ID       string
 	Name     string
 	Filename string
 	Path     string
+
+	// Checksum is SHA-256 of the file content encoded as lowercase hex, it's
+	// stable, so it can be stored along with the attachment and compared
+	// on the next run, see FilterChangedAttachments.
 	Checksum string
-	Link     string
-	Replace  string
+
+	Link    string
+	Replace string
 }
 
 func ResolveAttachments(
@@ -44,7 +56,7 @@ func ResolveAttachments(
 		return nil, err
 	}
 
-	for _, attach := range attaches {
+	for i, attach := range attaches {
 		checksum, err := getChecksum(attach.Path)
 		if err != nil {
 			return nil, karma.Format(
@@ -53,12 +65,12 @@ func ResolveAttachments(
 			)
 		}
 
-		attach.Checksum = checksum
+		attaches[i].Checksum = checksum
 	}
 
 	remotes, err := api.GetAttachments(page.ID)
 	if err != nil {
-		panic(err)
+		return nil, karma.Format(err, "unable to get attachments of the page")
 	}
 
 	existing := []Attachment{}
@@ -98,7 +110,7 @@ func ResolveAttachments(
 	}
 
 	for i, attach := range creating {
-		log.Infof(nil, "creating attachment: %q", attach.Name)
+		DefaultLogger.Infof("creating attachment: %q", attach.Name)
 
 		info, err := api.CreateAttachment(
 			page.ID,
@@ -124,7 +136,7 @@ func ResolveAttachments(
 	}
 
 	for i, attach := range updating {
-		log.Infof(nil, "updating attachment: %q", attach.Name)
+		DefaultLogger.Infof("updating attachment: %q", attach.Name)
 
 		info, err := api.UpdateAttachment(
 			page.ID,
@@ -157,6 +169,87 @@ func ResolveAttachments(
 	return attaches, nil
 }
 
+// FilterChangedAttachments returns attachments of the manifest which must be
+// uploaded, dropping ones which checksums match checksums of existing
+// attachments with the same file names. Existing checksums can be given with
+// AttachmentChecksumPrefix, as they're stored in comments of attachments.
+// Attachments without checksum are always kept.
+func FilterChangedAttachments(
+	manifest []Attachment,
+	existing map[string]string,
+) []Attachment {
+	changed := []Attachment{}
+
+	for _, attach := range manifest {
+		checksum, ok := existing[attach.Filename]
+		if ok && attach.Checksum != "" &&
+			attach.Checksum == strings.TrimPrefix(checksum, AttachmentChecksumPrefix) {
+			continue
+		}
+
+		changed = append(changed, attach)
+	}
+
+	return changed
+}
+
+// FindOrphanedAttachments returns names of existing attachments of the
+// page, which are not referenced anymore, e.g. left after diagrams are
+// renamed. Attachments are referenced if they're in the manifest or if
+// the compiled storage format refers to them by ri:attachment elements,
+// e.g. written in passthrough blocks. Attachments matching any of protect
+// patterns, e.g. *.xlsx for files uploaded manually, are never orphans.
+// Patterns are matched against whole names using path.Match syntax. Names
+// are returned in order of existing attachments.
+func FindOrphanedAttachments(
+	manifest []Attachment,
+	existing []string,
+	protect []string,
+	storage string,
+) ([]string, error) {
+	for _, pattern := range protect {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, karma.Format(err, "invalid protect pattern: %q", pattern)
+		}
+	}
+
+	referenced := map[string]bool{}
+
+	for _, attach := range manifest {
+		referenced[attach.Filename] = true
+	}
+
+	for _, match := range reAttachmentReference.FindAllStringSubmatch(storage, -1) {
+		referenced[html.UnescapeString(match[1]+match[2])] = true
+	}
+
+	orphans := []string{}
+
+	for _, name := range existing {
+		if referenced[name] {
+			continue
+		}
+
+		// names are listed once
+		referenced[name] = true
+
+		protected := false
+		for _, pattern := range protect {
+			if ok, _ := path.Match(pattern, name); ok {
+				protected = true
+
+				break
+			}
+		}
+
+		if !protected {
+			orphans = append(orphans, name)
+		}
+	}
+
+	return orphans, nil
+}
+
 // The logic of merging comment is the following. Since each comment comes with
 // its original selection text, we just search for that and put back the marker.
 // Note that this only finds the first instance, so if you put a comment on a
@@ -178,7 +271,7 @@ func prepareAttachments(base string, replacements []string) ([]Attachment, error
 	for _, name := range replacements {
 		attach := Attachment{
 			Name:     name,
-			Filename: strings.ReplaceAll(name, "/", "_"),
+			Filename: getAttachmentFilename(name),
 			Path:     filepath.Join(base, name),
 			Replace:  name,
 		}
@@ -189,6 +282,30 @@ func prepareAttachments(base string, replacements []string) ([]Attachment, error
 	return attaches, nil
 }
 
+// getAttachmentFilename returns the name of the attachment for the file at
+// given slash-separated path relative to the directory of the document.
+// Files of that directory are attached by their names, names of other files
+// are prefixed with the short hash of the relative directory they're in:
+//
+//	diagram.png        -> diagram.png
+//	images/diagram.png -> 21b2ee-diagram.png
+//
+// The hash is first 6 hex digits of SHA-256 of the cleaned directory path,
+// so files with the same name in different directories don't collide and
+// names don't change between runs.
+func getAttachmentFilename(name string) string {
+	dir, base := path.Split(path.Clean(name))
+
+	dir = path.Clean(dir)
+	if dir == "." {
+		return base
+	}
+
+	checksum := sha256.Sum256([]byte(dir))
+
+	return hex.EncodeToString(checksum[:3]) + "-" + base
+}
+
 func CompileAttachmentLinks(markdown []byte, attaches []Attachment) []byte {
 	links := map[string]string{}
 	replaces := []string{}
@@ -221,7 +338,7 @@ func CompileAttachmentLinks(markdown []byte, attaches []Attachment) []byte {
 		if bytes.Contains(markdown, []byte("attachment://"+replace)) {
 			from := "attachment://" + replace
 
-			log.Debugf(nil, "replacing legacy link: %q -> %q", from, to)
+			DefaultLogger.Debugf("replacing legacy link: %q -> %q", from, to)
 
 			markdown = bytes.ReplaceAll(
 				markdown,
@@ -235,7 +352,7 @@ func CompileAttachmentLinks(markdown []byte, attaches []Attachment) []byte {
 		if bytes.Contains(markdown, []byte(replace)) {
 			from := replace
 
-			log.Debugf(nil, "replacing link: %q -> %q", from, to)
+			DefaultLogger.Debugf("replacing link: %q -> %q", from, to)
 
 			markdown = bytes.ReplaceAll(
 				markdown,
@@ -247,7 +364,7 @@ func CompileAttachmentLinks(markdown []byte, attaches []Attachment) []byte {
 		}
 
 		if !found {
-			log.Warningf(nil, "unused attachment: %s", replace)
+			DefaultLogger.Warningf("unused attachment: %s", replace)
 		}
 	}
 
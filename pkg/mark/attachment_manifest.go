@@ -0,0 +1,177 @@
+package mark
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrAttachmentNotFound is matched by AttachmentFile.Err if the file doesn't
+// exist.
+var ErrAttachmentNotFound = errors.New("attachment file is not found")
+
+// AttachmentFile is the file which must be uploaded as attachment of the
+// compiled page.
+type AttachmentFile struct {
+	// SourcePath is the path of the file. Paths written in the document are
+	// relative to the directory of CompileOptions.File or of the included
+	// file they're written in.
+	SourcePath string
+
+	// Filename is the name of the attachment, which is the file name
+	// prefixed with the short hash of its directory relative to the
+	// directory of CompileOptions.File, the same way as for Attachment
+	// headers, e.g. 21b2ee-diagram.png for images/diagram.png.
+	Filename string
+
+	// ContentHash is SHA-256 of the file in hex, the same as
+	// Attachment.Checksum, it's empty until Hash is called, so files are not
+	// read while compiling.
+	ContentHash string
+
+	// Generated is set for files generated while compiling, e.g. by
+	// handlers, rather than written by the author.
+	Generated bool
+
+	// Alt and Caption are the alt text and the caption of the image of the
+	// diagram the file is generated from, see
+	// ConfluenceRenderer.RenderDiagram.
+	Alt     string
+	Caption string
+
+	// Err is set if the file can't be attached, e.g. it matches
+	// ErrAttachmentNotFound if CompileOptions.FileExists reports that the
+	// file doesn't exist.
+	Err error
+}
+
+// Hash returns ContentHash, computing it on the first call.
+func (file *AttachmentFile) Hash() (string, error) {
+	if file.ContentHash != "" {
+		return file.ContentHash, nil
+	}
+
+	checksum, err := getChecksum(file.SourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	file.ContentHash = checksum
+
+	return checksum, nil
+}
+
+// Attach adds the file to the attachment manifest of the compiled document,
+// e.g. the diagram generated by the handler. Files are listed once, in
+// order they're attached.
+func (renderer *ConfluenceRenderer) Attach(file AttachmentFile) {
+	renderer.state.attach(file)
+}
+
+func (state *compilation) attach(file AttachmentFile) {
+	if state == nil || state.attached[file.SourcePath] {
+		return
+	}
+
+	if state.attached == nil {
+		state.attached = map[string]bool{}
+	}
+
+	state.attached[file.SourcePath] = true
+	state.attachments = append(state.attachments, file)
+	state.stats.Attachments++
+}
+
+// attachMeta adds files of Attachment headers to the manifest.
+func (state *compilation) attachMeta(meta *Meta) {
+	if meta == nil {
+		return
+	}
+
+	for _, name := range meta.Attachments {
+		state.attachPath(0, name)
+	}
+}
+
+// attachDestination adds the local file which the image or the link at
+// given line of the parsed markdown refers to. Links to markdown files and
+// pages, anchors and URLs are not attachments.
+func (state *compilation) attachDestination(line int, destination string, image bool) {
+	if state == nil || destination == "" || destination[0] == '#' {
+		return
+	}
+
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.Path == "" {
+		return
+	}
+
+	if !image {
+		switch strings.ToLower(path.Ext(parsed.Path)) {
+		case "", ".md", ".markdown":
+			return
+		}
+	}
+
+	state.attachPath(line, parsed.Path)
+}
+
+// getReferenceBase returns the directory relative references at given
+// line of the parsed markdown are resolved against: BaseDir or the
+// directory of the included file.
+func (state *compilation) getReferenceBase(line int) string {
+	if state == nil {
+		return ""
+	}
+
+	if line > 0 {
+		if included := state.sources.File(line); included != "" {
+			return filepath.Dir(included)
+		}
+	}
+
+	return state.paths.BaseDir
+}
+
+func (state *compilation) attachPath(line int, name string) {
+	source, ok, err := resolveReference(
+		name,
+		state.getReferenceBase(line),
+		state.paths,
+	)
+	if !ok {
+		return
+	}
+
+	// files of included documents are named by paths relative to the
+	// compiled document, so the same names in different directories don't
+	// collide
+	relative, relErr := filepath.Rel(state.paths.BaseDir, source)
+	if relErr != nil {
+		relative = name
+	}
+
+	attachment := AttachmentFile{
+		SourcePath: source,
+		Filename:   getAttachmentFilename(filepath.ToSlash(relative)),
+	}
+
+	switch {
+	case err != nil:
+		attachment.Err = err
+
+		state.warn(line, "%s", err)
+
+	case state.fileExists != nil && !state.fileExists(attachment.SourcePath):
+		attachment.Err = fmt.Errorf(
+			"%w: %s",
+			ErrAttachmentNotFound,
+			attachment.SourcePath,
+		)
+	}
+
+	state.attach(attachment)
+}
@@ -0,0 +1,347 @@
+package mark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_Attachments(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"page.md": text(
+			`<!-- Title: Page -->`,
+			`<!-- Attachment: files/doc.pdf -->`,
+			``,
+			`![logo](images/logo.png) ![logo again](./images/logo.png)`,
+			``,
+			`See [the doc](files/doc.pdf), [the sheet](files/data%20sheet.xlsx),`,
+			`[other page](other.md), [section](#section),`,
+			`[site](https://example.com/file.pdf) and [directory](files).`,
+			``,
+			`![missing](missing.png) ![badge](https://example.com/badge.svg)`,
+			``,
+			`<!-- Include: shared/footer.md -->`,
+			``,
+		),
+		"images/logo.png":        "logo",
+		"files/doc.pdf":          "doc",
+		"files/data sheet.xlsx":  "sheet",
+		"shared/footer.md":       `![footer](footer.png)`,
+		"shared/footer.png":      "footer",
+		"shared/images/logo.png": "other logo",
+	})
+
+	markdown, sources, meta, err := readMarkdownFile(
+		filepath.Join(dir, "page.md"),
+		[]string{},
+		false,
+		CompileOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []AttachmentFile{
+		{
+			SourcePath: filepath.Join(dir, "files", "doc.pdf"),
+			Filename:   "3d7db3-doc.pdf",
+		},
+		{
+			SourcePath: filepath.Join(dir, "images", "logo.png"),
+			Filename:   "21b2ee-logo.png",
+		},
+		{
+			SourcePath: filepath.Join(dir, "files", "data sheet.xlsx"),
+			Filename:   "3d7db3-data sheet.xlsx",
+		},
+		{
+			SourcePath: filepath.Join(dir, "missing.png"),
+			Filename:   "missing.png",
+		},
+		{
+			SourcePath: filepath.Join(dir, "shared", "footer.png"),
+			Filename:   "a4d268-footer.png",
+		},
+	}
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		opts := CompileOptions{
+			Engine:  engine,
+			File:    filepath.Join(dir, "page.md"),
+			Sources: sources,
+			Meta:    meta,
+			Logger:  &testLogger{},
+		}
+
+		result, err := Compile(markdown, lib, opts)
+		assert.NoError(t, err, engine)
+		assert.Equal(t, expected, result.Attachments, engine)
+		assert.Equal(t, len(expected), result.Stats.Attachments, engine)
+
+		// missing files are flagged only if files can be checked
+		opts.FileExists = FileExistsOnDisk
+
+		result, err = Compile(markdown, lib, opts)
+		assert.NoError(t, err, engine)
+
+		if assert.Len(t, result.Attachments, len(expected), engine) {
+			for i, attachment := range result.Attachments {
+				if expected[i].Filename == "missing.png" {
+					assert.True(
+						t,
+						errors.Is(attachment.Err, ErrAttachmentNotFound),
+						engine,
+					)
+
+					continue
+				}
+
+				assert.NoError(t, attachment.Err, expected[i].Filename)
+			}
+		}
+	}
+}
+
+func TestCompile_AttachmentFilenames(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"page.md": text(
+			`![root](diagram.png) ![a](a/diagram.png) ![a/b](a/b/diagram.png)`,
+			``,
+			`<!-- Include: a/section.md -->`,
+			``,
+		),
+		"a/section.md": `![section](diagram.png) ![section b](b/diagram.png)`,
+	})
+
+	markdown, sources, _, err := readMarkdownFile(
+		filepath.Join(dir, "page.md"),
+		[]string{},
+		false,
+		CompileOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(markdown, lib, CompileOptions{
+		File:    filepath.Join(dir, "page.md"),
+		Sources: sources,
+	})
+	assert.NoError(t, err)
+
+	// files referenced by the included document are the same files
+	assert.Equal(
+		t,
+		[]AttachmentFile{
+			{
+				SourcePath: filepath.Join(dir, "diagram.png"),
+				Filename:   "diagram.png",
+			},
+			{
+				SourcePath: filepath.Join(dir, "a", "diagram.png"),
+				Filename:   "ca9781-diagram.png",
+			},
+			{
+				SourcePath: filepath.Join(dir, "a", "b", "diagram.png"),
+				Filename:   "c14cdd-diagram.png",
+			},
+		},
+		result.Attachments,
+	)
+
+	// Attachment headers are named the same way
+	attaches, err := prepareAttachments(dir, []string{
+		"diagram.png",
+		"a/diagram.png",
+		"a/b/diagram.png",
+	})
+	assert.NoError(t, err)
+
+	filenames := []string{}
+	for _, attach := range attaches {
+		filenames = append(filenames, attach.Filename)
+	}
+
+	assert.Equal(
+		t,
+		[]string{"diagram.png", "ca9781-diagram.png", "c14cdd-diagram.png"},
+		filenames,
+	)
+}
+
+func TestAttachmentFile_Hash(t *testing.T) {
+	dir := writeMarkdownFiles(t, map[string]string{"logo.png": "logo"})
+
+	file := AttachmentFile{SourcePath: filepath.Join(dir, "logo.png")}
+
+	checksum := sha256.Sum256([]byte("logo"))
+
+	hash, err := file.Hash()
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(checksum[:]), hash)
+	assert.Equal(t, hash, file.ContentHash)
+
+	// the hash is computed once
+	file.SourcePath = filepath.Join(dir, "missing.png")
+
+	hash, err = file.Hash()
+	assert.NoError(t, err)
+	assert.Equal(t, file.ContentHash, hash)
+
+	_, err = (&AttachmentFile{SourcePath: file.SourcePath}).Hash()
+	assert.Error(t, err)
+}
+
+func TestConfluenceRenderer_Attach(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(
+		[]byte(text(
+			"![logo](logo.png)",
+			"",
+			"```diagram",
+			"a -> b",
+			"```",
+		)),
+		lib,
+		CompileOptions{
+			Handlers: func(renderer *ConfluenceRenderer) {
+				renderer.Handle(bf.CodeBlock, func(
+					writer io.Writer,
+					node *bf.Node,
+					entering bool,
+				) (bf.WalkStatus, bool) {
+					if string(node.Info) != "diagram" {
+						return bf.GoToNext, false
+					}
+
+					renderer.Attach(AttachmentFile{
+						SourcePath: "/tmp/diagram.png",
+						Filename:   "diagram.png",
+						Generated:  true,
+					})
+
+					io.WriteString(
+						writer,
+						`<ac:image><ri:attachment ri:filename="diagram.png"/></ac:image>`,
+					)
+
+					return bf.GoToNext, true
+				})
+			},
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]AttachmentFile{
+			{SourcePath: "logo.png", Filename: "logo.png"},
+			{SourcePath: "/tmp/diagram.png", Filename: "diagram.png", Generated: true},
+		},
+		result.Attachments,
+	)
+}
+
+func TestConfluenceRenderer_RenderDiagram(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"```mermaid alt=\"Deploy & rollback\" caption=\"Deploy flow\" title Deploy",
+		"graph TD; A-->B;",
+		"```",
+		"",
+		"```mermaid",
+		"graph TD; B-->A;",
+		"```",
+		"",
+	))
+
+	opts := CompileOptions{
+		Handlers: func(renderer *ConfluenceRenderer) {
+			renderer.Handle(bf.CodeBlock, func(
+				writer io.Writer,
+				node *bf.Node,
+				entering bool,
+			) (bf.WalkStatus, bool) {
+				name := fmt.Sprintf("diagram-%d.png", len(renderer.state.attachments))
+
+				renderer.RenderDiagram(writer, node, AttachmentFile{
+					SourcePath: "/tmp/" + name,
+					Filename:   name,
+				})
+
+				return bf.GoToNext, true
+			})
+		},
+	}
+
+	result, err := Compile(markdown, lib, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(
+		t,
+		`<ac:image ac:alt="Deploy &amp; rollback">`+
+			`<ri:attachment ri:filename="diagram-0.png"/>`+
+			`<ac:caption><p>Deploy flow</p></ac:caption></ac:image>`+
+			`<ac:image><ri:attachment ri:filename="diagram-1.png"/></ac:image>`,
+		result.HTML,
+	)
+	assert.Equal(
+		t,
+		[]AttachmentFile{
+			{
+				SourcePath: "/tmp/diagram-0.png",
+				Filename:   "diagram-0.png",
+				Generated:  true,
+				Alt:        "Deploy & rollback",
+				Caption:    "Deploy flow",
+			},
+			{
+				SourcePath: "/tmp/diagram-1.png",
+				Filename:   "diagram-1.png",
+				Generated:  true,
+			},
+		},
+		result.Attachments,
+	)
+	assert.Equal(
+		t,
+		[]Diagnostic{{
+			Line:     5,
+			Severity: SeverityWarning,
+			Message:  `diagram "diagram-1.png" has no alt text`,
+		}},
+		result.Diagnostics,
+	)
+
+	opts.Strict = true
+
+	_, err = Compile(markdown, lib, opts)
+	assert.Error(t, err)
+}
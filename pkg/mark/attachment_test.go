@@ -54,3 +54,67 @@ func TestPrepareAttachmentsWithSubDirBase(t *testing.T) {
 
 	assert.Equal(t, len(attaches), 3)
 }
+
+func TestFilterChangedAttachments(t *testing.T) {
+	manifest := []Attachment{
+		{Filename: "same.png", Checksum: "aaa"},
+		{Filename: "prefixed.png", Checksum: "bbb"},
+		{Filename: "changed.png", Checksum: "ccc"},
+		{Filename: "new.png", Checksum: "ddd"},
+		{Filename: "unknown.png"},
+	}
+
+	assert.Equal(
+		t,
+		[]Attachment{
+			{Filename: "changed.png", Checksum: "ccc"},
+			{Filename: "new.png", Checksum: "ddd"},
+			{Filename: "unknown.png"},
+		},
+		FilterChangedAttachments(manifest, map[string]string{
+			"same.png":     "aaa",
+			"prefixed.png": AttachmentChecksumPrefix + "bbb",
+			"changed.png":  "old",
+			"unknown.png":  "",
+		}),
+	)
+
+	assert.Equal(t, manifest, FilterChangedAttachments(manifest, nil))
+}
+
+func TestFindOrphanedAttachments(t *testing.T) {
+	manifest := []Attachment{
+		{Filename: "diagram.png"},
+		{Filename: "21b2ee-chart.png"},
+	}
+
+	storage := `<p><ac:image><ri:attachment ri:filename="raw &amp; passthrough.png"/>` +
+		`</ac:image><ac:link><ri:attachment ri:version-at-save="1" ` +
+		`ri:filename='manual.pdf'></ri:attachment></ac:link></p>`
+
+	orphans, err := FindOrphanedAttachments(
+		manifest,
+		[]string{
+			"diagram.png",
+			"old-diagram.png",
+			"21b2ee-chart.png",
+			"raw & passthrough.png",
+			"manual.pdf",
+			"budget.xlsx",
+			"old-diagram.png",
+			"notes.txt",
+		},
+		[]string{"*.xlsx"},
+		storage,
+	)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"old-diagram.png", "notes.txt"}, orphans)
+	}
+
+	orphans, err = FindOrphanedAttachments(nil, nil, nil, "")
+	assert.NoError(t, err)
+	assert.Empty(t, orphans)
+
+	_, err = FindOrphanedAttachments(manifest, []string{"a.png"}, []string{"[a"}, "")
+	assert.Error(t, err)
+}
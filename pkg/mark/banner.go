@@ -0,0 +1,167 @@
+package mark
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/reconquest/karma-go"
+)
+
+// DefaultGeneratedBannerText is the template of the text of the banner
+// written by CompileOptions.GeneratedBanner, it's MessageGeneratedBanner of
+// DefaultLocale.
+const DefaultGeneratedBannerText = `This page is generated from ` +
+	`<code>{{ .Source }}</code>` +
+	`{{ with .RepoURL }} of <a href="{{ . }}">{{ . }}</a>{{ end }}` +
+	`{{ with .Commit }} at <code>{{ . }}</code>{{ end }}, ` +
+	`edit the source instead, changes of the page are overwritten.`
+
+// GeneratedBanner is the banner written at the beginning of pages, which
+// tells that the page is generated from the source file, so it's not
+// edited directly. The banner is written by ac:box template, so it's the
+// same macro as admonitions are.
+type GeneratedBanner struct {
+	// Macro is the macro of the banner, which is one of info, tip, note and
+	// warning macros, info by default.
+	Macro string
+
+	// Text is Go template of the text of the banner, which is storage
+	// format written as the paragraph, MessageGeneratedBanner of
+	// CompileOptions.Locale by default. Source, RepoURL and Commit fields are given to it, they're
+	// escaped, so they can be written both in text and attributes.
+	Text string
+
+	// Source is the path of the source file, e.g. relative to the root of
+	// the repository, CompileOptions.File by default.
+	Source string
+
+	// RepoURL is the URL of the repository the source file is in.
+	RepoURL string
+
+	// Commit is the commit the page is generated from.
+	Commit string
+}
+
+// bannerMacros are macros of ac:box template banners can be written with.
+var bannerMacros = map[string]bool{
+	"info":    true,
+	"tip":     true,
+	"note":    true,
+	"warning": true,
+}
+
+// banner is the macro written at the beginning of the output.
+type banner struct {
+	Macro string
+	Body  string
+}
+
+// getBanner returns the banner of the document, the draft banner takes
+// place of the generated one, so only one of them is written. Nil is
+// returned if there is no banner.
+func (opts CompileOptions) getBanner() (*banner, error) {
+	meta := opts.Meta
+
+	if opts.DraftBanner && meta != nil && meta.Status == StatusDraft {
+		body, err := opts.getDraftBannerBody()
+		if err != nil {
+			return nil, err
+		}
+
+		return &banner{Macro: "note", Body: body}, nil
+	}
+
+	if opts.GeneratedBanner == nil || (meta != nil && meta.NoGeneratedBanner) {
+		return nil, nil
+	}
+
+	return opts.GeneratedBanner.render(opts)
+}
+
+func (generated *GeneratedBanner) render(opts CompileOptions) (*banner, error) {
+	macro := generated.Macro
+	if macro == "" {
+		macro = "info"
+	}
+
+	if !bannerMacros[macro] {
+		return nil, fmt.Errorf(
+			"unexpected banner macro %q, expected one of: info, tip, note, warning",
+			macro,
+		)
+	}
+
+	text := generated.Text
+	if text == "" {
+		text = opts.getMessage(MessageGeneratedBanner)
+	}
+
+	tmpl, err := template.New("banner").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, karma.Format(err, "unable to parse banner template")
+	}
+
+	source := generated.Source
+	if source == "" {
+		source = filepath.ToSlash(opts.File)
+	}
+
+	var body strings.Builder
+
+	body.WriteString("<p>")
+
+	err = tmpl.Execute(&body, map[string]string{
+		"Source":  escape.EscapeAttr(source),
+		"RepoURL": escape.EscapeAttr(generated.RepoURL),
+		"Commit":  escape.EscapeAttr(generated.Commit),
+	})
+	if err != nil {
+		return nil, karma.Format(err, "unable to execute banner template")
+	}
+
+	body.WriteString("</p>\n")
+
+	return &banner{Macro: macro, Body: body.String()}, nil
+}
+
+// writeBanner writes the banner of the document, if there is one, using
+// ac:box template.
+func writeBanner(
+	writer io.Writer,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+	state *compilation,
+) error {
+	banner, err := opts.getBanner()
+	if err != nil || banner == nil {
+		return err
+	}
+
+	err = stdlib.Templates.ExecuteTemplate(
+		writer,
+		"ac:box",
+		struct {
+			Name  string
+			Icon  string
+			Title string
+			Body  string
+		}{
+			banner.Macro,
+			"true",
+			"",
+			banner.Body,
+		},
+	)
+	if err != nil {
+		return karma.Format(err, "unable to render banner")
+	}
+
+	state.countTemplate("ac:box")
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_GeneratedBanner(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte("# Section\n\nBody.\n")
+
+	banner := text(
+		`<ac:structured-macro ac:name="info">`,
+		`<ac:parameter ac:name="icon">true</ac:parameter>`,
+		`<ac:rich-text-body><p>This page is generated from <code>docs/a&amp;b.md</code>`+
+			` of <a href="https://example.com/repo?a=1&amp;b=&quot;2&quot;">`+
+			`https://example.com/repo?a=1&amp;b=&quot;2&quot;</a>`+
+			` at <code>abc123</code>, edit the source instead,`+
+			` changes of the page are overwritten.</p>`,
+		`</ac:rich-text-body>`,
+		`</ac:structured-macro>`,
+	)
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		opts := CompileOptions{
+			Engine: engine,
+			File:   `docs/a&b.md`,
+			GeneratedBanner: &GeneratedBanner{
+				RepoURL: `https://example.com/repo?a=1&b="2"`,
+				Commit:  "abc123",
+			},
+		}
+
+		result, err := Compile(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.True(t, strings.HasPrefix(result.HTML, banner), engine)
+			assert.Equal(t, 1, result.Stats.Templates["ac:box"], engine)
+		}
+
+		opts.GeneratedBanner.Macro = "warning"
+		opts.GeneratedBanner.Text = `Edit <a href="{{ .RepoURL }}/{{ .Source }}">source</a>.`
+		opts.GeneratedBanner.RepoURL = "https://example.com/repo"
+
+		html, err := CompileMarkdownWithOptions(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.Contains(t, html, `<ac:structured-macro ac:name="warning">`, engine)
+			assert.Contains(
+				t,
+				html,
+				`<p>Edit <a href="https://example.com/repo/docs/a&amp;b.md">source</a>.</p>`,
+				engine,
+			)
+		}
+
+		opts.Meta = &Meta{NoGeneratedBanner: true}
+
+		html, err = CompileMarkdownWithOptions(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.NotContains(t, html, "structured-macro", engine)
+		}
+
+		opts.Meta = nil
+		opts.GeneratedBanner.Macro = "danger"
+
+		_, err = CompileMarkdownWithOptions(markdown, lib, opts)
+		assert.Error(t, err, engine)
+
+		opts.GeneratedBanner.Macro = ""
+		opts.GeneratedBanner.Text = "{{ .Unknown }}"
+
+		_, err = CompileMarkdownWithOptions(markdown, lib, opts)
+		assert.Error(t, err, engine)
+	}
+}
+
+func TestCompile_GeneratedBannerDraft(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte("# Section\n\nBody.\n\n# Another section\n\nBody.\n")
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		for _, opts := range []CompileOptions{
+			{},
+			{ChunkSize: 1},
+			{Validate: true},
+			{ContentHash: true},
+		} {
+			opts.Engine = engine
+			opts.File = "docs/page.md"
+			opts.Meta = &Meta{Status: StatusDraft}
+			opts.DraftBanner = true
+			opts.GeneratedBanner = &GeneratedBanner{}
+
+			result, err := Compile(markdown, lib, opts)
+			if !assert.NoError(t, err, engine) {
+				continue
+			}
+
+			assert.Equal(t, 1, result.Stats.Templates["ac:box"], engine)
+			assert.Contains(t, result.HTML, "This page is a draft generated from", engine)
+			assert.NotContains(t, result.HTML, "edit the source instead", engine)
+
+			opts.Meta = &Meta{Status: StatusPublished}
+
+			result, err = Compile(markdown, lib, opts)
+			if assert.NoError(t, err, engine) {
+				assert.Equal(t, 1, result.Stats.Templates["ac:box"], engine)
+				assert.NotContains(t, result.HTML, "draft", engine)
+			}
+		}
+	}
+}
+
+func TestGetContentHash_Banner(t *testing.T) {
+	opts := CompileOptions{
+		File:            "a.md",
+		GeneratedBanner: &GeneratedBanner{Commit: "abc"},
+	}
+
+	hash := GetContentHash([]byte("text"), nil, opts)
+
+	opts.File = "b.md"
+	assert.NotEqual(t, hash, GetContentHash([]byte("text"), nil, opts))
+
+	opts.GeneratedBanner = &GeneratedBanner{Commit: "def"}
+	assert.NotEqual(t, hash, GetContentHash([]byte("text"), nil, opts))
+}
+
+func TestExtractMeta_GeneratedBanner(t *testing.T) {
+	meta, _, err := ExtractMeta([]byte("<!-- Generated-Banner: no -->\n\nbody\n"))
+	if assert.NoError(t, err) {
+		assert.True(t, meta.NoGeneratedBanner)
+	}
+
+	meta, _, err = ExtractMeta([]byte("<!-- Title: Page -->\n\nbody\n"))
+	if assert.NoError(t, err) {
+		assert.False(t, meta.NoGeneratedBanner)
+	}
+}
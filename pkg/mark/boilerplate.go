@@ -0,0 +1,143 @@
+package mark
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+)
+
+// boilerplateData is given to CompileOptions.HeaderTemplate and
+// CompileOptions.FooterTemplate.
+type boilerplateData struct {
+	Meta *Meta
+
+	// Vars are variables substituted in markdown, including space.
+	Vars map[string]string
+}
+
+// spliceBoilerplate renders HeaderTemplate and FooterTemplate and splices
+// them before and after the body. The header goes after the leading H1
+// heading, which is the title of the page, and after front matter, so the
+// title is found in the document as it's written. Lines of boilerplate are
+// mapped to lines they're spliced at.
+func spliceBoilerplate(
+	markdown []byte,
+	sources *sourcemap.Map,
+	opts CompileOptions,
+) ([]byte, *sourcemap.Map, []sourceIssue) {
+	if (opts.HeaderTemplate == "" && opts.FooterTemplate == "") ||
+		(opts.Meta != nil && opts.Meta.NoBoilerplate) {
+		return markdown, sources, nil
+	}
+
+	data := boilerplateData{Meta: opts.Meta}
+	if data.Meta == nil {
+		data.Meta = &Meta{}
+	}
+
+	data.Vars, _ = opts.getSubstitutedVars()
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+
+	header, err := renderBoilerplate("header", opts.HeaderTemplate, data)
+	if err != nil {
+		return markdown, sources, []sourceIssue{{message: err.Error()}}
+	}
+
+	footer, err := renderBoilerplate("footer", opts.FooterTemplate, data)
+	if err != nil {
+		return markdown, sources, []sourceIssue{{message: err.Error()}}
+	}
+
+	sources = sources.Clone()
+	if sources == nil {
+		sources = sourcemap.New(markdown)
+	}
+
+	var result []byte
+
+	if footer != nil {
+		if len(markdown) > 0 && !bytes.HasSuffix(markdown, []byte("\n")) {
+			footer = append([]byte("\n"), footer...)
+		}
+
+		footer = append([]byte("\n"), footer...)
+
+		sources.Replace(markdown, len(markdown), len(markdown), footer)
+
+		result = make([]byte, 0, len(markdown)+len(footer))
+		result = append(append(result, markdown...), footer...)
+
+		markdown = result
+	}
+
+	if header != nil {
+		offset := getBoilerplateOffset(markdown)
+		if offset > 0 {
+			header = append([]byte("\n"), header...)
+		}
+
+		header = append(header, '\n')
+
+		sources.Replace(markdown, offset, offset, header)
+
+		result = make([]byte, 0, len(markdown)+len(header))
+		result = append(result, markdown[:offset]...)
+		result = append(result, header...)
+		result = append(result, markdown[offset:]...)
+
+		markdown = result
+	}
+
+	return markdown, sources, nil
+}
+
+// renderBoilerplate renders markdown template, the result ends with line
+// break. Nil is returned if there is no template.
+func renderBoilerplate(
+	name string,
+	text string,
+	data boilerplateData,
+) ([]byte, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var rendered strings.Builder
+
+	err = tmpl.Execute(&rendered, data)
+	if err != nil {
+		return nil, err
+	}
+
+	boilerplate := strings.TrimRight(rendered.String(), "\r\n")
+	if boilerplate == "" {
+		return nil, nil
+	}
+
+	return []byte(boilerplate + "\n"), nil
+}
+
+// getBoilerplateOffset returns the offset the header is spliced at: right
+// after the leading H1 heading or front matter, 0 if there are none.
+func getBoilerplateOffset(markdown []byte) int {
+	if title, ok := findDocumentLeadingH1(markdown); ok {
+		return title.End
+	}
+
+	if line, next := readLine(markdown, 0); isFrontMatterDelimiter(line) {
+		if end, ok := skipFrontMatter(markdown, next); ok {
+			return end
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,113 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_Boilerplate(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`# Guide`,
+		``,
+		`Body.`,
+	))
+
+	opts := CompileOptions{
+		Meta: &Meta{Title: "Guide", Space: "DOC"},
+		Vars: map[string]string{"owner": "Docs team"},
+		HeaderTemplate: text(
+			`| Owner | Space |`,
+			`|---|---|`,
+			`| {{ .Vars.owner }} | ${space} |`,
+			``,
+		),
+		FooterTemplate: "_Last reviewed for **{{ .Meta.Title }}**._\n",
+	}
+
+	table := text(
+		`<table>`,
+		`<thead>`,
+		`<tr>`,
+		`<th>Owner</th>`,
+		`<th>Space</th>`,
+		`</tr>`,
+		`</thead>`,
+		``,
+		`<tbody>`,
+		`<tr>`,
+		`<td>Docs team</td>`,
+		`<td>DOC</td>`,
+		`</tr>`,
+		`</tbody>`,
+		`</table>`,
+	)
+
+	footer := `<p><em>Last reviewed for <strong>Guide</strong>.</em></p>`
+
+	html, err := CompileMarkdownWithOptions(markdown, lib, opts)
+	if assert.NoError(t, err) {
+		assert.Equal(
+			t,
+			text(`<h1 id="guide">Guide</h1>`, ``, table, `<p>Body.</p>`, ``, footer, ``),
+			html,
+		)
+	}
+
+	opts.TitleFromH1 = TitleFromH1Drop
+
+	html, err = CompileMarkdownWithOptions(markdown, lib, opts)
+	if assert.NoError(t, err) {
+		assert.Equal(t, text(table, `<p>Body.</p>`, ``, footer, ``), html)
+	}
+
+	assert.Equal(t, "Guide", ResolveTitle(markdown, CompileOptions{
+		TitleFromH1:    TitleFromH1KeepAndTitle,
+		HeaderTemplate: "# Header\n",
+	}))
+
+	opts.Meta = &Meta{Title: "Guide", NoBoilerplate: true}
+
+	html, err = CompileMarkdownWithOptions(markdown, lib, opts)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "<p>Body.</p>\n", html)
+	}
+
+	opts.Meta = nil
+	opts.HeaderTemplate = "{{ .Vars.unknown }}"
+
+	_, err = CompileMarkdownWithOptions(markdown, lib, opts)
+	assert.Error(t, err)
+}
+
+func TestSpliceBoilerplate_Sources(t *testing.T) {
+	markdown := []byte(text(`# Title`, ``, `Body.`, ``))
+
+	spliced, sources, issues := spliceBoilerplate(
+		markdown,
+		sourcemap.New(markdown),
+		CompileOptions{
+			HeaderTemplate: "Header\n\nmore\n",
+			FooterTemplate: "Footer",
+		},
+	)
+	assert.Empty(t, issues)
+	assert.Equal(
+		t,
+		text(`# Title`, ``, `Header`, ``, `more`, ``, ``, `Body.`, ``, `Footer`, ``),
+		string(spliced),
+	)
+
+	// lines of the header are mapped to the line it's spliced at
+	assert.Equal(t, 1, sources.Line(1))
+	assert.Equal(t, 2, sources.Line(3))
+	assert.Equal(t, 2, sources.Line(7))
+	assert.Equal(t, 3, sources.Line(8))
+}
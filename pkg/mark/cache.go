@@ -0,0 +1,324 @@
+package mark
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/reconquest/karma-go"
+)
+
+// cacheVersion is changed when results of compiling change for the same
+// input, so entries written by previous versions are not reused.
+const cacheVersion = 1
+
+// Cache keeps results of compiling documents in the directory, so documents
+// which are not changed are not compiled again, see CompileOptions.Cache.
+//
+// Results are keyed by the content hash of the document, see
+// GetContentHash, which covers markdown with included files resolved by
+// CompileMarkdownFile, templates and options, as well as by options which
+// change diagnostics, e.g. File, Strict and Sources. Callbacks such as
+// LinkResolver, FileExists and PageExists can't be hashed, so they must
+// give the same results for the same input. Results with errors and with
+// attachments which can't be attached are not cached, and cached results
+// are compiled again if their attachments are changed or removed since
+// then, which is checked by sizes and modification times of files.
+//
+// Cache is safe for concurrent use, e.g. by CompileAll.
+type Cache struct {
+	dir string
+
+	mutex sync.Mutex
+	stats CacheStats
+}
+
+// CacheStats counts lookups of documents in Cache.
+type CacheStats struct {
+	// Hits are documents which results are reused.
+	Hits int
+
+	// Misses are documents which are not cached yet.
+	Misses int
+
+	// Invalidations are documents which are cached, but changed since
+	// then, so they're compiled again.
+	Invalidations int
+}
+
+// cacheEntry is the result of compiling the document stored by Cache.
+type cacheEntry struct {
+	Key    string
+	Result CompileResult
+
+	// Attachments are stamps of attachments of the result, see
+	// getAttachmentStamps.
+	Attachments []string
+}
+
+// NewCache returns the cache of results in given directory, which is
+// created if it doesn't exist.
+func NewCache(dir string) (*Cache, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, karma.Format(err, "unable to create cache directory")
+	}
+
+	return &Cache{dir: dir}, nil
+}
+
+// Stats returns counts of lookups since the cache is created.
+func (cache *Cache) Stats() CacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return cache.stats
+}
+
+// compile returns the cached result of compiling the document or compiles
+// it and stores the result. Warnings of cached results are reported to the
+// logger again, the same way as they're reported while compiling.
+func (cache *Cache) compile(
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) (*CompileResult, error) {
+	var (
+		key  = getCacheKey(markdown, stdlib, opts)
+		path = cache.getPath(key, opts)
+	)
+
+	entry, found := cache.read(path)
+
+	switch {
+	case found && entry.Key == key && isAttachmentsFresh(entry, opts):
+		cache.count(func(stats *CacheStats) { stats.Hits++ })
+
+		logger := opts.getLogger()
+		for _, diagnostic := range entry.Result.Diagnostics {
+			if diagnostic.Severity == SeverityWarning {
+				logger.Warningf("%s", diagnostic)
+			}
+		}
+
+		return &entry.Result, nil
+
+	case found:
+		cache.count(func(stats *CacheStats) { stats.Invalidations++ })
+
+	default:
+		cache.count(func(stats *CacheStats) { stats.Misses++ })
+	}
+
+	result, err := compileResult(markdown, stdlib, opts)
+	if err != nil || !isCacheable(result) {
+		return result, err
+	}
+
+	err = cache.write(path, cacheEntry{
+		Key:         key,
+		Result:      *result,
+		Attachments: getAttachmentStamps(result.Attachments),
+	})
+	if err != nil {
+		opts.getLogger().Warningf("unable to write compile cache: %s", err)
+	}
+
+	return result, nil
+}
+
+func (cache *Cache) count(update func(stats *CacheStats)) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	update(&cache.stats)
+}
+
+// getPath returns the path of the entry of the document, which is the same
+// for all versions of the file, so changed files replace their entries.
+// Documents without file are stored by their keys.
+func (cache *Cache) getPath(key string, opts CompileOptions) string {
+	name := key
+	if opts.File != "" {
+		checksum := sha256.Sum256([]byte(getAbsolutePath(opts.File)))
+
+		name = hex.EncodeToString(checksum[:])
+	}
+
+	return filepath.Join(cache.dir, name+".json")
+}
+
+// read returns the entry stored at the path, entries which can't be read
+// are not found.
+func (cache *Cache) read(path string) (cacheEntry, bool) {
+	var entry cacheEntry
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return entry, false
+	}
+
+	if json.Unmarshal(data, &entry) != nil {
+		return cacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+// write stores the entry, it's written into the temporary file first, so
+// concurrent readers never see partially written entries.
+func (cache *Cache) write(path string, entry cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(cache.dir, ".entry-")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// isCacheable returns false for results, which attachments have errors,
+// since errors can't be stored.
+func isCacheable(result *CompileResult) bool {
+	for _, attachment := range result.Attachments {
+		if attachment.Err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isAttachmentsFresh returns true if attachments of the cached result still
+// exist and are not changed since the result is cached.
+func isAttachmentsFresh(entry cacheEntry, opts CompileOptions) bool {
+	if opts.FileExists != nil {
+		for _, attachment := range entry.Result.Attachments {
+			if !opts.FileExists(attachment.SourcePath) {
+				return false
+			}
+		}
+	}
+
+	stamps := getAttachmentStamps(entry.Result.Attachments)
+	if len(stamps) != len(entry.Attachments) {
+		return false
+	}
+
+	for index, stamp := range stamps {
+		if stamp != entry.Attachments[index] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getAttachmentStamps returns paths of attachments along with sizes and
+// modification times of their files, files which can't be read from disk,
+// e.g. checked by custom CompileOptions.FileExists, are stamped by paths.
+func getAttachmentStamps(attachments []AttachmentFile) []string {
+	stamps := []string{}
+
+	for _, attachment := range attachments {
+		stamp := attachment.SourcePath
+
+		info, err := os.Stat(attachment.SourcePath)
+		if err == nil {
+			stamp += ":" + strconv.FormatInt(info.Size(), 10) +
+				":" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+		}
+
+		stamps = append(stamps, stamp)
+	}
+
+	return stamps
+}
+
+// cacheKeyInput lists what the result of compiling depends on besides the
+// content hash: options which change diagnostics and fields of the result
+// other than HTML, e.g. the title.
+type cacheKeyInput struct {
+	Version     int
+	ContentHash string
+
+	File             string
+	Sources          []string
+	Strict           bool
+	Validate         bool
+	WriteHash        bool
+	BaseDir          string
+	RootDir          string
+	AllowOutsideRoot bool
+	Labels           []string
+	VersionData      map[string]string
+	MinorEdit        *bool
+	Meta             *Meta
+	TitlePrefix      string
+	TitleSuffix      string
+	Preview          bool
+}
+
+// getCacheKey returns the key of the result of compiling the document.
+func getCacheKey(markdown []byte, stdlib *stdlib.Lib, opts CompileOptions) string {
+	input := cacheKeyInput{
+		Version:          cacheVersion,
+		ContentHash:      GetContentHash(markdown, stdlib, opts),
+		File:             opts.File,
+		Strict:           opts.Strict,
+		Validate:         opts.Validate,
+		WriteHash:        opts.ContentHash,
+		BaseDir:          opts.BaseDir,
+		RootDir:          opts.RootDir,
+		AllowOutsideRoot: opts.AllowOutsideRoot,
+		Labels:           opts.Labels,
+		VersionData:      opts.VersionData,
+		MinorEdit:        opts.MinorEdit,
+		Meta:             opts.Meta,
+		TitlePrefix:      opts.TitlePrefix,
+		TitleSuffix:      opts.TitleSuffix,
+		Preview:          opts.Preview,
+	}
+
+	// diagnostics refer to original lines and included files
+	if opts.Sources != nil {
+		lines := bytes.Count(markdown, []byte("\n")) + 1
+		for line := 1; line <= lines; line++ {
+			input.Sources = append(
+				input.Sources,
+				opts.Sources.File(line)+":"+strconv.Itoa(opts.Sources.Line(line)),
+			)
+		}
+	}
+
+	// encoding of structs can't fail, map keys are sorted
+	data, _ := json.Marshal(input)
+
+	checksum := sha256.Sum256(data)
+
+	return hex.EncodeToString(checksum[:])
+}
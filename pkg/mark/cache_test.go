@@ -0,0 +1,227 @@
+package mark
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"page.md": text(
+			`<!-- Title: Page -->`,
+			``,
+			`<!-- Include: footer.md -->`,
+			``,
+			`![diagram](diagram.png)`,
+		),
+		"footer.md":   "b <!--comment_id='c d'-->commented<!----> text\n",
+		"diagram.png": "png",
+	})
+
+	cache, err := NewCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		path   = filepath.Join(dir, "page.md")
+		logger = &testLogger{}
+		opts   = CompileOptions{Cache: cache, Logger: logger}
+	)
+
+	compile := func() string {
+		t.Helper()
+
+		html, err := CompileMarkdownFile(path, lib, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return html
+	}
+
+	html := compile()
+	assert.Equal(t, CacheStats{Misses: 1}, cache.Stats())
+
+	assert.Equal(t, html, compile())
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, cache.Stats())
+
+	// warnings of cached results are reported again
+	warning := fmt.Sprintf(
+		`warning: %s:1: invalid inline comment id "c d", dropping comment marker`,
+		filepath.Join(dir, "footer.md"),
+	)
+	assert.Equal(t, []string{warning, warning}, logger.messages)
+
+	// changes of included files invalidate results
+	err = ioutil.WriteFile(filepath.Join(dir, "footer.md"), []byte("footer\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Contains(t, compile(), "<p>footer</p>")
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Invalidations: 1}, cache.Stats())
+
+	// and so do changes of options
+	opts.HardWraps = true
+	compile()
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Invalidations: 2}, cache.Stats())
+
+	// results are kept between runs along with the attachment manifest
+	cache, err = NewCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Cache = cache
+
+	compile()
+	assert.Equal(t, CacheStats{Hits: 1}, cache.Stats())
+
+	for _, stats := range []CacheStats{{Hits: 1, Misses: 1}, {Hits: 2, Misses: 1}} {
+		result, err := Compile(
+			[]byte("![diagram](diagram.png)\n"),
+			lib,
+			CompileOptions{Cache: cache, BaseDir: dir},
+		)
+		if assert.NoError(t, err) && assert.Len(t, result.Attachments, 1) {
+			assert.Equal(t, stats, cache.Stats())
+			assert.Equal(
+				t,
+				[]AttachmentFile{{
+					SourcePath: filepath.Join(dir, "diagram.png"),
+					Filename:   result.Attachments[0].Filename,
+				}},
+				result.Attachments,
+			)
+		}
+	}
+}
+
+func TestCache_Title(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte("# Hello\n\nBody.\n")
+
+	// titles aren't written into HTML, but they're a part of the result
+	for _, test := range []struct {
+		prefix string
+		suffix string
+		title  string
+		stats  CacheStats
+	}{
+		{"[A] ", "", "[A] Hello", CacheStats{Misses: 1}},
+		{"[B] ", "", "[B] Hello", CacheStats{Misses: 2}},
+		{"[B] ", " (old)", "[B] Hello (old)", CacheStats{Misses: 3}},
+		{"[B] ", " (old)", "[B] Hello (old)", CacheStats{Hits: 1, Misses: 3}},
+	} {
+		result, err := Compile(markdown, lib, CompileOptions{
+			Cache:       cache,
+			TitlePrefix: test.prefix,
+			TitleSuffix: test.suffix,
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.title, result.Title)
+			assert.Equal(t, test.stats, cache.Stats())
+		}
+	}
+}
+
+func TestCache_Attachments(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"page.md":     "![diagram](diagram.png)\n",
+		"diagram.png": "png",
+	})
+
+	cache, err := NewCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		path    = filepath.Join(dir, "page.md")
+		diagram = filepath.Join(dir, "diagram.png")
+		opts    = CompileOptions{Cache: cache}
+	)
+
+	compile := func() *CompileResult {
+		t.Helper()
+
+		markdown, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts.File = path
+		opts.FileExists = FileExistsOnDisk
+
+		result, err := Compile(markdown, lib, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return result
+	}
+
+	compile()
+	compile()
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, cache.Stats())
+
+	// changes of attachments invalidate results
+	err = ioutil.WriteFile(diagram, []byte("changed png"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Minute)
+
+	err = os.Chtimes(diagram, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compile()
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Invalidations: 1}, cache.Stats())
+
+	compile()
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1, Invalidations: 1}, cache.Stats())
+
+	// and so do removals, which are reported by the manifest
+	err = os.Remove(diagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := compile()
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1, Invalidations: 2}, cache.Stats())
+
+	if assert.Len(t, result.Attachments, 1) {
+		assert.True(t, errors.Is(result.Attachments[0].Err, ErrAttachmentNotFound))
+	}
+}
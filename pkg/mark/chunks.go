@@ -0,0 +1,205 @@
+package mark
+
+import (
+	"bytes"
+	"regexp"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+)
+
+var (
+	// reReferenceDefinition matches first lines of reference link
+	// definitions like [id]: https://example.com "title", footnotes are not
+	// references.
+	reReferenceDefinition = regexp.MustCompile(`^ {0,3}\[[^\]^][^\]]*\]:[ \t]*\S`)
+
+	// reReferenceTitle matches titles of reference link definitions written
+	// on the next line.
+	reReferenceTitle = regexp.MustCompile(`^[ \t]+["'(].*["')][ \t]*$`)
+
+	// reFootnoteDefinition matches definitions of footnotes, which are
+	// numbered and listed for the whole document.
+	reFootnoteDefinition = regexp.MustCompile(`(?m)^ {0,3}\[\^[^\]]+\]:`)
+
+	reHeadingLine = regexp.MustCompile(`^#{1,6}(?:[ \t]|$)`)
+
+	reHTMLBlockTag = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9-]*)(?:[\s/>]|$)`)
+)
+
+// markdownChunk is the part of markdown which is parsed separately, start is
+// the offset of the part in the whole markdown.
+type markdownChunk struct {
+	start    int
+	markdown []byte
+}
+
+// splitMarkdownChunks splits markdown into chunks of at least size bytes
+// before top-level headings which follow blank lines, so every chunk
+// consists of whole blocks. Headings in fenced code blocks and HTML blocks,
+// including raw Confluence macros, are not used for splitting.
+//
+// Markdown is not split if it's not longer than size, if footnotes are
+// defined or if the table of contents is rendered, since they need the
+// whole document.
+func splitMarkdownChunks(markdown []byte, size int, opts CompileOptions) []markdownChunk {
+	whole := []markdownChunk{{start: 0, markdown: markdown}}
+
+	if size <= 0 || len(markdown) <= size ||
+		opts.getHTMLFlags()&bf.TOC != 0 ||
+		(opts.getExtensions()&bf.Footnotes != 0 &&
+			reFootnoteDefinition.Match(markdown)) {
+		return whole
+	}
+
+	var (
+		chunks  = []markdownChunk{}
+		start   = 0
+		blank   = true
+		scanner = blockScanner{}
+	)
+
+	for offset := 0; offset < len(markdown); {
+		line, next := readLine(markdown, offset)
+
+		if scanner.outside(line) && blank && offset-start >= size &&
+			reHeadingLine.Match(line) {
+			chunks = append(chunks, markdownChunk{
+				start:    start,
+				markdown: markdown[start:offset],
+			})
+
+			start = offset
+		}
+
+		blank = len(bytes.TrimSpace(line)) == 0
+
+		offset = next
+	}
+
+	if len(chunks) == 0 {
+		return whole
+	}
+
+	return append(chunks, markdownChunk{
+		start:    start,
+		markdown: markdown[start:],
+	})
+}
+
+// blockScanner tracks fenced code blocks and HTML blocks, including raw
+// Confluence macros, while markdown is read line by line.
+type blockScanner struct {
+	fence []byte
+
+	// tag and depth of the HTML block the line belongs to
+	tag      []byte
+	depth    int
+	patterns map[string]*regexp.Regexp
+}
+
+// outside reports whether the line is outside of code and HTML blocks and
+// doesn't start one.
+func (scanner *blockScanner) outside(line []byte) bool {
+	switch {
+	case scanner.fence != nil:
+		if bytes.HasPrefix(bytes.TrimSpace(line), scanner.fence) {
+			scanner.fence = nil
+		}
+
+	case scanner.tag != nil:
+		scanner.depth += getHTMLTagDepth(line, scanner.tag, scanner.patterns)
+		if scanner.depth <= 0 {
+			scanner.tag = nil
+		}
+
+	case getCodeFence(line) != nil:
+		scanner.fence = getCodeFence(line)
+
+	case reHTMLBlockTag.Match(line):
+		if scanner.patterns == nil {
+			scanner.patterns = map[string]*regexp.Regexp{}
+		}
+
+		name := reHTMLBlockTag.FindSubmatch(line)[1]
+
+		scanner.depth = getHTMLTagDepth(line, name, scanner.patterns)
+		if scanner.depth > 0 {
+			scanner.tag = name
+		}
+
+	default:
+		return true
+	}
+
+	return false
+}
+
+// getHTMLTagDepth returns the number of opened minus the number of closed
+// tags with given name in the line, self-closing tags are not counted.
+func getHTMLTagDepth(
+	line []byte,
+	name []byte,
+	patterns map[string]*regexp.Regexp,
+) int {
+	pattern, ok := patterns[string(name)]
+	if !ok {
+		pattern = regexp.MustCompile(
+			`<(/?)` + regexp.QuoteMeta(string(name)) + `\b[^>]*?(/?)>`,
+		)
+
+		patterns[string(name)] = pattern
+	}
+
+	depth := 0
+
+	for _, groups := range pattern.FindAllSubmatch(line, -1) {
+		switch {
+		case len(groups[2]) > 0:
+		case len(groups[1]) > 0:
+			depth--
+		default:
+			depth++
+		}
+	}
+
+	return depth
+}
+
+// getReferenceDefinitions returns lines of reference link definitions found
+// outside of code blocks, so they can be appended to every chunk.
+func getReferenceDefinitions(markdown []byte) []byte {
+	var (
+		definitions []byte
+		fence       []byte
+		title       = false
+	)
+
+	for offset := 0; offset < len(markdown); {
+		line, next := readLine(markdown, offset)
+
+		switch {
+		case fence != nil:
+			if bytes.HasPrefix(bytes.TrimSpace(line), fence) {
+				fence = nil
+			}
+
+		case getCodeFence(line) != nil:
+			fence = getCodeFence(line)
+
+		case reReferenceDefinition.Match(line),
+			title && reReferenceTitle.Match(line):
+			definitions = append(definitions, line...)
+			definitions = append(definitions, '\n')
+
+			title = reReferenceDefinition.Match(line)
+			offset = next
+
+			continue
+		}
+
+		title = false
+		offset = next
+	}
+
+	return definitions
+}
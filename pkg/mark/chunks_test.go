@@ -0,0 +1,245 @@
+package mark
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitMarkdownChunks(t *testing.T) {
+	markdown := []byte(text(
+		`# A`,
+		``,
+		"```",
+		``,
+		`# code`,
+		"```",
+		``,
+		`<div>`,
+		``,
+		`# html`,
+		``,
+		`</div>`,
+		``,
+		`<ac:structured-macro ac:name="toc"/>`,
+		``,
+		`# B`,
+		`text`,
+		`# no blank line`,
+		``,
+		`    # indented`,
+		``,
+		`## C`,
+		``,
+	))
+
+	starts := func(chunks []markdownChunk) []string {
+		lines := []string{}
+		for _, chunk := range chunks {
+			line, _ := readLine(chunk.markdown, 0)
+
+			assert.Equal(t, markdown[chunk.start:chunk.start+len(line)], line)
+
+			lines = append(lines, string(line))
+		}
+
+		return lines
+	}
+
+	assert.Equal(
+		t,
+		[]string{`# A`, `# B`, `## C`},
+		starts(splitMarkdownChunks(markdown, 1, CompileOptions{})),
+	)
+
+	assert.Equal(
+		t,
+		[]string{`# A`, `## C`},
+		starts(splitMarkdownChunks(markdown, 100, CompileOptions{})),
+	)
+
+	assert.Equal(
+		t,
+		[]string{`# A`},
+		starts(splitMarkdownChunks(markdown, 0, CompileOptions{})),
+	)
+
+	assert.Equal(
+		t,
+		[]string{`# A`},
+		starts(splitMarkdownChunks(
+			append(markdown, "[^1]: footnote\n"...),
+			1,
+			CompileOptions{},
+		)),
+	)
+}
+
+func TestGetReferenceDefinitions(t *testing.T) {
+	assert.Equal(
+		t,
+		text(
+			`[a]: https://a.example.com`,
+			`[b]: https://b.example.com`,
+			`  "B"`,
+			`  [c]: <https://c.example.com> 'C'`,
+			``,
+		),
+		string(getReferenceDefinitions([]byte(text(
+			`[a]: https://a.example.com`,
+			`[b]: https://b.example.com`,
+			`  "B"`,
+			`  "not a title"`,
+			``,
+			"```",
+			`[code]: https://example.com`,
+			"```",
+			``,
+			`[^1]: footnote`,
+			`    [indented]: https://example.com`,
+			`  [c]: <https://c.example.com> 'C'`,
+		)))),
+	)
+}
+
+func TestCompileMarkdown_Chunks(t *testing.T) {
+	testcases, err := filepath.Glob("testdata/*.md")
+	if err != nil {
+		panic(err)
+	}
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	chunks := 0
+
+	for _, filename := range testcases {
+		source, err := ioutil.ReadFile(filename)
+		if err != nil {
+			panic(err)
+		}
+
+		var markdown bytes.Buffer
+
+		markdown.WriteString("# Title\n\n# Start\n\nSee [the end][end].\n\n")
+		markdown.Write(source)
+		markdown.WriteString("\n\n# Start\n\n[Start][start]\n\n# End\n\n")
+		markdown.WriteString("[end]: #end \"End\"\n[start]: #start\n")
+
+		chunks += len(splitMarkdownChunks(markdown.Bytes(), 1, CompileOptions{}))
+
+		for _, opts := range []CompileOptions{
+			{},
+			{TitleFromH1: TitleFromH1Drop, ResolvedComments: []string{"1"}},
+		} {
+			expected, err := CompileMarkdownWithOptions(markdown.Bytes(), lib, opts)
+			assert.NoError(t, err, filename)
+
+			opts.ChunkSize = 1
+
+			actual, err := CompileMarkdownWithOptions(markdown.Bytes(), lib, opts)
+			assert.NoError(t, err, filename)
+
+			assert.Equal(t, expected, actual, filename)
+			assert.Contains(t, actual, `<a href="#end" title="End">the end</a>`, filename)
+			assert.Contains(t, actual, `<h1 id="start-1">Start</h1>`, filename)
+		}
+	}
+
+	assert.Greater(t, chunks, len(testcases)*2)
+}
+
+func TestCompile_ChunksDiagnostics(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(
+		[]byte(text(
+			"# A",
+			"",
+			"text <!--comment_id='a b'-->commented<!---->",
+			"",
+			"# B",
+			"",
+			"text <!--comment_id='c d'-->commented<!---->",
+		)),
+		lib,
+		CompileOptions{File: "page.md", ChunkSize: 1, Logger: &testLogger{}},
+	)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]Diagnostic{
+			{
+				File:     "page.md",
+				Line:     3,
+				Severity: SeverityWarning,
+				Message:  `invalid inline comment id "a b", dropping comment marker`,
+			},
+			{
+				File:     "page.md",
+				Line:     7,
+				Severity: SeverityWarning,
+				Message:  `invalid inline comment id "c d", dropping comment marker`,
+			},
+		},
+		result.Diagnostics,
+	)
+}
+
+// TestCompileMarkdown_MemoryCeiling compiles 20MB document in chunks of 1MB
+// and checks that the heap grows by less than 256MB while compiling. The
+// same document takes about 1.2GB of heap without chunks, which is mostly
+// the tree of the whole document.
+func TestCompileMarkdown_MemoryCeiling(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling 20MB document takes a while")
+	}
+
+	var markdown bytes.Buffer
+	for markdown.Len() < 20<<20 {
+		for _, name := range []string{"admonitions", "codes", "lists", "table", "tags"} {
+			source, err := ioutil.ReadFile("testdata/" + name + ".md")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			markdown.Write(source)
+			markdown.WriteString("\n\n# Section\n\n")
+		}
+	}
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stats runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+
+	// heap reserved from the system only grows, so it's the peak
+	before := stats.HeapSys
+
+	err = CompileMarkdownTo(
+		ioutil.Discard,
+		markdown.Bytes(),
+		lib,
+		CompileOptions{ChunkSize: 1 << 20},
+	)
+	assert.NoError(t, err)
+
+	runtime.ReadMemStats(&stats)
+
+	assert.Less(t, stats.HeapSys-before, uint64(256<<20))
+}
@@ -0,0 +1,543 @@
+package mark
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+)
+
+// Inline comments are written in markdown as a pair of HTML comments around
+// the commented text:
+//
+//	<!--comment_id='d8d970d4-eecf-4169-b74d-0f08e0ce77ea'-->anything<!---->
+//
+// and are rendered as:
+//
+//	<span class="inline-comment-marker" data-ref="d8d970d4-...">anything</span>
+var (
+	reInlineCommentStart = regexp.MustCompile(
+		`^<!--[^>]*comment_id='(?P<comment_id>[^']*)'[^>]*-->$`,
+	)
+	reInlineCommentEnd = regexp.MustCompile(`^<!--\s*-->$`)
+
+	// Confluence uses UUIDs as comment ids, but let's be less strict here
+	// and allow any dash-separated alphanumeric sequence.
+	reInlineCommentID = regexp.MustCompile(`^[0-9A-Za-z]+(-[0-9A-Za-z]+)*$`)
+
+	reStructuredMacroTag = regexp.MustCompile(
+		`^<(/?)ac(?::|` + colonPlaceholder + `)structured-macro\b[^>]*?(/?)>$`,
+	)
+)
+
+type inlineCommentMarker struct {
+	ID      string
+	Closing bool
+
+	// Strip is set when marker should be removed from the output while
+	// keeping commented text.
+	Strip bool
+}
+
+// markInlineComments walks through the document tree and pairs inline comment
+// start and end markers. Markers are paired only when both of them are found
+// within the same block (paragraph, heading, table cell and so on), so
+// comment body can span several lines and inline elements, but never leaks
+// into the following blocks. Unpaired markers are left as is.
+//
+// When markers are placed at different nesting levels, e.g. comment starts
+// in the middle of bold text and ends after it, markers are moved outwards,
+// so the comment wraps whole inline elements instead of breaking them.
+//
+// Markers of comments listed in resolved are marked to be stripped, markers
+// with malformed comment id are stripped as well and reported using warn.
+func markInlineComments(
+	document *bf.Node,
+	resolved []string,
+	warn func(node *bf.Node, format string, args ...interface{}),
+) map[*bf.Node]inlineCommentMarker {
+	var (
+		markers = map[*bf.Node]inlineCommentMarker{}
+		opened  = map[*bf.Node][]*bf.Node{}
+		pairs   = [][2]*bf.Node{}
+		strip   = map[string]bool{}
+
+		// depth of raw structured macros per block, markers placed inside
+		// macro bodies are never touched
+		macros = map[*bf.Node]int{}
+	)
+
+	for _, id := range resolved {
+		strip[id] = true
+	}
+
+	document.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if !entering || node.Type != bf.HTMLSpan {
+			return bf.GoToNext
+		}
+
+		block := getInlineCommentBlock(node)
+
+		if groups := findStructuredMacroTag(node.Literal); groups != nil {
+			switch {
+			case len(groups[2]) > 0:
+			case len(groups[1]) > 0:
+				macros[block]--
+			default:
+				macros[block]++
+			}
+
+			return bf.GoToNext
+		}
+
+		if macros[block] > 0 {
+			return bf.GoToNext
+		}
+
+		parsed, ok := parseInlineCommentMarker(node.Literal)
+		if !ok {
+			return bf.GoToNext
+		}
+
+		if !parsed.Closing {
+			if parsed.Strip {
+				warn(
+					node,
+					"invalid inline comment id %q, dropping comment marker",
+					parsed.ID,
+				)
+			}
+
+			if strip[parsed.ID] {
+				parsed.Strip = true
+			}
+
+			opened[block] = append(opened[block], node)
+
+			markers[node] = parsed
+
+			return bf.GoToNext
+		}
+
+		starts := opened[block]
+		if len(starts) == 0 {
+			return bf.GoToNext
+		}
+
+		opened[block] = starts[:len(starts)-1]
+
+		pairs = append(pairs, [2]*bf.Node{starts[len(starts)-1], node})
+
+		marker := markers[starts[len(starts)-1]]
+		marker.Closing = true
+
+		markers[node] = marker
+
+		return bf.GoToNext
+	})
+
+	for _, starts := range opened {
+		for _, start := range starts {
+			delete(markers, start)
+		}
+	}
+
+	// tree can't be modified while walking through it
+	for _, pair := range pairs {
+		alignInlineCommentMarkers(pair[0], pair[1])
+
+		if block := getInlineCommentBlock(pair[0]); block.Type == bf.Heading {
+			block.HeadingID = dropInlineCommentAnchor(
+				block.HeadingID,
+				pair[0].Literal,
+			)
+		}
+	}
+
+	return markers
+}
+
+// findStructuredMacroTag matches reStructuredMacroTag against the literal,
+// skipping literals which can't be the tag without running regexp.
+func findStructuredMacroTag(literal []byte) [][]byte {
+	if !bytes.HasPrefix(literal, []byte(`<ac`)) &&
+		!bytes.HasPrefix(literal, []byte(`</ac`)) {
+		return nil
+	}
+
+	return reStructuredMacroTag.FindSubmatch(literal)
+}
+
+// dropInlineCommentAnchor removes part of automatically generated heading id
+// which came from the inline comment marker, so commenting heading doesn't
+// change its anchor.
+func dropInlineCommentAnchor(id string, marker []byte) string {
+	anchor := bf.SanitizedAnchorName(string(marker))
+	if anchor == "" || !strings.Contains(id, anchor) {
+		return id
+	}
+
+	id = strings.Replace(id, anchor, "", 1)
+	id = strings.ReplaceAll(id, "--", "-")
+
+	return strings.Trim(id, "-")
+}
+
+// parseInlineCommentMarker parses given HTML comment as inline comment start
+// or end marker. Start markers with malformed comment id are marked to be
+// stripped from the output.
+func parseInlineCommentMarker(literal []byte) (inlineCommentMarker, bool) {
+	if !bytes.HasPrefix(literal, []byte(`<!--`)) {
+		return inlineCommentMarker{}, false
+	}
+
+	if reInlineCommentEnd.Match(literal) {
+		return inlineCommentMarker{Closing: true}, true
+	}
+
+	groups := reInlineCommentStart.FindSubmatch(literal)
+	if groups == nil {
+		return inlineCommentMarker{}, false
+	}
+
+	marker := inlineCommentMarker{ID: string(groups[1])}
+
+	if !reInlineCommentID.MatchString(marker.ID) {
+		marker.Strip = true
+	}
+
+	return marker, true
+}
+
+// alignInlineCommentMarkers moves start and end markers to the same parent
+// node, extending commented region to whole inline elements.
+func alignInlineCommentMarkers(start, end *bf.Node) {
+	if start.Parent == end.Parent {
+		return
+	}
+
+	ancestors := map[*bf.Node]bool{}
+	for node := start.Parent; node != nil; node = node.Parent {
+		ancestors[node] = true
+	}
+
+	parent := end.Parent
+	for !ancestors[parent] {
+		parent = parent.Parent
+	}
+
+	before := start
+	for before.Parent != parent {
+		before = before.Parent
+	}
+
+	after := end
+	for after.Parent != parent {
+		after = after.Parent
+	}
+
+	if before != start {
+		start.Unlink()
+		before.InsertBefore(start)
+	}
+
+	if after != end {
+		end.Unlink()
+		if after.Next != nil {
+			after.Next.InsertBefore(end)
+		} else {
+			parent.AppendChild(end)
+		}
+	}
+}
+
+func getInlineCommentBlock(node *bf.Node) *bf.Node {
+	for node.Parent != nil {
+		node = node.Parent
+
+		switch node.Type {
+		case bf.Emph, bf.Strong, bf.Del, bf.Link, bf.Image:
+			continue
+		}
+
+		return node
+	}
+
+	return node
+}
+
+func (marker inlineCommentMarker) String() string {
+	if marker.Strip {
+		return ``
+	}
+
+	if marker.Closing {
+		return `</span>`
+	}
+
+	return fmt.Sprintf(
+		`<span class="inline-comment-marker" data-ref="%s">`,
+		escape.EscapeAttr(marker.ID),
+	)
+}
+
+// InlineComment describes inline comment found in markdown source.
+type InlineComment struct {
+	ID string
+
+	// Text is the commented markdown source, without markers.
+	Text string
+
+	// Start and End are byte offsets of the commented text in the source.
+	Start int
+	End   int
+
+	// StartLine and EndLine are 1-based line numbers of the commented text.
+	StartLine int
+	EndLine   int
+
+	// offsets of the start and end markers themselves
+	open  [2]int
+	close [2]int
+}
+
+// ExtractInlineComments returns inline comments found in given markdown in
+// order of their appearance. It follows the same rules as CompileMarkdown:
+// markers are paired only within the same block and markers inside code
+// blocks and code spans are ignored.
+func ExtractInlineComments(markdown []byte) []InlineComment {
+	comments := []InlineComment{}
+
+	for _, comment := range parseInlineComments(markdown) {
+		if comment.ID != "" {
+			comments = append(comments, comment)
+		}
+	}
+
+	return comments
+}
+
+// parseInlineComments pairs inline comment markers in the document tree
+// parsed from markdown, the same way as CompileMarkdown does, and locates
+// them in the source. Comments with invalid ids are returned with empty ID.
+func parseInlineComments(markdown []byte) []InlineComment {
+	var (
+		document = ParseDocument(markdown, CompileOptions{})
+		state    = newCompilation(CompileOptions{}, nil)
+		locator  = &blackfridayLocator{markdown: markdown, state: state}
+		offsets  = map[*bf.Node]int{}
+	)
+
+	state.index(markdown)
+
+	// markers are moved while pairing, so nodes are located beforehand
+	Walk(document, func(node *bf.Node) bf.WalkStatus {
+		if offset := locator.locateOffset(node); offset >= 0 {
+			offsets[node] = offset
+		}
+
+		return bf.GoToNext
+	})
+
+	markers := markInlineComments(
+		document,
+		nil,
+		func(*bf.Node, string, ...interface{}) {},
+	)
+
+	nodes := []*bf.Node{}
+	for node := range markers {
+		if _, ok := offsets[node]; ok {
+			nodes = append(nodes, node)
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return offsets[nodes[i]] < offsets[nodes[j]]
+	})
+
+	var (
+		comments = []InlineComment{}
+		opened   = []InlineComment{}
+	)
+
+	// markers are paired within blocks, so pairs never overlap
+	for _, node := range nodes {
+		var (
+			marker = markers[node]
+			start  = offsets[node]
+			end    = start + len(node.Literal)
+		)
+
+		if !marker.Closing {
+			id := marker.ID
+			if marker.Strip {
+				id = ""
+			}
+
+			opened = append(opened, InlineComment{
+				ID:        id,
+				Start:     end,
+				StartLine: state.line(start),
+				open:      [2]int{start, end},
+			})
+
+			continue
+		}
+
+		if len(opened) == 0 {
+			continue
+		}
+
+		comment := opened[len(opened)-1]
+		opened = opened[:len(opened)-1]
+
+		comment.End = start
+		comment.EndLine = state.line(start)
+		comment.close = [2]int{start, end}
+		comment.Text = string(markdown[comment.Start:comment.End])
+
+		comments = append(comments, comment)
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Start < comments[j].Start
+	})
+
+	return comments
+}
+
+// getCodeFence returns fence marker if given line opens fenced code block.
+func getCodeFence(line []byte) []byte {
+	line = bytes.TrimSpace(line)
+
+	for _, char := range []byte{'`', '~'} {
+		size := 0
+		for size < len(line) && line[size] == char {
+			size++
+		}
+
+		if size >= 3 {
+			return line[:size]
+		}
+	}
+
+	return nil
+}
+
+// skipCodeSpan returns length of code span starting at the beginning of
+// given line or length of backticks sequence if the span is not closed.
+func skipCodeSpan(line []byte) int {
+	size := 0
+	for size < len(line) && line[size] == '`' {
+		size++
+	}
+
+	ticks := line[:size]
+
+	for i := size; i < len(line); {
+		if line[i] != '`' {
+			i++
+			continue
+		}
+
+		run := 0
+		for i+run < len(line) && line[i+run] == '`' {
+			run++
+		}
+
+		if run == len(ticks) {
+			return i + run
+		}
+
+		i += run
+	}
+
+	return size
+}
+
+// StripInlineComments removes markers of inline comments with given ids from
+// markdown source, keeping commented text. All inline comments are stripped
+// if ids is nil.
+func StripInlineComments(markdown []byte, ids []string) []byte {
+	strip := map[string]bool{}
+	for _, id := range ids {
+		strip[id] = true
+	}
+
+	markers := [][2]int{}
+	for _, comment := range parseInlineComments(markdown) {
+		if ids == nil || strip[comment.ID] {
+			markers = append(markers, comment.open, comment.close)
+		}
+	}
+
+	if len(markers) == 0 {
+		return markdown
+	}
+
+	sort.Slice(markers, func(i, j int) bool {
+		return markers[i][0] < markers[j][0]
+	})
+
+	var (
+		buffer bytes.Buffer
+		offset int
+	)
+
+	buffer.Grow(len(markdown))
+
+	for _, marker := range markers {
+		buffer.Write(markdown[offset:marker[0]])
+		offset = marker[1]
+	}
+
+	buffer.Write(markdown[offset:])
+
+	return buffer.Bytes()
+}
+
+// convertInlineComment converts inline comment markers of both storage and
+// view formats back into the pair of HTML comments:
+//
+//	<ac:inline-comment-marker ac:ref="d8d970d4-...">anything</ac:inline-comment-marker>
+//	<span class="inline-comment-marker" data-ref="d8d970d4-...">anything</span>
+//
+// Whitespace around the commented text is moved out of the markers.
+func (conversion *conversion) convertInlineComment(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	var id string
+
+	if goquery.NodeName(selec) == "span" {
+		if !selec.HasClass("inline-comment-marker") {
+			return nil
+		}
+
+		id = selec.AttrOr("data-ref", "")
+	} else {
+		id = selec.AttrOr("ac:ref", "")
+	}
+
+	text := strings.TrimSpace(content)
+	if text == "" || !reInlineCommentID.MatchString(id) {
+		return &content
+	}
+
+	var (
+		leading  = content[:strings.Index(content, text)]
+		trailing = content[len(leading)+len(text):]
+	)
+
+	return md.String(
+		leading + "<!--comment_id='" + id + "'-->" + text + "<!---->" + trailing,
+	)
+}
@@ -0,0 +1,198 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func compileTestMarkdown(t *testing.T, markdown string) string {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html, err := CompileMarkdown([]byte(markdown), lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return html
+}
+
+func TestCompileMarkdown_InlineCommentInvalidID(t *testing.T) {
+	actual := compileTestMarkdown(
+		t,
+		`a <!--comment_id='abc" onmouseover="x'-->commented<!----> text`,
+	)
+
+	assert.Equal(t, "<p>a commented text</p>\n", actual)
+}
+
+func TestExtractInlineComments(t *testing.T) {
+	markdown := text(
+		"<!--comment_id='a1'-->first<!----> and <!--comment_id='b2'-->second<!---->",
+		"",
+		"<!--comment_id='c3'-->spans",
+		"two lines<!---->",
+		"",
+		"```",
+		"<!--comment_id='d4'-->in code<!---->",
+		"```",
+		"",
+		"`<!--comment_id='e5'-->in span<!---->`",
+		"",
+		`<ac:structured-macro ac:name="info"><ac:rich-text-body>`+
+			"<!--comment_id='f6'-->in macro<!---->"+
+			`</ac:rich-text-body></ac:structured-macro>`,
+	)
+
+	comments := ExtractInlineComments([]byte(markdown))
+
+	if !assert.Len(t, comments, 3) {
+		return
+	}
+
+	assert.Equal(t, "a1", comments[0].ID)
+	assert.Equal(t, "first", comments[0].Text)
+	assert.Equal(t, 22, comments[0].Start)
+	assert.Equal(t, 27, comments[0].End)
+	assert.Equal(t, 1, comments[0].StartLine)
+	assert.Equal(t, 1, comments[0].EndLine)
+
+	assert.Equal(t, "b2", comments[1].ID)
+	assert.Equal(t, "second", comments[1].Text)
+
+	assert.Equal(t, "c3", comments[2].ID)
+	assert.Equal(t, "spans\ntwo lines", comments[2].Text)
+	assert.Equal(t, 3, comments[2].StartLine)
+	assert.Equal(t, 4, comments[2].EndLine)
+	assert.Equal(
+		t,
+		comments[2].Text,
+		markdown[comments[2].Start:comments[2].End],
+	)
+}
+
+func TestExtractInlineComments_Blocks(t *testing.T) {
+	markdown := text(
+		"- a <!--comment_id='a'-->x",
+		"- b y<!---->",
+		"",
+		"# Heading <!--comment_id='b'-->x",
+		"paragraph<!---->",
+		"",
+		"    <!--comment_id='c'-->in code<!---->",
+		"",
+		"<!--comment_id='c'-->in code<!---->",
+	)
+
+	comments := ExtractInlineComments([]byte(markdown))
+
+	// markers in code blocks are neither paired nor confused with ones which
+	// follow them
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, "c", comments[0].ID)
+		assert.Equal(t, 9, comments[0].StartLine)
+		assert.Equal(
+			t,
+			"in code",
+			markdown[comments[0].Start:comments[0].End],
+		)
+	}
+
+	assert.Equal(
+		t,
+		text(
+			"- a <!--comment_id='a'-->x",
+			"- b y<!---->",
+			"",
+			"# Heading <!--comment_id='b'-->x",
+			"paragraph<!---->",
+			"",
+			"    <!--comment_id='c'-->in code<!---->",
+			"",
+			"in code",
+		),
+		string(StripInlineComments([]byte(markdown), nil)),
+	)
+}
+
+func TestCompileMarkdownWithOptions_ResolvedComments(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := CompileMarkdownWithOptions(
+		[]byte(
+			"<!--comment_id='a1'-->resolved<!----> and "+
+				"<!--comment_id='b2'-->open<!---->",
+		),
+		lib,
+		CompileOptions{ResolvedComments: []string{"a1"}},
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(
+		t,
+		`<p>resolved and <span class="inline-comment-marker" data-ref="b2">open</span></p>`+NL,
+		actual,
+	)
+}
+
+func TestStripInlineComments(t *testing.T) {
+	markdown := []byte(text(
+		"<!--comment_id='a1'-->first<!----> and <!--comment_id='b2'-->second<!---->",
+		"",
+		"```",
+		"<!--comment_id='a1'-->in code<!---->",
+		"```",
+	))
+
+	assert.Equal(
+		t,
+		text(
+			"first and <!--comment_id='b2'-->second<!---->",
+			"",
+			"```",
+			"<!--comment_id='a1'-->in code<!---->",
+			"```",
+		),
+		string(StripInlineComments(markdown, []string{"a1"})),
+	)
+
+	assert.Equal(
+		t,
+		text(
+			"first and second",
+			"",
+			"```",
+			"<!--comment_id='a1'-->in code<!---->",
+			"```",
+		),
+		string(StripInlineComments(markdown, nil)),
+	)
+
+	assert.Equal(
+		t,
+		string(markdown),
+		string(StripInlineComments(markdown, []string{})),
+	)
+}
+
+func TestCompileMarkdown_InlineCommentsWithIdenticalText(t *testing.T) {
+	actual := compileTestMarkdown(
+		t,
+		"<!--comment_id='a1'-->same<!----> and <!--comment_id='b2'-->same<!---->",
+	)
+
+	assert.Equal(
+		t,
+		`<p><span class="inline-comment-marker" data-ref="a1">same</span>`+
+			` and `+
+			`<span class="inline-comment-marker" data-ref="b2">same</span></p>`+NL,
+		actual,
+	)
+}
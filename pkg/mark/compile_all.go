@@ -0,0 +1,165 @@
+package mark
+
+import (
+	"context"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+)
+
+// Document is a markdown document to be compiled by CompileAll.
+type Document struct {
+	// Name identifies the document in errors and diagnostics, e.g. file
+	// name. It's used as Options.File if that is not set.
+	Name     string
+	Markdown []byte
+
+	// Stdlib can be shared by documents, see stdlib.Lib.
+	Stdlib *stdlib.Lib
+
+	// Options are used for this document only, Options.Context is replaced
+	// with the context passed to CompileAll.
+	Options CompileOptions
+}
+
+// Result is a result of the document compiling.
+type Result struct {
+	Name        string
+	HTML        string
+	Diagnostics []Diagnostic
+	Attachments []AttachmentFile
+	ContentHash string
+
+	// Err is set if the document can't be compiled.
+	Err error
+}
+
+// CompileAll compiles documents concurrently using given number of
+// workers. Results are returned in the same order as documents. A document
+// which can't be compiled doesn't stop compiling of other documents, errors
+// of all such documents are returned together. Documents which are not
+// compiled yet when the context is done get context error, which is
+// returned as well.
+//
+// Compiling doesn't change anything shared by documents, so the same
+// stdlib.Lib and options can be used by all of them, as long as callbacks
+// of the options, e.g. LinkResolver and Logger, are safe for concurrent
+// use.
+//
+// Links between documents, which are named by their paths, are checked by
+// CheckLinks, and broken ones are reported as errors of documents compiled
+// in strict mode.
+func CompileAll(
+	ctx context.Context,
+	docs []Document,
+	workers int,
+) ([]Result, error) {
+	results := make([]Result, len(docs))
+
+	err := runWorkers(
+		ctx,
+		len(docs),
+		workers,
+		func(index int) {
+			results[index] = compileDocument(ctx, docs[index])
+		},
+		func(index int, err error) {
+			results[index] = Result{Name: docs[index].Name, Err: err}
+		},
+	)
+	if err != nil {
+		return results, err
+	}
+
+	checkDocumentLinks(docs, results)
+
+	return results, pushWorkerErrors(
+		"unable to compile some of the documents",
+		"document",
+		len(results),
+		func(index int) (string, error) {
+			return results[index].Name, results[index].Err
+		},
+	)
+}
+
+// checkDocumentLinks reports links between documents broken according to
+// CheckLinks as errors of documents compiled in strict mode.
+func checkDocumentLinks(docs []Document, results []Result) {
+	var (
+		markdowns = map[string][]byte{}
+		strict    = false
+	)
+
+	for _, doc := range docs {
+		strict = strict || doc.Options.Strict
+
+		if _, ok := markdowns[doc.Name]; ok {
+			continue
+		}
+
+		markdowns[doc.Name] = doc.Markdown
+	}
+
+	// documents are parsed once more, which is not needed otherwise
+	if !strict {
+		return
+	}
+
+	reportDocumentLinks(docs, results, CheckLinks(markdowns))
+}
+
+// reportDocumentLinks reports issues of links between documents as errors
+// of documents compiled in strict mode.
+func reportDocumentLinks(docs []Document, results []Result, issues []LinkIssue) {
+	indexes := map[string]int{}
+	for index, doc := range docs {
+		if _, ok := indexes[doc.Name]; !ok {
+			indexes[doc.Name] = index
+		}
+	}
+
+	for _, issue := range issues {
+		index := indexes[issue.Source]
+
+		opts := docs[index].Options
+		if !opts.Strict {
+			continue
+		}
+
+		if opts.File == "" {
+			opts.File = docs[index].Name
+		}
+
+		state := newCompilation(opts, opts.Sources)
+		state.report(issue.Line, SeverityError, issue.Message)
+
+		result := &results[index]
+		result.Diagnostics = append(result.Diagnostics, state.diagnostics...)
+
+		// other errors are kept, since diagnostics are returned anyway
+		if _, ok := result.Err.(*StrictError); ok || result.Err == nil {
+			result.Err = &StrictError{Diagnostics: result.Diagnostics}
+		}
+	}
+}
+
+func compileDocument(ctx context.Context, doc Document) Result {
+	result := Result{Name: doc.Name}
+
+	opts := doc.Options
+	opts.Context = ctx
+
+	if opts.File == "" {
+		opts.File = doc.Name
+	}
+
+	compiled, err := Compile(doc.Markdown, doc.Stdlib, opts)
+
+	result.HTML = compiled.HTML
+	result.Diagnostics = compiled.Diagnostics
+	result.Attachments = compiled.Attachments
+	result.ContentHash = compiled.ContentHash
+	result.Err = err
+
+	return result
+}
@@ -0,0 +1,178 @@
+package mark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileAll(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases, err := filepath.Glob("testdata/*.md")
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		docs     = []Document{}
+		expected = []string{}
+	)
+
+	for i := 0; len(docs) < 100; i++ {
+		filename := testcases[i%len(testcases)]
+
+		markdown, err := ioutil.ReadFile(filename)
+		if err != nil {
+			panic(err)
+		}
+
+		opts := CompileOptions{Logger: &testLogger{}}
+		if i%2 == 1 {
+			opts.Engine = EngineGoldmark
+		}
+
+		html, err := CompileMarkdownWithOptions(markdown, lib, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// loggers are not shared between documents
+		opts.Logger = &testLogger{}
+
+		docs = append(docs, Document{
+			Name:     fmt.Sprintf("%d-%s", i, filepath.Base(filename)),
+			Markdown: markdown,
+			Stdlib:   lib,
+			Options:  opts,
+		})
+
+		expected = append(expected, html)
+	}
+
+	docs = append(docs, Document{
+		Name:     "invalid.md",
+		Markdown: []byte("<ac:rich-text-body>"),
+		Stdlib:   lib,
+		Options:  CompileOptions{Validate: true},
+	})
+
+	var group sync.WaitGroup
+
+	// templates can be registered while documents are compiled
+	group.Add(1)
+	go func() {
+		defer group.Done()
+
+		for i := 0; i < 10; i++ {
+			lib.MustAddTemplate(fmt.Sprintf("custom:%d", i), "{{ .Text }}")
+			lib.ListTemplates()
+		}
+	}()
+
+	results, err := CompileAll(context.Background(), docs, 8)
+
+	group.Wait()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "document: invalid.md")
+	assert.Len(t, results, len(docs))
+
+	for i, html := range expected {
+		assert.Equal(t, docs[i].Name, results[i].Name)
+		assert.NoError(t, results[i].Err, docs[i].Name)
+		assert.Equal(t, html, results[i].HTML, docs[i].Name)
+	}
+
+	invalid := results[len(results)-1]
+
+	var storage *StorageError
+	assert.True(t, errors.As(invalid.Err, &storage))
+	assert.Len(t, invalid.Diagnostics, 1)
+	assert.Equal(t, "invalid.md", invalid.Diagnostics[0].File)
+}
+
+func TestCompileAll_Context(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	docs := []Document{
+		{Name: "a.md", Markdown: []byte("a"), Stdlib: lib},
+		{Name: "b.md", Markdown: []byte("b"), Stdlib: lib},
+	}
+
+	results, err := CompileAll(ctx, docs, 1)
+	assert.Equal(t, context.Canceled, err)
+
+	for _, result := range results {
+		assert.Equal(t, context.Canceled, result.Err)
+		assert.Equal(t, "", strings.TrimSpace(result.HTML))
+	}
+}
+
+func TestCompileAll_Links(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := func(destination string) (string, bool) {
+		return "/display/DOC/" + strings.TrimSuffix(destination, ".md"), true
+	}
+
+	docs := []Document{
+		{
+			Name:     "index.md",
+			Markdown: []byte("# Index\n\nSee [guide](guide.md) and [old](old.md).\n"),
+			Stdlib:   lib,
+			Options: CompileOptions{
+				Strict:       true,
+				DefaultSpace: "DOC",
+				LinkResolver: resolver,
+			},
+		},
+		{
+			Name:     "guide.md",
+			Markdown: []byte("# Guide\n\n[Back](index.md#missing)\n"),
+			Stdlib:   lib,
+			Options:  CompileOptions{LinkResolver: resolver},
+		},
+	}
+
+	results, err := CompileAll(context.Background(), docs, 2)
+	assert.Error(t, err)
+
+	var strict *StrictError
+	if assert.True(t, errors.As(results[0].Err, &strict)) {
+		assert.Equal(
+			t,
+			[]Diagnostic{{
+				File:     "index.md",
+				Line:     3,
+				Severity: SeverityError,
+				Message:  `link to "old.md": document "old.md" is not found`,
+			}},
+			strict.Diagnostics,
+		)
+	}
+
+	// links of documents compiled in non-strict mode are not checked
+	assert.NoError(t, results[1].Err)
+	assert.Empty(t, results[1].Diagnostics)
+}
@@ -0,0 +1,269 @@
+package mark
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/reconquest/karma-go"
+)
+
+// MaxIncludeDepth is the maximum nesting of markdown includes.
+const MaxIncludeDepth = 16
+
+// <!-- Include: <markdown path> -->
+// <!-- excerpt-from: <markdown path> -->
+//
+// Includes of templates have another extension or data, so they are kept
+// for includes.ProcessIncludes.
+var reMarkdownInclude = regexp.MustCompile(
+	`<!--\s*(Include|excerpt-from):\s*(\S+\.(?:md|markdown))\s*-->`,
+)
+
+const directiveExcerptFrom = "excerpt-from"
+
+// CompileMarkdownFile compiles markdown file like CompileMarkdownWithOptions
+// does. Metadata header of the file is dropped, and markdown include
+// directives are replaced with contents of included files, which paths are
+// relative to the including file:
+//
+//	<!-- Include: relative/path.md -->
+//
+// Paths starting with slash are relative to RootDir, and paths of the file
+// itself are relative to BaseDir if these options are set, see
+// CompileOptions.RootDir.
+//
+// Metadata header and leading H1 heading of included files are dropped as
+// well. Excerpt directives include only the excerpt region of the file,
+// it's an error if the file has none:
+//
+//	<!-- excerpt-from: ../platform/oncall.md -->
+//
+// where the region of oncall.md is wrapped into markers:
+//
+//	<!-- excerpt -->
+//	...
+//	<!-- endexcerpt -->
+//
+// Included files can include other files up to MaxIncludeDepth, but
+// not the files which include them.
+//
+// Includes of templates and macros are not processed. File and Sources
+// options are set to the file, so diagnostics of included content refer to
+// included files. Meta option is set to metadata of the file if it's not
+// set, so its Var headers are substituted, and FileExists option is set to
+// FileExistsOnDisk if it's not set. Metadata of the file is merged with
+// DefaultsFile files of its directory and parent directories up to RootDir,
+// see ReadDefaultMeta.
+func CompileMarkdownFile(
+	path string,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) (string, error) {
+	markdown, sources, meta, err := readMarkdownFile(path, []string{}, false, opts)
+	if err != nil {
+		return "", err
+	}
+
+	opts.File = path
+	opts.Sources = sources
+
+	if opts.Meta == nil {
+		defaults, err := ReadDefaultMeta(filepath.Dir(path), opts.RootDir)
+		if err != nil {
+			return "", err
+		}
+
+		opts.Meta = MergeMeta(defaults, meta)
+	}
+
+	if opts.FileExists == nil {
+		opts.FileExists = FileExistsOnDisk
+	}
+
+	return CompileMarkdownWithOptions(markdown, stdlib, opts)
+}
+
+// FileExistsOnDisk reports whether the file exists in the file system.
+func FileExistsOnDisk(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+// readMarkdownFile reads markdown file with included files and its metadata,
+// chain lists files which include it.
+func readMarkdownFile(
+	path string,
+	chain []string,
+	included bool,
+	opts CompileOptions,
+) ([]byte, *sourcemap.Map, *Meta, error) {
+	// chain is copied, since it's shared by files included by the same file
+	chain = append(chain[:len(chain):len(chain)], path)
+
+	markdown, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, karma.
+			Describe("chain", strings.Join(chain, " -> ")).
+			Format(err, "unable to read markdown file")
+	}
+
+	sources := sourcemap.New(markdown)
+
+	meta, body, err := ExtractMeta(markdown)
+	if err != nil {
+		return nil, nil, nil, karma.Describe("file", path).Format(
+			err,
+			"unable to extract metadata",
+		)
+	}
+
+	sources.Replace(markdown, 0, len(markdown)-len(body), nil)
+	markdown = body
+
+	if included {
+		if title, ok := findDocumentLeadingH1(markdown); ok {
+			sources.Replace(markdown, title.Start, title.End, nil)
+			markdown = dropDocumentTitle(markdown, title)
+		}
+	}
+
+	// includes of the compiled file are relative to BaseDir, if it's set
+	base := filepath.Dir(path)
+	if !included && opts.BaseDir != "" {
+		base = opts.BaseDir
+	}
+
+	markdown, err = includeMarkdownFiles(markdown, sources, chain, base, opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return markdown, sources, meta, nil
+}
+
+// includeMarkdownFiles replaces markdown include directives with contents
+// of included files.
+func includeMarkdownFiles(
+	markdown []byte,
+	sources *sourcemap.Map,
+	chain []string,
+	base string,
+	opts CompileOptions,
+) ([]byte, error) {
+	var (
+		path    = chain[len(chain)-1]
+		matches = reMarkdownInclude.FindAllSubmatchIndex(markdown, -1)
+	)
+
+	if len(matches) == 0 {
+		return markdown, nil
+	}
+
+	type include struct {
+		path     string
+		markdown []byte
+		sources  *sourcemap.Map
+	}
+
+	var (
+		includes = make([]include, len(matches))
+		result   = make([]byte, 0, len(markdown))
+		offset   = 0
+	)
+
+	for index, match := range matches {
+		var (
+			directive = string(markdown[match[2]:match[3]])
+
+			name = string(markdown[match[4]:match[5]])
+			line = sources.Line(bytes.Count(markdown[:match[0]], []byte("\n")) + 1)
+
+			facts = karma.
+				Describe("source", fmt.Sprintf("%s:%d", path, line)).
+				Describe("include", name)
+		)
+
+		target, ok, err := resolveReference(name, base, opts)
+		if err != nil {
+			return nil, facts.Format(err, "unable to include markdown file")
+		}
+
+		if !ok {
+			target = filepath.Join(base, name)
+		}
+
+		if len(chain) > MaxIncludeDepth {
+			return nil, facts.
+				Describe("chain", strings.Join(append(chain, target), " -> ")).
+				Format(
+					nil,
+					"includes are nested deeper than %d levels",
+					MaxIncludeDepth,
+				)
+		}
+
+		for _, parent := range chain {
+			if getAbsolutePath(parent) == getAbsolutePath(target) {
+				return nil, facts.
+					Describe("chain", strings.Join(append(chain, target), " -> ")).
+					Format(nil, "include cycle detected")
+			}
+		}
+
+		contents, included, _, err := readMarkdownFile(target, chain, true, opts)
+		if err != nil {
+			return nil, facts.Format(err, "unable to include markdown file")
+		}
+
+		if directive == directiveExcerptFrom {
+			contents, included, err = getExcerpt(contents, included)
+			if err != nil {
+				return nil, facts.Format(err, "unable to include excerpt of markdown file")
+			}
+		}
+
+		includes[index] = include{
+			path:     target,
+			markdown: contents,
+			sources:  included,
+		}
+
+		result = append(result, markdown[offset:match[0]]...)
+		result = append(result, contents...)
+
+		offset = match[1]
+	}
+
+	result = append(result, markdown[offset:]...)
+
+	// offsets of the following directives are kept by replacing from the end
+	for index := len(matches) - 1; index >= 0; index-- {
+		sources.Include(
+			markdown,
+			matches[index][0],
+			matches[index][1],
+			includes[index].markdown,
+			includes[index].sources,
+			includes[index].path,
+		)
+	}
+
+	return result, nil
+}
+
+func getAbsolutePath(path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+
+	return absolute
+}
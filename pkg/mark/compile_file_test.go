@@ -0,0 +1,283 @@
+package mark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMarkdownFiles(t testing.TB, files map[string]string) string {
+	dir := t.TempDir()
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(path, []byte(contents), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestCompileMarkdownFile(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"page.md": text(
+			`<!-- Space: DOC -->`,
+			`<!-- Title: Page -->`,
+			``,
+			`# Page`,
+			``,
+			`<!-- Include: shared/footer.md -->`,
+			``,
+			`end`,
+			``,
+		),
+		"shared/footer.md": text(
+			`<!-- Title: Footer -->`,
+			``,
+			`# Footer`,
+			``,
+			`support`,
+			``,
+			`<!-- Include: glossary.md -->`,
+			``,
+		),
+		"shared/glossary.md": text(
+			`Glossary`,
+			`========`,
+			``,
+			`**term**`,
+			``,
+		),
+	})
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		html, err := CompileMarkdownFile(
+			filepath.Join(dir, "page.md"),
+			lib,
+			CompileOptions{Engine: engine},
+		)
+		assert.NoError(t, err, engine)
+		assert.Equal(
+			t,
+			normalizeBlocksBreaks(text(
+				`<h1 id="page">Page</h1>`,
+				`<p>support</p>`,
+				`<p><strong>term</strong></p>`,
+				`<p>end</p>`,
+				``,
+			)),
+			normalizeBlocksBreaks(html),
+			engine,
+		)
+	}
+}
+
+func TestCompileMarkdownFile_Diagnostics(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"page.md": text(
+			`<!-- Title: Page -->`,
+			``,
+			`<!-- Include: footer.md -->`,
+			``,
+			`a <!--comment_id='a b'-->commented<!----> text`,
+			``,
+		),
+		"footer.md": text(
+			`# Footer`,
+			``,
+			`first`,
+			``,
+			`b <!--comment_id='c d'-->commented<!----> text`,
+			``,
+		),
+	})
+
+	path := filepath.Join(dir, "page.md")
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		logger := &testLogger{}
+
+		_, err = CompileMarkdownFile(
+			path,
+			lib,
+			CompileOptions{Engine: engine, Logger: logger},
+		)
+		assert.NoError(t, err, engine)
+		assert.Equal(
+			t,
+			[]string{
+				fmt.Sprintf(
+					`warning: %s:5: invalid inline comment id "c d", dropping comment marker`,
+					filepath.Join(dir, "footer.md"),
+				),
+				fmt.Sprintf(
+					`warning: %s:5: invalid inline comment id "a b", dropping comment marker`,
+					path,
+				),
+			},
+			logger.messages,
+			engine,
+		)
+	}
+}
+
+func TestCompileMarkdownFile_Errors(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"a.md":       "a\n\n<!-- Include: b.md -->\n",
+		"b.md":       "b\n\n<!-- Include: a.md -->\n",
+		"missing.md": "a\n\n<!-- Include: unknown.md -->\n",
+		"self.md":    "a\n\n<!-- Include: self.md -->\n",
+	})
+
+	_, err = CompileMarkdownFile(filepath.Join(dir, "a.md"), lib, CompileOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle detected")
+	assert.Contains(
+		t,
+		err.Error(),
+		strings.Join([]string{
+			filepath.Join(dir, "a.md"),
+			filepath.Join(dir, "b.md"),
+			filepath.Join(dir, "a.md"),
+		}, " -> "),
+	)
+	assert.Contains(t, err.Error(), filepath.Join(dir, "b.md")+":3")
+
+	_, err = CompileMarkdownFile(filepath.Join(dir, "missing.md"), lib, CompileOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to read markdown file")
+
+	_, err = CompileMarkdownFile(filepath.Join(dir, "self.md"), lib, CompileOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle detected")
+
+	files := map[string]string{}
+	for level := 0; level <= MaxIncludeDepth+1; level++ {
+		files[fmt.Sprintf("%d.md", level)] = fmt.Sprintf(
+			"%d\n\n<!-- Include: %d.md -->\n",
+			level,
+			level+1,
+		)
+	}
+
+	dir = writeMarkdownFiles(t, files)
+
+	_, err = CompileMarkdownFile(filepath.Join(dir, "0.md"), lib, CompileOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "includes are nested deeper than 16 levels")
+}
+
+func TestCompileMarkdownFile_Excerpt(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"docs/page.md": text(
+			`<!-- Space: DOC -->`,
+			``,
+			`On-call:`,
+			``,
+			`<!-- excerpt-from: ../platform/oncall.md -->`,
+			``,
+			`end`,
+			``,
+		),
+		"platform/oncall.md": text(
+			`<!-- Space: OPS -->`,
+			``,
+			`# On-call`,
+			``,
+			`intro`,
+			``,
+			`<!-- excerpt -->`,
+			`Page the **on-call** engineer.`,
+			``,
+			`![pager](images/pager.png)`,
+			`<!-- endexcerpt -->`,
+			``,
+			`details`,
+			``,
+		),
+		"platform/images/pager.png": "pager",
+		"docs/none.md":              "a\n\n<!-- excerpt-from: ../platform/empty.md -->\n",
+		"platform/empty.md":         "# Empty\n\nno excerpt\n",
+		"docs/cycle.md":             "<!-- excerpt -->\n\n<!-- excerpt-from: cycle.md -->\n\n<!-- endexcerpt -->\n",
+	})
+
+	path := filepath.Join(dir, "docs", "page.md")
+
+	markdown, sources, meta, err := readMarkdownFile(path, []string{}, false, CompileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(markdown, lib, CompileOptions{
+		File:       path,
+		Sources:    sources,
+		Meta:       meta,
+		FileExists: FileExistsOnDisk,
+	})
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		normalizeBlocksBreaks(text(
+			`<p>On-call:</p>`,
+			`<p>Page the <strong>on-call</strong> engineer.</p>`,
+			`<p><img src="images/pager.png" alt="pager" /></p>`,
+			`<p>end</p>`,
+			``,
+		)),
+		normalizeBlocksBreaks(result.HTML),
+	)
+
+	// images are resolved against the directory of the pulled file
+	assert.Equal(
+		t,
+		[]AttachmentFile{{
+			SourcePath: filepath.Join(dir, "platform", "images", "pager.png"),
+			Filename:   getAttachmentFilename("../platform/images/pager.png"),
+		}},
+		result.Attachments,
+	)
+	assert.Empty(t, result.Diagnostics)
+
+	_, err = CompileMarkdownFile(filepath.Join(dir, "docs", "none.md"), lib, CompileOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to include excerpt of markdown file")
+	assert.Contains(t, err.Error(), ErrNoExcerpt.Error())
+
+	_, err = CompileMarkdownFile(filepath.Join(dir, "docs", "cycle.md"), lib, CompileOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle detected")
+}
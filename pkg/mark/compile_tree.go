@@ -0,0 +1,329 @@
+package mark
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/reconquest/karma-go"
+)
+
+// IgnoreFile is the name of the file of patterns of files and directories,
+// which CompileTree skips, see CompileOptions.Ignore.
+const IgnoreFile = ".markignore"
+
+// TreeResult is the result of compiling the directory by CompileTree.
+type TreeResult struct {
+	// Files are results of markdown files named by their slash-separated
+	// paths relative to the root, e.g. guides/deploy.md, in order of
+	// paths.
+	Files []Result
+
+	// Diagnostics are diagnostics of all files in order of Files.
+	Diagnostics []Diagnostic
+
+	// LinkIssues are broken links between files found by CheckLinks,
+	// which are checked whether files are compiled in strict mode or not.
+	LinkIssues []LinkIssue
+}
+
+// CompileTree walks markdown files of the root directory and compiles them
+// concurrently by given number of workers like CompileAll does: a file which
+// can't be read or compiled doesn't stop compiling of other files, errors of
+// all such files are returned together along with the result.
+//
+// Files are compiled like CompileMarkdownFile does with given options, so
+// their metadata is merged with DefaultsFile files of their directories up
+// to the root, and built-in templates are used. Directories starting with a
+// dot are skipped, so are files and directories matching patterns of
+// CompileOptions.Ignore and IgnoreFile files. Each line of IgnoreFile is the
+// pattern of path.Match relative to the directory of the file, or the pattern
+// of names if it has no slash, patterns ending with slash match directories
+// only, and lines starting with # are comments:
+//
+//	# drafts are not published
+//	drafts/
+//	*.draft.md
+//
+// Walking stops if the context is done, which error is returned then.
+func CompileTree(
+	ctx context.Context,
+	root string,
+	opts CompileOptions,
+	workers int,
+) (TreeResult, error) {
+	var result TreeResult
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		return result, err
+	}
+
+	files, err := walkTree(ctx, root, opts.Ignore)
+	if err != nil {
+		return result, err
+	}
+
+	var (
+		docs    = make([]Document, len(files))
+		results = make([]Result, len(files))
+
+		defaults = map[string]*Meta{}
+		mutex    sync.Mutex
+	)
+
+	// defaults are read once per directory and shared by its files
+	readDefaults := func(dir string) (*Meta, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if meta, ok := defaults[dir]; ok {
+			return meta, nil
+		}
+
+		meta, err := ReadDefaultMeta(dir, root)
+		if err != nil {
+			return nil, err
+		}
+
+		defaults[dir] = meta
+
+		return meta, nil
+	}
+
+	err = runWorkers(
+		ctx,
+		len(files),
+		workers,
+		func(index int) {
+			doc, err := readTreeDocument(root, files[index], lib, opts, readDefaults)
+			if err != nil {
+				results[index] = Result{Name: doc.Name, Err: err}
+			} else {
+				results[index] = compileDocument(ctx, doc)
+			}
+
+			docs[index] = doc
+		},
+		func(index int, err error) {
+			results[index] = Result{Name: filepath.ToSlash(files[index]), Err: err}
+		},
+	)
+	if err != nil {
+		result.Files = results
+
+		return result, err
+	}
+
+	markdowns := map[string][]byte{}
+	for _, doc := range docs {
+		markdowns[doc.Name] = doc.Markdown
+	}
+
+	result.LinkIssues = CheckLinks(markdowns)
+
+	reportDocumentLinks(docs, results, result.LinkIssues)
+
+	for _, file := range results {
+		result.Diagnostics = append(result.Diagnostics, file.Diagnostics...)
+	}
+
+	result.Files = results
+
+	return result, pushWorkerErrors(
+		"unable to compile some of the files",
+		"file",
+		len(results),
+		func(index int) (string, error) {
+			return results[index].Name, results[index].Err
+		},
+	)
+}
+
+// readTreeDocument reads the markdown file of the tree into the document.
+func readTreeDocument(
+	root string,
+	file string,
+	lib *stdlib.Lib,
+	opts CompileOptions,
+	readDefaults func(dir string) (*Meta, error),
+) (Document, error) {
+	var (
+		doc  = Document{Name: filepath.ToSlash(file), Stdlib: lib}
+		path = filepath.Join(root, file)
+	)
+
+	markdown, sources, meta, err := readMarkdownFile(path, []string{}, false, opts)
+	if err != nil {
+		return doc, err
+	}
+
+	if opts.Meta == nil {
+		defaults, err := readDefaults(filepath.Dir(path))
+		if err != nil {
+			return doc, err
+		}
+
+		opts.Meta = MergeMeta(defaults, meta)
+	}
+
+	opts.File = path
+	opts.Sources = sources
+
+	if opts.FileExists == nil {
+		opts.FileExists = FileExistsOnDisk
+	}
+
+	doc.Markdown = markdown
+	doc.Options = opts
+
+	return doc, nil
+}
+
+// walkTree returns markdown files of the root directory relative to it,
+// sorted by slash-separated paths, see CompileTree.
+func walkTree(ctx context.Context, root string, ignore []string) ([]string, error) {
+	var (
+		files    = []string{}
+		patterns = map[string][]string{".": ignore}
+	)
+
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+
+		relative = filepath.ToSlash(relative)
+
+		if relative != "." && isTreeIgnored(patterns, relative, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if entry.IsDir() {
+			if relative != "." && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			own, err := readIgnoreFile(filepath.Join(file, IgnoreFile))
+			if err != nil {
+				return err
+			}
+
+			patterns[relative] = append(patterns[relative], own...)
+
+			return nil
+		}
+
+		if !strings.EqualFold(filepath.Ext(file), ".md") ||
+			entry.Name() == DefaultsFile {
+			return nil
+		}
+
+		files = append(files, relative)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	for index, file := range files {
+		files[index] = filepath.FromSlash(file)
+	}
+
+	return files, nil
+}
+
+// isTreeIgnored returns true if the slash-separated path relative to the
+// root matches patterns of any of directories it's in.
+func isTreeIgnored(patterns map[string][]string, relative string, dir bool) bool {
+	for base := path.Dir(relative); ; base = path.Dir(base) {
+		name := relative
+		if base != "." {
+			name = strings.TrimPrefix(relative, base+"/")
+		}
+
+		for _, pattern := range patterns[base] {
+			if matchIgnorePattern(pattern, name, dir) {
+				return true
+			}
+		}
+
+		if base == "." {
+			return false
+		}
+	}
+}
+
+// matchIgnorePattern returns true if the slash-separated path relative to
+// the directory of the pattern matches it, see CompileTree.
+func matchIgnorePattern(pattern string, name string, dir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !dir {
+			return false
+		}
+
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+
+	matched, err := path.Match(pattern, name)
+
+	return err == nil && matched
+}
+
+// readIgnoreFile returns patterns of the ignore file, there are none if the
+// file doesn't exist.
+func readIgnoreFile(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, karma.Format(err, "unable to read ignore file: %s", file)
+	}
+
+	patterns := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		patterns = append(patterns, line)
+	}
+
+	return patterns, nil
+}
@@ -0,0 +1,178 @@
+package mark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileTree(t *testing.T) {
+	dir := writeMarkdownFiles(t, map[string]string{
+		".markignore":          "# drafts\ndrafts/\n*.draft.md\n",
+		"_defaults.md":         "<!-- Var: team=core -->\n",
+		"index.md":             "# Index\n\n[Deploy](guides/deploy.md) [Missing](missing.md)\n",
+		"guides/deploy.md":     "${team} deploys\n\n<!-- Include: footer.md -->\n",
+		"guides/footer.md":     "[Index](../index.md#unknown)\n",
+		"guides/plan.draft.md": "draft\n",
+		"guides/.markignore":   "footer.md\n",
+		"guides/broken.md":     "broken\n\n<!-- Include: unknown.md -->\n",
+		"drafts/page.md":       "draft\n",
+		".hidden/page.md":      "hidden\n",
+		"notes.md":             "notes\n",
+		"skipped/page.md":      "skipped\n",
+	})
+
+	for _, workers := range []int{0, 1, 4} {
+		result, err := CompileTree(
+			context.Background(),
+			dir,
+			CompileOptions{Ignore: []string{"skipped/"}},
+			workers,
+		)
+		assert.Error(t, err, workers)
+
+		names := []string{}
+		for _, file := range result.Files {
+			names = append(names, file.Name)
+		}
+
+		assert.Equal(
+			t,
+			[]string{"guides/broken.md", "guides/deploy.md", "index.md", "notes.md"},
+			names,
+		)
+
+		if assert.Len(t, result.Files, 4) {
+			// failure of one file doesn't stop others
+			assert.Error(t, result.Files[0].Err)
+			assert.NoError(t, result.Files[1].Err)
+			assert.Contains(t, result.Files[1].HTML, "<p>core deploys</p>")
+			assert.NoError(t, result.Files[3].Err)
+		}
+
+		assert.Equal(
+			t,
+			[]LinkIssue{
+				{
+					Source:  "guides/deploy.md",
+					Line:    3,
+					Target:  "../index.md#unknown",
+					Message: `link to "../index.md#unknown": heading "unknown" is not found in "index.md"`,
+				},
+				{
+					Source:  "index.md",
+					Line:    3,
+					Target:  "missing.md",
+					Message: `link to "missing.md": document "missing.md" is not found`,
+				},
+			},
+			result.LinkIssues,
+			workers,
+		)
+	}
+
+	// broken links fail files compiled in strict mode
+	result, err := CompileTree(
+		context.Background(),
+		dir,
+		CompileOptions{Strict: true, DefaultSpace: "DOC", Ignore: []string{"skipped/"}},
+		2,
+	)
+	assert.Error(t, err)
+
+	if assert.Len(t, result.Files, 4) {
+		var strict *StrictError
+
+		assert.True(t, errors.As(result.Files[2].Err, &strict))
+		assert.NoError(t, result.Files[3].Err)
+		assert.Equal(t, "<p>notes</p>\n", result.Files[3].HTML)
+	}
+
+	assert.Contains(
+		t,
+		result.Diagnostics,
+		Diagnostic{
+			File:     filepath.Join(dir, "index.md"),
+			Line:     3,
+			Severity: SeverityError,
+			Message:  `link to "missing.md": document "missing.md" is not found`,
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = CompileTree(ctx, dir, CompileOptions{}, 2)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func BenchmarkCompileTree(b *testing.B) {
+	files := map[string]string{}
+	for i := 0; i < 64; i++ {
+		files[fmt.Sprintf("dir%d/page%d.md", i%8, i)] = text(
+			fmt.Sprintf("# Page %d", i),
+			"",
+			"Some **text** with [link](https://example.com).",
+			"",
+			"```go",
+			"func main() {}",
+			"```",
+			"",
+			"| a | b |",
+			"|---|---|",
+			"| 1 | 2 |",
+		)
+	}
+
+	dir := writeMarkdownFiles(b, files)
+
+	b.Run("serial", func(b *testing.B) {
+		lib, err := stdlib.New(nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		paths, err := walkTree(context.Background(), dir, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		// the loop compiling files one by one and checking links between
+		// them afterwards, what CompileTree does concurrently
+		for i := 0; i < b.N; i++ {
+			markdowns := map[string][]byte{}
+
+			for _, path := range paths {
+				markdown, err := ioutil.ReadFile(filepath.Join(dir, path))
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				_, err = CompileMarkdownFile(filepath.Join(dir, path), lib, CompileOptions{RootDir: dir})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				markdowns[filepath.ToSlash(path)] = markdown
+			}
+
+			CheckLinks(markdowns)
+		}
+	})
+
+	b.Run("tree", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := CompileTree(context.Background(), dir, CompileOptions{}, runtime.NumCPU())
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
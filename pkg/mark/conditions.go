@@ -0,0 +1,330 @@
+package mark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+)
+
+// <!-- if: <condition> -->
+// ...
+// <!-- endif -->
+var reConditionMarker = regexp.MustCompile(
+	`<!--\s*(?:if:\s*(.*?)|(endif))\s*-->`,
+)
+
+var reConditionToken = regexp.MustCompile(
+	`"(?:[^"\\]|\\.)*"|'[^']*'|==|!=|!|\[|\]|,|[^\s"'\[\],!=]+`,
+)
+
+// getConditionValues returns values which conditions of sections are
+// evaluated against: effective variables and space of the document, see
+// CompileOptions.DefaultSpace.
+func (opts CompileOptions) getConditionValues() map[string]string {
+	values := map[string]string{}
+	if space := opts.getSpace(); space != "" {
+		values["space"] = space
+	}
+
+	for name, value := range opts.getVars() {
+		values[name] = value
+	}
+
+	return values
+}
+
+// processConditions removes conditional sections, which conditions are not
+// met, and markers of the rest sections. Markers in code are kept as is.
+// Markers which are the only content of their lines are removed with
+// lines. Sections are removed in place if inPlace is true.
+func processConditions(
+	markdown []byte,
+	sources *sourcemap.Map,
+	values map[string]string,
+	inPlace bool,
+) ([]byte, *sourcemap.Map, []sourceIssue) {
+	if !reConditionMarker.Match(markdown) {
+		return markdown, sources, nil
+	}
+
+	type section struct {
+		start int
+		line  int
+		met   bool
+	}
+
+	var (
+		sections = []section{}
+		removes  = [][2]int{}
+		issues   = []sourceIssue{}
+
+		// unmet is the number of opened sections, which conditions are not
+		// met, nothing is removed inside of them
+		unmet = 0
+	)
+
+	scanMarkdown(markdown, func(start, end, line int, code bool) {
+		if code {
+			return
+		}
+
+		matches := reConditionMarker.FindAllSubmatchIndex(markdown[start:end], -1)
+		for _, match := range matches {
+			var (
+				from = start + match[0]
+				to   = start + match[1]
+			)
+
+			if match[4] >= 0 {
+				if len(sections) == 0 {
+					issues = append(issues, sourceIssue{
+						line:    line,
+						message: "endif without if",
+					})
+
+					continue
+				}
+
+				section := sections[len(sections)-1]
+				sections = sections[:len(sections)-1]
+
+				switch {
+				case !section.met:
+					unmet--
+
+					if unmet == 0 {
+						removes = append(removes, [2]int{section.start, to})
+					}
+
+				case unmet == 0:
+					removes = append(removes, [2]int{from, to})
+				}
+
+				continue
+			}
+
+			condition := string(markdown[start+match[2] : start+match[3]])
+
+			met, err := evaluateCondition(condition, values)
+			if err != nil {
+				issues = append(issues, sourceIssue{
+					line:    line,
+					message: fmt.Sprintf("invalid condition %q: %s", condition, err),
+				})
+			}
+
+			if met && unmet == 0 {
+				removes = append(removes, [2]int{from, to})
+			}
+
+			if !met {
+				unmet++
+			}
+
+			sections = append(sections, section{start: from, line: line, met: met})
+		}
+	})
+
+	for _, section := range sections {
+		issues = append(issues, sourceIssue{
+			line:    section.line,
+			message: "if without endif",
+		})
+	}
+
+	if len(issues) > 0 {
+		return markdown, sources, issues
+	}
+
+	if len(removes) == 0 {
+		return markdown, sources, nil
+	}
+
+	for index, remove := range removes {
+		removes[index][0], removes[index][1] = expandToLines(
+			markdown,
+			remove[0],
+			remove[1],
+		)
+	}
+
+	sources = sources.Clone()
+	if sources == nil {
+		sources = sourcemap.New(markdown)
+	}
+
+	for index := len(removes) - 1; index >= 0; index-- {
+		sources.Replace(markdown, removes[index][0], removes[index][1], nil)
+	}
+
+	var (
+		result = markdown[:0]
+		copied = 0
+	)
+
+	if !inPlace {
+		result = make([]byte, 0, len(markdown))
+	}
+
+	for _, remove := range removes {
+		result = append(result, markdown[copied:remove[0]]...)
+		copied = remove[1]
+	}
+
+	result = append(result, markdown[copied:]...)
+
+	return result, sources, nil
+}
+
+// expandToLines expands the range to whole lines with trailing line break if
+// there is nothing but whitespace before and after it on its lines.
+func expandToLines(markdown []byte, start, end int) (int, int) {
+	first := bytes.LastIndexByte(markdown[:start], '\n') + 1
+
+	last := bytes.IndexByte(markdown[end:], '\n')
+	if last < 0 {
+		last = len(markdown)
+	} else {
+		last += end + 1
+	}
+
+	if len(bytes.TrimSpace(markdown[first:start])) > 0 ||
+		len(bytes.TrimSpace(markdown[end:last])) > 0 {
+		return start, end
+	}
+
+	return first, last
+}
+
+// evaluateCondition evaluates condition of the section:
+//
+//	name                  value is not empty and not "false"
+//	!name                 value is empty or "false"
+//	name == "value"       value equals to the given one
+//	name != "value"       value doesn't equal to the given one
+//	name in [a, "b"]      value is one of the given ones
+//	name not in [a, "b"]  value is none of the given ones
+//
+// Values can be quoted, undefined names have empty values.
+func evaluateCondition(condition string, values map[string]string) (bool, error) {
+	var (
+		tokens = []string{}
+		offset = 0
+	)
+
+	for _, match := range reConditionToken.FindAllStringIndex(condition, -1) {
+		if gap := strings.TrimSpace(condition[offset:match[0]]); gap != "" {
+			return false, fmt.Errorf("unexpected %q", gap)
+		}
+
+		tokens = append(tokens, condition[match[0]:match[1]])
+		offset = match[1]
+	}
+
+	if gap := strings.TrimSpace(condition[offset:]); gap != "" {
+		return false, fmt.Errorf("unexpected %q", gap)
+	}
+
+	if len(tokens) == 0 {
+		return false, errors.New("condition is empty")
+	}
+
+	negate := tokens[0] == "!"
+	if negate {
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 0 || !isConditionName(tokens[0]) {
+		return false, errors.New("name expected")
+	}
+
+	value := values[tokens[0]]
+
+	switch {
+	case len(tokens) == 1:
+		return (value != "" && value != "false") != negate, nil
+
+	case negate:
+		return false, errors.New("only names can be negated with !")
+
+	case len(tokens) == 3 && (tokens[1] == "==" || tokens[1] == "!="):
+		operand, err := parseConditionValue(tokens[2])
+		if err != nil {
+			return false, err
+		}
+
+		return (value == operand) == (tokens[1] == "=="), nil
+	}
+
+	tokens = tokens[1:]
+
+	not := tokens[0] == "not"
+	if not {
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) < 3 || tokens[0] != "in" || tokens[1] != "[" ||
+		tokens[len(tokens)-1] != "]" {
+		return false, errors.New("==, != or in [...] expected")
+	}
+
+	list := tokens[2 : len(tokens)-1]
+	if len(list) == 0 {
+		return false, errors.New("values expected in [...]")
+	}
+
+	found := false
+
+	for index, token := range list {
+		if index%2 == 1 {
+			if token != "," {
+				return false, errors.New("values must be separated by commas")
+			}
+
+			continue
+		}
+
+		operand, err := parseConditionValue(token)
+		if err != nil {
+			return false, err
+		}
+
+		found = found || value == operand
+	}
+
+	if len(list)%2 == 0 {
+		return false, errors.New("value expected after comma")
+	}
+
+	return found != not, nil
+}
+
+func isConditionName(token string) bool {
+	switch token {
+	case "==", "!=", "!", "[", "]", ",", "in", "not":
+		return false
+	}
+
+	return !strings.ContainsAny(token[:1], `"'`)
+}
+
+func parseConditionValue(token string) (string, error) {
+	switch {
+	case strings.HasPrefix(token, `"`):
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(
+			token[1 : len(token)-1],
+		), nil
+
+	case strings.HasPrefix(token, `'`):
+		return token[1 : len(token)-1], nil
+
+	case isConditionName(token):
+		return token, nil
+	}
+
+	return "", fmt.Errorf("value expected, got %q", token)
+}
@@ -0,0 +1,219 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateCondition(t *testing.T) {
+	values := map[string]string{
+		"space":   "INTERNAL",
+		"partner": "false",
+		"beta":    "true",
+		"name":    `a "b"`,
+	}
+
+	testcases := []struct {
+		condition string
+		met       bool
+		err       string
+	}{
+		{condition: `space == "INTERNAL"`, met: true},
+		{condition: `space == 'PARTNER'`, met: false},
+		{condition: `space == INTERNAL`, met: true},
+		{condition: `space != "INTERNAL"`, met: false},
+		{condition: `space in ["PARTNER", "INTERNAL"]`, met: true},
+		{condition: `space in [PARTNER]`, met: false},
+		{condition: `space not in ["PARTNER", "PUBLIC"]`, met: true},
+		{condition: `beta`, met: true},
+		{condition: `partner`, met: false},
+		{condition: `!partner`, met: true},
+		{condition: `unknown`, met: false},
+		{condition: `unknown == ""`, met: true},
+		{condition: `name == "a \"b\""`, met: true},
+
+		{condition: ``, err: "condition is empty"},
+		{condition: `space ==`, err: "==, != or in [...] expected"},
+		{condition: `space == "INTERNAL`, err: `unexpected "\""`},
+		{condition: `!space == "INTERNAL"`, err: "only names can be negated with !"},
+		{condition: `"space"`, err: "name expected"},
+		{condition: `space in []`, err: "values expected in [...]"},
+		{condition: `space in [a b]`, err: "values must be separated by commas"},
+		{condition: `space in [a,]`, err: "value expected after comma"},
+	}
+
+	for _, testcase := range testcases {
+		met, err := evaluateCondition(testcase.condition, values)
+		if testcase.err != "" {
+			assert.EqualError(t, err, testcase.err, testcase.condition)
+			continue
+		}
+
+		assert.NoError(t, err, testcase.condition)
+		assert.Equal(t, testcase.met, met, testcase.condition)
+	}
+}
+
+func TestCompileMarkdownWithOptions_Conditions(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`intro`,
+		``,
+		`<!-- if: space == "INTERNAL" -->`,
+		`internal`,
+		``,
+		`<!-- if: partner -->`,
+		`internal partner`,
+		`<!-- endif -->`,
+		``,
+		`<!-- endif -->`,
+		`<!-- if: space != "INTERNAL" -->`,
+		`public`,
+		`<!-- endif -->`,
+		``,
+		"`<!-- if: space == \"NONE\" -->` and a <!-- if: partner -->partner<!-- endif --> word",
+		``,
+		"```",
+		`<!-- if: space == "NONE" -->`,
+		"```",
+		``,
+		`a <!--comment_id='a b'-->commented<!----> text`,
+		``,
+	))
+
+	testcases := []struct {
+		space    string
+		vars     map[string]string
+		expected string
+		warning  string
+	}{
+		{
+			space: "INTERNAL",
+			expected: text(
+				`<p>intro</p>`,
+				`<p>internal</p>`,
+				`<p><code>&lt;!-- if: space == &quot;NONE&quot; --&gt;</code> and a  word</p>`,
+			),
+			warning: `warning: line 21: invalid inline comment id "a b", dropping comment marker`,
+		},
+		{
+			space: "INTERNAL",
+			vars:  map[string]string{"partner": "true"},
+			expected: text(
+				`<p>intro</p>`,
+				`<p>internal</p>`,
+				`<p>internal partner</p>`,
+				`<p><code>&lt;!-- if: space == &quot;NONE&quot; --&gt;</code> and a partner word</p>`,
+			),
+			warning: `warning: line 21: invalid inline comment id "a b", dropping comment marker`,
+		},
+		{
+			space: "PARTNER",
+			vars:  map[string]string{"partner": "true"},
+			expected: text(
+				`<p>intro</p>`,
+				`<p>public</p>`,
+				`<p><code>&lt;!-- if: space == &quot;NONE&quot; --&gt;</code> and a partner word</p>`,
+			),
+			warning: `warning: line 21: invalid inline comment id "a b", dropping comment marker`,
+		},
+	}
+
+	for _, testcase := range testcases {
+		for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+			logger := &testLogger{}
+
+			html, err := CompileMarkdownWithOptions(markdown, lib, CompileOptions{
+				Engine: engine,
+				Meta:   &Meta{Space: testcase.space},
+				Vars:   testcase.vars,
+				Logger: logger,
+			})
+			assert.NoError(t, err)
+			assert.Contains(
+				t,
+				normalizeBlocksBreaks(html),
+				normalizeBlocksBreaks(testcase.expected),
+				engine,
+			)
+			assert.Contains(t, html, `<![CDATA[<!-- if: space == "NONE" -->]]>`)
+			assert.Equal(t, []string{testcase.warning}, logger.messages, engine)
+		}
+	}
+}
+
+func TestCompileMarkdownWithOptions_ConditionsInPlace(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// markdown is copied because of CRLF line breaks, so sections and title
+	// are removed from the copy in place
+	source := "# Title\r\n\r\n<!-- if: beta -->\r\nbeta\r\n<!-- endif -->\r\ntext\r\n"
+	markdown := []byte(source)
+
+	for i := 0; i < 2; i++ {
+		html, err := CompileMarkdownWithOptions(markdown, lib, CompileOptions{
+			TitleFromH1: TitleFromH1Drop,
+			Vars:        map[string]string{"beta": "false"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "<p>text</p>\n", html)
+		assert.Equal(t, source, string(markdown))
+	}
+}
+
+func TestCompile_ConditionsErrors(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(
+		[]byte(text(
+			`<!-- if: space == -->`,
+			`a`,
+			`<!-- endif -->`,
+			`<!-- endif -->`,
+			``,
+			`<!-- if: beta -->`,
+			``,
+		)),
+		lib,
+		CompileOptions{File: "page.md"},
+	)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid condition "space =="`)
+	assert.Contains(t, err.Error(), "source: page.md:1")
+	assert.Equal(
+		t,
+		[]Diagnostic{
+			{
+				File:     "page.md",
+				Line:     1,
+				Severity: SeverityError,
+				Message:  `invalid condition "space ==": ==, != or in [...] expected`,
+			},
+			{
+				File:     "page.md",
+				Line:     4,
+				Severity: SeverityError,
+				Message:  "endif without if",
+			},
+			{
+				File:     "page.md",
+				Line:     6,
+				Severity: SeverityError,
+				Message:  "if without endif",
+			},
+		},
+		result.Diagnostics,
+	)
+}
@@ -0,0 +1,147 @@
+package mark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+)
+
+// contentHashVersion is hashed along with the input, it's bumped whenever
+// the same input is compiled into different output, so pages compiled by
+// older versions are updated.
+const contentHashVersion = 1
+
+// reContentHash matches the comment written by CompileOptions.ContentHash.
+var reContentHash = regexp.MustCompile(
+	`<!--\s*mark:content-hash\s+([0-9a-f]{64})\s*-->`,
+)
+
+// contentHashInput lists options which change the output of compiling.
+// Callbacks such as LinkResolver and Handlers can't be hashed, options
+// affecting only diagnostics, e.g. File and Strict, don't change the output.
+type contentHashInput struct {
+	Version           int
+	Markdown          []byte
+	Templates         string
+	Engine            MarkdownEngine
+	ResolvedComments  []string
+	TitleFromH1       TitleFromH1
+	InlineTOC         InlineTOC
+	WikiLinks         bool
+	ConfluenceURL     string
+	Meta              *Meta
+	DefaultSpace      string
+	Vars              map[string]string
+	KeepUndefinedVars bool
+	Extensions        bf.Extensions
+	HTMLFlags         bf.HTMLFlags
+	HardWraps         bool
+	HeadingIDPrefix   string
+	HeadingIDSuffix   string
+	AbsolutePrefix    string
+	CollapseCode      bool
+	EditorV2          bool
+	StripHTML         bool
+	HTMLTags          [3][]string
+	Locale            string
+	Messages          Catalog
+	HeaderTemplate    string
+	FooterTemplate    string
+	Permalinks        *Permalinks
+	Banner            *banner
+}
+
+// GetContentHash returns the hash which CompileOptions.ContentHash embeds
+// into the output of compiling markdown with given templates and options.
+func GetContentHash(
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) string {
+	input := contentHashInput{
+		Version:           contentHashVersion,
+		Markdown:          markdown,
+		Engine:            opts.Engine,
+		TitleFromH1:       opts.TitleFromH1,
+		InlineTOC:         opts.InlineTOC,
+		WikiLinks:         opts.WikiLinks,
+		ConfluenceURL:     opts.ConfluenceURL,
+		Meta:              opts.Meta,
+		DefaultSpace:      opts.DefaultSpace,
+		Vars:              opts.Vars,
+		KeepUndefinedVars: opts.KeepUndefinedVars,
+		Extensions:        opts.Extensions,
+		HTMLFlags:         opts.HTMLFlags,
+		HardWraps:         opts.HardWraps,
+		HeadingIDPrefix:   opts.HeadingIDPrefix,
+		HeadingIDSuffix:   opts.HeadingIDSuffix,
+		AbsolutePrefix:    opts.AbsolutePrefix,
+		CollapseCode:      opts.CollapseCode,
+		EditorV2:          opts.EditorV2,
+		StripHTML:         opts.StripHTML,
+		Locale:            opts.Locale,
+		Messages:          opts.Messages,
+		HeaderTemplate:    opts.HeaderTemplate,
+		FooterTemplate:    opts.FooterTemplate,
+		Permalinks:        opts.Permalinks,
+	}
+
+	// lists of tags are global, but they change the output of converting
+	// and stripping
+	input.HTMLTags = [3][]string{
+		SafeHTMLTags,
+		ConvertedHTMLTags,
+		StrippedHTMLTags,
+	}
+
+	// banners depend on File, which is not hashed otherwise
+	input.Banner, _ = opts.getBanner()
+
+	if stdlib != nil {
+		input.Templates = stdlib.Checksum()
+	}
+
+	// the version message and minor edits don't change the body, and the
+	// message is likely to differ between versions, e.g. by commits filled
+	// into placeholders
+	if opts.Meta != nil && (opts.Meta.VersionMessage != "" || opts.Meta.MinorEdit) {
+		meta := *opts.Meta
+		meta.VersionMessage = ""
+		meta.MinorEdit = false
+
+		input.Meta = &meta
+	}
+
+	// resolved comments are a set
+	input.ResolvedComments = append([]string{}, opts.ResolvedComments...)
+	sort.Strings(input.ResolvedComments)
+
+	// encoding of structs can't fail, map keys are sorted
+	data, _ := json.Marshal(input)
+
+	checksum := sha256.Sum256(data)
+
+	return hex.EncodeToString(checksum[:])
+}
+
+// ExtractContentHash returns the hash embedded by CompileOptions.ContentHash
+// into the storage format, e.g. body of the page fetched from Confluence.
+// Empty string is returned if there is no hash.
+func ExtractContentHash(storage string) string {
+	matches := reContentHash.FindStringSubmatch(storage)
+	if matches == nil {
+		return ""
+	}
+
+	return matches[1]
+}
+
+func getContentHashComment(hash string) string {
+	return fmt.Sprintf("<!-- mark:content-hash %s -->\n", hash)
+}
@@ -0,0 +1,108 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_ContentHash(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// templates producing random values don't change the hash
+	lib.MustAddTemplate(
+		"ac:code",
+		`<p>{{ uuid }}</p><pre>{{ .Text | cdata }}</pre>`,
+		stdlib.Override,
+	)
+
+	markdown := []byte(text(
+		"# Page",
+		"",
+		"```",
+		"code",
+		"```",
+	))
+
+	opts := CompileOptions{
+		ContentHash:      true,
+		ResolvedComments: []string{"a", "b"},
+		Validate:         true,
+	}
+
+	first, err := Compile(markdown, lib, opts)
+	assert.NoError(t, err)
+
+	second, err := Compile(markdown, lib, opts)
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first.HTML, second.HTML)
+	assert.Len(t, first.ContentHash, 64)
+	assert.Equal(t, first.ContentHash, second.ContentHash)
+	assert.Equal(t, first.ContentHash, GetContentHash(markdown, lib, opts))
+	assert.True(
+		t,
+		strings.HasPrefix(
+			first.HTML,
+			"<!-- mark:content-hash "+first.ContentHash+" -->\n<h1",
+		),
+	)
+
+	// the hash is found anywhere in the page, e.g. within the layout
+	assert.Equal(
+		t,
+		first.ContentHash,
+		ExtractContentHash("<ac:layout><ac:layout-cell>"+first.HTML),
+	)
+	assert.Equal(t, "", ExtractContentHash("<p>page</p>"))
+
+	// order of resolved comments doesn't matter
+	reordered := opts
+	reordered.ResolvedComments = []string{"b", "a"}
+	assert.Equal(t, first.ContentHash, GetContentHash(markdown, lib, reordered))
+
+	// nor options which don't change the output
+	reordered.Strict = true
+	reordered.File = "page.md"
+	reordered.ChunkSize = 1
+	assert.Equal(t, first.ContentHash, GetContentHash(markdown, lib, reordered))
+
+	changed := opts
+	changed.HeadingIDPrefix = "doc-"
+	assert.NotEqual(t, first.ContentHash, GetContentHash(markdown, lib, changed))
+
+	changed = opts
+	changed.Meta = &Meta{Vars: map[string]string{"name": "value"}}
+	assert.NotEqual(t, first.ContentHash, GetContentHash(markdown, lib, changed))
+
+	reordered = opts
+	reordered.Meta = &Meta{Vars: map[string]string{"name": "value"}}
+	hash := GetContentHash(markdown, lib, reordered)
+
+	reordered.Meta.VersionMessage = "Updated at {{ .GitCommit }}"
+	reordered.VersionData = map[string]string{"GitCommit": "1a2b3c"}
+	reordered.Meta.MinorEdit = true
+	assert.Equal(t, hash, GetContentHash(markdown, lib, reordered))
+
+	assert.NotEqual(
+		t,
+		first.ContentHash,
+		GetContentHash(append(markdown, '\n'), lib, opts),
+	)
+
+	lib.MustAddTemplate("ac:code", `<pre>{{ .Text | cdata }}</pre>`, stdlib.Override)
+	assert.NotEqual(t, first.ContentHash, GetContentHash(markdown, lib, opts))
+
+	// nothing is written by default
+	opts.ContentHash = false
+
+	result, err := Compile(markdown, lib, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "", result.ContentHash)
+	assert.Equal(t, "", ExtractContentHash(result.HTML))
+}
@@ -0,0 +1,618 @@
+package mark
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/JohannesKaufmann/html-to-markdown/escape"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/reconquest/karma-go"
+	"golang.org/x/net/html"
+)
+
+// ConvertOption configures conversion of Confluence pages into markdown.
+type ConvertOption func(*convertOptions)
+
+type convertOptions struct {
+	context  context.Context
+	progress func(int64)
+
+	attachmentsPath string
+	attachments     func(string) (string, error)
+	attachmentNames *[]string
+
+	pageLinks func(string, string) (string, bool)
+
+	admonitions AdmonitionStyle
+	expands     ExpandStyle
+
+	users    func(string) (string, bool)
+	mentions MentionStyle
+
+	emoticons EmoticonStyle
+	statuses  StatusStyle
+
+	jira string
+
+	layouts LayoutStyle
+
+	meta        *PageMeta
+	frontMatter bool
+}
+
+// ConvertContext sets context which cancels conversion when done.
+func ConvertContext(ctx context.Context) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.context = ctx
+	}
+}
+
+// ConvertProgress sets callback which is called with total number of input
+// bytes processed so far while reading the page body.
+func ConvertProgress(progress func(processed int64)) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.progress = progress
+	}
+}
+
+// ConvertAttachmentsPath sets directory which attached images are referenced
+// from, "attachments" by default.
+func ConvertAttachmentsPath(path string) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.attachmentsPath = path
+	}
+}
+
+// ConvertAttachments sets callback which is called for every attached image,
+// e.g. to download it. Returned path is used as image reference instead of
+// the path within attachments directory. Conversion fails if callback returns
+// an error.
+func ConvertAttachments(
+	handler func(filename string) (localPath string, err error),
+) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.attachments = handler
+	}
+}
+
+// ConvertAttachmentNames sets slice which filenames of all attachments
+// referenced by the page are stored into after conversion, so they can be
+// downloaded.
+func ConvertAttachmentNames(filenames *[]string) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.attachmentNames = filenames
+	}
+}
+
+// ConvertPageLinks sets callback which resolves links to other pages, e.g.
+// into relative paths of exported markdown files. Page title is used as link
+// if callback returns false.
+func ConvertPageLinks(
+	resolve func(space, title string) (link string, ok bool),
+) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.pageLinks = resolve
+	}
+}
+
+// ConvertAdmonitions sets style of converted info, tip, note and warning
+// macros, AdmonitionAlert by default.
+func ConvertAdmonitions(style AdmonitionStyle) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.admonitions = style
+	}
+}
+
+// ConvertExpands sets style of converted expand macros, ExpandDetails by
+// default.
+func ConvertExpands(style ExpandStyle) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.expands = style
+	}
+}
+
+// ConvertUsers sets callback which resolves display names of mentioned users
+// by their account ids.
+func ConvertUsers(
+	resolve func(accountID string) (name string, ok bool),
+) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.users = resolve
+	}
+}
+
+// ConvertMentions sets style of converted user mentions, MentionPlain by
+// default.
+func ConvertMentions(style MentionStyle) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.mentions = style
+	}
+}
+
+// ConvertEmoticons sets style of converted emoticons, EmoticonShortcode by
+// default.
+func ConvertEmoticons(style EmoticonStyle) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.emoticons = style
+	}
+}
+
+// ConvertStatuses sets style of converted status macros, StatusInclude by
+// default.
+func ConvertStatuses(style StatusStyle) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.statuses = style
+	}
+}
+
+// ConvertJiraURL sets base URL of Jira, which issue links are built with.
+// Issue keys are written as plain text if no URL is set.
+func ConvertJiraURL(url string) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.jira = url
+	}
+}
+
+// ConvertLayouts sets how cells of page layouts are separated, LayoutRule by
+// default.
+func ConvertLayouts(style LayoutStyle) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.layouts = style
+	}
+}
+
+func newConvertOptions(options []ConvertOption) *convertOptions {
+	opts := &convertOptions{
+		context:         context.Background(),
+		attachmentsPath: "attachments",
+		admonitions:     AdmonitionAlert,
+		expands:         ExpandDetails,
+		mentions:        MentionPlain,
+		emoticons:       EmoticonShortcode,
+		statuses:        StatusInclude,
+		layouts:         LayoutRule,
+	}
+
+	for _, option := range options {
+		option(opts)
+	}
+
+	return opts
+}
+
+// progressReader reports number of bytes read and stops reading when
+// context is done.
+type progressReader struct {
+	reader    io.Reader
+	opts      *convertOptions
+	processed int64
+}
+
+func (reader *progressReader) Read(data []byte) (int, error) {
+	if err := reader.opts.context.Err(); err != nil {
+		return 0, err
+	}
+
+	size, err := reader.reader.Read(data)
+
+	reader.processed += int64(size)
+	if reader.opts.progress != nil && size > 0 {
+		reader.opts.progress(reader.processed)
+	}
+
+	return size, err
+}
+
+// conversion holds state of a single page conversion.
+type conversion struct {
+	opts *convertOptions
+
+	// cdata contains CDATA sections cut out from the page body
+	cdata []string
+
+	// blocks contains markdown blocks which must be kept verbatim, e.g. code
+	// blocks; converter output contains placeholders instead of them
+	blocks []string
+
+	// err is the first error occurred while converting, since converter
+	// rules can't return errors
+	err error
+
+	// converter is used by rules which convert parts of the page separately
+	converter *md.Converter
+
+	// attachments contains paths of already handled attachments, since the
+	// same attachment can be converted several times
+	attachments map[string]string
+
+	// filenames contains filenames of handled attachments in order
+	filenames []string
+
+	// anchors maps anchors of the page headings to their slugs
+	anchors map[string]string
+}
+
+func (conversion *conversion) fail(err error) {
+	if conversion.err == nil {
+		conversion.err = err
+	}
+}
+
+func (conversion *conversion) newConverter() *md.Converter {
+	converter := md.NewConverter("", true, nil)
+	converter.Keep("#comment")
+	converter.Remove(
+		"ac:parameter",
+		"ac:task-id",
+		"ac:task-uuid",
+		"ac:task-status",
+	)
+
+	converter.AddRules(
+		// rules which skip the element leave nothing behind if no other rule
+		// converts it, so unknown macros and plain spans keep their content
+		md.Rule{
+			Filter: []string{"ac:structured-macro", "ac:macro", "span"},
+			Replacement: func(
+				content string,
+				selec *goquery.Selection,
+				opt *md.Options,
+			) *string {
+				return &content
+			},
+		},
+		md.Rule{
+			Filter: []string{cdataTag},
+			Replacement: func(
+				content string,
+				selec *goquery.Selection,
+				opt *md.Options,
+			) *string {
+				return md.String(
+					escape.MarkdownCharacters(conversion.getText(selec)),
+				)
+			},
+		},
+		md.Rule{
+			Filter:      []string{"#text"},
+			Replacement: convertInlineWhitespace,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro"},
+			Replacement: conversion.convertCodeMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro"},
+			Replacement: conversion.convertAdmonitionMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro"},
+			Replacement: conversion.convertExpandMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro"},
+			Replacement: conversion.convertTOCMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro"},
+			Replacement: conversion.convertStatusMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro", "ac:macro"},
+			Replacement: conversion.convertJiraMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro"},
+			Replacement: conversion.convertAnchorMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro"},
+			Replacement: conversion.convertPagePropertiesMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:structured-macro"},
+			Replacement: conversion.convertAttachmentsMacro,
+		},
+		md.Rule{
+			Filter:      []string{"ac:image"},
+			Replacement: conversion.convertImage,
+		},
+		md.Rule{
+			Filter:      []string{"ac:link"},
+			Replacement: conversion.convertLink,
+		},
+		md.Rule{
+			Filter:      []string{"ac:task-list"},
+			Replacement: conversion.convertTaskList,
+		},
+		md.Rule{
+			Filter:      []string{"ac:task"},
+			Replacement: conversion.convertTask,
+		},
+		md.Rule{
+			Filter:      []string{"table"},
+			Replacement: conversion.convertTable,
+		},
+		md.Rule{
+			Filter:      []string{"ac:emoticon"},
+			Replacement: conversion.convertEmoticon,
+		},
+		md.Rule{
+			Filter:      []string{"time"},
+			Replacement: conversion.convertTime,
+		},
+		md.Rule{
+			Filter:      []string{"span", "ac:inline-comment-marker"},
+			Replacement: conversion.convertInlineComment,
+		},
+		md.Rule{
+			Filter:      []string{"ac:layout-cell"},
+			Replacement: conversion.convertLayoutCell,
+		},
+		md.Rule{
+			Filter:      []string{"ac:layout"},
+			Replacement: conversion.convertLayout,
+		},
+	)
+
+	conversion.converter = converter
+
+	return converter
+}
+
+// convertInlineWhitespace keeps whitespace between inline elements, so
+// adjacent emoticons, mentions and so on don't stick together.
+func convertInlineWhitespace(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	node := selec.Get(0)
+
+	if strings.TrimSpace(node.Data) != "" ||
+		!isInlineNode(node.PrevSibling) ||
+		!isInlineNode(node.NextSibling) ||
+		isPaddedNode(node.PrevSibling) ||
+		isPaddedNode(node.NextSibling) {
+		return nil
+	}
+
+	return md.String(" ")
+}
+
+// isPaddedNode reports whether converter adds spaces around the node by
+// itself.
+func isPaddedNode(node *html.Node) bool {
+	switch node.Data {
+	case "a", "strong", "b", "em", "i", "code":
+		return true
+	}
+
+	return false
+}
+
+func isInlineNode(node *html.Node) bool {
+	if node == nil || node.Type != html.ElementNode {
+		return false
+	}
+
+	switch node.Data {
+	case "ac:emoticon", "ac:link", "ac:image", "ac:inline-comment-marker",
+		"time":
+		return true
+
+	case "ac:structured-macro":
+		for _, attr := range node.Attr {
+			if attr.Key == "ac:name" {
+				return attr.Val == "status" || attr.Val == "anchor"
+			}
+		}
+	}
+
+	return md.IsInlineElement(node.Data)
+}
+
+// addBlock stores given markdown block and returns placeholder which is
+// replaced with the block after conversion.
+func (conversion *conversion) addBlock(block string) string {
+	conversion.blocks = append(conversion.blocks, block)
+
+	return blockPlaceholder(len(conversion.blocks) - 1)
+}
+
+// blockPlaceholder uses NUL characters, which HTML parser never passes
+// through, so placeholder can't clash with the page text.
+func blockPlaceholder(index int) string {
+	return "\x00" + strconv.Itoa(index) + "\x00"
+}
+
+// restoreBlocks replaces placeholders with stored blocks. Lines of the block
+// are prefixed with the same indentation and blockquote markers as the
+// placeholder, so blocks nested into lists and quotes stay there.
+func (conversion *conversion) restoreBlocks(markdown string) string {
+	for index := len(conversion.blocks) - 1; index >= 0; index-- {
+		placeholder := blockPlaceholder(index)
+
+		offset := strings.Index(markdown, placeholder)
+		if offset < 0 {
+			continue
+		}
+
+		start := strings.LastIndexByte(markdown[:offset], '\n') + 1
+
+		prefix := markdown[start:offset]
+		if strings.Trim(prefix, " \t>") != "" {
+			prefix = ""
+		}
+
+		block := strings.ReplaceAll(
+			conversion.blocks[index],
+			"\n",
+			"\n"+prefix,
+		)
+
+		markdown = markdown[:offset] + block + markdown[offset+len(placeholder):]
+	}
+
+	return markdown
+}
+
+// convertCodeMacro converts code, noformat, mermaid and plantuml macros into
+// fenced code blocks. Bodies of these macros are CDATA sections, which are
+// put into code blocks verbatim. Code blocks have info string in the form
+// understood by ParseLanguage and ParseTitle:
+//
+//	```language collapse linenumbers title Some Title
+func (conversion *conversion) convertCodeMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	var language string
+
+	switch getMacroName(selec) {
+	case "code":
+		language = conversion.getMacroParameter(selec, "language")
+
+	case "noformat":
+
+	case "cloudscript-confluence-mermaid":
+		language = "mermaid"
+
+	case "plantuml":
+		language = "plantuml"
+
+	default:
+		return nil
+	}
+
+	info := []string{}
+	if language != "" {
+		info = append(info, language)
+	}
+
+	if conversion.getMacroParameter(selec, "collapse") == "true" {
+		info = append(info, "collapse")
+	}
+
+	if conversion.getMacroParameter(selec, "linenumbers") == "true" {
+		info = append(info, "linenumbers")
+	}
+
+	if title := conversion.getMacroParameter(selec, "title"); title != "" {
+		info = append(info, "title "+title)
+	}
+
+	code := conversion.getText(getStorageChild(selec, "ac:plain-text-body"))
+
+	fenceChar := '`'
+	if strings.Contains(strings.Join(info, " "), "`") {
+		fenceChar = '~'
+	}
+
+	fence := md.CalculateCodeFence(fenceChar, code)
+
+	block := fence + strings.Join(info, " ") + "\n" + code + "\n" + fence
+
+	return md.String("\n\n" + conversion.addBlock(block) + "\n\n")
+}
+
+// ConvertHTML reads Confluence page body from the reader and writes it as
+// markdown into the writer. The page body is parsed as it's read, so neither
+// the input nor the intermediate representation is kept as a string.
+func ConvertHTML(
+	reader io.Reader,
+	writer io.Writer,
+	options ...ConvertOption,
+) error {
+	conversion := &conversion{
+		opts:        newConvertOptions(options),
+		attachments: map[string]string{},
+	}
+
+	opts := conversion.opts
+
+	document, err := goquery.NewDocumentFromReader(
+		newStorageReader(
+			&progressReader{reader: reader, opts: opts},
+			&conversion.cdata,
+		),
+	)
+	if err != nil {
+		if err := opts.context.Err(); err != nil {
+			return err
+		}
+
+		return karma.Format(err, "unable to parse html")
+	}
+
+	if err := opts.context.Err(); err != nil {
+		return err
+	}
+
+	sanitize(document.Selection)
+	normalizeText(document.Selection)
+
+	conversion.collectAnchors(document.Selection)
+
+	markdown := conversion.restoreBlocks(
+		conversion.newConverter().Convert(document.Selection),
+	)
+
+	if err := opts.context.Err(); err != nil {
+		return err
+	}
+
+	if conversion.err != nil {
+		return conversion.err
+	}
+
+	if opts.attachmentNames != nil {
+		*opts.attachmentNames = conversion.filenames
+	}
+
+	header := formatMetaHeader(opts.meta, opts.frontMatter)
+
+	_, err = io.WriteString(writer, header+markdown)
+	if err != nil {
+		return karma.Format(err, "unable to write markdown")
+	}
+
+	return nil
+}
+
+// HtmlToMarkdown converts Confluence page body into markdown.
+func HtmlToMarkdown(html string, options ...ConvertOption) (string, error) {
+	var markdown strings.Builder
+
+	err := ConvertHTML(strings.NewReader(html), &markdown, options...)
+	if err != nil {
+		return "", karma.Format(err, "unable to convert html to markdown")
+	}
+
+	return markdown.String(), nil
+}
+
+// HtmlToMarkdownFile converts Confluence page body into markdown and writes
+// it into the specified file.
+func HtmlToMarkdownFile(
+	html string,
+	fileName string,
+	options ...ConvertOption,
+) error {
+	markdown, err := HtmlToMarkdown(html, options...)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(fileName, []byte(markdown), 0644)
+	if err != nil {
+		return karma.Format(err, "unable to write markdown file: %s", fileName)
+	}
+
+	return nil
+}
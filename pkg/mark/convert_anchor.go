@@ -0,0 +1,139 @@
+package mark
+
+import (
+	"strconv"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"golang.org/x/net/html"
+)
+
+const headingSelector = "h1, h2, h3, h4, h5, h6"
+
+// collectAnchors remembers slugs which CompileMarkdown generates for the
+// page headings, so links to the headings can be rewritten to them. Links to
+// headings are written by Confluence either with heading text or with id of
+// the heading, which is page title and heading text without spaces joined
+// with dash. Anchor macros placed into the heading or right before it are
+// linked to the heading as well.
+func (conversion *conversion) collectAnchors(document *goquery.Selection) {
+	conversion.anchors = map[string]string{}
+
+	slugs := map[string]int{}
+
+	document.Find(headingSelector).Each(func(_ int, heading *goquery.Selection) {
+		text := strings.TrimSpace(getHeadingText(heading.Get(0)))
+
+		slug := bf.SanitizedAnchorName(text)
+		if slug == "" {
+			return
+		}
+
+		// duplicate headings get numbered slugs the same way as in
+		// blackfriday
+		if count, ok := slugs[slug]; ok {
+			slugs[slug] = count + 1
+			slug += "-" + strconv.Itoa(count+1)
+		} else {
+			slugs[slug] = 0
+		}
+
+		names := []string{text, strings.ReplaceAll(text, " ", "")}
+		if id := heading.AttrOr("id", ""); id != "" {
+			names = append(names, id)
+		}
+
+		conversion.getHeadingAnchors(heading).Each(
+			func(_ int, anchor *goquery.Selection) {
+				names = append(names, conversion.getMacroParameter(anchor, ""))
+			},
+		)
+
+		for _, name := range names {
+			if _, ok := conversion.anchors[name]; !ok && name != "" {
+				conversion.anchors[name] = slug
+			}
+		}
+	})
+
+	document.Find(`a[href^="#"]`).Each(func(_ int, link *goquery.Selection) {
+		link.SetAttr("href", "#"+conversion.getAnchor(link.AttrOr("href", "")[1:]))
+	})
+}
+
+// getHeadingText returns text of the heading without parameters of macros
+// within it.
+func getHeadingText(node *html.Node) string {
+	switch {
+	case node.Type == html.TextNode:
+		return node.Data
+
+	case node.Type == html.ElementNode && node.Data == "ac:parameter":
+		return ""
+	}
+
+	var text string
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text += getHeadingText(child)
+	}
+
+	return text
+}
+
+// getHeadingAnchors returns anchor macros placed into the heading or into
+// the paragraph right before it.
+func (conversion *conversion) getHeadingAnchors(
+	heading *goquery.Selection,
+) *goquery.Selection {
+	anchors := heading.Find("ac\\:structured-macro")
+
+	if prev := heading.Prev(); goquery.NodeName(prev) == "p" &&
+		strings.TrimSpace(getHeadingText(prev.Get(0))) == "" {
+		anchors = anchors.AddSelection(prev.Find("ac\\:structured-macro"))
+	}
+
+	return anchors.FilterFunction(func(_ int, macro *goquery.Selection) bool {
+		return getMacroName(macro) == "anchor"
+	})
+}
+
+// getAnchor returns slug of the heading which is linked to with given
+// anchor, anchor itself is returned if it doesn't belong to any heading.
+func (conversion *conversion) getAnchor(anchor string) string {
+	if slug, ok := conversion.anchors[anchor]; ok {
+		return slug
+	}
+
+	return anchor
+}
+
+// convertAnchorMacro drops anchor macros which name matches slug of the
+// heading they belong to, since links to them are rewritten to the heading
+// slug anyway. Other anchors are written as ac:anchor template include:
+//
+//	<!-- Include: ac:anchor {Name: 'name'} -->
+func (conversion *conversion) convertAnchorMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if getMacroName(selec) != "anchor" {
+		return nil
+	}
+
+	name := conversion.getMacroParameter(selec, "")
+	if name == "" {
+		return md.String("")
+	}
+
+	if slug, ok := conversion.anchors[name]; ok &&
+		bf.SanitizedAnchorName(name) == slug {
+		return md.String("")
+	}
+
+	return md.String(
+		"<!-- Include: ac:anchor {Name: " + quoteYAML(name) + "} -->",
+	)
+}
@@ -0,0 +1,80 @@
+package mark
+
+import (
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LayoutStyle defines how cells of page layouts are separated in markdown
+// when converting Confluence pages.
+type LayoutStyle string
+
+const (
+	// LayoutRule separates layout cells with horizontal rules. This is the
+	// default.
+	LayoutRule LayoutStyle = "rule"
+
+	// LayoutColumn writes every layout cell as a container:
+	//
+	//	:::column
+	//	Content of the cell.
+	//	:::
+	LayoutColumn LayoutStyle = "column"
+)
+
+// layoutCellMarker precedes content of every layout cell, so the layout can
+// tell cells apart once they're converted. It uses NUL characters for the
+// same reason as block placeholders.
+const layoutCellMarker = "\x00layout-cell\x00"
+
+// convertLayoutCell marks content of the cell, the cell itself is written by
+// convertLayout along with the other cells of the layout.
+func (conversion *conversion) convertLayoutCell(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if selec.ParentsFiltered("*").FilterFunction(isLayout).Length() == 0 {
+		return &content
+	}
+
+	return md.String("\n\n" + layoutCellMarker + "\n\n" + content + "\n\n")
+}
+
+// convertLayout writes cells of all layout sections in order. Empty cells
+// are skipped, so they don't leave separators behind. Nested layouts are
+// converted first and don't contain cell markers any more, so their cells
+// don't mix up with the cells of the outer layout.
+func (conversion *conversion) convertLayout(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	cells := []string{}
+
+	for _, cell := range strings.Split(content, layoutCellMarker) {
+		cell = reBlankLines.ReplaceAllString(strings.TrimSpace(cell), "\n\n")
+		if cell == "" {
+			continue
+		}
+
+		if conversion.opts.layouts == LayoutColumn {
+			cell = ":::column\n" + cell + "\n:::"
+		}
+
+		cells = append(cells, cell)
+	}
+
+	separator := "\n\n---\n\n"
+	if conversion.opts.layouts == LayoutColumn {
+		separator = "\n\n"
+	}
+
+	return md.String("\n\n" + strings.Join(cells, separator) + "\n\n")
+}
+
+func isLayout(_ int, selec *goquery.Selection) bool {
+	return goquery.NodeName(selec) == "ac:layout"
+}
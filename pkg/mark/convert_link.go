@@ -0,0 +1,243 @@
+package mark
+
+import (
+	"path"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/reconquest/karma-go"
+)
+
+// convertImage converts attached and external images:
+//
+//	<ac:image ac:width="300"><ri:attachment ri:filename="x.png"/></ac:image>
+//
+// becomes:
+//
+//	![](attachments/x.png){width=300}
+func (conversion *conversion) convertImage(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	var target string
+
+	if attachment := getStorageChild(selec, "ri:attachment"); attachment.Length() > 0 {
+		target = conversion.getAttachmentPath(attachment.AttrOr("ri:filename", ""))
+	} else if url := getStorageChild(selec, "ri:url"); url.Length() > 0 {
+		target = url.AttrOr("ri:value", "")
+	}
+
+	if target == "" {
+		return md.String("")
+	}
+
+	alt := selec.AttrOr("ac:alt", selec.AttrOr("ac:title", ""))
+
+	image := "![" + escapeLinkText(alt) + "](" + getLinkDestination(target) + ")"
+
+	attributes := []string{}
+	for _, name := range []string{"width", "height", "align"} {
+		if value := selec.AttrOr("ac:"+name, ""); value != "" {
+			attributes = append(attributes, name+"="+value)
+		}
+	}
+
+	if len(attributes) > 0 {
+		image += "{" + strings.Join(attributes, " ") + "}"
+	}
+
+	return md.String(image)
+}
+
+// getAttachmentPath returns path which attachment with given filename is
+// referenced by in markdown.
+func (conversion *conversion) getAttachmentPath(filename string) string {
+	if filename == "" {
+		return ""
+	}
+
+	if local, ok := conversion.attachments[filename]; ok {
+		return local
+	}
+
+	local := path.Join(conversion.opts.attachmentsPath, filename)
+
+	if conversion.opts.attachments != nil {
+		var err error
+
+		local, err = conversion.opts.attachments(filename)
+		if err != nil {
+			conversion.fail(
+				karma.Format(err, "unable to handle attachment: %s", filename),
+			)
+
+			return ""
+		}
+	}
+
+	conversion.attachments[filename] = local
+	conversion.filenames = append(conversion.filenames, filename)
+
+	return local
+}
+
+// convertLink converts links to other pages, their anchors, attachments and
+// users:
+//
+//	<ac:link ac:anchor="usage"><ri:page ri:content-title="Other Page"/></ac:link>
+//
+// becomes:
+//
+//	[Other Page](Other Page#usage)
+//
+// Links to pages of other spaces than the space of ConvertMeta and to
+// space landing pages become links of PageLinkScheme, as they're compiled:
+//
+//	[Other Page](<confluence://SEC/Other Page#usage>)
+//
+// Link text is taken from the link body if it's given.
+func (conversion *conversion) convertLink(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	var (
+		text   = strings.TrimSpace(content)
+		anchor = selec.AttrOr("ac:anchor", "")
+		target string
+		title  string
+	)
+
+	switch {
+	case getStorageChild(selec, "ri:user").Length() > 0:
+		return md.String(
+			conversion.getMention(getStorageChild(selec, "ri:user")),
+		)
+
+	case getStorageChild(selec, "ri:attachment").Length() > 0:
+		title = getStorageChild(selec, "ri:attachment").AttrOr("ri:filename", "")
+		target = getLinkDestination(conversion.getAttachmentPath(title))
+
+	case getStorageChild(selec, "ri:page").Length() > 0,
+		getStorageChild(selec, "ri:blog-post").Length() > 0:
+		page := getStorageChild(selec, "ri:page")
+		if page.Length() == 0 {
+			page = getStorageChild(selec, "ri:blog-post")
+		}
+
+		space := page.AttrOr("ri:space-key", "")
+		title = page.AttrOr("ri:content-title", "")
+
+		target = escapePageLink(title)
+
+		// pages of the same space are linked by titles, the space of the
+		// page is not known without metadata
+		if meta := conversion.opts.meta; meta != nil && meta.Space != "" &&
+			space != "" && space != meta.Space {
+			target = pageLink{Space: space, Title: title}.String()
+		}
+
+		if conversion.opts.pageLinks != nil {
+			if link, ok := conversion.opts.pageLinks(space, title); ok {
+				target = link
+			}
+		}
+
+	case getStorageChild(selec, "ri:space").Length() > 0:
+		title = getStorageChild(selec, "ri:space").AttrOr("ri:space-key", "")
+		target = pageLink{Space: title}.String()
+
+	case getStorageChild(selec, "ri:url").Length() > 0:
+		target = getStorageChild(selec, "ri:url").AttrOr("ri:value", "")
+		title = target
+
+	case anchor != "":
+		title = anchor
+
+	default:
+		return &content
+	}
+
+	if anchor != "" {
+		// links within the page point to the headings by their slugs
+		if target == "" {
+			anchor = conversion.getAnchor(anchor)
+		}
+
+		target += "#" + anchor
+	}
+
+	// titles of pages have spaces usually
+	if strings.HasPrefix(target, PageLinkScheme) {
+		target = getLinkDestination(target)
+	}
+
+	if text == "" {
+		text = escapeLinkText(title)
+	}
+
+	if target == "" {
+		return md.String(text)
+	}
+
+	return md.String("[" + text + "](" + target + ")")
+}
+
+// MentionStyle defines how user mentions are written in markdown when
+// converting Confluence pages.
+type MentionStyle string
+
+const (
+	// MentionPlain writes mentions as @Display Name. This is the default.
+	MentionPlain MentionStyle = "plain"
+
+	// MentionMacro writes mentions as @{Display Name}, which is the form
+	// CompileMarkdown turns back into mentions.
+	MentionMacro MentionStyle = "macro"
+)
+
+// getMention returns mention of the user. Display name is used if the
+// user resolver is set and knows the user, account id is used otherwise.
+func (conversion *conversion) getMention(user *goquery.Selection) string {
+	id := user.AttrOr("ri:account-id", "")
+	for _, attr := range []string{"ri:username", "ri:userkey"} {
+		if id == "" {
+			id = user.AttrOr(attr, "")
+		}
+	}
+
+	name := id
+	if conversion.opts.users != nil {
+		if resolved, ok := conversion.opts.users(id); ok && resolved != "" {
+			name = resolved
+		}
+	}
+
+	if conversion.opts.mentions == MentionMacro {
+		return "@{" + name + "}"
+	}
+
+	return "@" + name
+}
+
+// escapePageLink escapes page title used as link destination. Spaces are kept
+// as is, since that's how relative links to pages are written.
+func escapePageLink(title string) string {
+	return strings.NewReplacer(`(`, `\(`, `)`, `\)`, `#`, `%23`).Replace(title)
+}
+
+// getLinkDestination wraps link destination into angle brackets if it
+// contains characters which would end it otherwise.
+func getLinkDestination(target string) string {
+	if strings.ContainsAny(target, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(target) + ">"
+	}
+
+	return target
+}
+
+func escapeLinkText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(text)
+}
@@ -0,0 +1,304 @@
+package mark
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ExpandStyle defines how expand macros are written in markdown when
+// converting Confluence pages.
+type ExpandStyle string
+
+const (
+	// ExpandDetails writes expand macros as HTML details element:
+	//
+	//	<details><summary>Title</summary>
+	//
+	//	Body of the expand.
+	//
+	//	</details>
+	//
+	// This is the default, the compiler converts such elements back into
+	// expand macros, see ConvertedHTMLTags.
+	ExpandDetails ExpandStyle = "details"
+
+	// ExpandFenced writes expand macros as fenced blocks:
+	//
+	//	```expand Title
+	//	Body of the expand.
+	//	```
+	ExpandFenced ExpandStyle = "fenced"
+)
+
+// convertExpandMacro converts expand macros recursively, so tables, code
+// blocks and other expands within the body are converted as well.
+func (conversion *conversion) convertExpandMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if getMacroName(selec) != "expand" {
+		return nil
+	}
+
+	body := getStorageChild(selec, "ac:rich-text-body")
+
+	// collapsed code blocks are rendered as code macro within expand macro,
+	// the code block carries collapse flag and title on its own
+	if children := body.ChildrenFiltered("*"); children.Length() == 1 &&
+		goquery.NodeName(children) == "ac:structured-macro" &&
+		conversion.getMacroParameter(children, "collapse") == "true" {
+		return &content
+	}
+
+	title := conversion.getMacroParameter(selec, "title")
+
+	content = reBlankLines.ReplaceAllString(strings.TrimSpace(content), "\n\n")
+
+	if conversion.opts.expands == ExpandFenced {
+		content = conversion.restoreBlocks(content)
+
+		fence := md.CalculateCodeFence('`', content)
+
+		header := fence + "expand"
+		if title != "" {
+			header += " " + title
+		}
+
+		block := header + "\n" + content + "\n" + fence
+
+		return md.String("\n\n" + conversion.addBlock(block) + "\n\n")
+	}
+
+	summary := ""
+	if title != "" {
+		summary = "<summary>" + html.EscapeString(title) + "</summary>"
+	}
+
+	return md.String(
+		"\n\n<details>" + summary + "\n\n" + content + "\n\n</details>\n\n",
+	)
+}
+
+// tocParameters lists parameters of the TOC macro along with the names of
+// corresponding ac:toc template fields.
+var tocParameters = [][2]string{
+	{"printable", "Printable"},
+	{"style", "Style"},
+	{"maxLevel", "MaxLevel"},
+	{"indent", "Indent"},
+	{"minLevel", "MinLevel"},
+	{"exclude", "Exclude"},
+	{"type", "Type"},
+	{"outline", "Outline"},
+	{"include", "Include"},
+}
+
+// convertTOCMacro converts TOC macro into the ac:toc template include, so
+// the macro is rendered back with the same parameters:
+//
+//	<!-- Include: ac:toc
+//	     MaxLevel: '3' -->
+func (conversion *conversion) convertTOCMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if getMacroName(selec) != "toc" {
+		return nil
+	}
+
+	include := "<!-- Include: ac:toc"
+
+	for _, parameter := range tocParameters {
+		value := conversion.getMacroParameter(selec, parameter[0])
+		if value == "" {
+			continue
+		}
+
+		include += "\n     " + parameter[1] + ": " + quoteYAML(value)
+	}
+
+	include += " -->"
+
+	return md.String("\n\n" + conversion.addBlock(include) + "\n\n")
+}
+
+// StatusStyle defines how status macros are written in markdown when
+// converting Confluence pages.
+type StatusStyle string
+
+const (
+	// StatusInclude writes status macros as inline ac:status template
+	// include, so they're rendered back as status macros. This is the
+	// default:
+	//
+	//	<!-- Include: ac:status {Title: 'SHIPPED', Color: 'Green'} -->
+	StatusInclude StatusStyle = "include"
+
+	// StatusText writes status macros as bold text, e.g. **[SHIPPED]**.
+	StatusText StatusStyle = "text"
+)
+
+// convertStatusMacro converts status lozenges. Both styles fit into single
+// line, so lozenges in table cells stay there.
+func (conversion *conversion) convertStatusMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if getMacroName(selec) != "status" {
+		return nil
+	}
+
+	var (
+		title  = conversion.getMacroParameter(selec, "title")
+		color  = conversion.getMacroParameter(selec, "colour")
+		subtle = conversion.getMacroParameter(selec, "subtle")
+	)
+
+	if color == "" {
+		color = conversion.getMacroParameter(selec, "color")
+	}
+
+	if conversion.opts.statuses == StatusText {
+		if title == "" {
+			title = color
+		}
+
+		if title == "" {
+			return md.String("")
+		}
+
+		return md.String(md.AddSpaceIfNessesary(
+			selec,
+			"**["+escapeLinkText(strings.ToUpper(title))+"]**",
+		))
+	}
+
+	config := []string{}
+	if title != "" {
+		config = append(config, "Title: "+quoteYAML(title))
+	}
+
+	if color != "" {
+		config = append(config, "Color: "+quoteYAML(color))
+	}
+
+	if subtle == "true" {
+		config = append(config, "Subtle: true")
+	}
+
+	return md.String(
+		"<!-- Include: ac:status {" + strings.Join(config, ", ") + "} -->",
+	)
+}
+
+func quoteYAML(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+var reJiraKey = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[0-9]+\b`)
+
+// convertJiraMacro converts single issue macros into issue links and JQL
+// macros into comments with the query. Depending on Confluence version issue
+// key is stored either in the key parameter or in the macro body.
+func (conversion *conversion) convertJiraMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if getMacroName(selec) != "jira" {
+		return nil
+	}
+
+	key := conversion.getMacroParameter(selec, "key")
+	if key == "" {
+		key = conversion.getMacroParameter(selec, "")
+	}
+
+	if key == "" {
+		key = strings.TrimSpace(
+			conversion.getText(getStorageChild(selec, "ac:default-parameter")),
+		)
+	}
+
+	if key == "" {
+		key = reJiraKey.FindString(
+			conversion.getText(getStorageChild(selec, "ac:plain-text-body")),
+		)
+	}
+
+	if key == "" {
+		query := conversion.getMacroParameter(selec, "jqlQuery")
+		if query == "" {
+			query = conversion.getMacroParameter(selec, "jql")
+		}
+
+		if query == "" {
+			return md.String("")
+		}
+
+		return md.String(
+			"<!-- Jira: " + strings.ReplaceAll(query, "--", "- -") + " -->",
+		)
+	}
+
+	if conversion.opts.jira == "" {
+		return md.String(key)
+	}
+
+	return md.String(
+		"[" + key + "](" + strings.TrimSuffix(conversion.opts.jira, "/") +
+			"/browse/" + key + ")",
+	)
+}
+
+// convertPagePropertiesMacro converts page properties macro into its body,
+// which is usually a table, preceded by marker with id of the macro:
+//
+//	<!-- page-properties id=release -->
+//
+// The marker has no colon, so it's never taken for metadata header.
+func (conversion *conversion) convertPagePropertiesMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if getMacroName(selec) != "details" {
+		return nil
+	}
+
+	marker := "<!-- page-properties"
+	if id := conversion.getMacroParameter(selec, "id"); id != "" {
+		marker += " id=" + strings.NewReplacer(
+			" ", "_", ":", "_", "--", "__",
+		).Replace(id)
+	}
+
+	marker += " -->"
+
+	return md.String(
+		"\n\n" + marker + "\n\n" + strings.TrimSpace(content) + "\n\n",
+	)
+}
+
+// convertAttachmentsMacro converts macro which lists attachments of the page
+// into the marker:
+//
+//	<!-- attachments -->
+func (conversion *conversion) convertAttachmentsMacro(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if getMacroName(selec) != "attachments" {
+		return nil
+	}
+
+	return md.String("\n\n<!-- attachments -->\n\n")
+}
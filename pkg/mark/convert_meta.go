@@ -0,0 +1,130 @@
+package mark
+
+import (
+	"strings"
+)
+
+// PageMeta describes the page converted into markdown. It's written as
+// metadata header which precedes page contents, so converted page can be
+// published back as is.
+type PageMeta struct {
+	Space       string
+	Type        string
+	Parents     []string
+	Title       string
+	Layout      string
+	Attachments []string
+	Labels      []string
+}
+
+// ConvertMeta sets metadata of the page, which is written as header in the
+// form parsed by ExtractMeta:
+//
+//	<!-- Space: ENG -->
+//	<!-- Parent: Parent Page -->
+//	<!-- Title: Page -->
+func ConvertMeta(meta PageMeta) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.meta = &meta
+	}
+}
+
+// ConvertFrontMatter sets whether metadata of the page is written as YAML
+// front matter instead of header comments.
+func ConvertFrontMatter(enabled bool) ConvertOption {
+	return func(opts *convertOptions) {
+		opts.frontMatter = enabled
+	}
+}
+
+type metaField struct {
+	header string
+	key    string
+	list   bool
+	values []string
+}
+
+func (meta *PageMeta) fields() []metaField {
+	// type is written only if it's not the default one
+	kind := meta.Type
+	if kind == "page" {
+		kind = ""
+	}
+
+	return []metaField{
+		{HeaderSpace, "space", false, []string{meta.Space}},
+		{HeaderType, "type", false, []string{kind}},
+		{HeaderParent, "parents", true, meta.Parents},
+		{HeaderTitle, "title", false, []string{meta.Title}},
+		{HeaderLayout, "layout", false, []string{meta.Layout}},
+		{HeaderAttachment, "attachments", true, meta.Attachments},
+		{HeaderLabel, "labels", true, meta.Labels},
+	}
+}
+
+// formatMetaHeader returns metadata header followed by blank line, or empty
+// string if there is nothing to write.
+func formatMetaHeader(meta *PageMeta, frontMatter bool) string {
+	if meta == nil {
+		return ""
+	}
+
+	lines := []string{}
+
+	for _, field := range meta.fields() {
+		values := []string{}
+		for _, value := range field.values {
+			// header values can't span multiple lines
+			value = strings.Join(strings.Fields(value), " ")
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		if !frontMatter {
+			for _, value := range values {
+				lines = append(
+					lines,
+					"<!-- "+field.header+": "+escapeMetaValue(value)+" -->",
+				)
+			}
+
+			continue
+		}
+
+		if !field.list {
+			lines = append(lines, field.key+": "+quoteYAML(values[0]))
+
+			continue
+		}
+
+		lines = append(lines, field.key+":")
+		for _, value := range values {
+			lines = append(lines, "  - "+quoteYAML(value))
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	if frontMatter {
+		return "---\n" + strings.Join(lines, "\n") + "\n---\n\n"
+	}
+
+	return strings.Join(lines, "\n") + "\n\n"
+}
+
+// escapeMetaValue escapes end of comment within header value, ExtractMeta
+// unescapes it back.
+func escapeMetaValue(value string) string {
+	return strings.ReplaceAll(value, "-->", "--&gt;")
+}
+
+func unescapeMetaValue(value string) string {
+	return strings.ReplaceAll(value, "--&gt;", "-->")
+}
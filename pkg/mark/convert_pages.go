@@ -0,0 +1,85 @@
+package mark
+
+import (
+	"context"
+	"strings"
+)
+
+// PageHTML is a Confluence page body to be converted by ConvertPages.
+type PageHTML struct {
+	// Name identifies the page in errors, e.g. page title or id.
+	Name string
+	HTML string
+
+	// Options are used for this page only.
+	Options []ConvertOption
+}
+
+// PageMarkdown is a result of the page conversion.
+type PageMarkdown struct {
+	Name     string
+	Markdown string
+
+	// Attachments are filenames of attachments referenced by the page.
+	Attachments []string
+
+	// Err is set if the page can't be converted.
+	Err error
+}
+
+// ConvertPages converts pages concurrently using given number of workers.
+// Results are returned in the same order as pages. A page which can't be
+// converted doesn't stop conversion of other pages, errors of all such pages
+// are returned together. Pages which are not converted yet when the context
+// is done get context error, which is returned as well.
+func ConvertPages(
+	ctx context.Context,
+	pages []PageHTML,
+	workers int,
+) ([]PageMarkdown, error) {
+	results := make([]PageMarkdown, len(pages))
+
+	err := runWorkers(
+		ctx,
+		len(pages),
+		workers,
+		func(index int) {
+			results[index] = convertPage(ctx, pages[index])
+		},
+		func(index int, err error) {
+			results[index] = PageMarkdown{Name: pages[index].Name, Err: err}
+		},
+	)
+	if err != nil {
+		return results, err
+	}
+
+	return results, pushWorkerErrors(
+		"unable to convert some of the pages",
+		"page",
+		len(results),
+		func(index int) (string, error) {
+			return results[index].Name, results[index].Err
+		},
+	)
+}
+
+func convertPage(ctx context.Context, page PageHTML) PageMarkdown {
+	result := PageMarkdown{Name: page.Name}
+
+	var markdown strings.Builder
+
+	options := append([]ConvertOption{}, page.Options...)
+	options = append(
+		options,
+		ConvertContext(ctx),
+		ConvertAttachmentNames(&result.Attachments),
+	)
+
+	result.Err = ConvertHTML(strings.NewReader(page.HTML), &markdown, options...)
+	if result.Err == nil {
+		result.Markdown = markdown.String()
+	}
+
+	return result
+}
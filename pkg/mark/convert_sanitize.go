@@ -0,0 +1,116 @@
+package mark
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// SanitizedAttributes lists attributes which are removed from the page body
+// before conversion. These attributes are used by Confluence editor only and
+// would otherwise leak into HTML kept in markdown, e.g. complex tables.
+var SanitizedAttributes = []string{
+	"ac:schema-version",
+	"ac:macro-id",
+	"ac:local-id",
+	"local-id",
+	"ri:version-at-save",
+}
+
+// SanitizedEmptyElements lists selectors of elements which are removed from
+// the page body before conversion if they have no content, like paragraphs
+// which Confluence editor adds around macros to place cursor into.
+var SanitizedEmptyElements = []string{
+	"p.auto-cursor-target",
+}
+
+// SanitizedWrappers lists selectors of elements which are replaced with
+// their content before conversion.
+var SanitizedWrappers = []string{
+	"span.confluence-embedded-file-wrapper",
+}
+
+// sanitize removes editor artifacts listed in SanitizedAttributes,
+// SanitizedEmptyElements and SanitizedWrappers from the page body.
+func sanitize(document *goquery.Selection) {
+	attributes := map[string]bool{}
+	for _, name := range SanitizedAttributes {
+		attributes[strings.ToLower(name)] = true
+	}
+
+	for _, node := range document.Nodes {
+		dropAttributes(node, attributes)
+	}
+
+	for _, selector := range SanitizedEmptyElements {
+		document.Find(selector).Each(func(_ int, selec *goquery.Selection) {
+			if isEmptyElement(selec.Get(0)) {
+				selec.Remove()
+			}
+		})
+	}
+
+	for _, selector := range SanitizedWrappers {
+		document.Find(selector).Each(func(_ int, selec *goquery.Selection) {
+			unwrap(selec.Get(0))
+		})
+	}
+}
+
+func dropAttributes(node *html.Node, attributes map[string]bool) {
+	attrs := node.Attr[:0]
+	for _, attr := range node.Attr {
+		if !attributes[strings.ToLower(attr.Key)] {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	node.Attr = attrs
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		dropAttributes(child, attributes)
+	}
+}
+
+// isEmptyElement reports whether the element contains nothing but
+// whitespace and line breaks.
+func isEmptyElement(node *html.Node) bool {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		switch {
+		case child.Type == html.TextNode:
+			if strings.TrimSpace(child.Data) != "" {
+				return false
+			}
+
+		case child.Type == html.ElementNode && child.Data == "br":
+
+		case child.Type == html.ElementNode:
+			switch child.Data {
+			case "ac:image", "ac:emoticon", "ac:link", "ac:structured-macro",
+				"ac:macro", "time", "img", "hr", cdataTag:
+				return false
+			}
+
+			if !isEmptyElement(child) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// unwrap replaces the node with its children.
+func unwrap(node *html.Node) {
+	if node.Parent == nil {
+		return
+	}
+
+	for child := node.FirstChild; child != nil; child = node.FirstChild {
+		node.RemoveChild(child)
+		node.Parent.InsertBefore(child, node)
+	}
+
+	node.Parent.RemoveChild(node)
+}
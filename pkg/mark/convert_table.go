@@ -0,0 +1,269 @@
+package mark
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+var (
+	reTextAlign = regexp.MustCompile(`text-align:\s*(left|center|right)`)
+
+	reCDATAPlaceholder = regexp.MustCompile(
+		`<` + cdataTag + ` index="([0-9]+)"></` + cdataTag + `>`,
+	)
+)
+
+// convertTable converts simple tables, which have header row and which cells
+// contain inline content only, into pipe tables. Other tables, e.g. with
+// merged cells or lists in cells, can't be written as pipe tables and are
+// kept as HTML, which is passed through by CompileMarkdown.
+func (conversion *conversion) convertTable(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	rows := getTableRows(selec)
+
+	if !isSimpleTable(rows) {
+		block := conversion.addBlock(conversion.renderHTML(selec))
+
+		return md.String("\n\n" + block + "\n\n")
+	}
+
+	var (
+		table  strings.Builder
+		header = rows[0].ChildrenFiltered("th")
+	)
+
+	table.WriteString("\n\n")
+
+	for index, row := range rows {
+		table.WriteString("|")
+
+		row.ChildrenFiltered("th, td").Each(func(_ int, cell *goquery.Selection) {
+			table.WriteString(" " + conversion.convertTableCell(cell) + " |")
+		})
+
+		table.WriteString("\n")
+
+		if index > 0 {
+			continue
+		}
+
+		table.WriteString("|")
+
+		header.Each(func(_ int, cell *goquery.Selection) {
+			switch getCellAlignment(cell) {
+			case "left":
+				table.WriteString(" :--- |")
+			case "center":
+				table.WriteString(" :---: |")
+			case "right":
+				table.WriteString(" ---: |")
+			default:
+				table.WriteString(" --- |")
+			}
+		})
+
+		table.WriteString("\n")
+	}
+
+	table.WriteString("\n")
+
+	return md.String(table.String())
+}
+
+// getTableRows returns rows of the table, but not of the nested tables.
+func getTableRows(table *goquery.Selection) []*goquery.Selection {
+	rows := []*goquery.Selection{}
+
+	table.ChildrenFiltered("thead, tbody, tfoot, tr").Each(
+		func(_ int, child *goquery.Selection) {
+			if goquery.NodeName(child) == "tr" {
+				rows = append(rows, child)
+				return
+			}
+
+			child.ChildrenFiltered("tr").Each(func(_ int, row *goquery.Selection) {
+				rows = append(rows, row)
+			})
+		},
+	)
+
+	return rows
+}
+
+func isSimpleTable(rows []*goquery.Selection) bool {
+	if len(rows) == 0 {
+		return false
+	}
+
+	columns := rows[0].ChildrenFiltered("th, td").Length()
+	if columns == 0 || rows[0].ChildrenFiltered("th").Length() != columns {
+		return false
+	}
+
+	for _, row := range rows {
+		cells := row.ChildrenFiltered("th, td")
+		if cells.Length() != columns {
+			return false
+		}
+
+		simple := true
+
+		cells.EachWithBreak(func(_ int, cell *goquery.Selection) bool {
+			simple = isSimpleTableCell(cell)
+			return simple
+		})
+
+		if !simple {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isSimpleTableCell(cell *goquery.Selection) bool {
+	for _, attr := range []string{"colspan", "rowspan"} {
+		if value := cell.AttrOr(attr, "1"); value != "1" && value != "" {
+			return false
+		}
+	}
+
+	if cell.ChildrenFiltered("p").Length() > 1 {
+		return false
+	}
+
+	complex := cell.Find("*").FilterFunction(
+		func(_ int, child *goquery.Selection) bool {
+			switch name := goquery.NodeName(child); name {
+			case "ul", "ol", "table", "pre", "blockquote", "hr", "div",
+				"h1", "h2", "h3", "h4", "h5", "h6",
+				"ac:task-list", "ac:layout":
+				return true
+
+			case "ac:structured-macro", "ac:macro":
+				switch getMacroName(child) {
+				case "status", "jira", "anchor":
+					return false
+				}
+
+				return true
+			}
+
+			return false
+		},
+	)
+
+	return complex.Length() == 0
+}
+
+// convertTableCell converts content of the cell into single line, which is
+// required by pipe tables.
+func (conversion *conversion) convertTableCell(cell *goquery.Selection) string {
+	content := conversion.converter.Convert(cell)
+
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.ReplaceAll(
+		escapeTablePipes(strings.Join(lines, "<br/>")),
+		nbspPlaceholder,
+		"&nbsp;",
+	)
+}
+
+// escapeTablePipes escapes pipes which are not escaped yet.
+func escapeTablePipes(text string) string {
+	var buffer strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			buffer.WriteString(text[i : i+2])
+			i++
+
+			continue
+		}
+
+		if text[i] == '|' {
+			buffer.WriteByte('\\')
+		}
+
+		buffer.WriteByte(text[i])
+	}
+
+	return buffer.String()
+}
+
+func getCellAlignment(cell *goquery.Selection) string {
+	if align := strings.ToLower(cell.AttrOr("align", "")); align != "" {
+		return align
+	}
+
+	if groups := reTextAlign.FindStringSubmatch(cell.AttrOr("style", "")); groups != nil {
+		return groups[1]
+	}
+
+	return ""
+}
+
+// renderHTML renders selection back into storage format with CDATA sections
+// put back in place.
+func (conversion *conversion) renderHTML(selec *goquery.Selection) string {
+	var buffer bytes.Buffer
+
+	for _, node := range selec.Nodes {
+		dropConverterAttributes(node)
+
+		_ = html.Render(&buffer, node)
+	}
+
+	rendered := strings.ReplaceAll(buffer.String(), nbspPlaceholder, "&nbsp;")
+
+	return reCDATAPlaceholder.ReplaceAllStringFunc(
+		rendered,
+		func(placeholder string) string {
+			index := reCDATAPlaceholder.FindStringSubmatch(placeholder)[1]
+
+			node := &html.Node{
+				Type: html.ElementNode,
+				Data: cdataTag,
+				Attr: []html.Attribute{{Key: "index", Val: index}},
+			}
+
+			return cdataStart + strings.ReplaceAll(
+				conversion.getCDATA(node),
+				cdataEnd,
+				"]]]]><![CDATA[>",
+			) + cdataEnd
+		},
+	)
+}
+
+// dropConverterAttributes removes attributes which are added by the
+// converter itself before conversion.
+func dropConverterAttributes(node *html.Node) {
+	attrs := node.Attr[:0]
+	for _, attr := range node.Attr {
+		if attr.Key != "data-index" &&
+			!strings.HasPrefix(attr.Key, "data-converter-") {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	node.Attr = attrs
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		dropConverterAttributes(child)
+	}
+}
@@ -0,0 +1,74 @@
+package mark
+
+import (
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// convertTaskList converts task lists into GitHub task lists, where every
+// task is a list item starting with [x] when it's complete or with [ ]
+// otherwise. Nested task lists are indented.
+func (conversion *conversion) convertTaskList(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if goquery.NodeName(selec.Parent()) == "ac:task-body" {
+		// indented by the parent task
+		return md.String("\n" + content)
+	}
+
+	return md.String("\n\n" + content + "\n\n")
+}
+
+func (conversion *conversion) convertTask(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	marker := "- [ ] "
+	if strings.EqualFold(
+		strings.TrimSpace(getStorageChild(selec, "ac:task-status").Text()),
+		"complete",
+	) {
+		marker = "- [x] "
+	}
+
+	// task body can't contain blank lines, otherwise it will be split into
+	// several list items
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return md.String(marker + "\n")
+	}
+
+	for i := range lines {
+		if i == 0 {
+			lines[i] = marker + strings.TrimSpace(lines[i])
+		} else {
+			lines[i] = "  " + lines[i]
+		}
+	}
+
+	return md.String(strings.Join(lines, "\n") + "\n")
+}
+
+// convertTime converts date lozenges into plain dates.
+func (conversion *conversion) convertTime(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	if datetime := selec.AttrOr("datetime", ""); datetime != "" {
+		return md.String(datetime)
+	}
+
+	return &content
+}
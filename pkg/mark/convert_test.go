@@ -0,0 +1,1092 @@
+package mark
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/includes"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHtmlToMarkdown(t *testing.T) {
+	markdown, err := HtmlToMarkdown(`<h1>Title</h1><p>Some <strong>bold</strong> text</p>`)
+	assert.NoError(t, err)
+	assert.Equal(t, text("# Title", "", "Some **bold** text"), markdown)
+}
+
+func TestHtmlToMarkdown_InvalidHTML(t *testing.T) {
+	markdown, err := HtmlToMarkdown(`<p>unclosed <strong>bold</p></div><<>`)
+	assert.NoError(t, err)
+	assert.Contains(t, markdown, `unclosed **bold**`)
+}
+
+func TestHtmlToMarkdownFile(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "page.md")
+
+	err := HtmlToMarkdownFile(`<p>text</p>`, path)
+	assert.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "text", string(contents))
+}
+
+func TestHtmlToMarkdownFile_Unwritable(t *testing.T) {
+	dir := t.TempDir()
+
+	err := HtmlToMarkdownFile(
+		`<p>text</p>`,
+		filepath.Join(dir, "missing", "page.md"),
+	)
+	assert.Error(t, err)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestConvertHTML(t *testing.T) {
+	var (
+		html     = `<p>Some <em>text</em></p>`
+		markdown strings.Builder
+		progress int64
+	)
+
+	err := ConvertHTML(
+		strings.NewReader(html),
+		&markdown,
+		ConvertProgress(func(processed int64) {
+			progress = processed
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "Some _text_", markdown.String())
+	assert.EqualValues(t, len(html), progress)
+}
+
+func TestConvertHTML_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var markdown strings.Builder
+
+	err := ConvertHTML(
+		strings.NewReader(`<p>text</p>`),
+		&markdown,
+		ConvertContext(ctx),
+	)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Empty(t, markdown.String())
+}
+
+func TestConvertHTML_WriteError(t *testing.T) {
+	err := ConvertHTML(strings.NewReader(`<p>text</p>`), failingWriter{})
+	assert.Error(t, err)
+}
+
+func TestHtmlToMarkdown_CodeMacro(t *testing.T) {
+	testcases := []struct {
+		name     string
+		html     string
+		markdown string
+	}{
+		{
+			name: "language and title",
+			html: text(
+				`<ac:structured-macro ac:name="code">`,
+				`<ac:parameter ac:name="language">go</ac:parameter>`,
+				`<ac:parameter ac:name="collapse">false</ac:parameter>`,
+				`<ac:parameter ac:name="linenumbers">true</ac:parameter>`,
+				`<ac:parameter ac:name="title">main.go</ac:parameter>`,
+				`<ac:plain-text-body><![CDATA[package main`,
+				``,
+				``,
+				`func main() {}]]></ac:plain-text-body>`,
+				`</ac:structured-macro>`,
+			),
+			markdown: text(
+				"```go linenumbers title main.go",
+				"package main",
+				"",
+				"",
+				"func main() {}",
+				"```",
+			),
+		},
+		{
+			name: "empty language",
+			html: text(
+				`<ac:structured-macro ac:name="code">`,
+				`<ac:parameter ac:name="language"></ac:parameter>`,
+				`<ac:parameter ac:name="collapse">true</ac:parameter>`,
+				`<ac:plain-text-body><![CDATA[text]]></ac:plain-text-body>`,
+				`</ac:structured-macro>`,
+			),
+			markdown: text(
+				"```collapse",
+				"text",
+				"```",
+			),
+		},
+		{
+			name: "special characters",
+			html: text(
+				`<p>before</p>`,
+				`<ac:structured-macro ac:name="code">`,
+				`<ac:plain-text-body><![CDATA[<a href="#">*x*</a> ]]]]><![CDATA[> `,
+				"```",
+				`  ]]></ac:plain-text-body>`,
+				`</ac:structured-macro>`,
+			),
+			markdown: text(
+				"before",
+				"",
+				"````",
+				`<a href="#">*x*</a> ]]> `,
+				"```",
+				"  ",
+				"````",
+			),
+		},
+		{
+			name: "noformat",
+			html: text(
+				`<ac:structured-macro ac:name="noformat">`,
+				`<ac:plain-text-body><![CDATA[&amp; &lt;b&gt; &#x27;`,
+				"\t<b>bold</b>   &nbsp;",
+				`]]></ac:plain-text-body>`,
+				`</ac:structured-macro>`,
+			),
+			markdown: text(
+				"```",
+				"&amp; &lt;b&gt; &#x27;",
+				"\t<b>bold</b>   &nbsp;",
+				"",
+				"```",
+			),
+		},
+		{
+			name: "plantuml",
+			html: text(
+				`<ac:structured-macro ac:name="plantuml">`,
+				`<ac:plain-text-body><![CDATA[@startuml`,
+				`A -> B : <![CDATA[x]]]]><![CDATA[>`,
+				`B --> A : ]]]]><![CDATA[>]]]]><![CDATA[>`,
+				`@enduml]]></ac:plain-text-body>`,
+				`</ac:structured-macro>`,
+			),
+			markdown: text(
+				"```plantuml",
+				"@startuml",
+				"A -> B : <![CDATA[x]]>",
+				"B --> A : ]]>]]>",
+				"@enduml",
+				"```",
+			),
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			markdown, err := HtmlToMarkdown(testcase.html)
+			assert.NoError(t, err)
+			assert.Equal(t, testcase.markdown, markdown)
+		})
+	}
+}
+
+func TestHtmlToMarkdown_CodeRoundTrip(t *testing.T) {
+	source, err := ioutil.ReadFile("testdata/codes.md")
+	assert.NoError(t, err)
+
+	html := compileTestMarkdown(t, string(source))
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+
+	assert.Equal(t, html, compileTestMarkdown(t, markdown))
+}
+
+func TestHtmlToMarkdown_Image(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<p><ac:image ac:width="300" ac:align="center"><ri:attachment ri:filename="x.png"/></ac:image></p>`,
+		`<p><ac:image ac:alt="my [logo]"><ri:attachment ri:filename="my logo.png" /></ac:image></p>`,
+		`<p><ac:image><ri:url ri:value="https://example.com/y.png"/></ac:image> text</p>`,
+	), ConvertAttachmentsPath("images"))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`![](images/x.png){width=300 align=center}`,
+		``,
+		`![my \[logo\]](<images/my logo.png>)`,
+		``,
+		`![](https://example.com/y.png) text`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_ImageAttachments(t *testing.T) {
+	filenames := []string{}
+
+	markdown, err := HtmlToMarkdown(
+		`<ac:image><ri:attachment ri:filename="x.png"/></ac:image>`+
+			`<table><tbody><tr><th>`+
+			`<ac:image><ri:attachment ri:filename="x.png"/></ac:image>`+
+			`</th></tr></tbody></table>`,
+		ConvertAttachments(func(filename string) (string, error) {
+			filenames = append(filenames, filename)
+
+			return "downloaded/" + filename, nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`![](downloaded/x.png)`,
+		``,
+		`| ![](downloaded/x.png) |`,
+		`| --- |`,
+	), markdown)
+	assert.Equal(t, []string{"x.png"}, filenames)
+
+	_, err = HtmlToMarkdown(
+		`<ac:image><ri:attachment ri:filename="x.png"/></ac:image>`,
+		ConvertAttachments(func(filename string) (string, error) {
+			return "", errors.New("download failed")
+		}),
+	)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "download failed")
+}
+
+func TestHtmlToMarkdown_Link(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<p><ac:link><ri:page ri:content-title="Other Page"/></ac:link></p>`,
+		`<p><ac:link ac:anchor="usage"><ri:page ri:space-key="DOC" ri:content-title="Other Page"/></ac:link></p>`,
+		`<p><ac:link><ri:page ri:content-title="Other Page"/><ac:plain-text-link-body><![CDATA[see here]]></ac:plain-text-link-body></ac:link></p>`,
+		`<p><ac:link><ri:page ri:content-title="Other Page"/><ac:link-body><strong>bold</strong> text</ac:link-body></ac:link></p>`,
+		`<p><ac:link ac:anchor="top"/></p>`,
+		`<p><ac:link><ri:attachment ri:filename="doc.pdf"/></ac:link></p>`,
+		`<p>ping <ac:link><ri:user ri:account-id="5b10ac8d"/></ac:link></p>`,
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`[Other Page](Other Page)`,
+		``,
+		`[Other Page](Other Page#usage)`,
+		``,
+		`[see here](Other Page)`,
+		``,
+		`[**bold** text](Other Page)`,
+		``,
+		`[top](#top)`,
+		``,
+		`[doc.pdf](attachments/doc.pdf)`,
+		``,
+		`ping @5b10ac8d`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_LinkResolver(t *testing.T) {
+	markdown, err := HtmlToMarkdown(
+		text(
+			`<p><ac:link><ri:page ri:space-key="DOC" ri:content-title="Known"/></ac:link></p>`,
+			`<p><ac:link ac:anchor="a"><ri:page ri:content-title="Unknown"/></ac:link></p>`,
+		),
+		ConvertPageLinks(func(space, title string) (string, bool) {
+			if title != "Known" {
+				return "", false
+			}
+
+			return "../" + space + "/known.md", true
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`[Known](../DOC/known.md)`,
+		``,
+		`[Unknown](Unknown#a)`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_Admonition(t *testing.T) {
+	html := text(
+		`<ac:structured-macro ac:name="warning">`,
+		`<ac:parameter ac:name="icon">true</ac:parameter>`,
+		`<ac:parameter ac:name="title">Be careful</ac:parameter>`,
+		`<ac:rich-text-body><p>Some <strong>bold</strong> text.</p><p>More.</p></ac:rich-text-body>`,
+		`</ac:structured-macro>`,
+	)
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`> [!CAUTION] Be careful`,
+		`> Some **bold** text.`,
+		`>`,
+		`> More.`,
+	), markdown)
+
+	markdown, err = HtmlToMarkdown(html, ConvertAdmonitions(AdmonitionContainer))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`:::warning Be careful`,
+		`Some **bold** text.`,
+		``,
+		`More.`,
+		`:::`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_AdmonitionRoundTrip(t *testing.T) {
+	source, err := ioutil.ReadFile("testdata/admonitions.md")
+	assert.NoError(t, err)
+
+	html := compileTestMarkdown(t, string(source))
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+
+	assert.Equal(t, html, compileTestMarkdown(t, markdown))
+}
+
+func TestHtmlToMarkdown_Expand(t *testing.T) {
+	html := text(
+		`<ac:structured-macro ac:name="expand">`,
+		`<ac:parameter ac:name="title">Details &amp; more</ac:parameter>`,
+		`<ac:rich-text-body>`,
+		`<p>Body</p>`,
+		`<ac:structured-macro ac:name="code">`,
+		`<ac:parameter ac:name="language">bash</ac:parameter>`,
+		`<ac:plain-text-body><![CDATA[echo 1]]></ac:plain-text-body>`,
+		`</ac:structured-macro>`,
+		`<ac:structured-macro ac:name="expand">`,
+		`<ac:rich-text-body><p>Nested</p></ac:rich-text-body>`,
+		`</ac:structured-macro>`,
+		`</ac:rich-text-body>`,
+		`</ac:structured-macro>`,
+	)
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`<details><summary>Details &amp; more</summary>`,
+		``,
+		`Body`,
+		``,
+		"```bash",
+		`echo 1`,
+		"```",
+		``,
+		`<details>`,
+		``,
+		`Nested`,
+		``,
+		`</details>`,
+		``,
+		`</details>`,
+	), markdown)
+
+	markdown, err = HtmlToMarkdown(html, ConvertExpands(ExpandFenced))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		"````expand Details & more",
+		`Body`,
+		``,
+		"```bash",
+		`echo 1`,
+		"```",
+		``,
+		"```expand",
+		`Nested`,
+		"```",
+		"````",
+	), markdown)
+}
+
+func TestHtmlToMarkdown_ExpandRoundTrip(t *testing.T) {
+	markdown := text(
+		`<details><summary>Details &amp; more</summary>`,
+		``,
+		`Body`,
+		``,
+		`<details>`,
+		``,
+		`Nested`,
+		``,
+		`</details>`,
+		``,
+		`</details>`,
+	)
+
+	html := compileTestMarkdown(t, markdown)
+	assert.NotContains(t, html, "details")
+
+	converted, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+
+	assert.Equal(t, markdown, converted)
+}
+
+func TestHtmlToMarkdown_TaskList(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<p>Tasks:</p>`,
+		`<ac:task-list>`,
+		`<ac:task>`,
+		`<ac:task-id>1</ac:task-id>`,
+		`<ac:task-status>complete</ac:task-status>`,
+		`<ac:task-body>Ship <strong>it</strong></ac:task-body>`,
+		`</ac:task>`,
+		`<ac:task>`,
+		`<ac:task-id>2</ac:task-id>`,
+		`<ac:task-status>incomplete</ac:task-status>`,
+		`<ac:task-body>Ask <ac:link><ri:user ri:account-id="5b10ac8d"/></ac:link> by <time datetime="2023-01-02"/>`,
+		`<ac:task-list>`,
+		`<ac:task>`,
+		`<ac:task-id>3</ac:task-id>`,
+		`<ac:task-status>incomplete</ac:task-status>`,
+		`<ac:task-body>Nested</ac:task-body>`,
+		`</ac:task>`,
+		`</ac:task-list>`,
+		`</ac:task-body>`,
+		`</ac:task>`,
+		`</ac:task-list>`,
+		`<p>After</p>`,
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`Tasks:`,
+		``,
+		`- [x] Ship **it**`,
+		`- [ ] Ask @5b10ac8d by 2023-01-02`,
+		`  - [ ] Nested`,
+		``,
+		`After`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_Mention(t *testing.T) {
+	html := text(
+		`<p>Ping <ac:link><ri:user ri:account-id="1a"/></ac:link>`,
+		`and <ac:link><ri:user ri:account-id="2b"/></ac:link></p>`,
+		`<table><tbody><tr><th><ac:link><ri:user ri:account-id="1a"/></ac:link></th></tr></tbody></table>`,
+	)
+
+	users := ConvertUsers(func(id string) (string, bool) {
+		if id == "1a" {
+			return "John Doe", true
+		}
+
+		return "", false
+	})
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+	assert.Contains(t, markdown, "Ping @1a\nand @2b")
+	assert.Equal(t, 2, strings.Count(markdown, "@1a"), "mention in table cell")
+
+	markdown, err = HtmlToMarkdown(html, users)
+	assert.NoError(t, err)
+	assert.Contains(t, markdown, "Ping @John Doe\nand @2b")
+
+	markdown, err = HtmlToMarkdown(html, users, ConvertMentions(MentionMacro))
+	assert.NoError(t, err)
+	assert.Contains(t, markdown, "Ping @{John Doe}\nand @{2b}")
+	assert.Equal(t, 2, strings.Count(markdown, "@{John Doe}"))
+}
+
+func TestHtmlToMarkdown_Emoticon(t *testing.T) {
+	html := text(
+		`<p>Done <ac:emoticon ac:name="tick"/>`,
+		`<ac:emoticon ac:name="blue-star" ac:emoji-shortname=":star:"/>`,
+		`<ac:emoticon ac:name="blue-star" ac:emoji-shortname=":grinning:" ac:emoji-fallback="😀"/>`,
+		`<ac:emoticon ac:name="custom"/></p>`,
+	)
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		`Done :white_check_mark: :large_blue_circle: :large_blue_circle: :custom:`,
+		markdown,
+	)
+
+	markdown, err = HtmlToMarkdown(html, ConvertEmoticons(EmoticonUnicode))
+	assert.NoError(t, err)
+	assert.Equal(t, `Done ✅ 🔵 🔵 :custom:`, markdown)
+}
+
+func TestHtmlToMarkdown_TOC(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	assert.NoError(t, err)
+
+	include := text(
+		`<!-- Include: ac:toc`,
+		`     MaxLevel: '3'`,
+		`     MinLevel: '2' -->`,
+	)
+
+	_, html, _, err := includes.ProcessIncludes(
+		".",
+		[]byte(include),
+		lib.Templates,
+		nil,
+	)
+	assert.NoError(t, err)
+
+	markdown, err := HtmlToMarkdown(
+		`<h1>Title</h1>` + string(html) + `<p>text</p>` +
+			`<ac:structured-macro ac:name="toc"></ac:structured-macro>`,
+	)
+	assert.NoError(t, err)
+
+	assert.Contains(t, markdown, text(
+		`<!-- Include: ac:toc`,
+		`     Printable: 'true'`,
+		`     Style: 'disc'`,
+		`     MaxLevel: '3'`,
+		`     MinLevel: '2'`,
+		`     Type: 'list'`,
+		`     Outline: 'clear' -->`,
+	))
+	assert.Contains(t, markdown, `<!-- Include: ac:toc -->`)
+
+	_, again, _, err := includes.ProcessIncludes(
+		".",
+		[]byte(strings.SplitN(markdown, "\n\n", 3)[1]),
+		lib.Templates,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, string(html), string(again))
+}
+
+func TestHtmlToMarkdown_Status(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	assert.NoError(t, err)
+
+	_, status, _, err := includes.ProcessIncludes(
+		".",
+		[]byte(text(
+			`<!-- Include: ac:status`,
+			`     Title: It's done`,
+			`     Color: Green -->`,
+		)),
+		lib.Templates,
+		nil,
+	)
+	assert.NoError(t, err)
+
+	html := `<table><tbody><tr><th>State</th></tr><tr><td>` + string(status) +
+		`</td></tr></tbody></table>`
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`| State |`,
+		`| --- |`,
+		`| <!-- Include: ac:status {Title: 'It''s done', Color: 'Green'} --> |`,
+	), markdown)
+
+	_, again, _, err := includes.ProcessIncludes(
+		".",
+		[]byte(`<!-- Include: ac:status {Title: 'It''s done', Color: 'Green'} -->`),
+		lib.Templates,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, string(status), string(again))
+
+	markdown, err = HtmlToMarkdown(html, ConvertStatuses(StatusText))
+	assert.NoError(t, err)
+	assert.Contains(t, markdown, `| **[IT'S DONE]** |`)
+}
+
+func TestHtmlToMarkdown_Jira(t *testing.T) {
+	html := text(
+		`<p>See <ac:structured-macro ac:name="jira"><ac:parameter ac:name="key">PROJ-123</ac:parameter></ac:structured-macro></p>`,
+		`<p>Old <ac:macro ac:name="jira"><ac:default-parameter>PROJ-7</ac:default-parameter></ac:macro></p>`,
+		`<p>Body <ac:structured-macro ac:name="jira"><ac:plain-text-body><![CDATA[OPS-1]]></ac:plain-text-body></ac:structured-macro></p>`,
+		`<ac:structured-macro ac:name="jira"><ac:parameter ac:name="jqlQuery">project = PROJ AND status != Done</ac:parameter></ac:structured-macro>`,
+	)
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`See PROJ-123`,
+		``,
+		`Old PROJ-7`,
+		``,
+		`Body OPS-1`,
+		``,
+		`<!-- Jira: project = PROJ AND status != Done -->`,
+	), markdown)
+
+	markdown, err = HtmlToMarkdown(html, ConvertJiraURL("https://jira.example.com/"))
+	assert.NoError(t, err)
+	assert.Contains(t, markdown, `See [PROJ-123](https://jira.example.com/browse/PROJ-123)`)
+	assert.Contains(t, markdown, `Old [PROJ-7](https://jira.example.com/browse/PROJ-7)`)
+}
+
+func TestHtmlToMarkdown_UnknownMacro(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<ac:macro ac:name="unknown"><ac:rich-text-body><p>kept</p></ac:rich-text-body></ac:macro>`,
+		`<ac:structured-macro ac:name="unknown"><ac:rich-text-body><p>also kept</p></ac:rich-text-body></ac:structured-macro>`,
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, text(`kept`, ``, `also kept`), markdown)
+}
+
+func TestHtmlToMarkdown_Table(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<table><tbody>`,
+		`<tr><th>Name</th><th style="text-align: center">Count</th><th align="right">Price</th></tr>`,
+		`<tr><td><p><strong>a</strong> | b</p></td><td>1</td><td>line<br/>break</td></tr>`,
+		`</tbody></table>`,
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`| Name | Count | Price |`,
+		`| --- | :---: | ---: |`,
+		`| **a** \| b | 1 | line<br/>break |`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_TableHTML(t *testing.T) {
+	testcases := []struct {
+		name string
+		html string
+	}{
+		{
+			name: "no header",
+			html: `<table><tbody><tr><td>a</td></tr></tbody></table>`,
+		},
+		{
+			name: "merged cells",
+			html: `<table><tbody><tr><th>a</th><th>b</th></tr><tr><td colspan="2">c</td></tr></tbody></table>`,
+		},
+		{
+			name: "block content",
+			html: `<table><tbody><tr><th>a</th></tr><tr><td><ul><li>b</li></ul></td></tr></tbody></table>`,
+		},
+		{
+			name: "code macro",
+			html: `<table><tbody><tr><th>a</th></tr><tr><td><ac:structured-macro ac:name="code"><ac:plain-text-body><![CDATA[<x> ]]]]><![CDATA[>]]></ac:plain-text-body></ac:structured-macro></td></tr></tbody></table>`,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			markdown, err := HtmlToMarkdown(`<p>text</p>` + testcase.html)
+			assert.NoError(t, err)
+			assert.Equal(t, "text\n\n"+testcase.html, markdown)
+		})
+	}
+}
+
+func TestHtmlToMarkdown_Layout(t *testing.T) {
+	html := text(
+		`<ac:layout>`,
+		`<ac:layout-section ac:type="two_equal">`,
+		`<ac:layout-cell><p>left</p></ac:layout-cell>`,
+		`<ac:layout-cell><p>right</p><ul><li>item</li></ul></ac:layout-cell>`,
+		`</ac:layout-section>`,
+		`<ac:layout-section ac:type="single">`,
+		`<ac:layout-cell><p></p></ac:layout-cell>`,
+		`</ac:layout-section>`,
+		`<ac:layout-section ac:type="single">`,
+		`<ac:layout-cell>`,
+		`<ac:layout><ac:layout-section ac:type="two_equal">`,
+		`<ac:layout-cell><p>inner</p></ac:layout-cell>`,
+		`<ac:layout-cell> </ac:layout-cell>`,
+		`</ac:layout-section></ac:layout>`,
+		`</ac:layout-cell>`,
+		`</ac:layout-section>`,
+		`</ac:layout>`,
+		`<p>after</p>`,
+	)
+
+	markdown, err := HtmlToMarkdown(html)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`left`,
+		``,
+		`---`,
+		``,
+		`right`,
+		``,
+		`- item`,
+		``,
+		`---`,
+		``,
+		`inner`,
+		``,
+		`after`,
+	), markdown)
+
+	markdown, err = HtmlToMarkdown(html, ConvertLayouts(LayoutColumn))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`:::column`,
+		`left`,
+		`:::`,
+		``,
+		`:::column`,
+		`right`,
+		``,
+		`- item`,
+		`:::`,
+		``,
+		`:::column`,
+		`:::column`,
+		`inner`,
+		`:::`,
+		`:::`,
+		``,
+		`after`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_Anchor(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<p><ac:link ac:anchor="Getting Started"/></p>`,
+		`<p><ac:link ac:anchor="install"><ac:plain-text-link-body><![CDATA[install]]></ac:plain-text-link-body></ac:link></p>`,
+		`<p><a href="#Page-GettingStarted">view link</a> <a href="#unknown">unknown</a></p>`,
+		`<p><ac:link ac:anchor="details"><ri:page ri:content-title="Other"/></ac:link></p>`,
+		`<h1><ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">getting-started</ac:parameter></ac:structured-macro>Getting Started</h1>`,
+		`<p><ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">install</ac:parameter></ac:structured-macro></p>`,
+		`<h2 id="Page-GettingStarted">Getting Started</h2>`,
+		`<p>text <ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">here</ac:parameter></ac:structured-macro> and more</p>`,
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`[Getting Started](#getting-started)`,
+		``,
+		`[install](#getting-started-1)`,
+		``,
+		`[view link](#getting-started-1) [unknown](#unknown)`,
+		``,
+		`[Other](Other#details)`,
+		``,
+		`# Getting Started`,
+		``,
+		`<!-- Include: ac:anchor {Name: 'install'} -->`,
+		``,
+		`## Getting Started`,
+		``,
+		`text <!-- Include: ac:anchor {Name: 'here'} --> and more`,
+	), markdown)
+}
+
+func TestConvertPages(t *testing.T) {
+	pages := []PageHTML{}
+	for i := 0; i < 20; i++ {
+		pages = append(pages, PageHTML{
+			Name: strconv.Itoa(i),
+			HTML: `<p>page ` + strconv.Itoa(i) + `</p>`,
+		})
+	}
+
+	pages[7].HTML = `<ac:image><ri:attachment ri:filename="x.png"/></ac:image>`
+	pages[7].Options = []ConvertOption{
+		ConvertAttachments(func(string) (string, error) {
+			return "", errors.New("download failed")
+		}),
+	}
+
+	results, err := ConvertPages(context.Background(), pages, 4)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "page: 7")
+	assert.Contains(t, err.Error(), "download failed")
+
+	assert.Len(t, results, len(pages))
+	for i, result := range results {
+		assert.Equal(t, strconv.Itoa(i), result.Name)
+
+		if i == 7 {
+			assert.Error(t, result.Err)
+			continue
+		}
+
+		assert.NoError(t, result.Err)
+		assert.Equal(t, "page "+strconv.Itoa(i), result.Markdown)
+	}
+}
+
+func TestConvertPages_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := ConvertPages(
+		ctx,
+		[]PageHTML{{Name: "a", HTML: `<p>a</p>`}, {Name: "b", HTML: `<p>b</p>`}},
+		2,
+	)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Len(t, results, 2)
+
+	for _, result := range results {
+		assert.True(t, errors.Is(result.Err, context.Canceled))
+	}
+}
+
+func BenchmarkConvertPages(b *testing.B) {
+	source, err := ioutil.ReadFile("testdata/codes.md")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	html := MustCompileMarkdown(source, lib)
+
+	pages := make([]PageHTML, 500)
+	for i := range pages {
+		pages[i] = PageHTML{Name: strconv.Itoa(i), HTML: html}
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := ConvertPages(context.Background(), pages, workers)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestHtmlToMarkdown_Sanitize(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<p class="auto-cursor-target"><br/></p>`,
+		`<table ac:local-id="123"><tbody><tr><td><p>a</p></td></tr></tbody></table>`,
+		`<p class="auto-cursor-target"> </p>`,
+		`<ac:structured-macro ac:name="sidebar" ac:schema-version="1" ac:macro-id="abc"><ac:rich-text-body><p>body</p></ac:rich-text-body></ac:structured-macro>`,
+		`<p class="auto-cursor-target"><ac:image><ri:attachment ri:filename="x.png" ri:version-at-save="2"/></ac:image></p>`,
+		`<p><span class="confluence-embedded-file-wrapper"><a href="https://example.com">link</a></span></p>`,
+		`<p><span class="highlight">kept</span></p>`,
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`<table><tbody><tr><td><p>a</p></td></tr></tbody></table>`,
+		``,
+		`body`,
+		``,
+		`![](attachments/x.png)`,
+		``,
+		`[link](https://example.com)`,
+		``,
+		`kept`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_SanitizeExtended(t *testing.T) {
+	defer func(attributes []string) {
+		SanitizedAttributes = attributes
+	}(SanitizedAttributes)
+
+	SanitizedAttributes = append(SanitizedAttributes, "data-custom")
+
+	markdown, err := HtmlToMarkdown(
+		`<table data-custom="x" class="wrapped"><tbody><tr><td>a</td></tr></tbody></table>`,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, `<table class="wrapped"><tbody><tr><td>a</td></tr></tbody></table>`, markdown)
+}
+
+func TestHtmlToMarkdown_Meta(t *testing.T) {
+	pageMeta := PageMeta{
+		Space:   "ENG",
+		Type:    "page",
+		Parents: []string{"Docs", "Guides"},
+		Title:   "Arrows --> and\nlines",
+		Labels:  []string{"a", "b"},
+	}
+
+	markdown, err := HtmlToMarkdown(`<p>body</p>`, ConvertMeta(pageMeta))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`<!-- Space: ENG -->`,
+		`<!-- Parent: Docs -->`,
+		`<!-- Parent: Guides -->`,
+		`<!-- Title: Arrows --&gt; and lines -->`,
+		`<!-- Label: a -->`,
+		`<!-- Label: b -->`,
+		``,
+		`body`,
+	), markdown)
+
+	meta, body, err := ExtractMeta([]byte(markdown))
+	assert.NoError(t, err)
+	assert.Equal(t, "ENG", meta.Space)
+	assert.Equal(t, "page", meta.Type)
+	assert.Equal(t, []string{"Docs", "Guides"}, meta.Parents)
+	assert.Equal(t, "Arrows --> and lines", meta.Title)
+	assert.Equal(t, []string{"a", "b"}, meta.Labels)
+	assert.Equal(t, "body", string(body))
+
+	markdown, err = HtmlToMarkdown(
+		`<p>body</p>`,
+		ConvertMeta(pageMeta),
+		ConvertFrontMatter(true),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`---`,
+		`space: 'ENG'`,
+		`parents:`,
+		`  - 'Docs'`,
+		`  - 'Guides'`,
+		`title: 'Arrows --> and lines'`,
+		`labels:`,
+		`  - 'a'`,
+		`  - 'b'`,
+		`---`,
+		``,
+		`body`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_PageProperties(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<ac:structured-macro ac:name="details">`,
+		`<ac:parameter ac:name="id">release</ac:parameter>`,
+		`<ac:rich-text-body><table><tbody>`,
+		`<tr><th>Owner</th><th>Due</th></tr>`,
+		`<tr><td>team</td><td>May</td></tr>`,
+		`</tbody></table></ac:rich-text-body>`,
+		`</ac:structured-macro>`,
+		`<ac:structured-macro ac:name="details">`,
+		`<ac:rich-text-body><table><tbody>`,
+		`<tr><th>Status</th><td>done</td></tr>`,
+		`</tbody></table></ac:rich-text-body>`,
+		`</ac:structured-macro>`,
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`<!-- page-properties id=release -->`,
+		``,
+		`| Owner | Due |`,
+		`| --- | --- |`,
+		`| team | May |`,
+		``,
+		`<!-- page-properties -->`,
+		``,
+		`<table><tbody>`,
+		`<tr><th>Status</th><td>done</td></tr>`,
+		`</tbody></table>`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_Entities(t *testing.T) {
+	html, err := ioutil.ReadFile("testdata/convert/entities.html")
+	assert.NoError(t, err)
+
+	expected, err := ioutil.ReadFile("testdata/convert/entities.md")
+	assert.NoError(t, err)
+
+	markdown, err := HtmlToMarkdown(string(html))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.TrimSuffix(string(expected), "\n"), markdown)
+
+	markdown, err = HtmlToMarkdown(compileTestMarkdown(t, markdown))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.TrimSuffix(string(expected), "\n"), markdown)
+}
+
+func TestFixMojibake(t *testing.T) {
+	assert.Equal(t, "café – 100\u00a0€", fixMojibake("cafÃ© â€“ 100Â\u00a0â‚¬"))
+	assert.Equal(t, "–", fixMojibake("Ã¢â‚¬â€œ"))
+	assert.Equal(t, "Ägypten Â x", fixMojibake("Ägypten Â x"))
+}
+
+func TestHtmlToMarkdown_Attachments(t *testing.T) {
+	filenames := []string{}
+
+	markdown, err := HtmlToMarkdown(
+		text(
+			`<ac:structured-macro ac:name="attachments"><ac:parameter ac:name="upload">false</ac:parameter></ac:structured-macro>`,
+			`<p><ac:link><ri:attachment ri:filename="release notes.pdf"/></ac:link></p>`,
+			`<p><ac:image><ri:attachment ri:filename="x.png"/></ac:image></p>`,
+			`<p><ac:link><ri:attachment ri:filename="x.png"/><ac:plain-text-link-body><![CDATA[image]]></ac:plain-text-link-body></ac:link></p>`,
+		),
+		ConvertAttachmentsPath("files"),
+		ConvertAttachmentNames(&filenames),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`<!-- attachments -->`,
+		``,
+		`[release notes.pdf](<files/release notes.pdf>)`,
+		``,
+		`![](files/x.png)`,
+		``,
+		`[image](files/x.png)`,
+	), markdown)
+	assert.Equal(t, []string{"release notes.pdf", "x.png"}, filenames)
+
+	results, err := ConvertPages(
+		context.Background(),
+		[]PageHTML{{HTML: `<ac:image><ri:attachment ri:filename="y.png"/></ac:image>`}},
+		1,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"y.png"}, results[0].Attachments)
+}
+
+func TestHtmlToMarkdown_InlineComment(t *testing.T) {
+	markdown, err := HtmlToMarkdown(text(
+		`<p>a <ac:inline-comment-marker ac:ref="5ef2-a1">commented </ac:inline-comment-marker>text</p>`,
+		`<p>b <span class="inline-comment-marker" data-ref="7c9d"><strong>bold</strong> text</span></p>`,
+		`<p><span class="inline-comment-marker" data-ref="x' onclick='y">invalid</span> <span class="other">plain</span></p>`,
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`a <!--comment_id='5ef2-a1'-->commented<!----> text`,
+		``,
+		`b <!--comment_id='7c9d'-->**bold** text<!---->`,
+		``,
+		`invalid plain`,
+	), markdown)
+}
+
+func TestHtmlToMarkdown_InlineCommentsWithIdenticalText(t *testing.T) {
+	markdown, err := HtmlToMarkdown(
+		`<p><span class="inline-comment-marker" data-ref="a1">same</span> and ` +
+			`<span class="inline-comment-marker" data-ref="b2">same</span> and ` +
+			`<span class="inline-comment-marker" data-ref="c3">two` + "\n" + `lines</span></p>`,
+	)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		"<!--comment_id='a1'-->same<!----> and "+
+			"<!--comment_id='b2'-->same<!----> and "+
+			"<!--comment_id='c3'-->two\nlines<!---->",
+		markdown,
+	)
+}
+
+func TestHtmlToMarkdown_InlineCommentRoundTrip(t *testing.T) {
+	source := text(
+		`# <!--comment_id='h1'-->Heading<!---->`,
+		``,
+		`Some <!--comment_id='d8d970d4-eecf'-->commented<!----> text.`,
+		``,
+		`- item with <!--comment_id='a1'-->**bold** and _italic_<!----> text`,
+	)
+
+	markdown, err := HtmlToMarkdown(compileTestMarkdown(t, source))
+	assert.NoError(t, err)
+	assert.Equal(t, source, markdown)
+}
@@ -0,0 +1,129 @@
+package mark
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+var reSpaces = regexp.MustCompile(`[ \t]{2,}`)
+
+// nbspPlaceholder replaces non-breaking spaces in table cells, since
+// converter trims them along with other whitespace. It uses NUL characters
+// for the same reason as block placeholders.
+const nbspPlaceholder = "\x00nbsp\x00"
+
+// windows1252 maps characters which Windows-1252 has in place of C1 control
+// characters of Latin-1 back to their bytes. UTF-8 text decoded as
+// Windows-1252 contains these characters, e.g. ’ becomes â€™.
+var windows1252 = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86,
+	'‡': 0x87, 'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c,
+	'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95,
+	'–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// normalizeText cleans up text of the page body. Entities are decoded by
+// HTML parser already, but the text still contains UTF-8 decoded twice,
+// non-breaking and zero-width spaces and runs of spaces inserted by
+// Confluence editor. Non-breaking spaces are kept in table cells, where
+// they're used for alignment, and text of code is kept as is.
+func normalizeText(document *goquery.Selection) {
+	var walk func(node *html.Node, cell bool)
+	walk = func(node *html.Node, cell bool) {
+		if node.Type == html.TextNode {
+			node.Data = normalizeTextData(node.Data, cell)
+
+			return
+		}
+
+		if node.Type == html.ElementNode {
+			switch node.Data {
+			case "pre", "code", "ac:plain-text-body", "ac:plain-text-link-body":
+				return
+
+			case "td", "th":
+				cell = true
+			}
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, cell)
+		}
+	}
+
+	for _, node := range document.Nodes {
+		walk(node, false)
+	}
+}
+
+func normalizeTextData(text string, cell bool) string {
+	text = fixMojibake(text)
+
+	text = strings.NewReplacer("\u200b", "", "\ufeff", "").Replace(text)
+
+	if cell {
+		text = strings.ReplaceAll(text, "\u00a0", nbspPlaceholder)
+	} else {
+		text = strings.ReplaceAll(text, "\u00a0", " ")
+	}
+
+	return reSpaces.ReplaceAllString(text, " ")
+}
+
+// fixMojibake decodes parts of text which are UTF-8 decoded as Latin-1 or
+// Windows-1252, e.g. Â followed by non-breaking space. Only sequences of
+// characters which bytes form valid multi-byte UTF-8 characters are decoded,
+// so text can mix such parts with proper ones. Text decoded twice is fixed
+// by decoding it until nothing changes.
+func fixMojibake(text string) string {
+	for {
+		fixed := decodeMojibake(text)
+		if fixed == text {
+			return text
+		}
+
+		text = fixed
+	}
+}
+
+func decodeMojibake(text string) string {
+	var (
+		chars = []rune(text)
+		raw   = make([]byte, len(chars))
+		valid = make([]bool, len(chars))
+	)
+
+	for index, char := range chars {
+		if b, ok := windows1252[char]; ok {
+			raw[index], valid[index] = b, true
+		} else if char >= 0x80 && char < 0x100 {
+			raw[index], valid[index] = byte(char), true
+		}
+	}
+
+	var buffer strings.Builder
+
+	for index := 0; index < len(chars); index++ {
+		end := index
+		for end < len(chars) && end-index < utf8.UTFMax && valid[end] {
+			end++
+		}
+
+		if char, size := utf8.DecodeRune(raw[index:end]); size > 1 &&
+			char != utf8.RuneError {
+			buffer.WriteRune(char)
+			index += size - 1
+
+			continue
+		}
+
+		buffer.WriteRune(chars[index])
+	}
+
+	return buffer.String()
+}
@@ -0,0 +1,216 @@
+package mark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// DefaultsFile is the name of the file of default metadata of documents of
+// the directory and its subdirectories. It's the header-only markdown file,
+// which headers are the same as headers of documents, e.g.:
+//
+//	<!-- Space: ENG -->
+//	<!-- Parent: Engineering -->
+//	<!-- Label: handbook -->
+//
+// Anything but headers is ignored and the file itself is not a document.
+const DefaultsFile = "_defaults.md"
+
+// ReadDefaultMeta returns default metadata of documents of the directory,
+// which is merged from DefaultsFile files of directories from root down to
+// the directory itself by MergeMeta, so nearer directories take precedence.
+// Only the file of the directory itself is read if root is empty or the
+// directory is not within root. Nil is returned if there are no such files.
+func ReadDefaultMeta(dir string, root string) (*Meta, error) {
+	var defaults *Meta
+
+	for _, dir := range getDefaultsDirs(dir, root) {
+		path := filepath.Join(dir, DefaultsFile)
+
+		markdown, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, karma.Format(err, "unable to read defaults file")
+		}
+
+		meta, _, err := ExtractMeta(markdown)
+		if err != nil {
+			return nil, karma.Describe("file", path).Format(
+				err,
+				"unable to extract metadata",
+			)
+		}
+
+		defaults = MergeMeta(defaults, meta)
+	}
+
+	return defaults, nil
+}
+
+// getDefaultsDirs returns directories from root down to dir.
+func getDefaultsDirs(dir string, root string) []string {
+	dir = filepath.Clean(dir)
+
+	if root == "" {
+		return []string{dir}
+	}
+
+	relative, err := filepath.Rel(root, dir)
+	if err != nil || relative == ".." ||
+		strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return []string{dir}
+	}
+
+	dirs := []string{filepath.Clean(root)}
+	if relative == "." {
+		return dirs
+	}
+
+	for _, name := range strings.Split(relative, string(filepath.Separator)) {
+		dirs = append(dirs, filepath.Join(dirs[len(dirs)-1], name))
+	}
+
+	return dirs
+}
+
+// MergeMeta returns metadata of the document merged with defaults, e.g.
+// read by ReadDefaultMeta. Headers of the document take precedence, so
+// values of defaults are used only for headers the document doesn't have:
+//
+//   - Labels of defaults are merged before labels of the document by
+//     MergeLabels, except for ones of Labels-Remove headers of the
+//     document.
+//   - Parents of the document replace parents of defaults, if it has any.
+//   - Vars and Options are merged by names.
+//   - Sidebar and Layout are inherited together, since Sidebar sets the
+//     layout as well.
+//   - Title and attachments are never inherited, since they're specific to
+//     the document, and so are unknown and invalid headers.
+//
+// Neither defaults nor meta are changed, either of them can be nil.
+func MergeMeta(defaults *Meta, meta *Meta) *Meta {
+	if defaults == nil {
+		return meta
+	}
+
+	var merged Meta
+	if meta != nil {
+		merged = *meta
+	} else {
+		merged = Meta{Type: "page", Status: StatusPublished}
+	}
+
+	inherit := func(headers ...string) bool {
+		for _, header := range headers {
+			if merged.has(header) {
+				return false
+			}
+		}
+
+		for _, header := range headers {
+			if defaults.has(header) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if inherit(HeaderParent) {
+		merged.Parents = append([]string{}, defaults.Parents...)
+	}
+
+	if inherit(HeaderSpace) {
+		merged.Space = defaults.Space
+	}
+
+	if inherit(HeaderType) {
+		merged.Type = defaults.Type
+	}
+
+	if inherit(HeaderLayout, HeaderSidebar) {
+		merged.Layout, merged.Sidebar = defaults.Layout, defaults.Sidebar
+	}
+
+	if len(defaults.Labels) > 0 {
+		merged.Labels = MergeLabels(
+			removeLabels(defaults.Labels, merged.RemovedLabels),
+			merged.Labels,
+		)
+	}
+
+	if len(defaults.RemovedLabels) > 0 {
+		merged.RemovedLabels = MergeLabels(defaults.RemovedLabels, merged.RemovedLabels)
+	}
+
+	if inherit(HeaderTitleH1) {
+		merged.TitleFromH1 = defaults.TitleFromH1
+	}
+
+	if len(defaults.Vars) > 0 {
+		vars := map[string]string{}
+		for name, value := range defaults.Vars {
+			vars[name] = value
+		}
+
+		for name, value := range merged.Vars {
+			vars[name] = value
+		}
+
+		merged.Vars = vars
+	}
+
+	if inherit(HeaderVersionMessage) {
+		merged.VersionMessage = defaults.VersionMessage
+	}
+
+	if inherit(HeaderMinorEdit) {
+		merged.MinorEdit = defaults.MinorEdit
+	}
+
+	if inherit(HeaderAppearance) {
+		merged.Appearance = defaults.Appearance
+	}
+
+	if inherit(HeaderEmoji) {
+		merged.Emoji, merged.EmojiID = defaults.Emoji, defaults.EmojiID
+	}
+
+	if inherit(HeaderGeneratedBanner) {
+		merged.NoGeneratedBanner = defaults.NoGeneratedBanner
+	}
+
+	if inherit(HeaderBoilerplate) {
+		merged.NoBoilerplate = defaults.NoBoilerplate
+	}
+
+	if inherit(HeaderStatus) {
+		merged.Status = defaults.Status
+	}
+
+	merged.Options = merged.Options.inherit(defaults.Options)
+
+	headers := map[string]bool{}
+	for _, given := range []map[string]bool{defaults.headers, merged.headers} {
+		for header := range given {
+			headers[header] = true
+		}
+	}
+
+	merged.headers = headers
+
+	return &merged
+}
+
+// has returns true if the header is given for the document, either by the
+// document itself or by defaults merged into it.
+func (meta *Meta) has(header string) bool {
+	return meta != nil && meta.headers[header]
+}
@@ -0,0 +1,157 @@
+package mark
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeMeta(t *testing.T) {
+	extract := func(lines ...string) *Meta {
+		t.Helper()
+
+		meta, _, err := ExtractMeta([]byte(text(append(lines, "", "body")...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return meta
+	}
+
+	defaults := extract(
+		`<!-- Space: ENG -->`,
+		`<!-- Type: blogpost -->`,
+		`<!-- Parent: Handbook -->`,
+		`<!-- Parent: Engineering -->`,
+		`<!-- Label: handbook -->`,
+		`<!-- Sidebar: Navigation -->`,
+		`<!-- Var: team=core -->`,
+		`<!-- Var: channel=#eng -->`,
+		`<!-- Title: Defaults -->`,
+		`<!-- Attachment: logo.png -->`,
+		`<!-- Status: draft -->`,
+	)
+
+	meta := MergeMeta(defaults, extract(
+		`<!-- Type: page -->`,
+		`<!-- Parent: Guides -->`,
+		`<!-- Label: deploy -->`,
+		`<!-- Layout: plain -->`,
+		`<!-- Var: team=ops -->`,
+	))
+
+	assert.Equal(t, "ENG", meta.Space)
+	assert.Equal(t, "page", meta.Type)
+	assert.Equal(t, []string{"Guides"}, meta.Parents)
+	assert.Equal(t, []string{"handbook", "deploy"}, meta.Labels)
+	assert.Equal(t, "plain", meta.Layout)
+	assert.Equal(t, "", meta.Sidebar)
+	assert.Equal(t, map[string]string{"team": "ops", "channel": "#eng"}, meta.Vars)
+	assert.Equal(t, "", meta.Title)
+	assert.Empty(t, meta.Attachments)
+	assert.Equal(t, StatusDraft, meta.Status)
+
+	// parents and layout are inherited if the document doesn't set them
+	meta = MergeMeta(defaults, extract(`<!-- Title: Deploy -->`))
+
+	assert.Equal(t, []string{"Handbook", "Engineering"}, meta.Parents)
+	assert.Equal(t, []string{"handbook"}, meta.Labels)
+	assert.Equal(t, "article", meta.Layout)
+	assert.Equal(t, "Navigation", meta.Sidebar)
+	assert.Equal(t, "blogpost", meta.Type)
+	assert.Equal(t, "Deploy", meta.Title)
+
+	// documents without headers get defaults as well
+	meta = MergeMeta(defaults, nil)
+	if assert.NotNil(t, meta) {
+		assert.Equal(t, "ENG", meta.Space)
+		assert.Equal(t, "", meta.Title)
+	}
+
+	own := extract(`<!-- Label: deploy -->`)
+
+	assert.Same(t, own, MergeMeta(nil, own))
+	assert.Nil(t, MergeMeta(nil, nil))
+
+	// neither of metadata are changed
+	MergeMeta(defaults, own)
+
+	assert.Equal(t, []string{"deploy"}, own.Labels)
+	assert.Equal(t, []string{"handbook"}, defaults.Labels)
+	assert.Equal(t, map[string]string{"team": "core", "channel": "#eng"}, defaults.Vars)
+}
+
+func TestReadDefaultMeta(t *testing.T) {
+	dir := writeMarkdownFiles(t, map[string]string{
+		"_defaults.md": text(
+			`<!-- Space: ENG -->`,
+			`<!-- Parent: Engineering -->`,
+			`<!-- Label: handbook -->`,
+		),
+		"guides/_defaults.md": text(
+			`<!-- Parent: Guides -->`,
+			`<!-- Label: guides -->`,
+			``,
+			`Defaults of guides.`,
+		),
+		"guides/deploy/rollback.md": "# Rollback\n",
+	})
+
+	defaults, err := ReadDefaultMeta(filepath.Join(dir, "guides", "deploy"), dir)
+	if assert.NoError(t, err) && assert.NotNil(t, defaults) {
+		assert.Equal(t, "ENG", defaults.Space)
+		assert.Equal(t, []string{"Guides"}, defaults.Parents)
+		assert.Equal(t, []string{"handbook", "guides"}, defaults.Labels)
+	}
+
+	// files above root are not read
+	defaults, err = ReadDefaultMeta(filepath.Join(dir, "guides"), filepath.Join(dir, "guides"))
+	if assert.NoError(t, err) && assert.NotNil(t, defaults) {
+		assert.Equal(t, "", defaults.Space)
+		assert.Equal(t, []string{"guides"}, defaults.Labels)
+	}
+
+	defaults, err = ReadDefaultMeta(filepath.Join(dir, "guides", "deploy"), "")
+	assert.NoError(t, err)
+	assert.Nil(t, defaults)
+
+	pages, err := ResolveHierarchy(dir)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]PageMeta{
+			"guides/deploy/rollback.md": {
+				Space:   "ENG",
+				Type:    "page",
+				Parents: []string{"Guides"},
+				Title:   "Rollback",
+				Labels:  []string{"handbook", "guides"},
+			},
+		},
+		pages,
+	)
+}
+
+func TestCompileMarkdownFile_Defaults(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"_defaults.md":      "<!-- Var: team=core -->\n",
+		"docs/_defaults.md": "<!-- Var: channel=#eng -->\n",
+		"docs/page.md":      "<!-- Var: team=ops -->\n\n${team} in ${channel}\n",
+	})
+
+	html, err := CompileMarkdownFile(
+		filepath.Join(dir, "docs", "page.md"),
+		lib,
+		CompileOptions{RootDir: dir},
+	)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "<p>ops in #eng</p>\n", html)
+	}
+}
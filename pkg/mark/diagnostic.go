@@ -0,0 +1,579 @@
+package mark
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/reconquest/karma-go"
+)
+
+// Severity of the diagnostic.
+type Severity string
+
+const (
+	// SeverityWarning is reported for problems which are worked around,
+	// e.g. dropped inline comment markers.
+	SeverityWarning Severity = "warning"
+
+	// SeverityError is reported for problems which make the output invalid.
+	SeverityError Severity = "error"
+)
+
+// Diagnostic is a problem found while compiling the document.
+type Diagnostic struct {
+	// File is CompileOptions.File or the file which the line is included
+	// from.
+	File string
+
+	// Line is 1-based line of the file, it's 0 if the line is not known.
+	// Lines are approximate, since not every node of the document tree can
+	// be located in the markdown precisely.
+	Line int
+
+	Severity Severity
+	Message  string
+}
+
+func (diagnostic Diagnostic) String() string {
+	switch {
+	case diagnostic.File != "" && diagnostic.Line > 0:
+		return fmt.Sprintf(
+			"%s:%d: %s",
+			diagnostic.File,
+			diagnostic.Line,
+			diagnostic.Message,
+		)
+
+	case diagnostic.File != "":
+		return diagnostic.File + ": " + diagnostic.Message
+
+	case diagnostic.Line > 0:
+		return fmt.Sprintf("line %d: %s", diagnostic.Line, diagnostic.Message)
+	}
+
+	return diagnostic.Message
+}
+
+// CompileResult is a result of Compile.
+type CompileResult struct {
+	HTML string
+
+	// Title is the title of the page resolved by ResolveTitle.
+	Title string
+
+	// Diagnostics are listed in order they're found. Warnings are reported
+	// to the logger as well.
+	Diagnostics []Diagnostic
+
+	// Attachments are files which must be uploaded as attachments of the
+	// page: files of Attachment headers, local images and links to local
+	// files other than markdown ones. Files are listed once, in order they
+	// appear in the document, missing files are listed with errors.
+	Attachments []AttachmentFile
+
+	Stats Stats
+
+	// ContentHash is the hash written into HTML if CompileOptions.ContentHash
+	// is set.
+	ContentHash string
+
+	// VersionMessage is Meta.VersionMessage with placeholders filled, the
+	// comment of the page version.
+	VersionMessage string
+
+	// MinorEdit defines whether the page is updated as minor edit, which
+	// doesn't notify watchers. It's false unless it's set by
+	// CompileOptions.MinorEdit or Meta.MinorEdit.
+	MinorEdit bool
+
+	// Labels are labels of the page, CompileOptions.Labels merged with
+	// Meta.Labels by MergeLabels.
+	Labels []string
+
+	// Options are effective values of options which documents can override
+	// by Options headers, see DocumentOptions.
+	Options DocumentOptions
+
+	// Preview is the HTML document rendered from HTML by RenderPreview if
+	// CompileOptions.Preview is set.
+	Preview string
+}
+
+// Compile compiles markdown like CompileMarkdownWithOptions does and returns
+// diagnostics found while compiling. Result is returned with the error as
+// well, e.g. storage format issues found by CompileOptions.Validate are
+// returned as error diagnostics along with *StorageError, HTML is empty
+// then.
+//
+// Results are reused from CompileOptions.Cache if it's set.
+func Compile(
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) (*CompileResult, error) {
+	if opts.Cache != nil {
+		return opts.Cache.compile(markdown, stdlib, opts)
+	}
+
+	return compileResult(markdown, stdlib, opts)
+}
+
+// compileResult compiles markdown into the result, see Compile.
+func compileResult(
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) (*CompileResult, error) {
+	var html strings.Builder
+
+	html.Grow(len(markdown))
+
+	state, err := compile(&html, markdown, stdlib, opts)
+
+	result := &CompileResult{
+		Title:       ResolveTitle(markdown, opts),
+		Diagnostics: state.diagnostics,
+		Attachments: state.attachments,
+		Stats:       state.stats,
+		ContentHash: state.contentHash,
+
+		VersionMessage: state.versionMessage,
+		MinorEdit:      opts.isMinorEdit(),
+		Labels:         opts.getLabels(),
+		Options:        opts.withDocumentOptions().getDocumentOptions(),
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.HTML = html.String()
+
+	if opts.Preview {
+		result.Preview, err = renderPreview(result.HTML, opts)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// compilation keeps track of positions of the document being compiled and
+// diagnostics found in it.
+type compilation struct {
+	file    string
+	sources *sourcemap.Map
+	logger  Logger
+
+	// strict reports warnings as errors
+	strict bool
+
+	// lines are offsets of lines of the markdown being parsed
+	lines []int
+
+	output *outputWriter
+
+	diagnostics []Diagnostic
+
+	stats Stats
+
+	// attachments is the manifest of files to be attached, attached are
+	// their source paths
+	attachments []AttachmentFile
+	attached    map[string]bool
+	fileExists  func(path string) bool
+
+	// paths are options references to local files are resolved with, see
+	// resolveReference
+	paths CompileOptions
+
+	// contentHash is written into the output if it's requested
+	contentHash string
+
+	versionMessage string
+}
+
+func newCompilation(opts CompileOptions, sources *sourcemap.Map) *compilation {
+	return &compilation{
+		file:    opts.File,
+		sources: sources,
+		logger:  opts.getLogger(),
+		strict:  opts.Strict,
+		stats:   newStats(),
+
+		fileExists: opts.FileExists,
+
+		paths: CompileOptions{
+			BaseDir:          opts.getBaseDir(),
+			RootDir:          opts.RootDir,
+			AllowOutsideRoot: opts.AllowOutsideRoot,
+		},
+	}
+}
+
+// index remembers offsets of lines of the markdown, which is parsed.
+func (state *compilation) index(markdown []byte) {
+	state.lines = append(state.lines[:0], 0)
+
+	for offset := 0; ; {
+		index := bytes.IndexByte(markdown[offset:], '\n')
+		if index < 0 {
+			return
+		}
+
+		offset += index + 1
+
+		state.lines = append(state.lines, offset)
+	}
+}
+
+// line returns 1-based line of the parsed markdown at given offset.
+func (state *compilation) line(offset int) int {
+	return sort.Search(len(state.lines), func(index int) bool {
+		return state.lines[index] > offset
+	})
+}
+
+// warn reports warning at given line of the parsed markdown, 0 if the line
+// is not known. Warnings are reported as errors in strict mode and are
+// returned by getStrictError instead of logging.
+func (state *compilation) warn(line int, format string, args ...interface{}) {
+	if state.strict {
+		state.report(line, SeverityError, fmt.Sprintf(format, args...))
+
+		return
+	}
+
+	diagnostic := state.report(line, SeverityWarning, fmt.Sprintf(format, args...))
+
+	state.logger.Warningf("%s", diagnostic)
+}
+
+func (state *compilation) report(
+	line int,
+	severity Severity,
+	message string,
+) Diagnostic {
+	diagnostic := Diagnostic{
+		File:     state.file,
+		Severity: severity,
+		Message:  message,
+	}
+
+	if line > 0 {
+		diagnostic.Line = state.sources.Line(line)
+
+		if file := state.sources.File(line); file != "" {
+			diagnostic.File = file
+		}
+	}
+
+	state.diagnostics = append(state.diagnostics, diagnostic)
+
+	return diagnostic
+}
+
+// position describes the source of the block being rendered for errors.
+func (state *compilation) position() string {
+	return state.locate(state.output.current)
+}
+
+// locate describes the source of given line of the parsed markdown, 0 if
+// the line is not known.
+func (state *compilation) locate(current int) string {
+	var (
+		file = state.file
+		line = 0
+	)
+
+	if current > 0 {
+		line = state.sources.Line(current)
+
+		if included := state.sources.File(current); included != "" {
+			file = included
+		}
+	}
+
+	switch {
+	case file != "" && line > 0:
+		return fmt.Sprintf("%s:%d", file, line)
+
+	case line > 0:
+		return fmt.Sprintf("line %d", line)
+	}
+
+	return file
+}
+
+// sourceIssue is the problem of markdown found while pre-processing it, line
+// is 1-based line of the pre-processed markdown.
+type sourceIssue struct {
+	line    int
+	message string
+}
+
+// reportSourceIssues reports issues as errors and returns the error
+// describing the first one.
+func (state *compilation) reportSourceIssues(issues []sourceIssue) error {
+	for _, issue := range issues {
+		state.report(issue.line, SeverityError, issue.message)
+	}
+
+	var facts *karma.Context
+	if position := state.locate(issues[0].line); position != "" {
+		facts = facts.Describe("source", position)
+	}
+
+	if len(issues) > 1 {
+		facts = facts.Describe("more", len(issues)-1)
+	}
+
+	return facts.Format(nil, "%s", issues[0].message)
+}
+
+// reportMetaHeaders reports unknown headers of the metadata and headers
+// with invalid values as errors in strict mode, lines of headers are lines
+// of the file.
+func (state *compilation) reportMetaHeaders(meta *Meta) {
+	if !state.strict {
+		return
+	}
+
+	state.diagnostics = append(
+		state.diagnostics,
+		getMetaDiagnostics(state.file, meta, SeverityError)...,
+	)
+}
+
+// getMetaDiagnostics returns diagnostics of unknown headers of the metadata
+// and headers with invalid values.
+func getMetaDiagnostics(file string, meta *Meta, severity Severity) []Diagnostic {
+	if meta == nil {
+		return nil
+	}
+
+	diagnostics := []Diagnostic{}
+
+	for _, header := range meta.unknown {
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     file,
+			Line:     header.line,
+			Severity: severity,
+			Message:  fmt.Sprintf("unknown metadata header %q", header.name),
+		})
+	}
+
+	for _, header := range meta.invalid {
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     file,
+			Line:     header.line,
+			Severity: severity,
+			Message:  fmt.Sprintf("invalid %s header: %s", header.name, header.err),
+		})
+	}
+
+	return diagnostics
+}
+
+// getStrictError returns *StrictError with diagnostics reported in strict
+// mode, nil if there are none.
+func (state *compilation) getStrictError() error {
+	if !state.strict || len(state.diagnostics) == 0 {
+		return nil
+	}
+
+	return &StrictError{Diagnostics: state.diagnostics}
+}
+
+// StrictError is returned when CompileOptions.Strict is set and problems,
+// which are warnings otherwise, are found. It lists all of them.
+type StrictError struct {
+	Diagnostics []Diagnostic
+}
+
+func (err *StrictError) Error() string {
+	issues := make([]string, len(err.Diagnostics))
+	for i, diagnostic := range err.Diagnostics {
+		issues[i] = diagnostic.String()
+	}
+
+	return "strict mode: " + strings.Join(issues, "; ")
+}
+
+// reportIssues reports storage format issues found in the output as errors
+// at lines of markdown they're rendered from.
+func (state *compilation) reportIssues(issues []ValidationIssue) {
+	for _, issue := range issues {
+		message := issue.Message
+		if issue.Element != "" {
+			message = fmt.Sprintf("<%s>: %s", issue.Element, message)
+		}
+
+		state.report(state.output.find(issue.Line), SeverityError, message)
+	}
+}
+
+// outputWriter counts lines written into the writer and remembers which
+// line of markdown is rendered at which line of the output.
+type outputWriter struct {
+	writer io.Writer
+
+	// line is the current 1-based line of the output
+	line int
+
+	// starts are lines of the output where rendering of lines of markdown
+	// started, sources are that lines of markdown
+	starts  []int
+	sources []int
+
+	// pending are lines of the output where rendering of blocks which lines
+	// are not known yet started
+	pending []int
+
+	// current is the line of markdown rendered last
+	current int
+}
+
+func (writer *outputWriter) Write(data []byte) (int, error) {
+	written, err := writer.writer.Write(data)
+
+	writer.line += bytes.Count(data[:written], []byte("\n"))
+
+	return written, err
+}
+
+// open remembers that rendering of the block which line is not known yet
+// starts at the current line of the output. The block gets the line passed
+// to mark next.
+func (writer *outputWriter) open() {
+	writer.pending = append(writer.pending, writer.line+1)
+}
+
+// mark remembers that given line of markdown is rendered starting at the
+// current line of the output.
+func (writer *outputWriter) mark(line int) {
+	if line <= 0 {
+		return
+	}
+
+	for _, start := range writer.pending {
+		writer.add(start, line)
+	}
+
+	writer.pending = writer.pending[:0]
+
+	writer.add(writer.line+1, line)
+
+	writer.current = line
+}
+
+// add keeps only the first line of markdown rendered at every line of the
+// output.
+func (writer *outputWriter) add(start int, line int) {
+	if count := len(writer.starts); count > 0 && writer.starts[count-1] >= start {
+		return
+	}
+
+	writer.starts = append(writer.starts, start)
+	writer.sources = append(writer.sources, line)
+}
+
+// find returns line of markdown rendered at given line of the output.
+func (writer *outputWriter) find(line int) int {
+	index := sort.SearchInts(writer.starts, line+1)
+	if index == 0 {
+		return 0
+	}
+
+	return writer.sources[index-1]
+}
+
+// blackfridayLocator locates nodes of blackfriday document in the markdown,
+// since blackfriday doesn't keep source positions. Nodes with literal text
+// are searched by the first line of the text after the node located before,
+// so nodes must be located in document order.
+type blackfridayLocator struct {
+	markdown []byte
+	state    *compilation
+	offset   int
+}
+
+// locate returns line of the node, 0 if the node has no literal text or it
+// can't be found.
+func (locator *blackfridayLocator) locate(node *bf.Node) int {
+	offset := locator.locateOffset(node)
+	if offset < 0 {
+		return 0
+	}
+
+	return locator.state.line(offset)
+}
+
+// locateOffset returns offset of the first line of the node's literal text
+// in the markdown, -1 if the node has no literal text or it can't be found.
+func (locator *blackfridayLocator) locateOffset(node *bf.Node) int {
+	probe := node.Literal
+	if index := bytes.IndexByte(probe, '\n'); index >= 0 {
+		probe = probe[:index]
+	}
+
+	probe = bytes.TrimSpace(probe)
+	if len(probe) == 0 {
+		return -1
+	}
+
+	index := bytes.Index(locator.markdown[locator.offset:], probe)
+	if index < 0 {
+		return -1
+	}
+
+	offset := locator.offset + index
+
+	locator.offset = offset + len(probe)
+
+	// code blocks are written verbatim, so the locator is moved past their
+	// last line, which may look like markup of the following nodes
+	if node.Type == bf.CodeBlock {
+		lines := bytes.Split(node.Literal, []byte{'\n'})
+
+		for _, line := range lines[1:] {
+			line = bytes.TrimSpace(line)
+
+			index := bytes.Index(locator.markdown[locator.offset:], line)
+			if index < 0 {
+				break
+			}
+
+			locator.offset += index + len(line)
+		}
+
+		return offset
+	}
+
+	// literal is never longer than its source, so nodes which follow it are
+	// not skipped
+	rest := len(node.Literal) - bytes.Index(node.Literal, probe) - len(probe)
+	if locator.offset+rest <= len(locator.markdown) {
+		locator.offset += rest
+	}
+
+	return offset
+}
+
+// isBlackfridayContainer returns true for blocks which get the line of their
+// first located descendant.
+func isBlackfridayContainer(nodeType bf.NodeType) bool {
+	switch nodeType {
+	case bf.BlockQuote, bf.List, bf.Item, bf.Paragraph, bf.Heading,
+		bf.Table, bf.TableHead, bf.TableBody, bf.TableRow, bf.TableCell:
+		return true
+	}
+
+	return false
+}
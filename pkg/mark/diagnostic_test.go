@@ -0,0 +1,252 @@
+package mark
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_Diagnostics(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		original = []byte(text(
+			"# Title",
+			"",
+			"<!-- Include: list -->",
+			"",
+			"text <!--comment_id='a b'-->commented<!----> text",
+		))
+
+		sources = sourcemap.New(original)
+		include = regexp.MustCompile(`<!-- Include: list -->`)
+	)
+
+	markdown := sourcemap.ReplaceAllFunc(
+		include,
+		original,
+		sources,
+		func([]byte) []byte {
+			return []byte(text(
+				"* one",
+				"* two <!--comment_id='c d'-->commented<!---->",
+				"* three",
+			))
+		},
+	)
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		for _, mode := range []TitleFromH1{TitleFromH1Keep, TitleFromH1Drop} {
+			result, err := Compile(markdown, lib, CompileOptions{
+				Engine:      engine,
+				TitleFromH1: mode,
+				File:        "page.md",
+				Sources:     sources,
+				Logger:      &testLogger{},
+			})
+			assert.NoError(t, err, engine)
+			assert.Equal(
+				t,
+				[]Diagnostic{
+					{
+						File:     "page.md",
+						Line:     3,
+						Severity: SeverityWarning,
+						Message:  `invalid inline comment id "c d", dropping comment marker`,
+					},
+					{
+						File:     "page.md",
+						Line:     5,
+						Severity: SeverityWarning,
+						Message:  `invalid inline comment id "a b", dropping comment marker`,
+					},
+				},
+				result.Diagnostics,
+				"%s: %s",
+				engine,
+				mode,
+			)
+		}
+	}
+}
+
+func TestCompile_StorageDiagnostics(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"# Title",
+		"",
+		"text",
+		"",
+		"* item",
+		"* <ac:rich-text-body>",
+		"",
+		"more",
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile(markdown, lib, CompileOptions{
+			Engine:      engine,
+			TitleFromH1: TitleFromH1Drop,
+			File:        "page.md",
+			Validate:    true,
+		})
+
+		var storage *StorageError
+		assert.True(t, errors.As(err, &storage), engine)
+		assert.Equal(t, "", result.HTML, engine)
+		assert.Len(t, result.Diagnostics, 1, engine)
+		assert.Equal(t, 6, result.Diagnostics[0].Line, engine)
+		assert.Equal(t, SeverityError, result.Diagnostics[0].Severity, engine)
+		assert.Contains(
+			t,
+			result.Diagnostics[0].String(),
+			"page.md:6: <ac:rich-text-body>: element <rich-text-body> closed by",
+			engine,
+		)
+	}
+}
+
+func TestCompile_ErrorPosition(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib.MustAddTemplate("ac:code", `{{ template "missing" }}`, stdlib.Override)
+
+	markdown := []byte(text("text", "", "```", "code", "```"))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		_, err := Compile(markdown, lib, CompileOptions{
+			Engine: engine,
+			File:   "page.md",
+		})
+		assert.Error(t, err, engine)
+		assert.Contains(t, err.Error(), "source: page.md:4", engine)
+	}
+}
+
+func TestCompile_Strict(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"page.md": text(
+			`<!-- Space: DOC -->`,
+			`<!-- Titel: Typo -->`,
+			``,
+			`See [other page](other.md#usage) and [site](https://example.com/a.md).`,
+			``,
+			`![Logo](images/logo.png) ![Missing](images/missing.png)`,
+			``,
+			`> [!NOTICE] Unknown type`,
+			``,
+			`text <!--comment_id='a b'-->commented<!---->`,
+		),
+		"images/logo.png": "png",
+	})
+
+	file := filepath.Join(dir, "page.md")
+
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, markdown, err := ExtractMeta(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics := func(severity Severity) []Diagnostic {
+		return []Diagnostic{
+			{
+				File:     file,
+				Line:     4,
+				Severity: severity,
+				Message:  `link to markdown file "other.md#usage" is not resolved`,
+			},
+			{
+				File:     file,
+				Line:     6,
+				Severity: severity,
+				Message:  `image file "images/missing.png" is not found`,
+			},
+			{
+				File:     file,
+				Line:     8,
+				Severity: severity,
+				Message:  `unknown admonition type "NOTICE"`,
+			},
+			{
+				File:     file,
+				Line:     10,
+				Severity: severity,
+				Message:  `invalid inline comment id "a b", dropping comment marker`,
+			},
+		}
+	}
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		opts := CompileOptions{
+			Engine:     engine,
+			Meta:       meta,
+			File:       file,
+			Sources:    sourcemap.New(source),
+			FileExists: FileExistsOnDisk,
+			Logger:     &testLogger{},
+		}
+
+		opts.Sources.Replace(source, 0, len(source)-len(markdown), nil)
+
+		result, err := Compile(markdown, lib, opts)
+		assert.NoError(t, err, engine)
+		assert.ElementsMatch(t, diagnostics(SeverityWarning), result.Diagnostics, engine)
+
+		opts.Strict = true
+		opts.Logger = &testLogger{}
+
+		result, err = Compile(markdown, lib, opts)
+		assert.Error(t, err, engine)
+
+		expected := append(
+			[]Diagnostic{
+				{
+					File:     file,
+					Line:     2,
+					Severity: SeverityError,
+					Message:  `unknown metadata header "Titel"`,
+				},
+			},
+			diagnostics(SeverityError)...,
+		)
+
+		var strict *StrictError
+		if assert.True(t, errors.As(err, &strict), engine) {
+			assert.ElementsMatch(t, expected, strict.Diagnostics, engine)
+		}
+
+		assert.ElementsMatch(t, expected, result.Diagnostics, engine)
+		assert.Empty(t, opts.Logger.(*testLogger).messages, engine)
+		assert.Contains(
+			t,
+			err.Error(),
+			"strict mode: "+file+`:2: unknown metadata header "Titel"; `,
+		)
+	}
+}
@@ -0,0 +1,80 @@
+package mark
+
+import (
+	"io"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+)
+
+// DiagramLanguages are languages of code blocks which are diagrams, e.g.
+// rendered into images by handlers, see ConfluenceRenderer.RenderDiagram.
+// Diagrams without alt text are reported by LintImageAlt.
+var DiagramLanguages = []string{"mermaid", "plantuml", "d2", "drawio"}
+
+func isDiagramLanguage(language string) bool {
+	for _, diagram := range DiagramLanguages {
+		if strings.EqualFold(diagram, language) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getDiagramLine returns the line of the opening fence of the diagram, which
+// goes right before the first line of its code, 0 if the line is unknown.
+func getDiagramLine(line int) int {
+	if line <= 1 {
+		return 0
+	}
+
+	return line - 1
+}
+
+// RenderDiagram writes the image of the diagram, which the handler has
+// generated from the code block into the file, and attaches the file. Alt
+// text and caption of the image are given by the info string of the code
+// block and are recorded in the attachment manifest as well:
+//
+//	```mermaid alt="Deploy flow" caption="Deploy of the service"
+//
+// Diagrams without alt text are reported, so they fail compiling in strict
+// mode.
+func (renderer *ConfluenceRenderer) RenderDiagram(
+	writer io.Writer,
+	node *bf.Node,
+	file AttachmentFile,
+) {
+	info := parseCodeInfo(string(node.Info))
+
+	file.Alt = info.Alt
+	file.Caption = info.Caption
+	file.Generated = true
+
+	if info.Alt == "" {
+		// the code block is the block rendered last
+		var line int
+		if renderer.state.output != nil {
+			line = getDiagramLine(renderer.state.output.current)
+		}
+
+		renderer.state.warn(line, "diagram %q has no alt text", file.Filename)
+	}
+
+	renderer.Attach(file)
+
+	image := `<ac:image`
+	if info.Alt != "" {
+		image += ` ac:alt="` + escape.EscapeAttr(info.Alt) + `"`
+	}
+
+	image += `><ri:attachment ri:filename="` + escape.EscapeAttr(file.Filename) + `"/>`
+
+	if info.Caption != "" {
+		image += `<ac:caption><p>` + escape.EscapeText(info.Caption) + `</p></ac:caption>`
+	}
+
+	io.WriteString(writer, image+`</ac:image>`)
+}
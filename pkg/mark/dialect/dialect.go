@@ -0,0 +1,146 @@
+// Package dialect describes differences of storage format accepted by
+// Confluence Cloud and Confluence Server or Data Center.
+//
+// Every feature which is written differently has a method of Dialect, so
+// the differences are kept here instead of templates and renderers, which
+// only call these methods. Auto dialect writes markup understood by both
+// editions where possible.
+package dialect
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+)
+
+// Dialect is the edition of Confluence the storage format is written for.
+type Dialect string
+
+const (
+	// Auto writes markup which is accepted by both editions. This is the
+	// default.
+	Auto Dialect = ""
+
+	// Cloud writes markup for Confluence Cloud.
+	Cloud Dialect = "cloud"
+
+	// Server writes markup for Confluence Server and Data Center.
+	Server Dialect = "server"
+)
+
+// Parse returns the dialect by its name, empty string and auto are Auto.
+func Parse(value string) (Dialect, error) {
+	switch value := strings.ToLower(strings.TrimSpace(value)); value {
+	case "", "auto":
+		return Auto, nil
+
+	case string(Cloud), string(Server):
+		return Dialect(value), nil
+	}
+
+	return "", fmt.Errorf(
+		"unexpected dialect %q, expected one of: auto, %s, %s",
+		value,
+		Cloud,
+		Server,
+	)
+}
+
+// String returns the name of the dialect as it's parsed by Parse.
+func (dialect Dialect) String() string {
+	if dialect == Auto {
+		return "auto"
+	}
+
+	return string(dialect)
+}
+
+// Emoticon returns ac:emoticon element for the emoticon given either by its
+// Confluence name, e.g. thumbs-up, or by its emoji shortcode, e.g. +1.
+//
+// Confluence Server knows emoticons by names only, so shortcodes of known
+// emoticons are replaced with their names. Confluence Cloud renders any
+// emoji by its shortname, which is written along with the name, the name
+// of unknown emoji is blue-star, the same way as Cloud editor does.
+func (dialect Dialect) Emoticon(name string) string {
+	name = strings.Trim(name, ":")
+
+	known, ok := GetEmoticon(name)
+	if !ok {
+		known, ok = GetEmoticonByShortcode(name)
+	}
+
+	if dialect != Cloud {
+		if ok {
+			name = known.Name
+		}
+
+		return `<ac:emoticon ac:name="` + escape.EscapeAttr(name) + `"/>`
+	}
+
+	if !ok {
+		known = Emoticon{Name: "blue-star", Shortcode: name}
+	}
+
+	element := `<ac:emoticon ac:name="` + escape.EscapeAttr(known.Name) + `"` +
+		` ac:emoji-shortname=":` + escape.EscapeAttr(known.Shortcode) + `:"`
+
+	if known.Emoji != "" {
+		element += ` ac:emoji-id="` + GetEmojiID(known.Emoji) + `"` +
+			` ac:emoji-fallback="` + escape.EscapeAttr(known.Emoji) + `"`
+	}
+
+	return element + `/>`
+}
+
+// User returns ri:user element referring to the user. Confluence Cloud
+// refers to users by account ids, while Confluence Server refers to them by
+// user keys or usernames. Auto refers to the user by any of them, which is
+// returned by the instance. Empty string is returned if the user has no id
+// the dialect accepts.
+func (dialect Dialect) User(user *confluence.User) string {
+	if user == nil {
+		return ""
+	}
+
+	var attrs []string
+
+	switch dialect {
+	case Cloud:
+		attrs = []string{"ri:account-id", user.AccountID}
+
+	case Server:
+		attrs = []string{"ri:userkey", user.UserKey, "ri:username", user.Username}
+
+	default:
+		attrs = []string{
+			"ri:account-id", user.AccountID,
+			"ri:userkey", user.UserKey,
+			"ri:username", user.Username,
+		}
+	}
+
+	for i := 0; i < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			return `<ri:user ` + attrs[i] + `="` + escape.EscapeAttr(attrs[i+1]) + `"/>`
+		}
+	}
+
+	return ""
+}
+
+// LayoutSection returns the opening ac:layout-section element of given
+// type, e.g. two_right_sidebar. Confluence Cloud editor writes breakout mode
+// of every section, so it's written as well and pages are not changed just
+// by opening them in the editor.
+func (dialect Dialect) LayoutSection(kind string) string {
+	element := `<ac:layout-section ac:type="` + escape.EscapeAttr(kind) + `"`
+
+	if dialect == Cloud {
+		element += ` ac:breakout-mode="default"`
+	}
+
+	return element + `>`
+}
@@ -0,0 +1,103 @@
+package dialect
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	for value, expected := range map[string]Dialect{
+		"":        Auto,
+		"auto":    Auto,
+		"Cloud":   Cloud,
+		" server": Server,
+	} {
+		dialect, err := Parse(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, dialect, value)
+	}
+
+	_, err := Parse("datacenter")
+	assert.EqualError(
+		t,
+		err,
+		`unexpected dialect "datacenter", expected one of: auto, cloud, server`,
+	)
+}
+
+func TestDialect_Emoticon(t *testing.T) {
+	type testcase struct {
+		name   string
+		auto   string
+		cloud  string
+		server string
+	}
+
+	tests := []testcase{
+		{
+			name:   "thumbs-up",
+			auto:   `<ac:emoticon ac:name="thumbs-up"/>`,
+			cloud:  `<ac:emoticon ac:name="thumbs-up" ac:emoji-shortname=":+1:" ac:emoji-id="1f44d" ac:emoji-fallback="👍"/>`,
+			server: `<ac:emoticon ac:name="thumbs-up"/>`,
+		},
+		{
+			name:   ":information_source:",
+			auto:   `<ac:emoticon ac:name="information"/>`,
+			cloud:  `<ac:emoticon ac:name="information" ac:emoji-shortname=":information_source:" ac:emoji-id="2139" ac:emoji-fallback="ℹ️"/>`,
+			server: `<ac:emoticon ac:name="information"/>`,
+		},
+		{
+			name:   "rocket",
+			auto:   `<ac:emoticon ac:name="rocket"/>`,
+			cloud:  `<ac:emoticon ac:name="blue-star" ac:emoji-shortname=":rocket:"/>`,
+			server: `<ac:emoticon ac:name="rocket"/>`,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.auto, Auto.Emoticon(test.name), test.name)
+		assert.Equal(t, test.cloud, Cloud.Emoticon(test.name), test.name)
+		assert.Equal(t, test.server, Server.Emoticon(test.name), test.name)
+	}
+}
+
+func TestDialect_User(t *testing.T) {
+	var (
+		cloud  = &confluence.User{AccountID: "5b10a2844c20165700ede21g"}
+		server = &confluence.User{UserKey: "ff8080815a", Username: "jdoe"}
+	)
+
+	assert.Equal(t, `<ri:user ri:account-id="5b10a2844c20165700ede21g"/>`, Cloud.User(cloud))
+	assert.Equal(t, ``, Cloud.User(server))
+
+	assert.Equal(t, `<ri:user ri:userkey="ff8080815a"/>`, Server.User(server))
+	assert.Equal(t, `<ri:user ri:username="jdoe"/>`, Server.User(&confluence.User{Username: "jdoe"}))
+	assert.Equal(t, ``, Server.User(cloud))
+
+	assert.Equal(t, `<ri:user ri:account-id="5b10a2844c20165700ede21g"/>`, Auto.User(cloud))
+	assert.Equal(t, `<ri:user ri:userkey="ff8080815a"/>`, Auto.User(server))
+	assert.Equal(t, ``, Auto.User(nil))
+}
+
+func TestDialect_LayoutSection(t *testing.T) {
+	assert.Equal(t, `<ac:layout-section ac:type="two_equal">`, Auto.LayoutSection("two_equal"))
+	assert.Equal(t, `<ac:layout-section ac:type="two_equal" ac:breakout-mode="default">`, Cloud.LayoutSection("two_equal"))
+	assert.Equal(t, `<ac:layout-section ac:type="two_equal">`, Server.LayoutSection("two_equal"))
+}
+
+func TestEmoticons_Unique(t *testing.T) {
+	seen := map[string]bool{}
+
+	for _, emoticon := range Emoticons {
+		for _, key := range []string{
+			"name:" + emoticon.Name,
+			"shortcode:" + emoticon.Shortcode,
+			"emoji:" + emoticon.Emoji,
+		} {
+			assert.False(t, seen[key], key)
+			seen[key] = true
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package dialect
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Emoticon describes Confluence emoticon along with its emoji equivalents.
+type Emoticon struct {
+	// Name is the emoticon name used in <ac:emoticon ac:name="..."/>.
+	Name string
+
+	// Shortcode is GitHub emoji shortcode without colons.
+	Shortcode string
+
+	Emoji string
+}
+
+// Emoticons lists emoticons supported by Confluence. The table is used in
+// both directions, so every emoticon has unique shortcode and emoji.
+var Emoticons = []Emoticon{
+	{Name: "smile", Shortcode: "slightly_smiling_face", Emoji: "🙂"},
+	{Name: "sad", Shortcode: "slightly_frowning_face", Emoji: "🙁"},
+	{Name: "cheeky", Shortcode: "stuck_out_tongue", Emoji: "😛"},
+	{Name: "laugh", Shortcode: "smiley", Emoji: "😃"},
+	{Name: "wink", Shortcode: "wink", Emoji: "😉"},
+	{Name: "thumbs-up", Shortcode: "+1", Emoji: "👍"},
+	{Name: "thumbs-down", Shortcode: "-1", Emoji: "👎"},
+	{Name: "information", Shortcode: "information_source", Emoji: "ℹ️"},
+	{Name: "tick", Shortcode: "white_check_mark", Emoji: "✅"},
+	{Name: "cross", Shortcode: "x", Emoji: "❌"},
+	{Name: "warning", Shortcode: "warning", Emoji: "⚠️"},
+	{Name: "plus", Shortcode: "heavy_plus_sign", Emoji: "➕"},
+	{Name: "minus", Shortcode: "heavy_minus_sign", Emoji: "➖"},
+	{Name: "question", Shortcode: "question", Emoji: "❓"},
+	{Name: "light-on", Shortcode: "bulb", Emoji: "💡"},
+	{Name: "light-off", Shortcode: "new_moon", Emoji: "🌑"},
+	{Name: "yellow-star", Shortcode: "star", Emoji: "⭐"},
+	{Name: "red-star", Shortcode: "red_circle", Emoji: "🔴"},
+	{Name: "green-star", Shortcode: "green_circle", Emoji: "🟢"},
+	{Name: "blue-star", Shortcode: "large_blue_circle", Emoji: "🔵"},
+	{Name: "heart", Shortcode: "heart", Emoji: "❤️"},
+	{Name: "broken-heart", Shortcode: "broken_heart", Emoji: "💔"},
+}
+
+// GetEmoticon returns the emoticon by its Confluence name.
+func GetEmoticon(name string) (Emoticon, bool) {
+	for _, emoticon := range Emoticons {
+		if emoticon.Name == name {
+			return emoticon, true
+		}
+	}
+
+	return Emoticon{}, false
+}
+
+// GetEmoticonByShortcode returns the emoticon by its shortcode without
+// colons.
+func GetEmoticonByShortcode(shortcode string) (Emoticon, bool) {
+	for _, emoticon := range Emoticons {
+		if emoticon.Shortcode == shortcode {
+			return emoticon, true
+		}
+	}
+
+	return Emoticon{}, false
+}
+
+// GetEmojiID returns the id of the emoji as Confluence Cloud writes it, which
+// is hex code points joined by dashes without variation selectors.
+func GetEmojiID(emoji string) string {
+	points := []string{}
+
+	for _, char := range emoji {
+		if char == '\ufe0f' {
+			continue
+		}
+
+		points = append(points, fmt.Sprintf("%x", char))
+	}
+
+	return strings.Join(points, "-")
+}
@@ -0,0 +1,215 @@
+package mark
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+)
+
+// smartypantsFlags are HTML flags of Smartypants, which are switched by the
+// smartypants document option.
+const smartypantsFlags = bf.Smartypants |
+	bf.SmartypantsFractions |
+	bf.SmartypantsDashes |
+	bf.SmartypantsLatexDashes
+
+// DocumentOptions override options of compiling for the document, they're
+// set by Options headers of space-separated name=value pairs:
+//
+//	<!-- Options: hard-wraps=true smartypants=false code-collapse=true -->
+//
+// Only options which change how the document itself is rendered can be
+// overridden: hard-wraps, smartypants, code-collapse, wiki-links and
+// inline-toc. Options which refer to the file system, e.g. BaseDir and
+// RootDir, to Confluence or to other documents can't be, since documents
+// shouldn't reach outside of themselves. Options which are not set are not
+// overridden.
+type DocumentOptions struct {
+	// HardWraps overrides CompileOptions.HardWraps, hard-wraps option.
+	HardWraps *bool
+
+	// Smartypants turns Smartypants flags of CompileOptions.HTMLFlags on
+	// or off, smartypants option.
+	Smartypants *bool
+
+	// CollapseCode overrides CompileOptions.CollapseCode, code-collapse
+	// option.
+	CollapseCode *bool
+
+	// WikiLinks overrides CompileOptions.WikiLinks, wiki-links option.
+	WikiLinks *bool
+
+	// InlineTOC overrides CompileOptions.InlineTOC, inline-toc option.
+	InlineTOC InlineTOC
+}
+
+// documentOptions parse values of document options by their names.
+var documentOptions = map[string]func(options *DocumentOptions, value string) error{
+	"hard-wraps": func(options *DocumentOptions, value string) error {
+		return parseDocumentBool(&options.HardWraps, value)
+	},
+	"smartypants": func(options *DocumentOptions, value string) error {
+		return parseDocumentBool(&options.Smartypants, value)
+	},
+	"code-collapse": func(options *DocumentOptions, value string) error {
+		return parseDocumentBool(&options.CollapseCode, value)
+	},
+	"wiki-links": func(options *DocumentOptions, value string) error {
+		return parseDocumentBool(&options.WikiLinks, value)
+	},
+	"inline-toc": func(options *DocumentOptions, value string) error {
+		mode, err := ParseInlineTOC(value)
+		if err != nil {
+			return err
+		}
+
+		options.InlineTOC = mode
+
+		return nil
+	},
+}
+
+func parseDocumentBool(option **bool, value string) error {
+	enabled, err := parseMetaBool(value)
+	if err != nil {
+		return err
+	}
+
+	*option = &enabled
+
+	return nil
+}
+
+// parse parses options of the Options header value into options, the error
+// is returned for each option which is unknown or has invalid value, other
+// options are parsed anyway.
+func (options *DocumentOptions) parse(value string) []error {
+	var errs []error
+
+	for _, field := range strings.Fields(value) {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("name=value expected: %q", field))
+
+			continue
+		}
+
+		parse, ok := documentOptions[strings.ToLower(name)]
+		if !ok {
+			errs = append(errs, fmt.Errorf(
+				"unknown option %q, expected one of: %s",
+				name,
+				strings.Join(getDocumentOptionNames(), ", "),
+			))
+
+			continue
+		}
+
+		if err := parse(options, value); err != nil {
+			errs = append(errs, fmt.Errorf("option %q: %w", name, err))
+		}
+	}
+
+	return errs
+}
+
+// getDocumentOptionNames returns sorted names of document options.
+func getDocumentOptionNames() []string {
+	names := make([]string, 0, len(documentOptions))
+	for name := range documentOptions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// inherit returns options with options of defaults, which are not set.
+func (options DocumentOptions) inherit(defaults DocumentOptions) DocumentOptions {
+	if options.HardWraps == nil {
+		options.HardWraps = defaults.HardWraps
+	}
+
+	if options.Smartypants == nil {
+		options.Smartypants = defaults.Smartypants
+	}
+
+	if options.CollapseCode == nil {
+		options.CollapseCode = defaults.CollapseCode
+	}
+
+	if options.WikiLinks == nil {
+		options.WikiLinks = defaults.WikiLinks
+	}
+
+	if options.InlineTOC == "" {
+		options.InlineTOC = defaults.InlineTOC
+	}
+
+	return options
+}
+
+// withDocumentOptions returns options overridden by Meta.Options.
+func (opts CompileOptions) withDocumentOptions() CompileOptions {
+	if opts.Meta == nil {
+		return opts
+	}
+
+	options := opts.Meta.Options
+
+	if options.HardWraps != nil {
+		opts.HardWraps = *options.HardWraps
+	}
+
+	if options.Smartypants != nil {
+		flags := opts.getHTMLFlags()
+		if *options.Smartypants {
+			flags |= smartypantsFlags
+		} else {
+			flags &^= smartypantsFlags
+		}
+
+		// zero flags are the default ones
+		if flags == 0 {
+			flags = bf.UseXHTML
+		}
+
+		opts.HTMLFlags = flags
+	}
+
+	if options.CollapseCode != nil {
+		opts.CollapseCode = *options.CollapseCode
+	}
+
+	if options.WikiLinks != nil {
+		opts.WikiLinks = *options.WikiLinks
+	}
+
+	if options.InlineTOC != "" {
+		opts.InlineTOC = options.InlineTOC
+	}
+
+	return opts
+}
+
+// getDocumentOptions returns effective values of options, which documents
+// can override, all of them are set.
+func (opts CompileOptions) getDocumentOptions() DocumentOptions {
+	var (
+		hardWraps    = opts.HardWraps
+		smartypants  = opts.getHTMLFlags()&bf.Smartypants != 0
+		collapseCode = opts.CollapseCode
+		wikiLinks    = opts.WikiLinks
+	)
+
+	return DocumentOptions{
+		HardWraps:    &hardWraps,
+		Smartypants:  &smartypants,
+		CollapseCode: &collapseCode,
+		WikiLinks:    &wikiLinks,
+		InlineTOC:    opts.getInlineTOC(),
+	}
+}
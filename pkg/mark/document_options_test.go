@@ -0,0 +1,125 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_DocumentOptions(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, markdown, err := ExtractMeta([]byte(text(
+		`<!-- Space: DOC -->`,
+		`<!-- Options: hard-wraps=true smartypants=false -->`,
+		`<!-- Options: code-collapse=yes Wiki-Links=true -->`,
+		``,
+		`"quoted" -- [[Page]]`,
+		`wrapped`,
+		``,
+		"```go",
+		`code`,
+		"```",
+		``,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yes, no := true, false
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile(markdown, lib, CompileOptions{
+			Engine: engine,
+			Meta:   meta,
+			Strict: true,
+		})
+		if assert.NoError(t, err, engine) {
+			assert.Contains(t, result.HTML, `&quot;quoted&quot; -- <ac:link>`, engine)
+			assert.Contains(t, result.HTML, "<br />\nwrapped", engine)
+			assert.Contains(t, result.HTML, `<ac:parameter ac:name="collapse">true</ac:parameter>`, engine)
+
+			assert.Equal(t, DocumentOptions{
+				HardWraps:    &yes,
+				Smartypants:  &no,
+				CollapseCode: &yes,
+				WikiLinks:    &yes,
+				InlineTOC:    InlineTOCKeep,
+			}, result.Options, engine)
+		}
+	}
+
+	// options of defaults are overridden by options of the document
+	defaults, _, err := ExtractMeta([]byte(`<!-- Options: smartypants=true inline-toc=strip -->`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(markdown, lib, CompileOptions{Meta: MergeMeta(defaults, meta)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &no, result.Options.Smartypants)
+		assert.Equal(t, InlineTOCStrip, result.Options.InlineTOC)
+	}
+
+	result, err = Compile(markdown, lib, CompileOptions{})
+	if assert.NoError(t, err) {
+		assert.Contains(t, result.HTML, `&ldquo;quoted&rdquo;`)
+		assert.Equal(t, &yes, result.Options.Smartypants)
+		assert.Equal(t, &no, result.Options.HardWraps)
+	}
+}
+
+func TestExtractMeta_InvalidDocumentOptions(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, markdown, err := ExtractMeta([]byte(text(
+		`<!-- Space: DOC -->`,
+		`<!-- Options: hard-wraps=true base-dir=/etc smartypants=maybe collapse -->`,
+		``,
+		`a`,
+		`b`,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.NotNil(t, meta.Options.HardWraps) {
+		assert.True(t, *meta.Options.HardWraps)
+	}
+
+	assert.Nil(t, meta.Options.Smartypants)
+
+	html, err := CompileMarkdownWithOptions(markdown, lib, CompileOptions{Meta: meta})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "<p>a<br />\nb</p>\n", html)
+	}
+
+	result, err := Compile(markdown, lib, CompileOptions{Meta: meta, Strict: true})
+	assert.Error(t, err)
+	assert.Equal(t, []Diagnostic{
+		{
+			Line:     2,
+			Severity: SeverityError,
+			Message: `invalid Options header: unknown option "base-dir", ` +
+				`expected one of: code-collapse, hard-wraps, inline-toc, smartypants, wiki-links`,
+		},
+		{
+			Line:     2,
+			Severity: SeverityError,
+			Message: `invalid Options header: option "smartypants": ` +
+				`unexpected boolean "maybe", expected one of: true, false, yes, no`,
+		},
+		{
+			Line:     2,
+			Severity: SeverityError,
+			Message:  `invalid Options header: name=value expected: "collapse"`,
+		},
+	}, result.Diagnostics)
+}
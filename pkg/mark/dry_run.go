@@ -0,0 +1,154 @@
+package mark
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// AttachmentStatus describes what publishing does with the attachment.
+type AttachmentStatus string
+
+const (
+	// AttachmentNew is the file which is not attached to the page yet.
+	AttachmentNew AttachmentStatus = "new"
+
+	// AttachmentChanged is the file which content differs from the
+	// attachment with the same name.
+	AttachmentChanged AttachmentStatus = "changed"
+
+	// AttachmentUnchanged is the file which is attached as is.
+	AttachmentUnchanged AttachmentStatus = "unchanged"
+
+	// AttachmentOrphaned is the attachment of the page which is not
+	// referenced by the document anymore.
+	AttachmentOrphaned AttachmentStatus = "orphaned"
+)
+
+// ExistingAttachment describes the attachment of the page in Confluence.
+type ExistingAttachment struct {
+	Filename string
+
+	// Checksum is SHA-256 of the attachment in hex, it can be given with
+	// AttachmentChecksumPrefix as it's stored in comments of attachments.
+	Checksum string
+}
+
+// AttachmentChange is the attachment which dry run compared.
+type AttachmentChange struct {
+	Filename string
+	Status   AttachmentStatus
+
+	// SourcePath is the path of the file, it's empty for orphaned
+	// attachments.
+	SourcePath string
+}
+
+// DryRunResult describes what publishing the compiled page would change.
+type DryRunResult struct {
+	// Update is true if the page body would be updated.
+	Update bool
+
+	// Diff is unified diff of the current and the compiled page body as
+	// they're normalized by StorageEqual, it's empty if the page is not
+	// updated.
+	Diff string
+
+	// Attachments are files of the attachment manifest in its order
+	// followed by orphaned attachments of the page.
+	Attachments []AttachmentChange
+}
+
+// DryRun compares the compiled storage format and attachment manifest, as
+// returned in CompileResult, with the current body and attachments of the
+// page fetched from Confluence without changing anything. Attachments are
+// matched by file names, the same way as ResolveAttachments does, and files
+// are read to compute their hashes.
+func DryRun(
+	compiled string,
+	current string,
+	manifest []AttachmentFile,
+	existing []ExistingAttachment,
+) (*DryRunResult, error) {
+	result := &DryRunResult{
+		Diff:        getStorageDiff(current, compiled, "current", "compiled"),
+		Attachments: []AttachmentChange{},
+	}
+
+	result.Update = result.Diff != ""
+
+	checksums := map[string]string{}
+	for _, attachment := range existing {
+		checksums[attachment.Filename] = strings.TrimPrefix(
+			attachment.Checksum,
+			AttachmentChecksumPrefix,
+		)
+	}
+
+	attached := map[string]bool{}
+
+	for _, file := range manifest {
+		// errors of the manifest describe files already
+		if file.Err != nil {
+			return nil, file.Err
+		}
+
+		hash, err := file.Hash()
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to get checksum for attachment: %q", file.Filename,
+			)
+		}
+
+		change := AttachmentChange{
+			Filename:   file.Filename,
+			Status:     AttachmentUnchanged,
+			SourcePath: file.SourcePath,
+		}
+
+		switch checksum, ok := checksums[file.Filename]; {
+		case !ok:
+			change.Status = AttachmentNew
+
+		case checksum != hash:
+			change.Status = AttachmentChanged
+		}
+
+		attached[file.Filename] = true
+
+		result.Attachments = append(result.Attachments, change)
+	}
+
+	for _, attachment := range existing {
+		if !attached[attachment.Filename] {
+			result.Attachments = append(result.Attachments, AttachmentChange{
+				Filename: attachment.Filename,
+				Status:   AttachmentOrphaned,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// String returns the report of the dry run: the diff of the page body
+// followed by attachments which are not unchanged.
+func (result *DryRunResult) String() string {
+	var report strings.Builder
+
+	if result.Update {
+		report.WriteString(result.Diff)
+	} else {
+		report.WriteString("page body is not changed\n")
+	}
+
+	for _, attachment := range result.Attachments {
+		if attachment.Status != AttachmentUnchanged {
+			fmt.Fprintf(&report, "attachment %s: %q\n", attachment.Status, attachment.Filename)
+		}
+	}
+
+	return report.String()
+}
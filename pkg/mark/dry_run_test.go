@@ -0,0 +1,106 @@
+package mark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	dir := writeMarkdownFiles(t, map[string]string{
+		"logo.png":  "logo",
+		"chart.png": "new chart",
+		"doc.pdf":   "doc",
+	})
+
+	sum := func(data string) string {
+		checksum := sha256.Sum256([]byte(data))
+
+		return hex.EncodeToString(checksum[:])
+	}
+
+	manifest := []AttachmentFile{
+		{SourcePath: filepath.Join(dir, "logo.png"), Filename: "logo.png"},
+		{SourcePath: filepath.Join(dir, "chart.png"), Filename: "chart.png"},
+		{SourcePath: filepath.Join(dir, "doc.pdf"), Filename: "doc.pdf"},
+	}
+
+	existing := []ExistingAttachment{
+		{Filename: "old.png", Checksum: sum("old")},
+		{Filename: "chart.png", Checksum: AttachmentChecksumPrefix + sum("chart")},
+		{Filename: "logo.png", Checksum: AttachmentChecksumPrefix + sum("logo")},
+	}
+
+	result, err := DryRun(
+		`<p>new text</p><ac:image ac:macro-id="1"><ri:attachment ri:filename="logo.png"/></ac:image>`,
+		"<p>old text</p>\n"+`  <ac:image ac:macro-id="2"><ri:attachment ri:filename='logo.png'></ri:attachment></ac:image>`,
+		manifest,
+		existing,
+	)
+	assert.NoError(t, err)
+	assert.True(t, result.Update)
+	assert.Equal(
+		t,
+		[]AttachmentChange{
+			{
+				Filename:   "logo.png",
+				Status:     AttachmentUnchanged,
+				SourcePath: filepath.Join(dir, "logo.png"),
+			},
+			{
+				Filename:   "chart.png",
+				Status:     AttachmentChanged,
+				SourcePath: filepath.Join(dir, "chart.png"),
+			},
+			{
+				Filename:   "doc.pdf",
+				Status:     AttachmentNew,
+				SourcePath: filepath.Join(dir, "doc.pdf"),
+			},
+			{Filename: "old.png", Status: AttachmentOrphaned},
+		},
+		result.Attachments,
+	)
+	assert.Equal(
+		t,
+		text(
+			`--- current`,
+			`+++ compiled`,
+			`@@ -1,5 +1,5 @@`,
+			` <p>`,
+			`-  old text`,
+			`+  new text`,
+			` </p>`,
+			` <ac:image>`,
+			`   <ri:attachment ri:filename="logo.png"/>`,
+			`attachment changed: "chart.png"`,
+			`attachment new: "doc.pdf"`,
+			`attachment orphaned: "old.png"`,
+			``,
+		),
+		result.String(),
+	)
+
+	// normalized bodies are equal
+	result, err = DryRun(`<p>text<br/></p>`, `<p>text<br></br></p>`, nil, nil)
+	assert.NoError(t, err)
+	assert.False(t, result.Update)
+	assert.Equal(t, "", result.Diff)
+	assert.Equal(t, "page body is not changed\n", result.String())
+
+	_, err = DryRun(
+		`<p>text</p>`,
+		`<p>text</p>`,
+		[]AttachmentFile{{
+			SourcePath: filepath.Join(dir, "missing.png"),
+			Filename:   "missing.png",
+			Err:        ErrAttachmentNotFound,
+		}},
+		nil,
+	)
+	assert.True(t, errors.Is(err, ErrAttachmentNotFound))
+}
@@ -0,0 +1,167 @@
+package mark
+
+import (
+	"regexp"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// reMacroOpening matches the opening tag of the macro, colons of tags are
+// replaced with colonPlaceholder while parsing.
+var reMacroOpening = regexp.MustCompile(
+	`<ac(?::|` + colonPlaceholder + `)structured-macro\b`,
+)
+
+// getBox returns the title and the body of the macro rendering the
+// admonition. The new Cloud editor converts admonitions into panels, which
+// have no titles, so with CompileOptions.EditorV2 the title is written as
+// the leading bold paragraph of the body instead of the macro parameter.
+func (admonition admonition) getBox(body string, editorV2 bool) (string, string) {
+	if !editorV2 || admonition.Title == "" {
+		return admonition.Title, body
+	}
+
+	return "", "<p><strong>" + escape.EscapeText(admonition.Title) + "</strong></p>\n" + body
+}
+
+// demoteTableHeads turns heads of tables, which cells contain macros, into
+// the first rows of table bodies. The new Cloud editor drops macros from
+// header cells, so such cells are written as regular cells with
+// CompileOptions.EditorV2.
+func demoteTableHeads(document *bf.Node) {
+	heads := []*bf.Node{}
+
+	document.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if entering && node.Type == bf.TableHead && hasBlackfridayMacro(node) {
+			heads = append(heads, node)
+		}
+
+		return bf.GoToNext
+	})
+
+	// tree can't be modified while walking through it
+	for _, head := range heads {
+		head.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+			if node.Type == bf.TableCell {
+				node.IsHeader = false
+			}
+
+			return bf.GoToNext
+		})
+
+		body := head.Next
+		if body == nil || body.Type != bf.TableBody {
+			head.Type = bf.TableBody
+
+			continue
+		}
+
+		// body is empty if the table has no rows but the head
+		first := body.FirstChild
+
+		for row := head.FirstChild; row != nil; row = head.FirstChild {
+			row.Unlink()
+
+			if first != nil {
+				first.InsertBefore(row)
+			} else {
+				body.AppendChild(row)
+			}
+		}
+
+		head.Unlink()
+	}
+}
+
+func hasBlackfridayMacro(node *bf.Node) bool {
+	found := false
+
+	node.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if node.Type == bf.HTMLSpan && reMacroOpening.Match(node.Literal) {
+			found = true
+
+			return bf.Terminate
+		}
+
+		return bf.GoToNext
+	})
+
+	return found
+}
+
+// demoteGoldmarkTableHeads is demoteTableHeads for goldmark documents, heads
+// are replaced with rows, which are the first rows of table bodies.
+func demoteGoldmarkTableHeads(document ast.Node, source []byte) {
+	heads := []*east.TableHeader{}
+
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if head, ok := node.(*east.TableHeader); ok && entering &&
+			hasGoldmarkMacro(head, source) {
+			heads = append(heads, head)
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	for _, head := range heads {
+		row := east.NewTableRow(head.Alignments)
+
+		for cell := head.FirstChild(); cell != nil; cell = head.FirstChild() {
+			row.AppendChild(row, cell)
+		}
+
+		head.Parent().ReplaceChild(head.Parent(), head, row)
+	}
+}
+
+func hasGoldmarkMacro(node ast.Node, source []byte) bool {
+	found := false
+
+	ast.Walk(node, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if raw, ok := node.(*ast.RawHTML); ok && entering &&
+			reMacroOpening.Match(getRawHTML(raw, source)) {
+			found = true
+
+			return ast.WalkStop, nil
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	return found
+}
+
+// renderTableRow renders rows the same way as goldmark does, but opens the
+// table body before the first row, which is left by demoted table head.
+func (renderer *goldmarkRenderer) renderTableRow(
+	writer util.BufWriter,
+	source []byte,
+	node ast.Node,
+	entering bool,
+) (ast.WalkStatus, error) {
+	if entering {
+		if node.PreviousSibling() == nil {
+			writer.WriteString("<tbody>\n")
+		}
+
+		writer.WriteString("<tr")
+		if node.Attributes() != nil {
+			html.RenderAttributes(writer, node, extension.TableRowAttributeFilter)
+		}
+
+		writer.WriteString(">\n")
+	} else {
+		writer.WriteString("</tr>\n")
+		if node.Parent().LastChild() == node {
+			writer.WriteString("</tbody>\n")
+		}
+	}
+
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,146 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_EditorV2(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := text(
+		`> [!TIP] Before you start`,
+		`> Body.`,
+		``,
+		`| <ac:structured-macro ac:name="status"></ac:structured-macro> | B |`,
+		`|---|:-:|`,
+		`| 1 | 2 |`,
+		``,
+		`| A | B |`,
+		`|---|---|`,
+		`| 1 | 2 |`,
+		``,
+	)
+
+	var (
+		admonition = text(
+			`<ac:structured-macro ac:name="tip">`,
+			`<ac:parameter ac:name="icon">true</ac:parameter>`,
+			`<ac:parameter ac:name="title">Before you start</ac:parameter>`,
+			`<ac:rich-text-body><p>Body.</p>`,
+			`</ac:rich-text-body>`,
+			`</ac:structured-macro>`,
+		)
+		head = text(
+			`<table>`,
+			`<thead>`,
+			`<tr>`,
+			`<th><ac:structured-macro ac:name="status"></ac:structured-macro></th>`,
+			`<th align="center">B</th>`,
+			`</tr>`,
+			`</thead>`,
+		)
+
+		// titles are moved into bodies and heads with macros into bodies
+		admonitionV2 = text(
+			`<ac:structured-macro ac:name="tip">`,
+			`<ac:parameter ac:name="icon">true</ac:parameter>`,
+			`<ac:rich-text-body><p><strong>Before you start</strong></p>`,
+			`<p>Body.</p>`,
+			`</ac:rich-text-body>`,
+			`</ac:structured-macro>`,
+		)
+		headV2 = text(
+			`<table>`,
+			`<tbody>`,
+			`<tr>`,
+			`<td><ac:structured-macro ac:name="status"></ac:structured-macro></td>`,
+			`<td align="center">B</td>`,
+			`</tr>`,
+		)
+
+		// tables without macros in heads are the same
+		plain = text(
+			`<table>`,
+			`<thead>`,
+			`<tr>`,
+			`<th>A</th>`,
+			`<th>B</th>`,
+			`</tr>`,
+			`</thead>`,
+		)
+	)
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		legacy, err := Compile([]byte(markdown), lib, CompileOptions{Engine: engine})
+		assert.NoError(t, err, engine)
+
+		v2, err := Compile([]byte(markdown), lib, CompileOptions{
+			Engine:   engine,
+			EditorV2: true,
+		})
+		assert.NoError(t, err, engine)
+
+		// goldmark doesn't separate blocks with blank lines
+		actual := strings.ReplaceAll(legacy.HTML, "\n\n", "\n")
+		assert.Contains(t, actual, admonition, engine)
+		assert.Contains(t, actual, head, engine)
+		assert.Contains(t, actual, plain, engine)
+
+		actual = strings.ReplaceAll(v2.HTML, "\n\n", "\n")
+		assert.Contains(t, actual, admonitionV2, engine)
+		assert.Contains(t, actual, headV2+"\n<tr>\n<td>1</td>", engine)
+		assert.Contains(t, actual, plain, engine)
+		assert.Equal(t, 1, strings.Count(actual, "<thead>"), engine)
+
+		assert.NotEqual(
+			t,
+			GetContentHash([]byte(markdown), lib, CompileOptions{Engine: engine}),
+			GetContentHash([]byte(markdown), lib, CompileOptions{Engine: engine, EditorV2: true}),
+		)
+	}
+}
+
+func TestCompile_EditorV2HeadOnly(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := text(
+		`| <ac:structured-macro ac:name="status"/> | B |`,
+		`|---|---|`,
+		``,
+	)
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile([]byte(markdown), lib, CompileOptions{
+			Engine:   engine,
+			EditorV2: true,
+			Validate: true,
+		})
+		assert.NoError(t, err, engine)
+		assert.Equal(
+			t,
+			text(
+				`<table>`,
+				`<tbody>`,
+				`<tr>`,
+				`<td><ac:structured-macro ac:name="status"/></td>`,
+				`<td>B</td>`,
+				`</tr>`,
+				`</tbody>`,
+				`</table>`,
+				``,
+			),
+			strings.ReplaceAll(result.HTML, "\n\n", "\n"),
+			engine,
+		)
+	}
+}
@@ -0,0 +1,176 @@
+package mark
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kovetskiy/mark/pkg/mark/dialect"
+)
+
+// Content properties which Confluence Cloud reads the emoji shown next to
+// the title of the draft and the published version of the page from.
+const (
+	PropertyEmojiDraft     = "emoji-title-draft"
+	PropertyEmojiPublished = "emoji-title-published"
+)
+
+// ParseEmoji parses the value of Emoji header, which is either a single
+// emoji, e.g. 🚀, or the shortcode of the emoticon known to Confluence, e.g.
+// :white_check_mark:. The emoji is returned along with its id, which is the
+// form content properties of the page store it in.
+func ParseEmoji(value string) (string, string, error) {
+	value = strings.TrimSpace(value)
+
+	if len(value) > 2 && strings.HasPrefix(value, ":") && strings.HasSuffix(value, ":") {
+		emoticon, ok := dialect.GetEmoticonByShortcode(strings.Trim(value, ":"))
+		if !ok {
+			return "", "", fmt.Errorf("unknown emoji shortcode %q", value)
+		}
+
+		value = emoticon.Emoji
+	}
+
+	if value == "" || getLeadingEmoji(value) != value {
+		return "", "", fmt.Errorf(
+			"unexpected emoji %q, expected single emoji or shortcode like :bulb:",
+			value,
+		)
+	}
+
+	return value, dialect.GetEmojiID(value), nil
+}
+
+// SplitTitleEmoji splits the leading emoji of the title, e.g. of the leading
+// H1 heading returned by ExtractDocumentLeadingH1, from the rest of the
+// title. The title is returned as is if it doesn't start with emoji or
+// there is nothing but emoji.
+func SplitTitleEmoji(title string) (string, string) {
+	emoji := getLeadingEmoji(title)
+	if emoji == "" {
+		return "", title
+	}
+
+	rest := strings.TrimSpace(title[len(emoji):])
+	if rest == "" {
+		return "", title
+	}
+
+	return emoji, rest
+}
+
+// ResolveEmoji returns the emoji of the page along with its id, which is
+// the one of Emoji header, i.e. CompileOptions.Meta.Emoji, or the leading
+// emoji of the H1 heading, if the title is taken from it, see ResolveTitle.
+// Empty strings are returned if there is no emoji.
+func ResolveEmoji(markdown []byte, opts CompileOptions) (string, string) {
+	if opts.Meta != nil {
+		if opts.Meta.Emoji != "" {
+			return opts.Meta.Emoji, opts.Meta.EmojiID
+		}
+
+		if opts.Meta.Title != "" {
+			return "", ""
+		}
+	}
+
+	emoji, _ := SplitTitleEmoji(ExtractDocumentLeadingH1(markdown))
+	if emoji == "" {
+		return "", ""
+	}
+
+	return emoji, dialect.GetEmojiID(emoji)
+}
+
+// getLeadingEmoji returns the emoji sequence the text starts with, which is
+// a flag, a keycap or pictographs joined by zero width joiners, each of them
+// can be followed by a variation selector or a skin tone modifier, or
+// empty string if the text doesn't start with emoji.
+func getLeadingEmoji(text string) string {
+	var (
+		offset int
+		next   = func() rune {
+			char, size := utf8.DecodeRuneInString(text[offset:])
+			if size == 0 {
+				return utf8.RuneError
+			}
+
+			offset += size
+
+			return char
+		}
+		peek = func() rune {
+			char, _ := utf8.DecodeRuneInString(text[offset:])
+			return char
+		}
+	)
+
+	first := next()
+
+	switch {
+	case isRegionalIndicator(first):
+		if !isRegionalIndicator(peek()) {
+			return ""
+		}
+
+		next()
+
+		return text[:offset]
+
+	case first == '#' || first == '*' || (first >= '0' && first <= '9'):
+		if peek() == '\ufe0f' {
+			next()
+		}
+
+		if next() != '\u20e3' {
+			return ""
+		}
+
+		return text[:offset]
+
+	case !isPictograph(first):
+		return ""
+	}
+
+	for {
+		switch char := peek(); {
+		case char == '\ufe0f', char >= 0x1f3fb && char <= 0x1f3ff:
+			next()
+
+		// tags, e.g. of subdivision flags
+		case char >= 0xe0020 && char <= 0xe007f:
+			next()
+
+		case char == '\u200d':
+			end := offset
+
+			next()
+
+			if !isPictograph(next()) {
+				return text[:end]
+			}
+
+		default:
+			return text[:offset]
+		}
+	}
+}
+
+func isRegionalIndicator(char rune) bool {
+	return char >= 0x1f1e6 && char <= 0x1f1ff
+}
+
+func isPictograph(char rune) bool {
+	switch {
+	case char >= 0x1f000 && char <= 0x1faff:
+		return true
+
+	case char < 0x80, char == utf8.RuneError:
+		return false
+	}
+
+	return unicode.Is(unicode.So, char) ||
+		char == 0x203c || char == 0x2049 || char == 0x2122 || char == 0x2139 ||
+		char == 0x3030 || char == 0x303d
+}
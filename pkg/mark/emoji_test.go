@@ -0,0 +1,90 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEmoji(t *testing.T) {
+	type testcase struct {
+		value string
+		emoji string
+		id    string
+	}
+
+	tests := []testcase{
+		{value: "🚀", emoji: "🚀", id: "1f680"},
+		{value: " ⚠️ ", emoji: "⚠️", id: "26a0"},
+		{value: ":bulb:", emoji: "💡", id: "1f4a1"},
+		{value: "👍🏽", emoji: "👍🏽", id: "1f44d-1f3fd"},
+		{value: "👩‍💻", emoji: "👩‍💻", id: "1f469-200d-1f4bb"},
+		{value: "🇺🇦", emoji: "🇺🇦", id: "1f1fa-1f1e6"},
+		{value: "#️⃣", emoji: "#️⃣", id: "23-20e3"},
+	}
+
+	for _, test := range tests {
+		emoji, id, err := ParseEmoji(test.value)
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.emoji, emoji, test.value)
+		assert.Equal(t, test.id, id, test.value)
+	}
+
+	for _, value := range []string{"", "rocket", "🚀🚀", "🚀 launch", "#", "🇺"} {
+		_, _, err := ParseEmoji(value)
+		assert.Error(t, err, value)
+	}
+
+	_, _, err := ParseEmoji(":rocket:")
+	assert.EqualError(t, err, `unknown emoji shortcode ":rocket:"`)
+}
+
+func TestSplitTitleEmoji(t *testing.T) {
+	emoji, title := SplitTitleEmoji("🚀 Launch checklist")
+	assert.Equal(t, "🚀", emoji)
+	assert.Equal(t, "Launch checklist", title)
+
+	emoji, title = SplitTitleEmoji("Launch 🚀")
+	assert.Equal(t, "", emoji)
+	assert.Equal(t, "Launch 🚀", title)
+
+	emoji, title = SplitTitleEmoji("🚀")
+	assert.Equal(t, "", emoji)
+	assert.Equal(t, "🚀", title)
+}
+
+func TestResolveEmoji(t *testing.T) {
+	markdown := []byte("# 🚀 Launch\n\nbody\n")
+
+	emoji, id := ResolveEmoji(markdown, CompileOptions{})
+	assert.Equal(t, "🚀", emoji)
+	assert.Equal(t, "1f680", id)
+	assert.Equal(t, "Launch", ResolveTitle(markdown, CompileOptions{}))
+
+	// header wins over the heading
+	meta, body, err := ExtractMeta([]byte("<!-- Emoji: :bulb: -->\n\n# 🚀 Launch\n"))
+	if assert.NoError(t, err) {
+		emoji, id = ResolveEmoji(body, CompileOptions{Meta: meta})
+		assert.Equal(t, "💡", emoji)
+		assert.Equal(t, "1f4a1", id)
+
+		assert.Equal(
+			t,
+			map[string]string{
+				"emoji-title-draft":     "1f4a1",
+				"emoji-title-published": "1f4a1",
+			},
+			meta.GetProperties(),
+		)
+	}
+
+	// the heading is not the title
+	emoji, id = ResolveEmoji(markdown, CompileOptions{Meta: &Meta{Title: "Launch"}})
+	assert.Equal(t, "", emoji)
+	assert.Equal(t, "", id)
+
+	_, _, err = ExtractMeta([]byte("<!-- Emoji: 🚀🚀 -->\n\nbody\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid Emoji header on line 1`)
+	}
+}
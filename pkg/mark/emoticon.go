@@ -0,0 +1,55 @@
+package mark
+
+import (
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/kovetskiy/mark/pkg/mark/dialect"
+)
+
+// EmoticonStyle defines how emoticons are written in markdown when
+// converting Confluence pages.
+type EmoticonStyle string
+
+const (
+	// EmoticonShortcode writes emoticons as :shortcode:. This is the default.
+	EmoticonShortcode EmoticonStyle = "shortcode"
+
+	// EmoticonUnicode writes emoticons as unicode emoji.
+	EmoticonUnicode EmoticonStyle = "unicode"
+)
+
+// convertEmoticon converts emoticons into emoji. Unknown emoticons are
+// written as :name:, so they're not lost.
+func (conversion *conversion) convertEmoticon(
+	content string,
+	selec *goquery.Selection,
+	opt *md.Options,
+) *string {
+	name := selec.AttrOr("ac:name", "")
+
+	known, ok := dialect.GetEmoticon(name)
+	if !ok {
+		// Confluence Cloud stores emoji along with emoticon
+		known = dialect.Emoticon{
+			Name:      name,
+			Shortcode: strings.Trim(selec.AttrOr("ac:emoji-shortname", ""), ":"),
+			Emoji:     selec.AttrOr("ac:emoji-fallback", ""),
+		}
+
+		if known.Shortcode == "" {
+			known.Shortcode = name
+		}
+	}
+
+	if conversion.opts.emoticons == EmoticonUnicode && known.Emoji != "" {
+		return md.String(known.Emoji)
+	}
+
+	if known.Shortcode == "" {
+		return md.String("")
+	}
+
+	return md.String(":" + known.Shortcode + ":")
+}
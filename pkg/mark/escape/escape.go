@@ -0,0 +1,143 @@
+// Package escape escapes text for Confluence storage format, which is XML
+// using HTML entities.
+//
+// Every function replaces characters which are not allowed in XML, e.g.
+// control characters other than tab, line feed and carriage return, and
+// invalid UTF-8 sequences with U+FFFD replacement character. Apart from
+// that, escaped text is parsed by XML parser back to the original text,
+// including carriage returns, which parsers normalize otherwise.
+package escape
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// EscapeText escapes text of element content: &, < and > are replaced with
+// &amp;, &lt; and &gt;, carriage return is replaced with &#13;. Quotes are
+// kept as is.
+func EscapeText(text string) string {
+	return escape(text, func(builder *strings.Builder, char rune) bool {
+		switch char {
+		case '&':
+			builder.WriteString("&amp;")
+		case '<':
+			builder.WriteString("&lt;")
+		case '>':
+			builder.WriteString("&gt;")
+		case '\r':
+			builder.WriteString("&#13;")
+		default:
+			return false
+		}
+
+		return true
+	})
+}
+
+// EscapeAttr escapes value of attribute quoted by either double or single
+// quotes: &, <, >, " and ' are replaced with &amp;, &lt;, &gt;, &quot; and
+// &#39;, tab, line feed and carriage return are replaced with &#9;, &#10;
+// and &#13;, since parsers replace them with spaces otherwise.
+func EscapeAttr(value string) string {
+	return escape(value, func(builder *strings.Builder, char rune) bool {
+		switch char {
+		case '&':
+			builder.WriteString("&amp;")
+		case '<':
+			builder.WriteString("&lt;")
+		case '>':
+			builder.WriteString("&gt;")
+		case '"':
+			builder.WriteString("&quot;")
+		case '\'':
+			builder.WriteString("&#39;")
+		case '\t':
+			builder.WriteString("&#9;")
+		case '\n':
+			builder.WriteString("&#10;")
+		case '\r':
+			builder.WriteString("&#13;")
+		default:
+			return false
+		}
+
+		return true
+	})
+}
+
+// EscapeCDATA escapes text which is put between <![CDATA[ and ]]>. CDATA
+// section can't contain its end marker and can't keep carriage return, so
+// the section is split: ]]> is replaced with ]]]]><![CDATA[> and carriage
+// return with ]]>&#13;<![CDATA[. Nothing else is escaped.
+func EscapeCDATA(text string) string {
+	if !strings.Contains(text, "]]>") {
+		return escape(text, escapeCDATAChar)
+	}
+
+	return escape(
+		strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>"),
+		escapeCDATAChar,
+	)
+}
+
+func escapeCDATAChar(builder *strings.Builder, char rune) bool {
+	if char != '\r' {
+		return false
+	}
+
+	builder.WriteString("]]>&#13;<![CDATA[")
+
+	return true
+}
+
+// escape writes text replacing characters using replace, which returns
+// false for characters which are written as is. Characters not allowed in
+// XML are replaced with U+FFFD.
+func escape(
+	text string,
+	replace func(builder *strings.Builder, char rune) bool,
+) string {
+	var builder strings.Builder
+
+	builder.Grow(len(text))
+
+	for offset, char := range text {
+		if char == utf8.RuneError {
+			_, size := utf8.DecodeRuneInString(text[offset:])
+			if size == 1 {
+				builder.WriteRune(utf8.RuneError)
+
+				continue
+			}
+		}
+
+		if !isAllowed(char) {
+			builder.WriteRune(utf8.RuneError)
+
+			continue
+		}
+
+		if !replace(&builder, char) {
+			builder.WriteRune(char)
+		}
+	}
+
+	return builder.String()
+}
+
+// isAllowed returns true for characters allowed in XML documents.
+func isAllowed(char rune) bool {
+	switch {
+	case char == '\t', char == '\n', char == '\r':
+		return true
+	case char >= 0x20 && char <= 0xd7ff:
+		return true
+	case char >= 0xe000 && char <= 0xfffd:
+		return true
+	case char >= 0x10000 && char <= 0x10ffff:
+		return true
+	}
+
+	return false
+}
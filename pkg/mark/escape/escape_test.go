@@ -0,0 +1,136 @@
+package escape
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeText(t *testing.T) {
+	assert.Equal(t, `a &lt;b&gt; &amp;amp; "c" 'd'`, EscapeText(`a <b> &amp; "c" 'd'`))
+	assert.Equal(t, "a&#13;\nb\t", EscapeText("a\r\nb\t"))
+	assert.Equal(t, "a�b�", EscapeText("a\x00b\xff"))
+}
+
+func TestEscapeAttr(t *testing.T) {
+	assert.Equal(
+		t,
+		`a &lt;b&gt; &amp;amp; &quot;c&quot; &#39;d&#39;`,
+		EscapeAttr(`a <b> &amp; "c" 'd'`),
+	)
+	assert.Equal(t, "a&#13;&#10;b&#9;", EscapeAttr("a\r\nb\t"))
+}
+
+func TestEscapeCDATA(t *testing.T) {
+	assert.Equal(t, `a <b> &amp;`, EscapeCDATA(`a <b> &amp;`))
+	assert.Equal(t, `a]]]]><![CDATA[>b`, EscapeCDATA(`a]]>b`))
+	assert.Equal(t, "a]]>&#13;<![CDATA[\nb", EscapeCDATA("a\r\nb"))
+	assert.Equal(t, "�", EscapeCDATA("\x1b"))
+}
+
+// sanitize replaces characters which can't be escaped the same way as
+// escape functions do.
+func sanitize(text string) string {
+	var builder strings.Builder
+
+	for offset, char := range text {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		if char == utf8.RuneError && size == 1 || !isAllowed(char) {
+			char = utf8.RuneError
+		}
+
+		builder.WriteRune(char)
+	}
+
+	return builder.String()
+}
+
+// parse parses escaped text of element content, attribute value and CDATA
+// section back.
+func parse(t *testing.T, text, attr, cdata string) (string, string, string) {
+	decoder := xml.NewDecoder(strings.NewReader(
+		`<r a="` + attr + `"><t>` + text + `</t>` +
+			`<c><![CDATA[` + cdata + `]]></c></r>`,
+	))
+	decoder.Strict = true
+
+	var (
+		values  = map[string]string{}
+		element string
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if element != "" || len(values) == 0 {
+				t.Fatalf("unable to parse escaped text: %s", err)
+			}
+
+			return values["t"], values["a"], values["c"]
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			element = token.Name.Local
+
+			for _, attr := range token.Attr {
+				values[attr.Name.Local] = attr.Value
+			}
+
+		case xml.CharData:
+			values[element] += string(token)
+
+		case xml.EndElement:
+			element = ""
+		}
+	}
+}
+
+func fuzzSeeds(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"text",
+		`<a href="x">&amp;</a>`,
+		"]]>",
+		"]]]>>]]",
+		"a\r\nb\rc\n",
+		"\t'\"",
+		"\x00\x1f\xff￾",
+		"привет, 世界 🙂",
+	} {
+		f.Add(seed)
+	}
+}
+
+func FuzzEscapeText(f *testing.F) {
+	fuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		text, _, _ := parse(t, EscapeText(input), "", "")
+
+		assert.Equal(t, sanitize(input), text)
+	})
+}
+
+func FuzzEscapeAttr(f *testing.F) {
+	fuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		_, attr, _ := parse(t, "", EscapeAttr(input), "")
+
+		assert.Equal(t, sanitize(input), attr)
+	})
+}
+
+func FuzzEscapeCDATA(f *testing.F) {
+	fuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		_, _, cdata := parse(t, "", "", EscapeCDATA(input))
+
+		assert.Equal(t, sanitize(input), cdata)
+	})
+}
@@ -0,0 +1,30 @@
+package mark_test
+
+import (
+	"fmt"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark"
+)
+
+func ExampleParseDocument() {
+	document := mark.ParseDocument(
+		[]byte("See [guide](docs/guide.md) and ![logo](images/logo.png).\n\n"+
+			"Also <https://example.com>."),
+		mark.CompileOptions{},
+	)
+
+	mark.Walk(document, func(node *bf.Node) bf.WalkStatus {
+		switch node.Type {
+		case bf.Link, bf.Image:
+			fmt.Println(string(node.LinkData.Destination))
+		}
+
+		return bf.GoToNext
+	})
+
+	// Output:
+	// docs/guide.md
+	// images/logo.png
+	// https://example.com
+}
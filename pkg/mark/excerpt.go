@@ -0,0 +1,68 @@
+package mark
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+)
+
+// ErrNoExcerpt is returned if the file pulled by excerpt-from directive has
+// no excerpt region.
+var ErrNoExcerpt = errors.New(
+	"no excerpt region, it must be wrapped into <!-- excerpt --> and <!-- endexcerpt -->",
+)
+
+// <!-- excerpt -->
+// ...
+// <!-- endexcerpt -->
+var reExcerptMarker = regexp.MustCompile(`<!--\s*(?:(excerpt)|(endexcerpt))\s*-->`)
+
+// getExcerpt returns the first excerpt region of markdown without markers.
+// Markers which are the only content of their lines are dropped with line
+// breaks. The map is updated the same way.
+func getExcerpt(
+	markdown []byte,
+	sources *sourcemap.Map,
+) ([]byte, *sourcemap.Map, error) {
+	var (
+		start = -1
+		end   = -1
+	)
+
+	for _, match := range reExcerptMarker.FindAllSubmatchIndex(markdown, -1) {
+		switch {
+		case start < 0 && match[2] >= 0:
+			start = match[1]
+			if line, next := readLine(markdown, start); len(bytes.TrimSpace(line)) == 0 {
+				start = next
+			}
+
+		case start >= 0 && match[4] >= 0:
+			end = match[0]
+		}
+
+		if end >= 0 {
+			break
+		}
+	}
+
+	if start < 0 || end < start {
+		return nil, nil, ErrNoExcerpt
+	}
+
+	// the line of the closing marker is dropped if it's blank before it
+	if head := bytes.LastIndexByte(markdown[:end], '\n'); head >= start &&
+		len(bytes.TrimSpace(markdown[head+1:end])) == 0 {
+		end = head + 1
+	}
+
+	sources.Replace(markdown, end, len(markdown), nil)
+	markdown = markdown[:end]
+
+	sources.Replace(markdown, 0, start, nil)
+	markdown = markdown[start:]
+
+	return markdown, sources, nil
+}
@@ -0,0 +1,82 @@
+package mark
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+)
+
+// getPathologicalMarkdown returns shapes of markdown which used to break
+// parsers: unterminated blocks at the end of the document, including ones
+// with ac: tags which are escaped before parsing, and nesting deeper than
+// parsers expect.
+func getPathologicalMarkdown() []string {
+	var lists strings.Builder
+	for depth := 0; depth < 256; depth++ {
+		lists.WriteString(strings.Repeat("  ", depth) + "- item\n")
+	}
+
+	return []string{
+		"```",
+		"```\n<ac:rich-text-body>",
+		"<ac:structured-macro ac:name=\"info\">\n```\n<ac:rich-text-body>",
+		"text <ac:",
+		"> ```\n> <ac:image>",
+		"<!-- Macro: x\n",
+		"<!--comment_id='1'-->",
+		"<!-- if: a -->\n```\n<!-- endif -->",
+		"${",
+		lists.String(),
+		strings.Repeat("- ", 1000) + "item",
+		strings.Repeat("> ", 1000) + "quote",
+		strings.Repeat("*", 1000) + "text",
+		strings.Repeat("[", 1000) + "link",
+		strings.Repeat("<div>", 1000),
+	}
+}
+
+func FuzzCompileMarkdown(f *testing.F) {
+	fixtures, err := filepath.Glob("testdata/*.md")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, fixture := range fixtures {
+		markdown, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(markdown)
+	}
+
+	for _, markdown := range getPathologicalMarkdown() {
+		f.Add([]byte(markdown))
+	}
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, markdown []byte) {
+		for _, opts := range []CompileOptions{
+			{},
+			{ChunkSize: 1},
+			{Engine: EngineGoldmark},
+		} {
+			opts.Logger = &testLogger{}
+
+			_, err := CompileMarkdownWithOptions(markdown, lib, opts)
+
+			var panicked *PanicError
+			if errors.As(err, &panicked) {
+				t.Fatalf("%s\n%s", err, panicked.Stack)
+			}
+		}
+	})
+}
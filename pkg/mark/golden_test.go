@@ -0,0 +1,18 @@
+package mark_test
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/marktest"
+)
+
+func TestCompileMarkdown(t *testing.T) {
+	marktest.RunGolden(t, "testdata", nil, mark.CompileOptions{})
+}
+
+func TestCompileMarkdown_InlineTOC(t *testing.T) {
+	marktest.RunGolden(t, "testdata/toc", nil, mark.CompileOptions{
+		InlineTOC: mark.InlineTOCMacro,
+	})
+}
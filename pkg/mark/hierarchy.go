@@ -0,0 +1,211 @@
+package mark
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// ErrTitleConflict is returned by ResolveHierarchy if several files resolve
+// to the same page.
+var ErrTitleConflict = errors.New("page title conflict")
+
+// hierarchyIndexFiles are names of files describing the page of the
+// directory, in order of precedence.
+var hierarchyIndexFiles = []string{"index.md", "README.md"}
+
+// ResolveHierarchy walks markdown files of the root directory and returns
+// their metadata by paths relative to the root, which are slash-separated,
+// e.g. guides/deploy/rollback.md. Directories starting with a dot are
+// skipped.
+//
+// Parents are inferred from directories the file is in, so rollback.md
+// above becomes a child of "Deploy", which is a child of "Guides". The title
+// of the directory page is resolved from its index.md or README.md the same
+// way as ResolveTitle does, or the directory name is title-cased, and the
+// index file itself becomes that page. Parent headers of the file win over
+// the inferred chain.
+//
+// Metadata of files is merged with DefaultsFile files of their directories
+// and parent directories up to the root, see ReadDefaultMeta, and these
+// files are not pages.
+//
+// ErrTitleConflict is returned if two files have the same title in the same
+// space.
+func ResolveHierarchy(root string) (map[string]PageMeta, error) {
+	var (
+		metas    = map[string]*Meta{}
+		pages    = map[string]PageMeta{}
+		defaults = map[string]*Meta{}
+	)
+
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if file != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !strings.EqualFold(filepath.Ext(file), ".md") ||
+			entry.Name() == DefaultsFile {
+			return nil
+		}
+
+		relative, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+
+		markdown, err := ioutil.ReadFile(file)
+		if err != nil {
+			return karma.Describe("file", file).Format(
+				err,
+				"unable to read markdown file",
+			)
+		}
+
+		meta, body, err := ExtractMeta(markdown)
+		if err != nil {
+			return karma.Describe("file", file).Format(
+				err,
+				"unable to extract metadata",
+			)
+		}
+
+		dir := filepath.Dir(file)
+		if _, ok := defaults[dir]; !ok {
+			defaults[dir], err = ReadDefaultMeta(dir, root)
+			if err != nil {
+				return err
+			}
+		}
+
+		meta = MergeMeta(defaults[dir], meta)
+		if meta == nil {
+			meta = &Meta{Type: "page"}
+		}
+
+		relative = filepath.ToSlash(relative)
+
+		meta.Title = ResolveTitle(body, CompileOptions{Meta: meta})
+
+		metas[relative] = meta
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[string]string{}
+
+	for file, meta := range metas {
+		dir, name := path.Split(file)
+		dir = strings.TrimSuffix(dir, "/")
+
+		page := PageMeta{
+			Space:       meta.Space,
+			Type:        meta.Type,
+			Parents:     meta.Parents,
+			Title:       meta.Title,
+			Layout:      meta.Layout,
+			Attachments: meta.Attachments,
+			Labels:      meta.Labels,
+		}
+
+		if getHierarchyIndexFile(metas, dir) == file {
+			page.Title = getHierarchyTitle(metas, dir)
+			dir = path.Dir(dir)
+		}
+
+		if page.Title == "" {
+			page.Title = strings.TrimSpace(strings.TrimSuffix(name, path.Ext(name)))
+		}
+
+		if len(page.Parents) == 0 {
+			page.Parents = getHierarchyParents(metas, dir)
+		}
+
+		pages[file] = page
+	}
+
+	files := make([]string, 0, len(pages))
+	for file := range pages {
+		files = append(files, file)
+	}
+
+	// files are compared in order of paths, so errors don't depend on the
+	// order of map iteration
+	sort.Strings(files)
+
+	for _, file := range files {
+		page := pages[file]
+
+		key := page.Space + "\x00" + page.Title
+		if owner, ok := owners[key]; ok {
+			return nil, fmt.Errorf(
+				"%w: files %q and %q have the same title %q in space %q",
+				ErrTitleConflict,
+				owner,
+				file,
+				page.Title,
+				page.Space,
+			)
+		}
+
+		owners[key] = file
+	}
+
+	return pages, nil
+}
+
+// getHierarchyParents returns titles of directories of the chain down to
+// given directory, the root directory is not a page.
+func getHierarchyParents(metas map[string]*Meta, dir string) []string {
+	if dir == "." || dir == "" {
+		return nil
+	}
+
+	return append(getHierarchyParents(metas, path.Dir(dir)), getHierarchyTitle(metas, dir))
+}
+
+// getHierarchyTitle returns the title of the directory page: the title of
+// its index file or the title-cased name of the directory.
+func getHierarchyTitle(metas map[string]*Meta, dir string) string {
+	if index := getHierarchyIndexFile(metas, dir); index != "" && metas[index].Title != "" {
+		return metas[index].Title
+	}
+
+	name := strings.NewReplacer("-", " ", "_", " ").Replace(path.Base(dir))
+
+	return strings.Title(strings.Join(strings.Fields(name), " "))
+}
+
+// getHierarchyIndexFile returns the path of the index file of the directory
+// or empty string if there is none.
+func getHierarchyIndexFile(metas map[string]*Meta, dir string) string {
+	if dir == "." || dir == "" {
+		return ""
+	}
+
+	for _, name := range hierarchyIndexFiles {
+		if _, ok := metas[dir+"/"+name]; ok {
+			return dir + "/" + name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,77 @@
+package mark
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveHierarchy(t *testing.T) {
+	dir := writeMarkdownFiles(t, map[string]string{
+		"index.md":                  "# Docs\n",
+		"guides/README.md":          "<!-- Space: DOC -->\n\n# All _guides_\n",
+		"guides/deploy/rollback.md": "<!-- Space: DOC -->\n<!-- Label: ops -->\n\n# Rollback\n",
+		"guides/deploy/canary.md":   "<!-- Parent: Releases -->\n\nNo title.\n",
+		"getting-started/setup.md":  "<!-- Title: Setup -->\n",
+		".git/notes.md":             "# Notes\n",
+		"guides/logo.png":           "png",
+	})
+
+	pages, err := ResolveHierarchy(dir)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]PageMeta{
+			"index.md": {Type: "page", Title: "Docs"},
+			"guides/README.md": {
+				Space: "DOC",
+				Type:  "page",
+				Title: "All guides",
+			},
+			"guides/deploy/rollback.md": {
+				Space:   "DOC",
+				Type:    "page",
+				Parents: []string{"All guides", "Deploy"},
+				Title:   "Rollback",
+				Labels:  []string{"ops"},
+			},
+			"guides/deploy/canary.md": {
+				Type:    "page",
+				Parents: []string{"Releases"},
+				Title:   "canary",
+			},
+			"getting-started/setup.md": {
+				Type:    "page",
+				Parents: []string{"Getting Started"},
+				Title:   "Setup",
+			},
+		},
+		pages,
+	)
+}
+
+func TestResolveHierarchy_Conflict(t *testing.T) {
+	dir := writeMarkdownFiles(t, map[string]string{
+		"a/intro.md": "<!-- Space: DOC -->\n\n# Intro\n",
+		"b/intro.md": "<!-- Space: DOC -->\n\n# Intro\n",
+	})
+
+	_, err := ResolveHierarchy(dir)
+	assert.True(t, errors.Is(err, ErrTitleConflict))
+	assert.EqualError(
+		t,
+		err,
+		`page title conflict: files "a/intro.md" and "b/intro.md" `+
+			`have the same title "Intro" in space "DOC"`,
+	)
+
+	// the same title in different spaces is not a conflict
+	dir = writeMarkdownFiles(t, map[string]string{
+		"a/intro.md": "<!-- Space: DOC -->\n\n# Intro\n",
+		"b/intro.md": "<!-- Space: ENG -->\n\n# Intro\n",
+	})
+
+	_, err = ResolveHierarchy(dir)
+	assert.NoError(t, err)
+}
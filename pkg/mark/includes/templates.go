@@ -6,19 +6,34 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 
 	"gopkg.in/yaml.v2"
 
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/pkg/log"
 )
 
+// Logger receives trace messages about included templates. It discards them
+// by default, mark sets it along with mark.DefaultLogger.
+var Logger interface {
+	Tracef(format string, args ...interface{})
+} = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Tracef(format string, args ...interface{}) {}
+
 // <!-- Include: <template path>
 //      <optional yaml data> -->
+//
+// or, when the include should stay inline, e.g. in table cell:
+//
+// <!-- Include: <template path> {<optional yaml data>} -->
 var reIncludeDirective = regexp.MustCompile(
-	`(?s)<!--\s*Include:\s*(?P<template>\S+)\s*(\n(?P<config>.*?))?-->`)
+	`(?s)<!--\s*Include:\s*(?P<template>\S+)\s*(\n(?P<config>.*?)|\{[^\n]*?\}\s*)?-->`)
 
 func LoadTemplate(
 	base string,
@@ -65,16 +80,30 @@ func LoadTemplate(
 	return templates, nil
 }
 
+// ProcessIncludes replaces include directives with executed templates.
+// Sources, if given, are updated so lines of included templates are mapped
+// to lines of their directives.
 func ProcessIncludes(
 	base string,
 	contents []byte,
 	templates *template.Template,
+	sources *sourcemap.Map,
 ) (*template.Template, []byte, bool, error) {
 	vardump := func(
 		facts *karma.Context,
 		data map[string]interface{},
 	) *karma.Context {
-		for key, value := range data {
+		// keys are sorted, so errors are the same for the same include
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value := data[key]
+
 			key = "var " + key
 			facts = facts.Describe(
 				key,
@@ -94,8 +123,10 @@ func ProcessIncludes(
 		err     error
 	)
 
-	contents = reIncludeDirective.ReplaceAllFunc(
+	contents = sourcemap.ReplaceAllFunc(
+		reIncludeDirective,
 		contents,
+		sources,
 		func(spec []byte) []byte {
 			if err != nil {
 				return nil
@@ -122,7 +153,7 @@ func ProcessIncludes(
 				return nil
 			}
 
-			log.Tracef(vardump(facts, data), "including template %q", path)
+			Logger.Tracef("including template %q, data: %v", path, data)
 
 			templates, err = LoadTemplate(base, path, templates)
 			if err != nil {
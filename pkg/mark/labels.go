@@ -0,0 +1,101 @@
+package mark
+
+import (
+	"strings"
+	"unicode"
+)
+
+// labelInvalidChars are characters Confluence doesn't allow in labels.
+const labelInvalidChars = `!#&()*,.:;<>?@[]^`
+
+// MergeLabels normalizes labels the way Confluence stores them and merges
+// them into the single list: labels are lowercased, whitespace within them is
+// replaced with dashes, since labels can't contain spaces, and characters
+// Confluence doesn't allow are stripped with a warning. Labels which are
+// empty after normalizing are dropped, duplicates are dropped as well, so
+// labels are listed once, in order they're given first.
+func MergeLabels(labels ...[]string) []string {
+	var (
+		merged = []string{}
+		seen   = map[string]bool{}
+	)
+
+	for _, list := range labels {
+		for _, label := range list {
+			normalized := normalizeLabel(label)
+			if normalized == "" || seen[normalized] {
+				continue
+			}
+
+			seen[normalized] = true
+			merged = append(merged, normalized)
+		}
+	}
+
+	return merged
+}
+
+// normalizeLabel returns the label as Confluence stores it, see MergeLabels.
+func normalizeLabel(label string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(label)), "-")
+
+	stripped := strings.Map(func(char rune) rune {
+		if strings.ContainsRune(labelInvalidChars, char) || !unicode.IsPrint(char) {
+			return -1
+		}
+
+		return char
+	}, normalized)
+
+	switch {
+	case stripped == "" && strings.TrimSpace(label) != "":
+		DefaultLogger.Warningf(
+			"label %q consists of characters which are not allowed in labels, it's dropped",
+			label,
+		)
+
+	case stripped != normalized:
+		DefaultLogger.Warningf(
+			"label %q contains characters which are not allowed in labels, "+
+				"they're stripped: %q",
+			label,
+			stripped,
+		)
+	}
+
+	return stripped
+}
+
+// removeLabels returns labels without removed ones, which are normalized the
+// same way as labels.
+func removeLabels(labels []string, removed []string) []string {
+	if len(removed) == 0 {
+		return labels
+	}
+
+	drop := map[string]bool{}
+	for _, label := range MergeLabels(removed) {
+		drop[label] = true
+	}
+
+	kept := []string{}
+	for _, label := range labels {
+		if !drop[label] {
+			kept = append(kept, label)
+		}
+	}
+
+	return kept
+}
+
+// getLabels returns labels of the page, see CompileResult.Labels.
+func (opts CompileOptions) getLabels() []string {
+	if opts.Meta == nil {
+		return MergeLabels(opts.Labels)
+	}
+
+	return MergeLabels(
+		removeLabels(MergeLabels(opts.Labels), opts.Meta.RemovedLabels),
+		opts.Meta.Labels,
+	)
+}
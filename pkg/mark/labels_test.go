@@ -0,0 +1,86 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeLabels(t *testing.T) {
+	defer func(logger Logger) {
+		DefaultLogger = logger
+	}(DefaultLogger)
+
+	logger := &testLogger{}
+
+	DefaultLogger = logger
+
+	assert.Equal(
+		t,
+		[]string{"ops", "release-notes", "v12", "ünïcode", "日本語", "c++"},
+		MergeLabels(
+			[]string{"Ops", "Release  Notes", "v1.2"},
+			nil,
+			[]string{"OPS", "ops", "ÜNÏCODE", "ünïcode", "日本語"},
+			[]string{" ", "?!", "release-notes", "c++"},
+		),
+	)
+
+	assert.Equal(
+		t,
+		[]string{
+			`warning: label "v1.2" contains characters which are not allowed in labels, they're stripped: "v12"`,
+			`warning: label "?!" consists of characters which are not allowed in labels, it's dropped`,
+		},
+		logger.messages,
+	)
+
+	assert.Equal(t, []string{}, MergeLabels())
+}
+
+func TestCompile_Labels(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults, _, err := ExtractMeta([]byte(text(
+		`<!-- Label: Handbook -->`,
+		`<!-- Label: draft -->`,
+		`<!-- Labels-Remove: Team -->`,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, markdown, err := ExtractMeta([]byte(text(
+		`<!-- Label: Deploy -->`,
+		`<!-- Label: handbook -->`,
+		`<!-- Labels-Remove: DRAFT -->`,
+		`<!-- Labels-Remove: generated -->`,
+		``,
+		`body`,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta = MergeMeta(defaults, meta)
+
+	assert.Equal(t, []string{"handbook", "deploy"}, meta.Labels)
+	assert.Equal(t, []string{"team", "draft", "generated"}, meta.RemovedLabels)
+
+	result, err := Compile(markdown, lib, CompileOptions{
+		Meta:   meta,
+		Labels: []string{"Generated", "team", "docs", "Deploy"},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"docs", "deploy", "handbook"}, result.Labels)
+	}
+
+	result, err = Compile(markdown, lib, CompileOptions{Labels: []string{"Docs"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"docs"}, result.Labels)
+	}
+}
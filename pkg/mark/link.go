@@ -8,15 +8,23 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/pkg/log"
 )
 
 type LinkSubstitution struct {
 	From string
 	To   string
+
+	// Title is the title of the linked page, which is the text of links
+	// without text, like [](../deploy.md).
+	Title string
+
+	// Issue is the problem of the link, which is substituted anyway, e.g.
+	// the heading it points to is not found in the linked file.
+	Issue string
 }
 
 type markdownLink struct {
@@ -35,54 +43,58 @@ func ResolveRelativeLinks(
 
 	links := []LinkSubstitution{}
 	for _, match := range matches {
-		log.Tracef(
-			nil,
+		DefaultLogger.Tracef(
 			"found a relative link: full=%s filename=%s hash=%s",
 			match.full,
 			match.filename,
 			match.hash,
 		)
 
-		resolved, err := resolveLink(api, base, match)
+		link, err := resolveLink(api, base, match)
 		if err != nil {
 			return nil, karma.Format(err, "resolve link: %q", match.full)
 		}
 
-		if resolved == "" {
+		if link.To == "" {
 			continue
 		}
 
-		links = append(links, LinkSubstitution{
-			From: match.full,
-			To:   resolved,
-		})
+		link.From = match.full
+
+		links = append(links, link)
 	}
 
 	return links, nil
 }
 
+// resolveLink returns the link to the page of the linked file along with
+// the title of the page, see ResolveTitle. Links to headings of other files
+// are resolved into links of PageLinkScheme with anchors, see
+// getAnchoredPageLink.
 func resolveLink(
 	api *confluence.API,
 	base string,
 	link markdownLink,
-) (string, error) {
-	var result string
+) (LinkSubstitution, error) {
+	var result, title string
 
 	if len(link.filename) > 0 {
+		dir := filepath.Dir(filepath.Join(base, link.filename))
+
 		filepath := filepath.Join(base, link.filename)
 
 		stat, err := os.Stat(filepath)
 		if err != nil {
-			return "", nil
+			return LinkSubstitution{}, nil
 		}
 
 		if stat.IsDir() {
-			return "", nil
+			return LinkSubstitution{}, nil
 		}
 
 		linkContents, err := ioutil.ReadFile(filepath)
 		if err != nil {
-			return "", karma.Format(err, "read file: %s", filepath)
+			return LinkSubstitution{}, karma.Format(err, "read file: %s", filepath)
 		}
 
 		linkContents = bytes.ReplaceAll(
@@ -93,34 +105,49 @@ func resolveLink(
 
 		// This helps to determine if found link points to file that's
 		// not markdown or have mark required metadata
-		linkMeta, _, err := ExtractMeta(linkContents)
+		linkMeta, linkBody, err := ExtractMeta(linkContents)
 		if err != nil {
-			log.Errorf(
-				err,
-				"unable to extract metadata from %q; ignoring the relative link",
+			DefaultLogger.Errorf(
+				"unable to extract metadata from %q; ignoring the relative link: %s",
 				filepath,
+				err,
 			)
 
-			return "", nil
+			return LinkSubstitution{}, nil
 		}
 
+		defaults, err := ReadDefaultMeta(dir, base)
+		if err != nil {
+			return LinkSubstitution{}, err
+		}
+
+		linkMeta = MergeMeta(defaults, linkMeta)
+
 		if linkMeta == nil {
-			return "", nil
+			return LinkSubstitution{}, nil
 		}
 
-		result, err = getConfluenceLink(api, linkMeta.Space, linkMeta.Title)
+		title = ResolveTitle(linkBody, CompileOptions{Meta: linkMeta, File: filepath})
+
+		// headings of pages can't be linked to by URLs of pages, so links
+		// to them are written as ac:link with anchor
+		if len(link.hash) > 0 {
+			return getAnchoredPageLink(linkMeta.Space, title, linkBody, link), nil
+		}
+
+		result, err = getConfluenceLink(api, linkMeta.Space, title)
 		if err != nil {
-			return "", karma.Format(
+			return LinkSubstitution{}, karma.Format(
 				err,
 				"find confluence page: %s / %s / %s",
 				filepath,
 				linkMeta.Space,
-				linkMeta.Title,
+				title,
 			)
 		}
 
 		if result == "" {
-			return "", nil
+			return LinkSubstitution{}, nil
 		}
 	}
 
@@ -128,7 +155,40 @@ func resolveLink(
 		result = result + "#" + link.hash
 	}
 
-	return result, nil
+	return LinkSubstitution{To: result, Title: title}, nil
+}
+
+// getAnchoredPageLink returns the link of PageLinkScheme to the heading of
+// the page of the linked file. The anchor is the one the heading gets when
+// the file is compiled, see HeadingAnchors, so links to duplicate headings,
+// like #rollback-1, point to the right ones. The fragment is kept as is and
+// the issue is set if there is no such heading.
+func getAnchoredPageLink(
+	space string,
+	title string,
+	body []byte,
+	link markdownLink,
+) LinkSubstitution {
+	target := pageLink{Space: space, Title: title, Anchor: link.hash}
+
+	var issue string
+
+	if anchor, ok := HeadingAnchors(body, CompileOptions{})[link.hash]; ok {
+		target.Anchor = anchor
+	} else {
+		issue = fmt.Sprintf(
+			"link to %q: heading %q is not found in %q",
+			link.full,
+			link.hash,
+			link.filename,
+		)
+	}
+
+	return LinkSubstitution{
+		To:    getLinkDestination(target.String()),
+		Title: title,
+		Issue: issue,
+	}
 }
 
 func SubstituteLinks(markdown []byte, links []LinkSubstitution) []byte {
@@ -137,7 +197,17 @@ func SubstituteLinks(markdown []byte, links []LinkSubstitution) []byte {
 			continue
 		}
 
-		log.Tracef(nil, "substitute link: %q -> %q", link.From, link.To)
+		DefaultLogger.Tracef("substitute link: %q -> %q", link.From, link.To)
+
+		// links without text are titled by the page, links with text are
+		// never changed
+		if link.Title != "" {
+			markdown = bytes.ReplaceAll(
+				markdown,
+				[]byte(fmt.Sprintf("[](%s)", link.From)),
+				[]byte(fmt.Sprintf("[%s](%s)", escapeLinkText(link.Title), link.To)),
+			)
+		}
 
 		markdown = bytes.ReplaceAll(
 			markdown,
@@ -149,9 +219,12 @@ func SubstituteLinks(markdown []byte, links []LinkSubstitution) []byte {
 	return markdown
 }
 
+var reMarkdownLink = regexp.MustCompile(
+	"\\[[^\\]]*\\]\\((([^\\)#]+)?#?([^\\)]+)?)\\)",
+)
+
 func parseLinks(markdown string) []markdownLink {
-	re := regexp.MustCompile("\\[[^\\]]+\\]\\((([^\\)#]+)?#?([^\\)]+)?)\\)")
-	matches := re.FindAllStringSubmatch(markdown, -1)
+	matches := reMarkdownLink.FindAllStringSubmatch(markdown, -1)
 
 	links := make([]markdownLink, len(matches))
 	for i, match := range matches {
@@ -171,12 +244,7 @@ func getConfluenceLink(
 	api *confluence.API,
 	space, title string,
 ) (string, error) {
-	link := fmt.Sprintf(
-		"%s/display/%s/%s",
-		api.BaseURL,
-		space,
-		url.QueryEscape(title),
-	)
+	link := api.BaseURL + getPageLinkPath(space, title)
 
 	page, err := api.FindPage(space, title, "page")
 	if err != nil {
@@ -191,3 +259,53 @@ func getConfluenceLink(
 
 	return link, nil
 }
+
+// getPageLinkPath returns the path of the page relative to base URL of
+// Confluence.
+func getPageLinkPath(space, title string) string {
+	return fmt.Sprintf("/display/%s/%s", space, url.QueryEscape(title))
+}
+
+// getDestinationIssue returns the problem of the link or image destination
+// left after resolving links, which is reported as warning: links to
+// markdown files are not resolved into links to pages, links to pages refer
+// to pages which don't exist, see CompileOptions.PageExists, and images refer
+// to local files which don't exist. Images are checked only if the directory
+// relative paths are resolved against is known, see CompileOptions.FileExists.
+func getDestinationIssue(
+	destination string,
+	image bool,
+	base string,
+	opts CompileOptions,
+) string {
+	if destination == "" || destination[0] == '#' {
+		return ""
+	}
+
+	if link, ok := opts.getPageLink(destination); ok {
+		return link.getIssue(opts)
+	}
+
+	if destination[0] == '/' && opts.RootDir == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.Path == "" {
+		return ""
+	}
+
+	switch extension := strings.ToLower(filepath.Ext(parsed.Path)); {
+	case !image && (extension == ".md" || extension == ".markdown"):
+		return fmt.Sprintf("link to markdown file %q is not resolved", destination)
+
+	case image && base != "" && opts.FileExists != nil:
+		// paths outside of the root are reported by attachDestination
+		path, ok, err := resolveReference(parsed.Path, base, opts)
+		if ok && err == nil && !opts.FileExists(path) {
+			return fmt.Sprintf("image file %q is not found", destination)
+		}
+	}
+
+	return ""
+}
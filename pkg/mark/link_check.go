@@ -0,0 +1,224 @@
+package mark
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+)
+
+// LinkIssue is the broken link between markdown documents found by
+// CheckLinks.
+type LinkIssue struct {
+	// Source is the name of the document the link is written in.
+	Source string
+
+	// Line is 1-based line of the link in the source document, it's 0 if
+	// the link can't be located.
+	Line int
+
+	// Target is the destination of the link as it's written.
+	Target string
+
+	Message string
+}
+
+func (issue LinkIssue) String() string {
+	return Diagnostic{
+		File:    issue.Source,
+		Line:    issue.Line,
+		Message: issue.Message,
+	}.String()
+}
+
+// CheckLinks checks relative links between documents, which are given by
+// their paths, e.g. docs/guide.md. Links to markdown files, which are not in
+// the set, links which differ from paths in the set only in case, which
+// work on case-insensitive file systems only, and links to headings, which
+// are not found in existing documents, are reported. Issues are sorted by
+// documents and lines.
+func CheckLinks(docs map[string][]byte) []LinkIssue {
+	var (
+		names = make([]string, 0, len(docs))
+		paths = map[string]string{}
+		folds = map[string]string{}
+		ids   = map[string]map[string]bool{}
+	)
+
+	for name := range docs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := getLinkCheckPath(name)
+
+		paths[key] = name
+		folds[strings.ToLower(key)] = name
+	}
+
+	getIDs := func(name string) map[string]bool {
+		if ids[name] == nil {
+			ids[name] = getHeadingIDs(ParseDocument(docs[name], CompileOptions{}))
+		}
+
+		return ids[name]
+	}
+
+	issues := []LinkIssue{}
+
+	for _, name := range names {
+		var (
+			markdown = docs[name]
+			document = ParseDocument(markdown, CompileOptions{})
+			state    = newCompilation(CompileOptions{}, nil)
+			locator  = &blackfridayLocator{markdown: markdown, state: state}
+		)
+
+		state.index(markdown)
+
+		Walk(document, func(node *bf.Node) bf.WalkStatus {
+			if node.Type != bf.Link {
+				return bf.GoToNext
+			}
+
+			destination := string(node.LinkData.Destination)
+
+			message := getLinkCheckMessage(destination, name, paths, folds, getIDs)
+			if message == "" {
+				return bf.GoToNext
+			}
+
+			probe := node
+			if node.FirstChild != nil {
+				probe = node.FirstChild
+			}
+
+			issues = append(issues, LinkIssue{
+				Source:  name,
+				Line:    locator.locate(probe),
+				Target:  destination,
+				Message: message,
+			})
+
+			return bf.GoToNext
+		})
+	}
+
+	return issues
+}
+
+// getLinkCheckMessage returns the problem of the link written in given
+// document, empty string is returned for links which are not checked.
+func getLinkCheckMessage(
+	destination string,
+	source string,
+	paths map[string]string,
+	folds map[string]string,
+	getIDs func(name string) map[string]bool,
+) string {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" ||
+		strings.HasPrefix(parsed.Path, "/") {
+		return ""
+	}
+
+	target := source
+
+	if parsed.Path != "" {
+		switch strings.ToLower(path.Ext(parsed.Path)) {
+		case ".md", ".markdown":
+		default:
+			return ""
+		}
+
+		key := path.Join(path.Dir(getLinkCheckPath(source)), parsed.Path)
+
+		name, ok := paths[key]
+		if !ok {
+			if name, ok := folds[strings.ToLower(key)]; ok {
+				return fmt.Sprintf(
+					"link to %q differs in case from document %q",
+					destination,
+					name,
+				)
+			}
+
+			return fmt.Sprintf("link to %q: document %q is not found", destination, key)
+		}
+
+		target = name
+	}
+
+	if parsed.Fragment != "" && !getIDs(target)[parsed.Fragment] {
+		return fmt.Sprintf(
+			"link to %q: heading %q is not found in %q",
+			destination,
+			parsed.Fragment,
+			target,
+		)
+	}
+
+	return ""
+}
+
+// getLinkCheckPath returns the slash-separated clean path of the document.
+func getLinkCheckPath(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// getHeadingIDs returns ids of headings of the document as they're rendered,
+// duplicate ids get numeric suffixes the same way as blackfriday does.
+func getHeadingIDs(document *bf.Node) map[string]bool {
+	var (
+		ids    = map[string]bool{}
+		counts = map[string]int{}
+	)
+
+	Walk(document, func(node *bf.Node) bf.WalkStatus {
+		if node.Type != bf.Heading || node.HeadingID == "" {
+			return bf.GoToNext
+		}
+
+		id := node.HeadingID
+		for count, found := counts[id]; found; count, found = counts[id] {
+			unique := fmt.Sprintf("%s-%d", id, count+1)
+
+			if _, ok := counts[unique]; !ok {
+				counts[id] = count + 1
+				id = unique
+			} else {
+				id += "-1"
+			}
+		}
+
+		counts[id] = 0
+		ids[id] = true
+
+		return bf.SkipChildren
+	})
+
+	return ids
+}
+
+// HeadingAnchors returns anchors of headings of the document compiled with
+// given options by ids, which links to the headings are written with, e.g.
+// "rollback" for [steps](deploy.md#rollback). Anchors are ids of headings
+// after compilation, i.e. with CompileOptions.HeadingIDPrefix and
+// CompileOptions.HeadingIDSuffix, and duplicate headings are linked to by
+// ids with numeric suffixes, like #rollback-1, the same way as within the
+// document.
+func HeadingAnchors(markdown []byte, opts CompileOptions) map[string]string {
+	anchors := map[string]string{}
+
+	for id := range getHeadingIDs(ParseDocument(markdown, opts)) {
+		anchors[id] = opts.HeadingIDPrefix + id + opts.HeadingIDSuffix
+	}
+
+	return anchors
+}
@@ -0,0 +1,104 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLinks(t *testing.T) {
+	docs := map[string][]byte{
+		"index.md": []byte(text(
+			`<!-- Title: Index -->`,
+			``,
+			`# Index`,
+			``,
+			`See [install](guides/install.md#requirements), [usage](./guides/usage.md)`,
+			`and [the site](https://example.com/old.md).`,
+			``,
+			`* [renamed](guides/old-name.md)`,
+			`* [case](guides/Install.md)`,
+			`* [missing heading](guides/install.md#removed)`,
+			`* [top](#index) and [bottom](#bottom)`,
+			``,
+			`![logo](images/logo.png) [file](files/doc.pdf)`,
+		)),
+		"guides/install.md": []byte(text(
+			`# Install`,
+			``,
+			`## Requirements`,
+			``,
+			`## Requirements`,
+			``,
+			`Back to [index](../index.md#index), [second](#requirements-1),`,
+			`[third](#requirements-2) and [custom](usage.md#custom-id).`,
+		)),
+		"guides/usage.md": []byte(text(
+			`# Usage {#custom-id}`,
+			``,
+			`[Root](/docs/index.md)`,
+		)),
+	}
+
+	assert.Equal(
+		t,
+		[]LinkIssue{
+			{
+				Source:  "guides/install.md",
+				Line:    8,
+				Target:  "#requirements-2",
+				Message: `link to "#requirements-2": heading "requirements-2" is not found in "guides/install.md"`,
+			},
+			{
+				Source:  "index.md",
+				Line:    8,
+				Target:  "guides/old-name.md",
+				Message: `link to "guides/old-name.md": document "guides/old-name.md" is not found`,
+			},
+			{
+				Source:  "index.md",
+				Line:    9,
+				Target:  "guides/Install.md",
+				Message: `link to "guides/Install.md" differs in case from document "guides/install.md"`,
+			},
+			{
+				Source:  "index.md",
+				Line:    10,
+				Target:  "guides/install.md#removed",
+				Message: `link to "guides/install.md#removed": heading "removed" is not found in "guides/install.md"`,
+			},
+			{
+				Source:  "index.md",
+				Line:    11,
+				Target:  "#bottom",
+				Message: `link to "#bottom": heading "bottom" is not found in "index.md"`,
+			},
+		},
+		CheckLinks(docs),
+	)
+
+	assert.Equal(
+		t,
+		`index.md:8: link to "guides/old-name.md": document "guides/old-name.md" is not found`,
+		CheckLinks(docs)[1].String(),
+	)
+}
+
+func TestHeadingAnchors(t *testing.T) {
+	markdown := []byte(text(
+		"# Deploy",
+		"",
+		"## Rollback",
+		"",
+		"## Rollback",
+		"",
+		"## Steps {#custom}",
+	))
+
+	assert.Equal(t, map[string]string{
+		"deploy":     "doc-deploy",
+		"rollback":   "doc-rollback",
+		"rollback-1": "doc-rollback-1",
+		"custom":     "doc-custom",
+	}, HeadingAnchors(markdown, CompileOptions{HeadingIDPrefix: "doc-"}))
+}
@@ -1,8 +1,15 @@
 package mark
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,6 +22,7 @@ func TestParseLinks(t *testing.T) {
 	[Image link that should be put as attachment](../path/to/example.png)
 	[relative link without dots](relative-link-without-dots.md)
 	[relative link without dots but with hash](relative-link-without-dots-but-with-hash.md#hash)
+	[](empty-text.md)
 	`
 
 	links := parseLinks(markdown)
@@ -47,5 +55,203 @@ func TestParseLinks(t *testing.T) {
 	assert.Equal(t, "relative-link-without-dots-but-with-hash.md", links[6].filename)
 	assert.Equal(t, "hash", links[6].hash)
 
-	assert.Equal(t, len(links), 7)
+	assert.Equal(t, "empty-text.md", links[7].full)
+	assert.Equal(t, "empty-text.md", links[7].filename)
+
+	assert.Equal(t, len(links), 8)
+}
+
+func TestSubstituteLinks(t *testing.T) {
+	markdown := []byte("[](deploy.md), [Deploy](deploy.md) and [](deploy.md#usage)")
+
+	assert.Equal(
+		t,
+		"[Deploy \\[v2\\]](https://example.com/deploy), "+
+			"[Deploy](https://example.com/deploy) and "+
+			"[Deploy \\[v2\\]](https://example.com/deploy#usage)",
+		string(SubstituteLinks(markdown, []LinkSubstitution{
+			{
+				From:  "deploy.md",
+				To:    "https://example.com/deploy",
+				Title: "Deploy [v2]",
+			},
+			{
+				From:  "deploy.md#usage",
+				To:    "https://example.com/deploy#usage",
+				Title: "Deploy [v2]",
+			},
+		})),
+	)
+}
+
+func TestCompile_EmptyPageLinkText(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"[](<confluence:///R&D Guide>), [](confluence://OPS/Runbook#steps),",
+		"[guide](confluence:///Guide) and [[Wiki|]].",
+		"",
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		html, err := CompileMarkdownWithOptions(markdown, lib, CompileOptions{
+			Engine:    engine,
+			WikiLinks: true,
+		})
+		if assert.NoError(t, err, engine) {
+			assert.Equal(t, text(
+				`<p><ac:link><ri:page ri:content-title="R&amp;D Guide"/>`+
+					`<ac:link-body>R&amp;D Guide</ac:link-body></ac:link>, `+
+					`<ac:link ac:anchor="steps"><ri:page ri:space-key="OPS" ri:content-title="Runbook"/>`+
+					`<ac:link-body>Runbook</ac:link-body></ac:link>,`,
+				`<ac:link><ri:page ri:content-title="Guide"/>`+
+					`<ac:link-body>guide</ac:link-body></ac:link> and `+
+					`<ac:link><ri:page ri:content-title="Wiki"/>`+
+					`<ac:link-body>Wiki</ac:link-body></ac:link>.</p>`,
+				``,
+			), html, engine)
+		}
+	}
+}
+
+func TestResolveRelativeLinks_Anchors(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	deploy := []byte(text(
+		"<!-- Space: OPS -->",
+		"<!-- Title: Deploy -->",
+		"",
+		"## Rollback",
+		"",
+		"## Rollback",
+		"",
+	))
+
+	err = ioutil.WriteFile(filepath.Join(dir, "deploy.md"), deploy, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"[rollback steps](./deploy.md#rollback), [](./deploy.md#rollback-1)",
+		"and [missing](./deploy.md#missing).",
+		"",
+	))
+
+	links, err := ResolveRelativeLinks(nil, nil, markdown, dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []LinkSubstitution{
+		{
+			From:  "./deploy.md#rollback",
+			To:    "confluence://OPS/Deploy#rollback",
+			Title: "Deploy",
+		},
+		{
+			From:  "./deploy.md#rollback-1",
+			To:    "confluence://OPS/Deploy#rollback-1",
+			Title: "Deploy",
+		},
+		{
+			From:  "./deploy.md#missing",
+			To:    "confluence://OPS/Deploy#missing",
+			Title: "Deploy",
+			Issue: `link to "./deploy.md#missing": heading "missing" is not found in "./deploy.md"`,
+		},
+	}, links)
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		html, err := CompileMarkdownWithOptions(
+			SubstituteLinks(markdown, links),
+			lib,
+			CompileOptions{Engine: engine},
+		)
+		if assert.NoError(t, err, engine) {
+			assert.Contains(
+				t,
+				html,
+				`<ac:link ac:anchor="rollback"><ri:page ri:space-key="OPS" ri:content-title="Deploy"/>`+
+					`<ac:link-body>rollback steps</ac:link-body></ac:link>`,
+				engine,
+			)
+			assert.Contains(
+				t,
+				html,
+				`<ac:link ac:anchor="rollback-1"><ri:page ri:space-key="OPS" ri:content-title="Deploy"/>`+
+					`<ac:link-body>Deploy</ac:link-body></ac:link>`,
+				engine,
+			)
+		}
+
+		// anchors are ids of headings of the compiled page
+		html, err = CompileMarkdownWithOptions(deploy, lib, CompileOptions{Engine: engine})
+		if assert.NoError(t, err, engine) {
+			assert.Contains(t, html, `<h2 id="rollback-1">Rollback</h2>`, engine)
+		}
+	}
+}
+
+func TestResolveRelativeLinks_Titles(t *testing.T) {
+	titles := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			title := request.URL.Query().Get("title")
+
+			titles = append(titles, title)
+
+			json.NewEncoder(writer).Encode(map[string]interface{}{
+				"results": []map[string]interface{}{
+					{"_links": map[string]string{"webui": "/pages/" + title}},
+				},
+			})
+		},
+	))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"guide.md":   text("<!-- Space: OPS -->", "", "# Guide", ""),
+		"runbook.md": text("<!-- Space: OPS -->", "", "## Steps", ""),
+	}
+
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links, err := ResolveRelativeLinks(
+		confluence.NewAPI(server.URL, "", "token"),
+		nil,
+		[]byte("[](./guide.md), [](./runbook.md) and [](./runbook.md#steps)"),
+		dir,
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// pages are looked up by the titles they're published with
+	assert.Equal(t, []string{"Guide", "runbook"}, titles)
+	assert.Equal(t, []LinkSubstitution{
+		{From: "./guide.md", To: server.URL + "/pages/Guide", Title: "Guide"},
+		{From: "./runbook.md", To: server.URL + "/pages/runbook", Title: "runbook"},
+		{
+			From:  "./runbook.md#steps",
+			To:    "confluence://OPS/runbook#steps",
+			Title: "runbook",
+		},
+	}, links)
 }
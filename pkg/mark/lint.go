@@ -0,0 +1,445 @@
+package mark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+)
+
+// LintRule is the group of checks made by Lint, rules are enabled by
+// CompileOptions.LintRules.
+type LintRule string
+
+const (
+	// LintMeta reports metadata headers which can't be parsed, unknown
+	// headers and headers with invalid values.
+	LintMeta LintRule = "meta"
+
+	// LintCompile reports problems found while compiling, e.g. unknown
+	// admonition types, images which files don't exist and undefined
+	// variables.
+	LintCompile LintRule = "compile"
+
+	// LintStorage reports storage format issues found by ValidateStorage.
+	LintStorage LintRule = "storage"
+
+	// LintLinks reports links to headings, which are not found in the
+	// document, and links to markdown files, which don't exist.
+	LintLinks LintRule = "links"
+
+	// LintSingleH1 reports documents which have no H1 heading or more than
+	// one of them.
+	LintSingleH1 LintRule = "single-h1"
+
+	// LintHeadingLevels reports headings which skip levels, e.g. H4 after
+	// H2.
+	LintHeadingLevels LintRule = "heading-levels"
+
+	// LintImageAlt reports images and diagrams without alt text, see
+	// DiagramLanguages.
+	LintImageAlt LintRule = "image-alt"
+)
+
+// DefaultLintRules are rules checked by Lint if CompileOptions.LintRules is
+// not set, diagnostics are returned in this order of rules.
+var DefaultLintRules = []LintRule{
+	LintMeta,
+	LintCompile,
+	LintStorage,
+	LintLinks,
+	LintSingleH1,
+	LintHeadingLevels,
+	LintImageAlt,
+}
+
+// Lint checks the document, which is the whole markdown file including
+// metadata headers, without Confluence and returns diagnostics of rules
+// given by CompileOptions.LintRules. Compile problems and storage format
+// issues are errors and warnings the same way as Compile reports them,
+// problems of other rules are warnings.
+//
+// The document is compiled with built-in templates and Meta parsed from
+// its headers. Links to markdown files are compiled as links to pages like
+// they're resolved, LinkResolver is not called, since it may look pages up
+// remotely. Files are looked up by FileExists, FileExistsOnDisk by default,
+// only in BaseDir or the directory of File, files outside of it are
+// reported as missing, and nothing is looked up if neither is set.
+func Lint(doc []byte, opts CompileOptions) []Diagnostic {
+	var (
+		rules       = opts.getLintRules()
+		diagnostics = []Diagnostic{}
+	)
+
+	meta, markdown, err := ExtractMeta(doc)
+	if err != nil {
+		if rules[LintMeta] {
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     opts.File,
+				Severity: SeverityError,
+				Message:  err.Error(),
+			})
+		}
+
+		meta, markdown = nil, doc
+	}
+
+	if rules[LintMeta] {
+		diagnostics = append(
+			diagnostics,
+			getMetaDiagnostics(opts.File, meta, SeverityWarning)...,
+		)
+	}
+
+	sources := opts.Sources.Clone()
+	if sources == nil {
+		sources = sourcemap.New(doc)
+	}
+
+	sources.Replace(doc, 0, len(doc)-len(markdown), nil)
+
+	opts.Meta = meta
+	opts.Sources = sources
+	opts.Strict = false
+	opts.Validate = rules[LintStorage]
+	opts.ContentHash = false
+	opts.LinkResolver = resolveLintLink
+	opts.FileExists = getLintFileExists(opts.getBaseDir(), opts.FileExists)
+
+	if opts.Logger == nil {
+		opts.Logger = NopLogger
+	}
+
+	if rules[LintCompile] || rules[LintStorage] {
+		diagnostics = append(diagnostics, lintCompile(markdown, opts, rules)...)
+	}
+
+	if rules[LintLinks] || rules[LintSingleH1] || rules[LintHeadingLevels] ||
+		rules[LintImageAlt] {
+		diagnostics = append(diagnostics, lintDocument(markdown, opts, rules)...)
+	}
+
+	return diagnostics
+}
+
+func (opts CompileOptions) getLintRules() map[LintRule]bool {
+	rules := opts.LintRules
+	if rules == nil {
+		rules = DefaultLintRules
+	}
+
+	enabled := map[LintRule]bool{}
+	for _, rule := range rules {
+		enabled[rule] = true
+	}
+
+	return enabled
+}
+
+// lintCompile compiles markdown and returns diagnostics of compiling and
+// storage format validation, which are enabled.
+func lintCompile(
+	markdown []byte,
+	opts CompileOptions,
+	rules map[LintRule]bool,
+) []Diagnostic {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		return []Diagnostic{{
+			File:     opts.File,
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("unable to load templates: %s", err),
+		}}
+	}
+
+	result, err := Compile(markdown, lib, opts)
+
+	var (
+		compiled = result.Diagnostics
+		storage  []Diagnostic
+		invalid  *StorageError
+	)
+
+	// storage format issues are reported after everything else
+	if errors.As(err, &invalid) {
+		split := len(compiled) - len(invalid.Issues)
+
+		compiled, storage = compiled[:split], compiled[split:]
+		err = nil
+	}
+
+	diagnostics := []Diagnostic{}
+
+	if rules[LintCompile] {
+		diagnostics = append(diagnostics, compiled...)
+
+		if err != nil && !hasErrorDiagnostics(compiled) {
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     opts.File,
+				Severity: SeverityError,
+				Message:  err.Error(),
+			})
+		}
+	}
+
+	if rules[LintStorage] {
+		diagnostics = append(diagnostics, storage...)
+	}
+
+	return diagnostics
+}
+
+func hasErrorDiagnostics(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lintDocument checks links and the structure of the document. Headings are
+// checked as they're written, even if the leading one is dropped, see
+// TitleFromH1Drop.
+func lintDocument(
+	markdown []byte,
+	opts CompileOptions,
+	rules map[LintRule]bool,
+) []Diagnostic {
+	opts.TitleFromH1 = TitleFromH1Keep
+	if opts.Meta != nil {
+		meta := *opts.Meta
+		meta.TitleFromH1 = ""
+		opts.Meta = &meta
+	}
+
+	var (
+		document = ParseDocument(markdown, opts)
+		ids      = getHeadingIDs(document)
+		state    = newCompilation(opts, opts.Sources)
+		locator  = &blackfridayLocator{markdown: markdown, state: state}
+
+		// title is set once H1 heading is found, level is the level of the
+		// previous heading
+		title bool
+		level int
+	)
+
+	state.index(markdown)
+
+	Walk(document, func(node *bf.Node) bf.WalkStatus {
+		probe := node
+		if node.FirstChild != nil {
+			probe = node.FirstChild
+		}
+
+		switch node.Type {
+		case bf.Heading:
+			line := locator.locate(probe)
+
+			switch {
+			case node.Level != 1:
+			case !title:
+				title = true
+			case rules[LintSingleH1]:
+				state.report(line, SeverityWarning, "more than one H1 heading")
+			}
+
+			if rules[LintHeadingLevels] && level > 0 && node.Level > level+1 {
+				state.report(line, SeverityWarning, fmt.Sprintf(
+					"heading level is skipped: H%d follows H%d",
+					node.Level,
+					level,
+				))
+			}
+
+			level = node.Level
+
+		case bf.Image:
+			var (
+				destination = string(node.LinkData.Destination)
+				alt         = hasBlackfridayText(node)
+			)
+
+			if !alt {
+				probe = &bf.Node{Literal: node.LinkData.Destination}
+			}
+
+			line := locator.locate(probe)
+
+			if rules[LintImageAlt] && !alt {
+				state.report(line, SeverityWarning, fmt.Sprintf(
+					"image %q has no alt text",
+					destination,
+				))
+			}
+
+		case bf.CodeBlock:
+			info := parseCodeInfo(string(node.Info))
+
+			line := locator.locate(node)
+
+			if rules[LintImageAlt] && isDiagramLanguage(info.Language) &&
+				info.Alt == "" {
+				state.report(getDiagramLine(line), SeverityWarning, fmt.Sprintf(
+					"%s diagram has no alt text",
+					info.Language,
+				))
+			}
+
+		case bf.Link:
+			destination := string(node.LinkData.Destination)
+
+			line := locator.locate(probe)
+			if !rules[LintLinks] {
+				break
+			}
+
+			message := getLintLinkMessage(
+				destination,
+				state.getReferenceBase(line),
+				ids,
+				opts,
+			)
+			if message != "" {
+				state.report(line, SeverityWarning, message)
+			}
+		}
+
+		return bf.GoToNext
+	})
+
+	if rules[LintSingleH1] && !title {
+		state.report(0, SeverityWarning, "no H1 heading")
+	}
+
+	return state.diagnostics
+}
+
+// hasBlackfridayText returns true if the node has non-blank text, e.g. alt
+// text of the image.
+func hasBlackfridayText(node *bf.Node) bool {
+	found := false
+
+	node.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if node.Type == bf.Text && len(bytes.TrimSpace(node.Literal)) > 0 {
+			found = true
+
+			return bf.Terminate
+		}
+
+		return bf.GoToNext
+	})
+
+	return found
+}
+
+// getLintLinkMessage returns the problem of the link to the heading of the
+// document or to the markdown file, empty string is returned for other
+// links. Headings of other documents are not checked.
+func getLintLinkMessage(
+	destination string,
+	base string,
+	ids map[string]bool,
+	opts CompileOptions,
+) string {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" ||
+		strings.HasPrefix(parsed.Path, "/") {
+		return ""
+	}
+
+	self := parsed.Path == ""
+
+	if !self {
+		switch strings.ToLower(path.Ext(parsed.Path)) {
+		case ".md", ".markdown":
+		default:
+			return ""
+		}
+
+		if base == "" {
+			return ""
+		}
+
+		target := filepath.Join(base, filepath.FromSlash(parsed.Path))
+
+		self = opts.File != "" && filepath.Clean(opts.File) == target
+
+		if !self && opts.FileExists != nil && !opts.FileExists(target) {
+			return fmt.Sprintf(
+				"link to %q: document %q is not found",
+				destination,
+				filepath.ToSlash(target),
+			)
+		}
+	}
+
+	if self && parsed.Fragment != "" && !ids[parsed.Fragment] {
+		return fmt.Sprintf(
+			"link to %q: heading %q is not found",
+			destination,
+			parsed.Fragment,
+		)
+	}
+
+	return ""
+}
+
+// resolveLintLink resolves links to markdown files into links to pages
+// titled by names of files, so they're compiled like they're resolved.
+func resolveLintLink(destination string) (string, bool) {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" {
+		return "", false
+	}
+
+	extension := path.Ext(parsed.Path)
+
+	switch strings.ToLower(extension) {
+	case ".md", ".markdown":
+	default:
+		return "", false
+	}
+
+	link := PageLinkScheme + "/" +
+		url.PathEscape(strings.TrimSuffix(path.Base(parsed.Path), extension))
+
+	if parsed.Fragment != "" {
+		link += "#" + parsed.Fragment
+	}
+
+	return link, true
+}
+
+// getLintFileExists returns FileExists, which looks files up only in the
+// base directory, nil is returned if there is no base directory.
+func getLintFileExists(base string, exists func(string) bool) func(string) bool {
+	if base == "" {
+		return nil
+	}
+
+	if exists == nil {
+		exists = FileExistsOnDisk
+	}
+
+	root := getAbsolutePath(base)
+
+	return func(path string) bool {
+		relative, err := filepath.Rel(root, getAbsolutePath(path))
+		if err != nil || relative == ".." ||
+			strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			return false
+		}
+
+		return exists(path)
+	}
+}
@@ -0,0 +1,187 @@
+package mark
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	dir := writeMarkdownFiles(t, map[string]string{
+		"docs/other.md":   "# Other\n",
+		"docs/logo.png":   "png",
+		"outside/page.md": "# Outside\n",
+	})
+
+	file := filepath.Join(dir, "docs", "page.md")
+
+	doc := []byte(text(
+		`<!-- Space: DOC -->`,
+		`<!-- Titel: Typo -->`,
+		`<!-- Status: ready -->`,
+		``,
+		`# Page`,
+		``,
+		`See [usage](#usage), [missing](#missing), [other](other.md#intro),`,
+		`[gone](gone.md), [outside](../outside/page.md) and [self](page.md#in).`,
+		``,
+		`### In`,
+		``,
+		`![](logo.png) ![Missing](missing.png)`,
+		``,
+		`# Usage`,
+		``,
+		`> [!NOTICE] Unknown type`,
+		``,
+	))
+
+	expected := []Diagnostic{
+		{
+			File:     file,
+			Line:     2,
+			Severity: SeverityWarning,
+			Message:  `unknown metadata header "Titel"`,
+		},
+		{
+			File:     file,
+			Line:     3,
+			Severity: SeverityWarning,
+			Message: `invalid Status header: unexpected status "ready", ` +
+				`expected one of: draft, published`,
+		},
+		{
+			File:     file,
+			Line:     16,
+			Severity: SeverityWarning,
+			Message:  `unknown admonition type "NOTICE"`,
+		},
+		{
+			File:     file,
+			Line:     12,
+			Severity: SeverityWarning,
+			Message:  `image file "missing.png" is not found`,
+		},
+		{
+			File:     file,
+			Line:     7,
+			Severity: SeverityWarning,
+			Message:  `link to "#missing": heading "missing" is not found`,
+		},
+		{
+			File:     file,
+			Line:     8,
+			Severity: SeverityWarning,
+			Message: `link to "gone.md": document "` +
+				filepath.ToSlash(filepath.Join(dir, "docs", "gone.md")) +
+				`" is not found`,
+		},
+		{
+			File:     file,
+			Line:     8,
+			Severity: SeverityWarning,
+			Message: `link to "../outside/page.md": document "` +
+				filepath.ToSlash(filepath.Join(dir, "outside", "page.md")) +
+				`" is not found`,
+		},
+		{
+			File:     file,
+			Line:     10,
+			Severity: SeverityWarning,
+			Message:  `heading level is skipped: H3 follows H1`,
+		},
+		{
+			File:     file,
+			Line:     12,
+			Severity: SeverityWarning,
+			Message:  `image "logo.png" has no alt text`,
+		},
+		{
+			File:     file,
+			Line:     14,
+			Severity: SeverityWarning,
+			Message:  `more than one H1 heading`,
+		},
+	}
+
+	assert.Equal(t, expected, Lint(doc, CompileOptions{File: file}))
+
+	assert.Equal(
+		t,
+		expected[7:8],
+		Lint(doc, CompileOptions{
+			File:      file,
+			LintRules: []LintRule{LintHeadingLevels},
+		}),
+	)
+
+	// nothing is looked up without the base directory
+	assert.Equal(
+		t,
+		[]Diagnostic{
+			{
+				Line:     7,
+				Severity: SeverityWarning,
+				Message:  `link to "#missing": heading "missing" is not found`,
+			},
+		},
+		Lint(doc, CompileOptions{LintRules: []LintRule{LintCompile, LintLinks}})[1:],
+	)
+}
+
+func TestLint_Storage(t *testing.T) {
+	doc := []byte(text(
+		`<!-- Title: Page -->`,
+		``,
+		`Text <ac:structured-macro>`,
+		``,
+	))
+
+	diagnostics := Lint(doc, CompileOptions{LintRules: []LintRule{LintStorage}})
+	if assert.Len(t, diagnostics, 1) {
+		assert.Equal(t, SeverityError, diagnostics[0].Severity)
+		assert.Equal(t, 3, diagnostics[0].Line)
+	}
+
+	assert.Empty(t, Lint(doc, CompileOptions{LintRules: []LintRule{LintMeta}}))
+
+	diagnostics = Lint(
+		[]byte("<!-- Title: Page -->\n<!-- Appearance: wide -->\n\n# Page\n"),
+		CompileOptions{},
+	)
+	if assert.Len(t, diagnostics, 1) {
+		assert.Equal(t, SeverityError, diagnostics[0].Severity)
+		assert.Contains(t, diagnostics[0].Message, `invalid Appearance header`)
+	}
+}
+
+func TestLint_DiagramAlt(t *testing.T) {
+	doc := []byte(text(
+		`# Page`,
+		``,
+		"```mermaid",
+		`graph TD; A-->B;`,
+		"```",
+		``,
+		"```plantuml alt=\"Deploy flow\" title Deploy",
+		`a -> b`,
+		"```",
+		``,
+		"```go",
+		`func main() {}`,
+		"```",
+		``,
+	))
+
+	assert.Equal(
+		t,
+		[]Diagnostic{
+			{
+				Line:     3,
+				Severity: SeverityWarning,
+				Message:  `mermaid diagram has no alt text`,
+			},
+		},
+		Lint(doc, CompileOptions{LintRules: []LintRule{LintImageAlt}}),
+	)
+}
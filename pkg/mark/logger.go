@@ -0,0 +1,61 @@
+package mark
+
+import (
+	"github.com/reconquest/pkg/log"
+)
+
+// Logger receives messages of the library. It's satisfied by most of leveled
+// loggers, e.g. by a thin wrapper around log.Logger of the standard library.
+type Logger interface {
+	Tracef(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// DefaultLogger receives messages if no other logger is given, e.g. by
+// CompileOptions.Logger. It discards messages, so the library doesn't write
+// anywhere by itself, mark sets it to GlobalLogger.
+var DefaultLogger Logger = NopLogger
+
+// NopLogger discards all messages.
+var NopLogger Logger = nopLogger{}
+
+// GlobalLogger writes messages into the global logger of
+// github.com/reconquest/pkg/log, which is configured by mark itself.
+var GlobalLogger Logger = globalLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Tracef(format string, args ...interface{}) {}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+func (nopLogger) Infof(format string, args ...interface{}) {}
+
+func (nopLogger) Warningf(format string, args ...interface{}) {}
+
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+type globalLogger struct{}
+
+func (globalLogger) Tracef(format string, args ...interface{}) {
+	log.Tracef(nil, format, args...)
+}
+
+func (globalLogger) Debugf(format string, args ...interface{}) {
+	log.Debugf(nil, format, args...)
+}
+
+func (globalLogger) Infof(format string, args ...interface{}) {
+	log.Infof(nil, format, args...)
+}
+
+func (globalLogger) Warningf(format string, args ...interface{}) {
+	log.Warningf(nil, format, args...)
+}
+
+func (globalLogger) Errorf(format string, args ...interface{}) {
+	log.Errorf(nil, format, args...)
+}
@@ -0,0 +1,74 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultLogger(t *testing.T) {
+	assert.Equal(t, NopLogger, DefaultLogger)
+
+	defer func(logger Logger) {
+		DefaultLogger = logger
+	}(DefaultLogger)
+
+	logger := &testLogger{}
+
+	DefaultLogger = logger
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, markdown, err := ExtractMeta([]byte(text(
+		`[]: # (Space: DOC)`,
+		`<!-- Titel: Page -->`,
+		``,
+		`a <!--comment_id='a b'-->commented<!----> text`,
+	)))
+	assert.NoError(t, err)
+	assert.Equal(t, "DOC", meta.Space)
+
+	_, err = CompileMarkdownWithOptions(markdown, lib, CompileOptions{})
+	assert.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]string{
+			`warning: legacy header usage found: []: # (Space: DOC), ` +
+				`please use new header format: <!-- Space: DOC -->`,
+			`error: encountered unknown header "Titel" line: "<!-- Titel: Page -->"`,
+			`warning: line 1: invalid inline comment id "a b", dropping comment marker`,
+		},
+		logger.messages,
+	)
+}
+
+func TestDefaultLogger_Links(t *testing.T) {
+	defer func(logger Logger) {
+		DefaultLogger = logger
+	}(DefaultLogger)
+
+	logger := &testLogger{}
+
+	DefaultLogger = logger
+
+	SubstituteLinks(
+		[]byte("[a](b.md)"),
+		[]LinkSubstitution{{From: "b.md", To: "https://example.com/b"}},
+	)
+
+	CompileAttachmentLinks([]byte("text"), []Attachment{{Replace: "image.png"}})
+
+	assert.Equal(
+		t,
+		[]string{
+			`trace: substitute link: "b.md" -> "https://example.com/b"`,
+			`warning: unused attachment: image.png`,
+		},
+		logger.messages,
+	)
+}
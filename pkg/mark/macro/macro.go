@@ -8,8 +8,8 @@ import (
 	"text/template"
 
 	"github.com/kovetskiy/mark/pkg/mark/includes"
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/pkg/log"
 	"github.com/reconquest/regexputil-go"
 	"gopkg.in/yaml.v2"
 )
@@ -25,19 +25,34 @@ var reMacroDirective = regexp.MustCompile(
 		/*   */ `(?P<config>\n.*?)?-->`,
 )
 
+// Logger receives trace messages about loaded macros. It discards them by
+// default, mark sets it along with mark.DefaultLogger.
+var Logger interface {
+	Tracef(format string, args ...interface{})
+} = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Tracef(format string, args ...interface{}) {}
+
 type Macro struct {
 	Regexp   *regexp.Regexp
 	Template *template.Template
 	Config   string
 }
 
+// Apply replaces text matched by the macro regexp with executed template.
+// Sources, if given, are updated to map lines of the result.
 func (macro *Macro) Apply(
 	content []byte,
+	sources *sourcemap.Map,
 ) ([]byte, error) {
 	var err error
 
-	content = macro.Regexp.ReplaceAllFunc(
+	content = sourcemap.ReplaceAllFunc(
+		macro.Regexp,
 		content,
+		sources,
 		func(match []byte) []byte {
 			config := map[string]interface{}{}
 
@@ -104,17 +119,22 @@ func (macro *Macro) configure(node interface{}, groups [][]byte) interface{} {
 	return node
 }
 
+// ExtractMacros loads macros declared in contents and removes their
+// directives. Sources, if given, are updated to map lines of the result.
 func ExtractMacros(
 	base string,
 	contents []byte,
 	templates *template.Template,
+	sources *sourcemap.Map,
 ) ([]Macro, []byte, error) {
 	var err error
 
 	var macros []Macro
 
-	contents = reMacroDirective.ReplaceAllFunc(
+	contents = sourcemap.ReplaceAllFunc(
+		reMacroDirective,
 		contents,
+		sources,
 		func(spec []byte) []byte {
 			if err != nil {
 				return spec
@@ -158,10 +178,11 @@ func ExtractMacros(
 
 			macro.Config = config
 
-			log.Tracef(
-				facts.Describe("config", macro.Config),
-				"loaded macro %q",
+			Logger.Tracef(
+				"loaded macro %q, template %q, config: %s",
 				expr,
+				template,
+				macro.Config,
 			)
 
 			macros = append(macros, macro)
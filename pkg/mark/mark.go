@@ -5,7 +5,6 @@ import (
 
 	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/pkg/log"
 )
 
 func ResolvePage(
@@ -23,8 +22,7 @@ func ResolvePage(
 	}
 
 	if meta.Type == "blogpost" {
-		log.Infof(
-			nil,
+		DefaultLogger.Infof(
 			"blog post will be stored as: %s",
 			meta.Title,
 		)
@@ -65,8 +63,7 @@ func ResolvePage(
 		}
 
 		if page == nil {
-			log.Warningf(
-				nil,
+			DefaultLogger.Warningf(
 				"page %q is not found ",
 				meta.Parents[len(ancestry)-1],
 			)
@@ -75,8 +72,7 @@ func ResolvePage(
 		path := meta.Parents
 		path = append(path, meta.Title)
 
-		log.Debugf(
-			nil,
+		DefaultLogger.Debugf(
 			"resolving page path: ??? > %s",
 			strings.Join(path, ` > `),
 		)
@@ -103,8 +99,7 @@ func ResolvePage(
 
 	titles = append(titles, parent.Title)
 
-	log.Infof(
-		nil,
+	DefaultLogger.Infof(
 		"page will be stored under path: %s > %s",
 		strings.Join(titles, ` > `),
 		meta.Title,
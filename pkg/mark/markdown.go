@@ -2,53 +2,214 @@ package mark
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"io"
-	"os"
 	"regexp"
 	"strings"
 
-	md "github.com/JohannesKaufmann/html-to-markdown"
 	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
 	"github.com/kovetskiy/mark/pkg/mark/stdlib"
-	"github.com/reconquest/pkg/log"
+	"github.com/reconquest/karma-go"
 )
 
+// colonPlaceholder temporarily replaces colon in <ac:*> tags, so markdown
+// parser doesn't treat them as autolinks.
+const colonPlaceholder = `---bf-COLON---`
+
+var (
+	colonPlaceholderBytes = []byte(colonPlaceholder)
+
+	// reConfluenceTag matches tags like <ac:rich-text-body>, see
+	// CompileMarkdownTo.
+	reConfluenceTag = regexp.MustCompile(`<(/?ac):(\S+?)>`)
+)
+
+// DefaultExtensions is the set of markdown extensions used for parsing
+// documents unless CompileOptions specify another one.
+const DefaultExtensions = bf.Tables |
+	bf.FencedCode |
+	bf.Autolink |
+	bf.LaxHTMLBlocks |
+	bf.Strikethrough |
+	bf.SpaceHeadings |
+	bf.HeadingIDs |
+	bf.AutoHeadingIDs |
+	bf.Titleblock |
+	bf.BackslashLineBreak |
+	bf.DefinitionLists |
+	bf.NoEmptyLineBeforeBlock |
+	bf.Footnotes
+
+// DefaultHTMLFlags is the set of HTML renderer flags used unless
+// CompileOptions specify another one.
+const DefaultHTMLFlags = bf.UseXHTML |
+	bf.Smartypants |
+	bf.SmartypantsFractions |
+	bf.SmartypantsDashes |
+	bf.SmartypantsLatexDashes
+
+// NodeHandler renders the node instead of ConfluenceRenderer. Handler
+// returns false if it doesn't handle the node, so the node is rendered as
+// usual.
+type NodeHandler func(
+	writer io.Writer,
+	node *bf.Node,
+	entering bool,
+) (bf.WalkStatus, bool)
+
+// ConfluenceRenderer renders blackfriday document into Confluence storage
+// format. Renderer is created for every compiled document and is not safe
+// for concurrent use, while Stdlib and options it uses can be shared by
+// documents compiled concurrently.
 type ConfluenceRenderer struct {
 	bf.Renderer
 
 	Stdlib *stdlib.Lib
+
+	handlers map[bf.NodeType][]NodeHandler
+
+	inlineComments map[*bf.Node]inlineCommentMarker
+	admonitions    map[*bf.Node]admonition
+
+	opts CompileOptions
+
+	// locate returns line of the node being rendered
+	locate func(node *bf.Node) int
+
+	// state collects statistics, attachments and warnings of rendered nodes
+	state *compilation
+
+	// permalinks are written after headings, if CompileOptions.Permalinks
+	// is set
+	permalinks *permalinks
+
+	// err is the first error occurred while rendering, since RenderNode
+	// can't return errors
+	err *error
 }
 
-func ParseLanguage(lang string) string {
-	// lang takes the following form: language? "collapse"? ("title"? <any string>*)?
-	// let's split it by spaces
-	paramlist := strings.Fields(lang)
+func (renderer ConfluenceRenderer) fail(err error) bf.WalkStatus {
+	if renderer.err != nil && *renderer.err == nil {
+		*renderer.err = err
+	}
+
+	return bf.Terminate
+}
+
+// codeInfo is the info string of the fenced code block, which takes the
+// following form:
+//
+//	language? "collapse"? "linenumbers"? alt="..."? caption="..."?
+//	("title" <any string>)?
+//
+// Flags and parameters are recognized only before the title, so they can
+// be a part of it. Alt and Caption are used by diagrams, see
+// ConfluenceRenderer.RenderDiagram.
+type codeInfo struct {
+	Language    string
+	Collapse    bool
+	LineNumbers bool
+	Alt         string
+	Caption     string
+	Title       string
+}
+
+// parseCodeInfo splits the info string into words and parses them until
+// the title, which is the rest of the string.
+func parseCodeInfo(info string) codeInfo {
+	var (
+		parsed codeInfo
+		rest   = info
+	)
+
+	for first := true; ; first = false {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			break
+		}
+
+		word := rest
+		if index := strings.IndexAny(rest, " \t"); index >= 0 {
+			word = rest[:index]
+		}
+
+		// values of parameters are quoted, so they can contain spaces
+		if name, value, ok := strings.Cut(word, "="); ok &&
+			strings.HasPrefix(value, `"`) {
+			start := len(name) + len(`="`)
+			if end := strings.IndexByte(rest[start:], '"'); end >= 0 {
+				word = rest[:start+end+1]
+			}
+		}
+
+		rest = rest[len(word):]
+
+		switch {
+		case strings.HasPrefix(word, "alt="):
+			parsed.Alt = getCodeInfoValue(word)
+
+		case strings.HasPrefix(word, "caption="):
+			parsed.Caption = getCodeInfoValue(word)
+
+		case word == "collapse":
+			parsed.Collapse = true
+
+		case word == "linenumbers":
+			parsed.LineNumbers = true
 
-	// get the word in question, aka the first one
-	first := lang
-	if len(paramlist) > 0 {
-		first = paramlist[0]
+		case word == "title":
+			parsed.Title = strings.TrimLeft(rest, " \t")
+
+			return parsed
+
+		case first:
+			parsed.Language = word
+		}
 	}
 
-	if first == "collapse" || first == "title" {
-		// collapsing or including a title without a language
-		return ""
+	return parsed
+}
+
+// getCodeInfoValue returns the value of the parameter of the info string,
+// e.g. Deploy flow for alt="Deploy flow".
+func getCodeInfoValue(word string) string {
+	_, value, _ := strings.Cut(word, "=")
+
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
 	}
-	// the default case with language being the first one
-	return first
+
+	return value
+}
+
+// ParseLanguage returns the language of the code block, which is the first
+// word of the info string unless it's a flag or the title, see
+// parseCodeInfo.
+func ParseLanguage(lang string) string {
+	return parseCodeInfo(lang).Language
 }
 
+// ParseTitle returns the title of the code block, which is the rest of the
+// info string after the title word, see parseCodeInfo.
 func ParseTitle(lang string) string {
-	index := strings.Index(lang, "title")
-	if index >= 0 {
-		// it's found, check if title is given and return it
-		start := index + 6
-		if len(lang) > start {
-			return lang[start:]
-		}
+	return parseCodeInfo(lang).Title
+}
+
+// Handle registers handler for nodes of given type, which is called both
+// when entering the node and when leaving it. Handlers are consulted before
+// the built-in rendering, the last registered handler goes first, so
+// handlers can override ones registered before them. The node is passed to
+// the next handler only if the handler returns false.
+func (renderer *ConfluenceRenderer) Handle(
+	nodeType bf.NodeType,
+	handler NodeHandler,
+) {
+	if renderer.handlers == nil {
+		renderer.handlers = map[bf.NodeType][]NodeHandler{}
 	}
-	return ""
+
+	renderer.handlers[nodeType] = append(renderer.handlers[nodeType], handler)
 }
 
 func (renderer ConfluenceRenderer) RenderNode(
@@ -56,143 +217,1117 @@ func (renderer ConfluenceRenderer) RenderNode(
 	node *bf.Node,
 	entering bool,
 ) bf.WalkStatus {
+	// the text of links without text is never written
+	if isEmptyLinkText(node) {
+		return bf.GoToNext
+	}
+
+	// nodes are counted before handlers, which can render them differently
+	if entering {
+		renderer.state.countBlackfriday(node)
+	}
+
+	renderer.permalinks.track(
+		node,
+		entering,
+		renderer.opts.HeadingIDPrefix,
+		renderer.opts.HeadingIDSuffix,
+	)
+
+	handlers := renderer.handlers[node.Type]
+	for i := len(handlers) - 1; i >= 0; i-- {
+		if status, ok := handlers[i](writer, node, entering); ok {
+			return status
+		}
+	}
+
+	if !entering {
+		renderer.permalinks.write(writer, node)
+	}
+
 	if node.Type == bf.CodeBlock {
-		lang := string(node.Info)
+		info := parseCodeInfo(string(node.Info))
 
-		renderer.Stdlib.Templates.ExecuteTemplate(
+		err := renderer.Stdlib.Templates.ExecuteTemplate(
 			writer,
 			"ac:code",
 			struct {
-				Language string
-				Collapse bool
-				Title    string
-				Text     string
+				Language    string
+				Collapse    bool
+				LineNumbers bool
+				Title       string
+				Text        string
 			}{
-				ParseLanguage(lang),
-				strings.Contains(lang, "collapse"),
-				ParseTitle(lang),
+				info.Language,
+				renderer.opts.CollapseCode || info.Collapse,
+				info.LineNumbers,
+				info.Title,
 				strings.TrimSuffix(string(node.Literal), "\n"),
 			},
 		)
+		if err != nil {
+			return renderer.fail(
+				karma.Format(err, "unable to render code block"),
+			)
+		}
+
+		renderer.state.countTemplate("ac:code")
 
 		return bf.GoToNext
 	}
+
+	if node.Type == bf.BlockQuote && entering {
+		if admonition, ok := renderer.admonitions[node]; ok {
+			var body bytes.Buffer
+
+			for child := node.FirstChild; child != nil; child = child.Next {
+				child.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+					return renderer.RenderNode(&body, node, entering)
+				})
+			}
+
+			title, content := admonition.getBox(body.String(), renderer.opts.EditorV2)
+
+			err := renderer.Stdlib.Templates.ExecuteTemplate(
+				writer,
+				"ac:box",
+				struct {
+					Name  string
+					Icon  string
+					Title string
+					Body  string
+				}{
+					admonition.Macro,
+					"true",
+					title,
+					content,
+				},
+			)
+			if err != nil {
+				return renderer.fail(
+					karma.Format(err, "unable to render admonition"),
+				)
+			}
+
+			renderer.state.countTemplate("ac:box")
+
+			return bf.SkipChildren
+		}
+	}
+
+	if (node.Type == bf.Link || node.Type == bf.Image) && entering {
+		if renderer.opts.LinkResolver != nil {
+			destination, ok := renderer.opts.LinkResolver(
+				string(node.LinkData.Destination),
+			)
+			if ok {
+				node.LinkData.Destination = []byte(destination)
+			}
+
+			// resolver may be slow, e.g. look up pages remotely
+			if err := renderer.opts.getContext().Err(); err != nil {
+				return renderer.fail(err)
+			}
+		}
+
+		if renderer.locate != nil {
+			var (
+				destination = string(node.LinkData.Destination)
+				image       = node.Type == bf.Image
+				line        = renderer.locate(node)
+			)
+
+			issue := getDestinationIssue(
+				destination,
+				image,
+				renderer.state.getReferenceBase(line),
+				renderer.opts,
+			)
+			if issue != "" {
+				renderer.state.warn(line, "%s", issue)
+			}
+
+			renderer.state.attachDestination(line, destination, image)
+		}
+	}
+
+	// links are resolved when entering, so closing tags match opening ones
+	if node.Type == bf.Link {
+		if link, ok := renderer.opts.getPageLink(string(node.LinkData.Destination)); ok {
+			if entering {
+				link.writeOpening(writer, renderer.opts.getSpace())
+
+				if node.FirstChild == nil || isEmptyLinkText(node.FirstChild) {
+					link.writeTitle(writer)
+				}
+			} else {
+				link.writeClosing(writer)
+			}
+
+			return bf.GoToNext
+		}
+	}
+
+	if node.Type == bf.HTMLSpan {
+		if marker, ok := renderer.inlineComments[node]; ok {
+			io.WriteString(writer, marker.String())
+
+			return bf.GoToNext
+		}
+	}
+
 	return renderer.Renderer.RenderNode(writer, node, entering)
 }
 
-// compileMarkdown will replace tags like <ac:rich-tech-body> with escaped
-// equivalent, because bf markdown parser replaces that tags with
-// <a href="ac:rich-text-body">ac:rich-text-body</a> because of the autolink
-// rule.
+// CompileOptions controls how markdown is compiled into Confluence storage
+// format.
+type CompileOptions struct {
+	// Engine is the markdown engine, EngineBlackfriday is used if it's not
+	// set.
+	Engine MarkdownEngine
+
+	// ResolvedComments lists ids of inline comments which markers should be
+	// stripped from the output, leaving only commented text.
+	ResolvedComments []string
+
+	// TitleFromH1 defines whether the leading H1 heading is kept in the
+	// output. The leading H1 heading is kept by default.
+	TitleFromH1 TitleFromH1
+
+	// WikiLinks compiles wiki links into links to pages by their titles:
+	//
+	//	[[Deployment Guide]]
+	//	[[Deployment Guide|how we deploy]]
+	//	[[OPS:Deployment Guide]]
+	//
+	// Pages are in the space of the document unless the title is prefixed
+	// with the space key. Links in code and links with escaped brackets,
+	// like \[\[Title]], are kept as is.
+	WikiLinks bool
+
+	// ConfluenceURL is the base URL of Confluence, e.g.
+	// https://example.atlassian.net/wiki, links to pages of which, like
+	// https://example.atlassian.net/wiki/spaces/ENG/pages/12345/Deploy, are
+	// compiled into ac:link elements the same way as links of
+	// PageLinkScheme, so they survive migrations of the site. Links which
+	// are not recognized as links to pages are kept as is, and so are all
+	// links if it's not set.
+	ConfluenceURL string
+
+	// InlineTOC defines whether tables of contents written in documents for
+	// readers of the repository, e.g. by doctoc, are kept in the output,
+	// removed or replaced with the toc macro. They're kept by default.
+	InlineTOC InlineTOC
+
+	// TitlePrefix and TitleSuffix are added to the page title, see
+	// ResolveTitle.
+	TitlePrefix string
+	TitleSuffix string
+
+	// Meta is the metadata of the compiled document, if any. Metadata
+	// headers override corresponding options for the document.
+	Meta *Meta
+
+	// Labels are added to labels of every document, except for ones of its
+	// Labels-Remove headers. Labels of the page are returned as
+	// CompileResult.Labels.
+	Labels []string
+
+	// DefaultSpace is the space of documents which don't have Space
+	// header. The space is available as ${space} variable and in conditions,
+	// it's an error if it's not set in strict mode.
+	DefaultSpace string
+
+	// Vars are values of ${name} tokens, which are substituted before
+	// parsing, except for fenced code blocks and code spans, where only
+	// ${!name} tokens are substituted. Escaped $${name} is kept as literal
+	// ${name}. Meta.Vars are added to Vars, nothing is substituted if no
+	// variables are defined, except for ${space}, which is the space of the
+	// document unless the variable is defined.
+	Vars map[string]string
+
+	// VersionData are values of placeholders like {{ .GitCommit }} in
+	// Meta.VersionMessage, which is returned as
+	// CompileResult.VersionMessage.
+	VersionData map[string]string
+
+	// MinorEdit overrides Meta.MinorEdit if it's set, the result is returned
+	// as CompileResult.MinorEdit.
+	MinorEdit *bool
+
+	// KeepUndefinedVars keeps tokens of undefined variables as is, it's an
+	// error by default.
+	KeepUndefinedVars bool
+
+	// Extensions is the set of markdown extensions, DefaultExtensions are
+	// used if it's not set.
+	Extensions bf.Extensions
+
+	// HTMLFlags is the set of HTML renderer flags, DefaultHTMLFlags are used
+	// if it's not set. E.g. bf.UseXHTML alone turns Smartypants off.
+	HTMLFlags bf.HTMLFlags
+
+	// HardWraps renders every newline within paragraph as line break.
+	HardWraps bool
+
+	// HeadingIDPrefix and HeadingIDSuffix are added to ids of headings.
+	HeadingIDPrefix string
+	HeadingIDSuffix string
+
+	// AbsolutePrefix is prepended to links and images starting with slash,
+	// e.g. base URL of the documents.
+	AbsolutePrefix string
+
+	// CollapseCode collapses all code blocks, not only ones marked with
+	// collapse.
+	CollapseCode bool
+
+	// LinkResolver rewrites destinations of links and images. Destination
+	// is kept as is if it returns false.
+	LinkResolver func(destination string) (string, bool)
+
+	// Handlers is called before compiling to register custom node handlers
+	// with ConfluenceRenderer.Handle. Handlers are supported by the
+	// blackfriday engine only.
+	Handlers func(renderer *ConfluenceRenderer)
+
+	// Logger receives warnings and trace messages, DefaultLogger is used if
+	// it's not set.
+	Logger Logger
+
+	// Validate checks that generated storage format is valid using
+	// ValidateStorage and returns *StorageError otherwise. Nothing is
+	// written if the storage format is not valid.
+	Validate bool
+
+	// Context cancels compiling when it's done, context.Background is used
+	// if it's not set. Context is checked between stages of compiling and
+	// after LinkResolver calls, context error is returned as is.
+	Context context.Context
+
+	// File is the name of the compiled file used in diagnostics.
+	File string
+
+	// Sources map lines of markdown to lines of File, if markdown is changed
+	// by pre-processing, e.g. includes and macros.
+	Sources *sourcemap.Map
+
+	// BaseDir is the directory relative references to images, files and
+	// included documents are resolved against, the directory of File is
+	// used if it's not set. References of included documents are relative
+	// to their directories.
+	BaseDir string
+
+	// RootDir is the directory references starting with slash, like
+	// /assets/logo.png, are resolved against, e.g. the root of the
+	// repository. Such references are links to pages if it's not set.
+	// References resolved outside of RootDir are reported unless
+	// AllowOutsideRoot is set.
+	RootDir          string
+	AllowOutsideRoot bool
+
+	// FileExists reports whether the file exists, it's used to warn about
+	// images which files don't exist. Paths of images are resolved against
+	// BaseDir or the directory of File, so images are checked only if
+	// FileExists and either of them are set. Compiling doesn't touch the
+	// file system otherwise, CompileMarkdownFile sets it to
+	// FileExistsOnDisk.
+	FileExists func(path string) bool
+
+	// PageExists reports whether the page exists, e.g. by looking it up in
+	// Confluence, it's used to warn about links of PageLinkScheme, which
+	// wiki links are compiled into, to pages which don't exist. Space is
+	// the space of the document if the link has no space key. Links are
+	// not checked if it's not set.
+	PageExists func(space string, title string) bool
+
+	// ChunkSize enables compiling very large documents in chunks of at
+	// least ChunkSize bytes, which are parsed and rendered one by one, so
+	// only the tree of the current chunk is kept in memory. Markdown is
+	// split before top-level headings outside of code and HTML blocks, and
+	// reference link definitions are shared by all chunks, so the output
+	// is the same as without chunks. Documents with footnotes or the table of
+	// contents are compiled as a whole. Chunks are supported by the
+	// blackfriday engine only, Handlers don't see nodes of other chunks.
+	ChunkSize int
+
+	// Strict fails compiling with *StrictError if any warning is reported,
+	// e.g. unresolved links to markdown files, images which files don't
+	// exist, see FileExists, unknown admonition types, invalid inline
+	// comment ids or unknown metadata headers. Every problem is reported as
+	// an error diagnostic and listed in the error, output is written
+	// anyway.
+	Strict bool
+
+	// LintRules are rules checked by Lint, DefaultLintRules are checked if
+	// it's nil. Rules can be enabled one by one, so documents are fixed
+	// incrementally. Compiling ignores it.
+	LintRules []LintRule
+
+	// Ignore are patterns of files and directories CompileTree skips in
+	// addition to patterns of IgnoreFile files, they're relative to the
+	// root. Compiling ignores it.
+	Ignore []string
+
+	// ContentHash writes the comment with the hash of markdown, templates
+	// and options at the beginning of the output:
+	//
+	//	<!-- mark:content-hash <hex SHA-256> -->
+	//
+	// The hash is computed by GetContentHash from the input only, so it
+	// doesn't depend on values which templates or handlers produce, and
+	// it can be read back from the page by ExtractContentHash to skip
+	// updating pages which are not changed. Callbacks such as LinkResolver
+	// and Handlers and functions of templates are not hashed.
+	ContentHash bool
+
+	// Cache reuses results of compiling documents which are not changed
+	// since they're compiled last time, see Cache. It's not hashed.
+	Cache *Cache
+
+	// DraftBanner writes note macro at the beginning of the output of
+	// documents with draft Status header, which tells the page is a draft
+	// generated from File, so accidentally published drafts are obvious.
+	DraftBanner bool
+
+	// HeaderTemplate and FooterTemplate are Go templates of markdown, which
+	// is spliced before and after the body before parsing, e.g. the table
+	// of page owners and the "last reviewed" footer. Templates get Meta and
+	// Vars fields, variables are substituted in the spliced markdown as
+	// well. The header goes after the leading H1 heading, which is dropped
+	// or kept according to TitleFromH1 before splicing. Documents opt out
+	// by Boilerplate: false header.
+	HeaderTemplate string
+	FooterTemplate string
+
+	// Permalinks writes links to headings after their text, see
+	// Permalinks.
+	Permalinks *Permalinks
+
+	// GeneratedBanner writes the banner at the beginning of the output,
+	// which tells that the page is generated from the source file and
+	// must not be edited directly. Documents suppress it by
+	// Generated-Banner header. Drafts get only the draft banner, see
+	// DraftBanner.
+	GeneratedBanner *GeneratedBanner
+
+	// EditorV2 avoids storage format constructs which the new Cloud editor
+	// rewrites when the page is edited, losing formatting:
+	//
+	//   - titles of admonitions are written as leading bold paragraphs of
+	//     their bodies, since panels of the new editor have no titles;
+	//   - heads of tables, which cells contain macros, are written as the
+	//     first rows of table bodies, since the new editor drops macros from
+	//     header cells.
+	EditorV2 bool
+
+	// StripHTML removes raw HTML tags which Confluence strips when the page
+	// is saved, e.g. <script>, <style> and custom elements, so the stored
+	// page matches the compiled one, see ClassifyHTMLTag. Such tags are
+	// reported whether they're removed or not.
+	StripHTML bool
+
+	// Locale selects the catalog of texts which the compiler writes into
+	// pages itself, e.g. texts of banners, from Catalogs, e.g. de or ja.
+	// Messages missing in the catalog, and all messages of unknown locales,
+	// are written in DefaultLocale, which is used if it's not set.
+	Locale string
+
+	// Messages override texts of the catalog of Locale one by one, see
+	// Catalog.
+	Messages Catalog
+
+	// Preview renders the compiled storage format into the HTML document
+	// which can be viewed in a browser, see RenderPreview. The preview is
+	// returned by Compile as CompileResult.Preview, other functions ignore
+	// it.
+	Preview bool
+
+	// Debug enables trace dumps of the whole markdown and rendered HTML,
+	// which are expensive for big documents.
+	Debug bool
+}
+
+func (opts CompileOptions) getLogger() Logger {
+	if opts.Logger == nil {
+		return DefaultLogger
+	}
+
+	return opts.Logger
+}
+
+func (opts CompileOptions) isMinorEdit() bool {
+	if opts.MinorEdit != nil {
+		return *opts.MinorEdit
+	}
+
+	return opts.Meta != nil && opts.Meta.MinorEdit
+}
+
+func (opts CompileOptions) getContext() context.Context {
+	if opts.Context == nil {
+		return context.Background()
+	}
+
+	return opts.Context
+}
+
+func (opts CompileOptions) getExtensions() bf.Extensions {
+	extensions := opts.Extensions
+	if extensions == 0 {
+		extensions = DefaultExtensions
+	}
+
+	if opts.HardWraps {
+		extensions |= bf.HardLineBreak
+	}
+
+	return extensions
+}
+
+func (opts CompileOptions) getHTMLFlags() bf.HTMLFlags {
+	if opts.HTMLFlags == 0 {
+		return DefaultHTMLFlags
+	}
+
+	return opts.HTMLFlags
+}
+
+// getTitleFromH1 returns effective H1 handling policy.
+func (opts CompileOptions) getTitleFromH1() TitleFromH1 {
+	if opts.Meta != nil && opts.Meta.TitleFromH1 != "" {
+		return opts.Meta.TitleFromH1
+	}
+
+	if opts.TitleFromH1 == "" {
+		return TitleFromH1Keep
+	}
+
+	return opts.TitleFromH1
+}
+
+// getInlineTOC returns effective policy of tables of contents.
+func (opts CompileOptions) getInlineTOC() InlineTOC {
+	if opts.InlineTOC == "" {
+		return InlineTOCKeep
+	}
+
+	return opts.InlineTOC
+}
+
+// CompileMarkdown compiles markdown using default options.
 func CompileMarkdown(
 	markdown []byte,
 	stdlib *stdlib.Lib,
-) string {
-	log.Tracef(nil, "rendering markdown:\n%s", string(markdown))
+) (string, error) {
+	return CompileMarkdownWithOptions(markdown, stdlib, CompileOptions{})
+}
+
+// MustCompileMarkdown compiles markdown using default options and panics if
+// it can't be compiled.
+func MustCompileMarkdown(markdown []byte, stdlib *stdlib.Lib) string {
+	html, err := CompileMarkdown(markdown, stdlib)
+	if err != nil {
+		panic(err)
+	}
+
+	return html
+}
 
-	colon := regexp.MustCompile(`---bf-COLON---`)
+// CompileMarkdownWithOptions compiles markdown into Confluence storage format.
+//
+// Output is byte-identical for the same markdown, templates and options, so
+// it can be diffed between runs or cached by its input. Compiling doesn't
+// use current time or random values, and macro parameters are emitted in
+// order they're written in templates. Handlers, LinkResolver and templates
+// which call them are expected to be deterministic as well, see
+// stdlib.WithDeterministicOutput for templates using now and uuid functions.
+//
+// Panics occurred while compiling, e.g. on pathological input, are returned
+// as *PanicError matching ErrCompilePanic.
+func CompileMarkdownWithOptions(
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) (string, error) {
+	var html strings.Builder
 
-	tags := regexp.MustCompile(`<(/?ac):(\S+?)>`)
+	// storage format is usually longer than markdown
+	html.Grow(len(markdown))
 
-	// <span class="inline-comment-marker" data-ref="d8d970d4-eecf-4169-b74d-0f08e0ce77ea">anything</span>
-	inlineCommment := regexp.MustCompile(`<!--[^>]*comment_id='(?P<comment_id>.*)'[^>]*-->(?P<body>.*)<!--[^>]*-->`)
+	err := CompileMarkdownTo(&html, markdown, stdlib, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.Debug {
+		opts.getLogger().Tracef("rendered markdown to html:\n%s", html.String())
+	}
+
+	return html.String(), nil
+}
+
+// CompileMarkdownTo compiles markdown and writes Confluence storage format
+// into the writer as it's rendered. Output written before an error is
+// occurred is left in the writer.
+//
+// Tags like <ac:rich-tech-body> are replaced with escaped equivalent before
+// parsing, because bf markdown parser replaces that tags with
+// <a href="ac:rich-text-body">ac:rich-text-body</a> because of the autolink
+// rule. Escaped tags are restored while the output is written.
+//
+// The output is written at once if CompileOptions.Cache is set, since it's
+// either read from the cache or stored there as a whole.
+func CompileMarkdownTo(
+	writer io.Writer,
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) error {
+	if opts.Cache != nil {
+		result, err := Compile(markdown, stdlib, opts)
+		if err != nil {
+			return err
+		}
 
-	markdown = tags.ReplaceAll(
+		_, err = io.WriteString(writer, result.HTML)
+
+		return err
+	}
+
+	_, err := compile(writer, markdown, stdlib, opts)
+
+	return err
+}
+
+// compile compiles markdown, panics are recovered and returned as
+// *PanicError, the compilation is returned to collect diagnostics anyway.
+func compile(
+	writer io.Writer,
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) (state *compilation, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = newPanicError(value, markdown, opts, state)
+
+			if state == nil {
+				state = newCompilation(opts, opts.Sources)
+			}
+
+			state.report(0, SeverityError, err.Error())
+		}
+	}()
+
+	opts = opts.withDocumentOptions()
+
+	ctx := opts.getContext()
+
+	if err := ctx.Err(); err != nil {
+		return newCompilation(opts, opts.Sources), err
+	}
+
+	if opts.Validate {
+		return compileValidated(writer, markdown, stdlib, opts)
+	}
+
+	if opts.Debug {
+		opts.getLogger().Tracef("rendering markdown:\n%s", string(markdown))
+	}
+
+	toc, err := renderInlineTOCMacro(stdlib, opts)
+	if err != nil {
+		return newCompilation(opts, opts.Sources), err
+	}
+
+	prepared, sources, issues := preprocessMarkdown(markdown, opts, toc)
+
+	state = newCompilation(opts, sources)
+	state.output = &outputWriter{writer: writer}
+	state.reportMetaHeaders(opts.Meta)
+	state.reportSpace(opts)
+	state.attachMeta(opts.Meta)
+	state.versionMessage = state.getVersionMessage(opts.Meta, opts.VersionData)
+
+	if len(issues) > 0 {
+		return state, state.reportSourceIssues(issues)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return state, err
+	}
+
+	if opts.ContentHash {
+		state.contentHash = GetContentHash(markdown, stdlib, opts)
+
+		_, err := io.WriteString(
+			state.output,
+			getContentHashComment(state.contentHash),
+		)
+		if err != nil {
+			return state, karma.Format(err, "unable to write html")
+		}
+	}
+
+	output := &colonWriter{
+		writer: &macroWriter{writer: state.output, stats: &state.stats},
+	}
+
+	if err := writeBanner(output, stdlib, opts, state); err != nil {
+		return state, err
+	}
+
+	if opts.Engine == EngineGoldmark {
+		err = renderGoldmark(output, prepared, stdlib, opts, state)
+	} else {
+		err = renderBlackfriday(output, prepared, stdlib, opts, state)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return state, err
+	}
+
+	if err != nil {
+		if position := state.position(); position != "" {
+			err = karma.Describe("source", position).Reason(err)
+		}
+
+		return state, karma.Format(err, "unable to render markdown")
+	}
+
+	if err := output.Flush(); err != nil {
+		return state, karma.Format(err, "unable to write html")
+	}
+
+	return state, state.getStrictError()
+}
+
+func compileValidated(
+	writer io.Writer,
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+) (*compilation, error) {
+	var html strings.Builder
+
+	opts.Validate = false
+
+	state, err := compile(&html, markdown, stdlib, opts)
+	if err != nil {
+		return state, err
+	}
+
+	issues := ValidateStorage(html.String())
+
+	if err := opts.getContext().Err(); err != nil {
+		return state, err
+	}
+
+	if len(issues) > 0 {
+		state.reportIssues(issues)
+
+		return state, &StorageError{Issues: issues}
+	}
+
+	_, err = io.WriteString(writer, html.String())
+	if err != nil {
+		return state, karma.Format(err, "unable to write html")
+	}
+
+	return state, nil
+}
+
+// preprocessMarkdown prepares markdown for parsing the same way for every
+// engine and ParseDocument. Returned sources map lines of the prepared
+// markdown to lines of the file. Issues are returned if conditional sections
+// are not valid, markdown is not processed further then, or if variables
+// are not defined. Toc is the toc macro tables of contents are replaced
+// with, see CompileOptions.InlineTOC.
+func preprocessMarkdown(
+	markdown []byte,
+	opts CompileOptions,
+	toc []byte,
+) ([]byte, *sourcemap.Map, []sourceIssue) {
+	input := bytes.TrimPrefix(markdown, utf8BOM)
+
+	// passes which only remove parts of markdown work in place once it's
+	// copied, markdown passed by the caller is never modified
+	copied := func(markdown []byte) bool {
+		return len(markdown) > 0 && len(input) > 0 && &markdown[0] != &input[0]
+	}
+
+	markdown = prepareMarkdown(input)
+
+	markdown, sources, issues := processConditions(
 		markdown,
-		[]byte(`<$1`+colon.String()+`$2>`),
+		opts.Sources,
+		opts.getConditionValues(),
+		copied(markdown),
+	)
+	if len(issues) > 0 {
+		return markdown, sources, issues
+	}
+
+	markdown, sources = replaceInlineTOCs(markdown, sources, opts.getInlineTOC(), toc)
+
+	if opts.getTitleFromH1() == TitleFromH1Drop {
+		if title, ok := findDocumentLeadingH1(markdown); ok {
+			sources = sources.Clone()
+			if sources == nil {
+				sources = sourcemap.New(markdown)
+			}
+
+			sources.Replace(markdown, title.Start, title.End, nil)
+
+			if copied(markdown) {
+				markdown = append(markdown[:title.Start], markdown[title.End:]...)
+			} else {
+				markdown = dropDocumentTitle(markdown, title)
+			}
+		}
+	}
+
+	markdown, sources, issues = spliceBoilerplate(markdown, sources, opts)
+	if len(issues) > 0 {
+		return markdown, sources, issues
+	}
+
+	if opts.WikiLinks {
+		markdown = replaceWikiLinks(markdown)
+	}
+
+	vars, keep := opts.getSubstitutedVars()
+	if vars == nil {
+		return markdown, sources, nil
+	}
+
+	markdown, sources, undefined := substituteVars(markdown, sources, vars)
+	if keep {
+		return markdown, sources, nil
+	}
+
+	return markdown, sources, undefined
+}
+
+// ParseDocument parses markdown into the document tree the same way as
+// CompileMarkdownWithOptions does before rendering, using the same
+// extensions and pre-processing, so the tree can be analyzed without
+// compiling. Documents are always parsed by the blackfriday engine, Engine
+// option is ignored. Nodes are kept as parsed, e.g. admonitions are still
+// blockquotes and inline comment markers are HTML spans, and tables of
+// contents, which are replaced with the toc macro, are removed.
+func ParseDocument(markdown []byte, opts CompileOptions) *bf.Node {
+	opts = opts.withDocumentOptions()
+
+	markdown, _, _ = preprocessMarkdown(markdown, opts, nil)
+
+	document := newBlackfridayParser(opts).Parse(markEmptyLinks(markdown))
+
+	// texts of links without text are dropped
+	empty := []*bf.Node{}
+
+	// colons are replaced back while rendering, but tree is returned as is
+	document.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if isEmptyLinkText(node) {
+			empty = append(empty, node)
+		}
+
+		if entering && bytes.Contains(node.Literal, colonPlaceholderBytes) {
+			node.Literal = bytes.ReplaceAll(
+				node.Literal,
+				colonPlaceholderBytes,
+				[]byte(`:`),
+			)
+		}
+
+		return bf.GoToNext
+	})
+
+	// tree can't be modified while walking through it
+	for _, node := range empty {
+		node.Unlink()
+	}
+
+	return document
+}
+
+// Walk calls visitor for every node of the document tree in document
+// order. Children of the node are skipped if visitor returns
+// bf.SkipChildren and walking stops if it returns bf.Terminate.
+func Walk(document *bf.Node, visitor func(node *bf.Node) bf.WalkStatus) {
+	document.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if !entering {
+			return bf.GoToNext
+		}
+
+		return visitor(node)
+	})
+}
+
+func newBlackfridayParser(opts CompileOptions) *bf.Markdown {
+	return bf.New(bf.WithExtensions(opts.getExtensions()))
+}
+
+// prepareMarkdown replaces CRLF line breaks with LF and colons in Confluence
+// tags with placeholders in a single pass. Markdown is returned as is if
+// there is nothing to replace.
+func prepareMarkdown(markdown []byte) []byte {
+	tags := reConfluenceTag.FindAllSubmatchIndex(markdown, -1)
+	if len(tags) == 0 && bytes.IndexByte(markdown, '\r') < 0 {
+		return markdown
+	}
+
+	prepared := make(
+		[]byte,
+		0,
+		len(markdown)+len(tags)*(len(colonPlaceholder)-1),
 	)
 
+	offset := 0
+	for _, tag := range tags {
+		prepared = appendLF(prepared, markdown[offset:tag[0]])
+		prepared = append(prepared, '<')
+		prepared = append(prepared, markdown[tag[2]:tag[3]]...)
+		prepared = append(prepared, colonPlaceholder...)
+		prepared = append(prepared, markdown[tag[4]:tag[5]]...)
+		prepared = append(prepared, '>')
+
+		offset = tag[1]
+	}
+
+	return appendLF(prepared, markdown[offset:])
+}
+
+// appendLF appends data replacing CRLF line breaks with LF.
+func appendLF(buffer []byte, data []byte) []byte {
+	for {
+		index := bytes.Index(data, []byte("\r\n"))
+		if index < 0 {
+			return append(buffer, data...)
+		}
+
+		buffer = append(buffer, data[:index]...)
+		buffer = append(buffer, '\n')
+
+		data = data[index+2:]
+	}
+}
+
+// renderBlackfriday renders markdown using the blackfriday engine, which is
+// the default one.
+func renderBlackfriday(
+	output *colonWriter,
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+	state *compilation,
+) error {
 	renderer := ConfluenceRenderer{
 		Renderer: bf.NewHTMLRenderer(
 			bf.HTMLRendererParameters{
-				Flags: bf.UseXHTML |
-					bf.Smartypants |
-					bf.SmartypantsFractions |
-					bf.SmartypantsDashes |
-					bf.SmartypantsLatexDashes,
+				AbsolutePrefix:  opts.AbsolutePrefix,
+				HeadingIDPrefix: opts.HeadingIDPrefix,
+				HeadingIDSuffix: opts.HeadingIDSuffix,
+				Flags:           opts.getHTMLFlags(),
 			},
 		),
 
 		Stdlib: stdlib,
+
+		opts:       opts,
+		state:      state,
+		permalinks: newPermalinks(opts.Permalinks),
 	}
 
-	html := bf.Run(
-		markdown,
-		bf.WithRenderer(renderer),
-		bf.WithExtensions(
-			bf.Tables|
-				bf.FencedCode|
-				bf.Autolink|
-				bf.LaxHTMLBlocks|
-				bf.Strikethrough|
-				bf.SpaceHeadings|
-				bf.HeadingIDs|
-				bf.AutoHeadingIDs|
-				bf.Titleblock|
-				bf.BackslashLineBreak|
-				bf.DefinitionLists|
-				bf.NoEmptyLineBeforeBlock|
-				bf.Footnotes,
-		),
+	if opts.Handlers != nil {
+		opts.Handlers(&renderer)
+	}
+
+	var err error
+
+	renderer.err = &err
+
+	markdown = markEmptyLinks(markdown)
+
+	state.index(markdown)
+
+	var (
+		ctx    = opts.getContext()
+		chunks = splitMarkdownChunks(markdown, opts.ChunkSize, opts)
+
+		// warnings are reported in document order as well
+		comments    = &blackfridayLocator{markdown: markdown, state: state}
+		admonitions = &blackfridayLocator{markdown: markdown, state: state}
+		htmls       = &blackfridayLocator{markdown: markdown, state: state}
+		blocks      = &blackfridayLocator{markdown: markdown, state: state}
+
+		// definitions of references are parsed with every chunk, since
+		// they are usually written at the end of the document
+		definitions []byte
+		buffer      []byte
 	)
 
-	html = colon.ReplaceAll(html, []byte(`:`))
-	matches := inlineCommment.FindAllSubmatch(html, -1)
+	if len(chunks) > 1 {
+		definitions = getReferenceDefinitions(markdown)
+	}
 
-	for _, match := range matches {
-		commentId := string(match[1])
-		body := string(match[2])
-		html = bytes.ReplaceAll(html, match[0],
-			[]byte(fmt.Sprintf(`<span class="inline-comment-marker" data-ref="%s">%s</span>`, commentId, body)))
+	renderer.locate = func(node *bf.Node) int {
+		// inline nodes are located by their text after the block being
+		// rendered, without moving locator of blocks
+		locator := *blocks
+
+		if node.FirstChild != nil {
+			node = node.FirstChild
+		}
+
+		return locator.locate(node)
 	}
 
-	log.Tracef(nil, "rendered markdown to html:\n%s", string(html))
-	fmt.Printf("%s\n", string(html))
-	return string(html)
-}
+	for index, chunk := range chunks {
+		source := chunk.markdown
+		if len(definitions) > 0 {
+			buffer = append(append(append(buffer[:0], source...), '\n'), definitions...)
+			source = buffer
+		}
 
-// DropDocumentLeadingH1 will drop leading H1 headings to prevent
-// duplication of or visual conflict with page titles.
-// NOTE: This is intended only to operate on the whole markdown document.
-// Operating on individual lines will clear them if the begin with `#`.
-func DropDocumentLeadingH1(
-	markdown []byte,
-) []byte {
-	h1 := regexp.MustCompile(`^#[^#].*\n`)
-	markdown = h1.ReplaceAll(markdown, []byte(""))
-	return markdown
-}
-
-// ExtractDocumentLeadingH1 will extract leading H1 heading
-func ExtractDocumentLeadingH1(markdown []byte) string {
-	h1 := regexp.MustCompile(`^#[^#]\s*(.*)\s*\n`)
-	groups := h1.FindSubmatch(markdown)
-	if groups == nil {
-		return ""
-	} else {
-		return string(groups[1])
+		document := newBlackfridayParser(opts).Parse(source)
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		renderer.inlineComments = markInlineComments(
+			document,
+			opts.ResolvedComments,
+			func(node *bf.Node, format string, args ...interface{}) {
+				state.warn(comments.locate(node), format, args...)
+			},
+		)
+		renderer.admonitions = markAdmonitions(
+			document,
+			func(node *bf.Node, format string, args ...interface{}) {
+				state.warn(admonitions.locate(node), format, args...)
+			},
+		)
+
+		checkRawHTML(
+			document,
+			opts.StripHTML,
+			htmls.locate,
+			state.warn,
+		)
+
+		if opts.EditorV2 {
+			demoteTableHeads(document)
+		}
+
+		if index == 0 {
+			renderer.RenderHeader(output, document)
+		}
+
+		document.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+			if output.err != nil {
+				return bf.Terminate
+			}
+
+			if entering && node.Parent == document {
+				if err := ctx.Err(); err != nil {
+					return renderer.fail(err)
+				}
+			}
+
+			if entering {
+				if isBlackfridayContainer(node.Type) {
+					state.output.open()
+				} else if node.Type != bf.Document {
+					state.output.mark(blocks.locate(node))
+				}
+			}
+
+			return renderer.RenderNode(output, node, entering)
+		})
+
+		if err != nil || output.err != nil {
+			return err
+		}
+
+		if index == len(chunks)-1 {
+			renderer.RenderFooter(output, document)
+		}
 	}
+
+	return err
 }
 
-func HtmlToMarkdown(html string, fileName string) {
-	converter := md.NewConverter("", true, nil)
-	converter.Keep("#comment")
-	markdown, err := converter.ConvertString(html)
-	if err != nil {
-		log.Fatal(err)
+// colonWriter replaces colon placeholders back with colons while the output
+// is written. Data which can be the beginning of the placeholder is held
+// until the next write.
+type colonWriter struct {
+	writer  io.Writer
+	pending []byte
+	err     error
+
+	// chunk and output are reused between writes
+	chunk  []byte
+	output []byte
+}
+
+func (writer *colonWriter) Write(data []byte) (int, error) {
+	if writer.err != nil {
+		return 0, writer.err
 	}
 
-	f, err := os.Create(fileName)
+	chunk := data
+	if len(writer.pending) > 0 {
+		writer.chunk = append(append(writer.chunk[:0], writer.pending...), data...)
+		chunk = writer.chunk
+	}
 
-	if err != nil {
-		log.Fatal(err)
+	if bytes.Contains(chunk, colonPlaceholderBytes) {
+		output := writer.output[:0]
+		for {
+			index := bytes.Index(chunk, colonPlaceholderBytes)
+			if index < 0 {
+				break
+			}
+
+			output = append(output, chunk[:index]...)
+			output = append(output, ':')
+
+			chunk = chunk[index+len(colonPlaceholder):]
+		}
+
+		writer.output = append(output, chunk...)
+		chunk = writer.output
 	}
 
-	defer f.Close()
+	hold := 0
+	for size := len(colonPlaceholder) - 1; size > 0; size-- {
+		if bytes.HasSuffix(chunk, colonPlaceholderBytes[:size]) {
+			hold = size
+			break
+		}
+	}
 
-	_, err2 := f.WriteString(markdown)
+	writer.pending = append(writer.pending[:0], chunk[len(chunk)-hold:]...)
 
-	if err2 != nil {
-		log.Fatal(err2)
+	_, writer.err = writer.writer.Write(chunk[:len(chunk)-hold])
+	if writer.err != nil {
+		return 0, writer.err
 	}
+
+	return len(data), nil
+}
+
+// Flush writes data held by the writer.
+func (writer *colonWriter) Flush() error {
+	if writer.err != nil {
+		return writer.err
+	}
+
+	_, writer.err = writer.writer.Write(writer.pending)
+	writer.pending = nil
+
+	return writer.err
 }
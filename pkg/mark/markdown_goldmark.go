@@ -0,0 +1,988 @@
+package mark
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/reconquest/karma-go"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	gtext "github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// MarkdownEngine defines which markdown parser and renderer are used to
+// compile documents.
+type MarkdownEngine string
+
+const (
+	// EngineBlackfriday compiles documents using blackfriday. This is the
+	// default.
+	EngineBlackfriday MarkdownEngine = "blackfriday"
+
+	// EngineGoldmark compiles documents using goldmark, which follows
+	// CommonMark. It produces the same storage format, known differences
+	// are listed by goldmark fixtures in testdata.
+	EngineGoldmark MarkdownEngine = "goldmark"
+)
+
+var (
+	// reAdmonitionLine matches raw first line of the paragraph, which
+	// goldmark splits into several text nodes.
+	reAdmonitionLine = regexp.MustCompile(`^\[!([A-Za-z]+)\]`)
+
+	// reInlineCommentMarker matches inline comment markers within raw
+	// markdown line.
+	reInlineCommentMarker = regexp.MustCompile(
+		`<!--[^>]*comment_id='[^']*'[^>]*-->|<!--\s*-->`,
+	)
+
+	// reTagColon matches colon in names of Confluence tags, which are not
+	// valid HTML tag names for CommonMark.
+	reTagColon = regexp.MustCompile(`<(/?(?:ac|ri)):([A-Za-z])`)
+)
+
+// goldmarkDocument holds nodes found by goldmarkTransformer, which are
+// rendered by goldmarkRenderer differently from plain HTML.
+type goldmarkDocument struct {
+	inlineComments map[ast.Node]inlineCommentMarker
+	admonitions    map[ast.Node]admonition
+
+	// html are literals of raw HTML with converted tags or without tags
+	// which Confluence strips, see convertHTMLTags and
+	// CompileOptions.StripHTML
+	html map[ast.Node][]byte
+}
+
+// renderGoldmark renders markdown using the goldmark engine. Extensions and
+// HTML flags of the options are mapped to goldmark extensions, ones which
+// have no goldmark equivalent are ignored.
+func renderGoldmark(
+	output *colonWriter,
+	markdown []byte,
+	stdlib *stdlib.Lib,
+	opts CompileOptions,
+	state *compilation,
+) error {
+	if opts.Handlers != nil {
+		return karma.Format(
+			nil,
+			"custom node handlers are not supported by %s engine",
+			EngineGoldmark,
+		)
+	}
+
+	var (
+		extensions = opts.getExtensions()
+		flags      = opts.getHTMLFlags()
+
+		document = &goldmarkDocument{
+			inlineComments: map[ast.Node]inlineCommentMarker{},
+			admonitions:    map[ast.Node]admonition{},
+			html:           map[ast.Node][]byte{},
+		}
+
+		extenders     = []goldmark.Extender{}
+		parserOptions = []parser.Option{}
+		htmlOptions   = []html.Option{html.WithUnsafe()}
+	)
+
+	if extensions&bf.Tables != 0 {
+		extenders = append(extenders, extension.Table)
+	}
+
+	if extensions&bf.Strikethrough != 0 {
+		extenders = append(extenders, extension.Strikethrough)
+	}
+
+	if extensions&bf.Autolink != 0 {
+		extenders = append(extenders, extension.Linkify)
+	}
+
+	if extensions&bf.DefinitionLists != 0 {
+		extenders = append(extenders, extension.DefinitionList)
+	}
+
+	if extensions&bf.Footnotes != 0 {
+		extenders = append(extenders, extension.Footnote)
+	}
+
+	if flags&bf.Smartypants != 0 {
+		extenders = append(extenders, extension.Typographer)
+	}
+
+	if extensions&bf.AutoHeadingIDs != 0 {
+		parserOptions = append(parserOptions, parser.WithAutoHeadingID())
+	}
+
+	if extensions&bf.HeadingIDs != 0 {
+		parserOptions = append(parserOptions, parser.WithAttribute())
+	}
+
+	if extensions&bf.HardLineBreak != 0 {
+		htmlOptions = append(htmlOptions, html.WithHardWraps())
+	}
+
+	if flags&bf.UseXHTML != 0 {
+		htmlOptions = append(htmlOptions, html.WithXHTML())
+	}
+
+	// options are used by renderers of extensions as well
+	rendererOptions := make([]renderer.Option, len(htmlOptions))
+	for i, option := range htmlOptions {
+		rendererOptions[i] = option.(renderer.Option)
+	}
+
+	parserOptions = append(
+		parserOptions,
+		parser.WithASTTransformers(
+			util.Prioritized(
+				&goldmarkTransformer{
+					document: document,
+					opts:     opts,
+					state:    state,
+				},
+				100,
+			),
+		),
+	)
+
+	blockParsers := parser.DefaultBlockParsers()
+	for i, blockParser := range blockParsers {
+		// HTML block parser
+		if blockParser.Priority == 900 {
+			blockParsers[i].Value = htmlBlockParser{
+				blockParser.Value.(parser.BlockParser),
+			}
+		}
+	}
+
+	engine := goldmark.New(
+		goldmark.WithParser(
+			parser.NewParser(
+				parser.WithBlockParsers(blockParsers...),
+				parser.WithInlineParsers(parser.DefaultInlineParsers()...),
+				parser.WithParagraphTransformers(
+					parser.DefaultParagraphTransformers()...,
+				),
+			),
+		),
+		goldmark.WithExtensions(extenders...),
+		goldmark.WithParserOptions(parserOptions...),
+		goldmark.WithRendererOptions(rendererOptions...),
+	)
+
+	engine.Renderer().AddOptions(
+		renderer.WithNodeRenderers(
+			util.Prioritized(
+				&goldmarkRenderer{
+					engine:   engine,
+					document: document,
+					stdlib:   stdlib,
+					opts:     opts,
+					state:    state,
+					html:     htmlOptions,
+				},
+				100,
+			),
+		),
+	)
+
+	if reTagColon.Match(markdown) {
+		markdown = reTagColon.ReplaceAll(markdown, []byte(`<$1`+colonPlaceholder+`$2`))
+	}
+
+	state.index(markdown)
+
+	context := parser.NewContext()
+	context = parser.NewContext(
+		parser.WithIDs(headingIDs{context.IDs()}),
+	)
+
+	return engine.Convert(
+		markdown,
+		goldmarkWriter{output},
+		parser.WithContext(context),
+	)
+}
+
+// goldmarkWriter passes the output of goldmark renderer to the writer
+// without buffering, so lines of the output are counted as nodes are
+// rendered.
+type goldmarkWriter struct {
+	io.Writer
+}
+
+func (writer goldmarkWriter) WriteByte(c byte) error {
+	_, err := writer.Write([]byte{c})
+	return err
+}
+
+func (writer goldmarkWriter) WriteRune(r rune) (int, error) {
+	var buffer [utf8.UTFMax]byte
+
+	return writer.Write(buffer[:utf8.EncodeRune(buffer[:], r)])
+}
+
+func (writer goldmarkWriter) WriteString(s string) (int, error) {
+	return io.WriteString(writer.Writer, s)
+}
+
+func (writer goldmarkWriter) Available() int { return 0 }
+
+func (writer goldmarkWriter) Buffered() int { return 0 }
+
+func (writer goldmarkWriter) Flush() error { return nil }
+
+// htmlBlockParser doesn't start HTML block with inline comment marker, so
+// paragraph starting with commented text is kept as paragraph like
+// blackfriday does.
+type htmlBlockParser struct {
+	parser.BlockParser
+}
+
+func (blockParser htmlBlockParser) Open(
+	parent ast.Node,
+	reader gtext.Reader,
+	context parser.Context,
+) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	if marker := reInlineCommentMarker.FindIndex(line); marker != nil &&
+		len(bytes.TrimLeft(line[:marker[0]], " ")) == 0 {
+		return nil, parser.NoChildren
+	}
+
+	return blockParser.BlockParser.Open(parent, reader, context)
+}
+
+// headingIDs generates heading ids ignoring inline comment markers, so
+// commenting heading doesn't change its anchor.
+type headingIDs struct {
+	parser.IDs
+}
+
+func (ids headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	return ids.IDs.Generate(reInlineCommentMarker.ReplaceAll(value, nil), kind)
+}
+
+// goldmarkTransformer makes the same changes to the document tree which
+// blackfriday engine makes before and while rendering: pairs inline comment
+// markers, marks admonitions and resolves links.
+type goldmarkTransformer struct {
+	document *goldmarkDocument
+	opts     CompileOptions
+	state    *compilation
+}
+
+func (transformer *goldmarkTransformer) Transform(
+	node *ast.Document,
+	reader gtext.Reader,
+	context parser.Context,
+) {
+	source := reader.Source()
+
+	transformer.markInlineComments(node, source)
+	transformer.markAdmonitions(node, source)
+	transformer.checkRawHTML(node, source)
+
+	if transformer.opts.EditorV2 {
+		demoteGoldmarkTableHeads(node, source)
+	}
+
+	ast.Walk(node, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		// links are counted before they're resolved
+		transformer.state.countGoldmark(node, source)
+
+		switch node := node.(type) {
+		case *ast.Link:
+			node.Destination = transformer.resolveLink(node, node.Destination, false)
+
+		case *ast.Image:
+			node.Destination = transformer.resolveLink(node, node.Destination, true)
+
+		case *ast.Heading:
+			if id, ok := node.AttributeString("id"); ok {
+				if id, ok := id.([]byte); ok {
+					node.SetAttributeString(
+						"id",
+						[]byte(transformer.opts.HeadingIDPrefix+
+							string(id)+
+							transformer.opts.HeadingIDSuffix),
+					)
+				}
+			}
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	addGoldmarkPermalinks(node, source, transformer.opts.Permalinks)
+}
+
+// resolveLink applies LinkResolver and AbsolutePrefix the same way as
+// blackfriday renderer does, reports problems of the destination and adds
+// local files to the attachment manifest.
+func (transformer *goldmarkTransformer) resolveLink(
+	node ast.Node,
+	link []byte,
+	image bool,
+) []byte {
+	if transformer.opts.LinkResolver != nil {
+		if destination, ok := transformer.opts.LinkResolver(string(link)); ok {
+			link = []byte(destination)
+		}
+	}
+
+	line := 0
+	if offset, ok := getGoldmarkOffset(node); ok {
+		line = transformer.state.line(offset)
+	}
+
+	if issue := getDestinationIssue(
+		string(link),
+		image,
+		transformer.state.getReferenceBase(line),
+		transformer.opts,
+	); issue != "" {
+		transformer.state.warn(line, "%s", issue)
+	}
+
+	transformer.state.attachDestination(line, string(link), image)
+
+	prefix := transformer.opts.AbsolutePrefix
+	if prefix == "" || len(link) == 0 || link[0] == '.' {
+		return link
+	}
+
+	// links to pages are compiled into ac:link elements, blackfriday
+	// renderer doesn't prefix them either
+	if _, ok := transformer.opts.getPageLink(string(link)); ok && !image {
+		return link
+	}
+
+	switch {
+	case link[0] == '#',
+		link[0] == '/' && (len(link) == 1 || link[1] != '/'):
+	default:
+		return link
+	}
+
+	if link[0] != '/' {
+		prefix += "/"
+	}
+
+	return []byte(prefix + string(link))
+}
+
+// markInlineComments pairs inline comment markers following the same rules
+// as markInlineComments for blackfriday documents.
+func (transformer *goldmarkTransformer) markInlineComments(
+	document *ast.Document,
+	source []byte,
+) {
+	var (
+		markers = transformer.document.inlineComments
+		opened  = map[ast.Node][]ast.Node{}
+		pairs   = [][2]ast.Node{}
+		strip   = map[string]bool{}
+		macros  = map[ast.Node]int{}
+	)
+
+	for _, id := range transformer.opts.ResolvedComments {
+		strip[id] = true
+	}
+
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		raw, ok := node.(*ast.RawHTML)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		literal := getRawHTML(raw, source)
+
+		block := getGoldmarkCommentBlock(node)
+
+		if groups := findStructuredMacroTag(literal); groups != nil {
+			switch {
+			case len(groups[2]) > 0:
+			case len(groups[1]) > 0:
+				macros[block]--
+			default:
+				macros[block]++
+			}
+
+			return ast.WalkContinue, nil
+		}
+
+		if macros[block] > 0 {
+			return ast.WalkContinue, nil
+		}
+
+		parsed, ok := parseInlineCommentMarker(literal)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+
+		if !parsed.Closing {
+			if parsed.Strip {
+				transformer.state.warn(
+					transformer.state.line(raw.Segments.At(0).Start),
+					"invalid inline comment id %q, dropping comment marker",
+					parsed.ID,
+				)
+			}
+
+			if strip[parsed.ID] {
+				parsed.Strip = true
+			}
+
+			opened[block] = append(opened[block], node)
+
+			markers[node] = parsed
+
+			return ast.WalkContinue, nil
+		}
+
+		starts := opened[block]
+		if len(starts) == 0 {
+			return ast.WalkContinue, nil
+		}
+
+		opened[block] = starts[:len(starts)-1]
+
+		pairs = append(pairs, [2]ast.Node{starts[len(starts)-1], node})
+
+		marker := markers[starts[len(starts)-1]]
+		marker.Closing = true
+
+		markers[node] = marker
+
+		return ast.WalkContinue, nil
+	})
+
+	for _, starts := range opened {
+		for _, start := range starts {
+			delete(markers, start)
+		}
+	}
+
+	// tree can't be modified while walking through it
+	for _, pair := range pairs {
+		alignGoldmarkCommentMarkers(pair[0], pair[1])
+	}
+}
+
+func getRawHTML(node *ast.RawHTML, source []byte) []byte {
+	var literal []byte
+	for i := 0; i < node.Segments.Len(); i++ {
+		segment := node.Segments.At(i)
+		literal = append(literal, segment.Value(source)...)
+	}
+
+	return literal
+}
+
+func getGoldmarkCommentBlock(node ast.Node) ast.Node {
+	for node.Parent() != nil {
+		node = node.Parent()
+
+		switch node.Kind() {
+		case ast.KindEmphasis, ast.KindLink, ast.KindImage,
+			east.KindStrikethrough:
+			continue
+		}
+
+		return node
+	}
+
+	return node
+}
+
+// alignGoldmarkCommentMarkers moves start and end markers to the same parent
+// node, extending commented region to whole inline elements.
+func alignGoldmarkCommentMarkers(start, end ast.Node) {
+	if start.Parent() == end.Parent() {
+		return
+	}
+
+	ancestors := map[ast.Node]bool{}
+	for node := start.Parent(); node != nil; node = node.Parent() {
+		ancestors[node] = true
+	}
+
+	parent := end.Parent()
+	for !ancestors[parent] {
+		parent = parent.Parent()
+	}
+
+	before := start
+	for before.Parent() != parent {
+		before = before.Parent()
+	}
+
+	after := end
+	for after.Parent() != parent {
+		after = after.Parent()
+	}
+
+	if before != start {
+		start.Parent().RemoveChild(start.Parent(), start)
+		parent.InsertBefore(parent, before, start)
+	}
+
+	if after != end {
+		end.Parent().RemoveChild(end.Parent(), end)
+		parent.InsertAfter(parent, after, end)
+	}
+}
+
+// markAdmonitions finds blockquotes written as GitHub alerts like
+// markAdmonitions for blackfriday documents does.
+func (transformer *goldmarkTransformer) markAdmonitions(
+	document *ast.Document,
+	source []byte,
+) {
+	quotes := []ast.Node{}
+
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && node.Kind() == ast.KindBlockquote {
+			quotes = append(quotes, node)
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	// tree can't be modified while walking through it
+	for _, quote := range quotes {
+		for _, quote := range splitGoldmarkAdmonitions(quote, source) {
+			if admonition, ok := parseGoldmarkAdmonition(quote, source); ok {
+				transformer.document.admonitions[quote] = admonition
+
+				continue
+			}
+
+			paragraph := quote.FirstChild()
+			if paragraph == nil || paragraph.Kind() != ast.KindParagraph ||
+				paragraph.Lines().Len() == 0 {
+				continue
+			}
+
+			line := paragraph.Lines().At(0)
+
+			if groups := reAdmonitionLine.FindSubmatch(line.Value(source)); groups != nil {
+				transformer.state.warn(
+					transformer.state.line(line.Start),
+					"unknown admonition type %q",
+					groups[1],
+				)
+			}
+		}
+	}
+}
+
+func splitGoldmarkAdmonitions(quote ast.Node, source []byte) []ast.Node {
+	quotes := []ast.Node{quote}
+
+	for node := quote.FirstChild(); node != nil; node = node.NextSibling() {
+		if node == quote.FirstChild() ||
+			!isGoldmarkAdmonitionParagraph(node, source) {
+			continue
+		}
+
+		split := ast.NewBlockquote()
+		quote.Parent().InsertAfter(quote.Parent(), quote, split)
+
+		for node != nil {
+			next := node.NextSibling()
+
+			quote.RemoveChild(quote, node)
+			split.AppendChild(split, node)
+
+			node = next
+		}
+
+		return append(quotes, splitGoldmarkAdmonitions(split, source)...)
+	}
+
+	return quotes
+}
+
+func isGoldmarkAdmonitionParagraph(node ast.Node, source []byte) bool {
+	if node.Kind() != ast.KindParagraph || node.Lines().Len() == 0 {
+		return false
+	}
+
+	line := node.Lines().At(0)
+
+	groups := reAdmonitionLine.FindSubmatch(line.Value(source))
+	if groups == nil {
+		return false
+	}
+
+	_, ok := admonitionMacros[strings.ToUpper(string(groups[1]))]
+
+	return ok
+}
+
+func parseGoldmarkAdmonition(
+	quote ast.Node,
+	source []byte,
+) (admonition, bool) {
+	paragraph := quote.FirstChild()
+	if paragraph == nil || !isGoldmarkAdmonitionParagraph(paragraph, source) {
+		return admonition{}, false
+	}
+
+	line := paragraph.Lines().At(0)
+
+	groups := reAdmonitionLine.FindSubmatch(line.Value(source))
+
+	macro := admonitionMacros[strings.ToUpper(string(groups[1]))]
+
+	// everything up to the end of the first line is the title
+	var title bytes.Buffer
+
+	for node := paragraph.FirstChild(); node != nil; {
+		next := node.NextSibling()
+
+		if start, ok := getGoldmarkStart(node); ok && start >= line.Stop {
+			break
+		}
+
+		paragraph.RemoveChild(paragraph, node)
+
+		title.Write(node.Text(source))
+
+		if text, ok := node.(*ast.Text); ok &&
+			(text.SoftLineBreak() || text.HardLineBreak()) {
+			break
+		}
+
+		node = next
+	}
+
+	if paragraph.ChildCount() == 0 {
+		quote.RemoveChild(quote, paragraph)
+	}
+
+	return admonition{
+		Macro: macro,
+		Title: strings.TrimSpace(
+			reAdmonition.ReplaceAllString(title.String(), ""),
+		),
+	}, true
+}
+
+// getGoldmarkStart returns offset of the first text of the inline node in
+// the source.
+func getGoldmarkStart(node ast.Node) (int, bool) {
+	if text, ok := node.(*ast.Text); ok {
+		return text.Segment.Start, true
+	}
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if start, ok := getGoldmarkStart(child); ok {
+			return start, true
+		}
+	}
+
+	return 0, false
+}
+
+// getGoldmarkOffset returns offset of the node in the source, which is the
+// offset of its first child for container blocks.
+func getGoldmarkOffset(node ast.Node) (int, bool) {
+	switch node := node.(type) {
+	case *ast.Text:
+		return node.Segment.Start, true
+
+	case *ast.RawHTML:
+		if node.Segments.Len() > 0 {
+			return node.Segments.At(0).Start, true
+		}
+	}
+
+	if node.Type() == ast.TypeBlock && node.Lines().Len() > 0 {
+		return node.Lines().At(0).Start, true
+	}
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if offset, ok := getGoldmarkOffset(child); ok {
+			return offset, true
+		}
+	}
+
+	return 0, false
+}
+
+// goldmarkRenderer renders code blocks, admonitions and inline comment
+// markers as Confluence macros, everything else is rendered by goldmark as
+// HTML.
+//
+// Nodes rendered by goldmark HTML renderer are rendered through it as well,
+// so lines of the output are mapped to lines of markdown.
+type goldmarkRenderer struct {
+	engine   goldmark.Markdown
+	document *goldmarkDocument
+	stdlib   *stdlib.Lib
+	opts     CompileOptions
+	state    *compilation
+
+	// html are options of goldmark HTML renderer
+	html []html.Option
+}
+
+// goldmarkFuncs collects node renderer functions.
+type goldmarkFuncs map[ast.NodeKind]renderer.NodeRendererFunc
+
+func (funcs goldmarkFuncs) Register(
+	kind ast.NodeKind,
+	render renderer.NodeRendererFunc,
+) {
+	funcs[kind] = render
+}
+
+func (renderer *goldmarkRenderer) RegisterFuncs(
+	registerer renderer.NodeRendererFuncRegisterer,
+) {
+	funcs := goldmarkFuncs{}
+
+	html.NewRenderer(renderer.html...).RegisterFuncs(funcs)
+
+	funcs[ast.KindCodeBlock] = renderer.renderCodeBlock
+	funcs[ast.KindFencedCodeBlock] = renderer.renderCodeBlock
+	funcs[ast.KindBlockquote] = renderer.renderBlockquote
+	funcs[ast.KindRawHTML] = renderer.renderRawHTML
+	funcs[ast.KindHTMLBlock] = renderer.renderHTMLBlock(funcs[ast.KindHTMLBlock])
+	funcs[ast.KindLink] = renderer.renderLink(funcs[ast.KindLink])
+
+	if renderer.opts.EditorV2 {
+		funcs[east.KindTableRow] = renderer.renderTableRow
+	}
+
+	for kind, render := range funcs {
+		registerer.Register(kind, renderer.track(render))
+	}
+}
+
+// track marks lines of markdown rendered by blocks in the output and stops
+// rendering when the context is done.
+func (renderer *goldmarkRenderer) track(
+	render renderer.NodeRendererFunc,
+) renderer.NodeRendererFunc {
+	return func(
+		writer util.BufWriter,
+		source []byte,
+		node ast.Node,
+		entering bool,
+	) (ast.WalkStatus, error) {
+		if entering && node.Parent() != nil && node.Parent().Kind() == ast.KindDocument {
+			if err := renderer.opts.getContext().Err(); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+
+		if entering && node.Type() == ast.TypeBlock {
+			if offset, ok := getGoldmarkOffset(node); ok {
+				renderer.state.output.mark(renderer.state.line(offset))
+			}
+		}
+
+		return render(writer, source, node, entering)
+	}
+}
+
+// renderLink renders links of PageLinkScheme into ac:link elements and
+// other links with given function.
+func (renderer *goldmarkRenderer) renderLink(
+	render renderer.NodeRendererFunc,
+) renderer.NodeRendererFunc {
+	return func(
+		writer util.BufWriter,
+		source []byte,
+		node ast.Node,
+		entering bool,
+	) (ast.WalkStatus, error) {
+		link, ok := renderer.opts.getPageLink(string(node.(*ast.Link).Destination))
+		if !ok {
+			return render(writer, source, node, entering)
+		}
+
+		if entering {
+			link.writeOpening(writer, renderer.opts.getSpace())
+
+			if !node.HasChildren() {
+				link.writeTitle(writer)
+			}
+		} else {
+			link.writeClosing(writer)
+		}
+
+		return ast.WalkContinue, nil
+	}
+}
+
+func (renderer *goldmarkRenderer) renderCodeBlock(
+	writer util.BufWriter,
+	source []byte,
+	node ast.Node,
+	entering bool,
+) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	var lang string
+	if fenced, ok := node.(*ast.FencedCodeBlock); ok && fenced.Info != nil {
+		lang = string(fenced.Info.Text(source))
+	}
+
+	info := parseCodeInfo(lang)
+
+	var code bytes.Buffer
+	for i := 0; i < node.Lines().Len(); i++ {
+		line := node.Lines().At(i)
+		code.Write(line.Value(source))
+	}
+
+	err := renderer.stdlib.Templates.ExecuteTemplate(
+		writer,
+		"ac:code",
+		struct {
+			Language    string
+			Collapse    bool
+			LineNumbers bool
+			Title       string
+			Text        string
+		}{
+			info.Language,
+			renderer.opts.CollapseCode || info.Collapse,
+			info.LineNumbers,
+			info.Title,
+			strings.TrimSuffix(code.String(), "\n"),
+		},
+	)
+	if err != nil {
+		return ast.WalkStop, karma.Format(err, "unable to render code block")
+	}
+
+	renderer.state.countTemplate("ac:code")
+
+	return ast.WalkSkipChildren, nil
+}
+
+func (renderer *goldmarkRenderer) renderBlockquote(
+	writer util.BufWriter,
+	source []byte,
+	node ast.Node,
+	entering bool,
+) (ast.WalkStatus, error) {
+	admonition, ok := renderer.document.admonitions[node]
+	if !ok {
+		if entering {
+			writer.WriteString("<blockquote>\n")
+		} else {
+			writer.WriteString("</blockquote>\n")
+		}
+
+		return ast.WalkContinue, nil
+	}
+
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	var body bytes.Buffer
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		err := renderer.engine.Renderer().Render(&body, source, child)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+	}
+
+	title, content := admonition.getBox(body.String(), renderer.opts.EditorV2)
+
+	err := renderer.stdlib.Templates.ExecuteTemplate(
+		writer,
+		"ac:box",
+		struct {
+			Name  string
+			Icon  string
+			Title string
+			Body  string
+		}{
+			admonition.Macro,
+			"true",
+			title,
+			content,
+		},
+	)
+	if err != nil {
+		return ast.WalkStop, karma.Format(err, "unable to render admonition")
+	}
+
+	renderer.state.countTemplate("ac:box")
+
+	return ast.WalkSkipChildren, nil
+}
+
+func (renderer *goldmarkRenderer) renderRawHTML(
+	writer util.BufWriter,
+	source []byte,
+	node ast.Node,
+	entering bool,
+) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+
+	if marker, ok := renderer.document.inlineComments[node]; ok {
+		writer.WriteString(marker.String())
+	} else if literal, ok := renderer.document.html[node]; ok {
+		writer.Write(literal)
+	} else {
+		writer.Write(getRawHTML(node.(*ast.RawHTML), source))
+	}
+
+	return ast.WalkSkipChildren, nil
+}
+
+// renderHTMLBlock renders HTML blocks with converted tags or without tags
+// which Confluence strips, see checkRawHTML, and other blocks with given
+// function.
+func (renderer *goldmarkRenderer) renderHTMLBlock(
+	render renderer.NodeRendererFunc,
+) renderer.NodeRendererFunc {
+	return func(
+		writer util.BufWriter,
+		source []byte,
+		node ast.Node,
+		entering bool,
+	) (ast.WalkStatus, error) {
+		literal, ok := renderer.document.html[node]
+		if !ok {
+			return render(writer, source, node, entering)
+		}
+
+		if entering {
+			writer.Write(literal)
+		}
+
+		return ast.WalkContinue, nil
+	}
+}
@@ -1,11 +1,20 @@
 package mark
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/includes"
+	"github.com/kovetskiy/mark/pkg/mark/macro"
 	"github.com/kovetskiy/mark/pkg/mark/stdlib"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,46 +27,656 @@ func text(lines ...string) string {
 	return strings.Join(lines, "\n")
 }
 
-func TestCompileMarkdown(t *testing.T) {
-	test := assert.New(t)
+// goldmarkDifferences lists fixtures which goldmark engine compiles
+// differently, its output is kept in testdata/goldmark.
+var goldmarkDifferences = map[string]string{
+	"links": "footnotes are rendered with back links and ARIA roles",
+}
+
+// reBlocksBreak matches line breaks between blocks, which blackfriday and
+// goldmark place differently.
+var reBlocksBreak = regexp.MustCompile(`>\s*\n\s*<|\n{2,}`)
 
+func TestCompileMarkdown_Goldmark(t *testing.T) {
 	testcases, err := filepath.Glob("testdata/*.md")
 	if err != nil {
 		panic(err)
 	}
 
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		panic(err)
+	}
+
 	for _, filename := range testcases {
-		basename := filepath.Base(filename)
-		testname := strings.TrimSuffix(basename, ".md")
-		htmlname := filepath.Join(filepath.Dir(filename), testname+".html")
+		testname := strings.TrimSuffix(filepath.Base(filename), ".md")
+
+		htmlname := filepath.Join("testdata", testname+".html")
+		if _, ok := goldmarkDifferences[testname]; ok {
+			htmlname = filepath.Join("testdata", "goldmark", testname+".html")
+		}
 
 		markdown, err := ioutil.ReadFile(filename)
 		if err != nil {
 			panic(err)
 		}
+
 		html, err := ioutil.ReadFile(htmlname)
 		if err != nil {
 			panic(err)
 		}
 
-		lib, err := stdlib.New(nil)
-		if err != nil {
-			panic(err)
+		actual, err := CompileMarkdownWithOptions(
+			markdown,
+			lib,
+			CompileOptions{Engine: EngineGoldmark},
+		)
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			normalizeBlocksBreaks(string(html)),
+			normalizeBlocksBreaks(actual),
+			filename+" vs "+htmlname,
+		)
+	}
+
+	// every difference must be intentional
+	differences, err := filepath.Glob("testdata/goldmark/*.html")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, filename := range differences {
+		testname := strings.TrimSuffix(filepath.Base(filename), ".html")
+		assert.Contains(t, goldmarkDifferences, testname, filename)
+	}
+}
+
+func normalizeBlocksBreaks(html string) string {
+	return reBlocksBreak.ReplaceAllStringFunc(
+		strings.TrimSpace(html),
+		func(match string) string {
+			if strings.HasPrefix(match, ">") {
+				return "><"
+			}
+
+			return "\n"
+		},
+	)
+}
+
+func TestCompileMarkdown_GoldmarkHandlers(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CompileMarkdownWithOptions(
+		[]byte("text"),
+		lib,
+		CompileOptions{
+			Engine:   EngineGoldmark,
+			Handlers: func(*ConfluenceRenderer) {},
+		},
+	)
+	assert.Error(t, err)
+	assert.Contains(
+		t,
+		err.Error(),
+		"custom node handlers are not supported by goldmark engine",
+	)
+}
+
+func TestCompileMarkdown_TemplateError(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lib.Templates.New("ac:code").Parse(`{{ .Missing }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CompileMarkdown([]byte("```go\ncode\n```"), lib)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to render code block")
+
+	assert.Panics(t, func() {
+		MustCompileMarkdown([]byte("```go\ncode\n```"), lib)
+	})
+}
+
+func TestCompileMarkdownTo(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown, err := ioutil.ReadFile("testdata/inline-comments-blocks.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := CompileMarkdown(markdown, lib)
+	assert.NoError(t, err)
+
+	var html strings.Builder
+
+	err = CompileMarkdownTo(&html, markdown, lib, CompileOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, html.String())
+
+	err = CompileMarkdownTo(failingWriter{}, markdown, lib, CompileOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "write failed")
+}
+
+func TestColonWriter(t *testing.T) {
+	var (
+		html   strings.Builder
+		writer = &colonWriter{writer: &html}
+		data   = "<ac" + colonPlaceholder + "link>-" + colonPlaceholder[:5] + "-"
+	)
+
+	// placeholder split between writes is replaced as well
+	for i := range data {
+		_, err := writer.Write([]byte{data[i]})
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, writer.Flush())
+	assert.Equal(t, "<ac:link>-"+colonPlaceholder[:5]+"-", html.String())
+}
+
+func TestPrepareMarkdown(t *testing.T) {
+	markdown := []byte("plain\ntext")
+	assert.Equal(t, &markdown[0], &prepareMarkdown(markdown)[0])
+
+	assert.Equal(
+		t,
+		"a\n<ac"+colonPlaceholder+"rich-text-body>\r</ac"+colonPlaceholder+"b>\n",
+		string(prepareMarkdown([]byte("a\r\n<ac:rich-text-body>\r</ac:b>\r\n"))),
+	)
+}
+
+func TestCompileMarkdownWithOptions(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name     string
+		markdown string
+		opts     CompileOptions
+		contains string
+	}{
+		{
+			name:     "default smartypants",
+			markdown: `"quoted" -- text`,
+			contains: `&ldquo;quoted&rdquo; &ndash; text`,
+		},
+		{
+			name:     "html flags",
+			markdown: `"quoted" -- text`,
+			opts:     CompileOptions{HTMLFlags: bf.UseXHTML},
+			contains: `&quot;quoted&quot; -- text`,
+		},
+		{
+			name:     "extensions",
+			markdown: "a ~~b~~",
+			opts:     CompileOptions{Extensions: bf.CommonExtensions &^ bf.Strikethrough},
+			contains: `<p>a ~~b~~</p>`,
+		},
+		{
+			name:     "hard wraps",
+			markdown: text("first", "second"),
+			opts:     CompileOptions{HardWraps: true},
+			contains: `first<br />` + NL + `second`,
+		},
+		{
+			name:     "heading ids",
+			markdown: "# Title",
+			opts:     CompileOptions{HeadingIDPrefix: "doc-", HeadingIDSuffix: "-h"},
+			contains: `<h1 id="doc-title-h">Title</h1>`,
+		},
+		{
+			name:     "absolute prefix",
+			markdown: "[docs](/guide/index.html)",
+			opts:     CompileOptions{AbsolutePrefix: "https://example.com/base"},
+			contains: `<a href="https://example.com/base/guide/index.html">docs</a>`,
+		},
+		{
+			name:     "collapse code",
+			markdown: text("```go", "code", "```"),
+			opts:     CompileOptions{CollapseCode: true},
+			contains: `<ac:parameter ac:name="collapse">true</ac:parameter>`,
+		},
+		{
+			name:     "link resolver",
+			markdown: "[a](a.md) [b](b.md) ![c](c.png)",
+			opts: CompileOptions{
+				LinkResolver: func(destination string) (string, bool) {
+					if destination == "b.md" {
+						return "", false
+					}
+
+					return "/resolved/" + destination, true
+				},
+			},
+			contains: `<a href="/resolved/a.md">a</a> <a href="b.md">b</a> <img src="/resolved/c.png" alt="c" />`,
+		},
+	}
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		for _, testcase := range testcases {
+			opts := testcase.opts
+			opts.Engine = engine
+
+			t.Run(string(engine)+"/"+testcase.name, func(t *testing.T) {
+				html, err := CompileMarkdownWithOptions(
+					[]byte(testcase.markdown),
+					lib,
+					opts,
+				)
+				assert.NoError(t, err)
+				assert.Contains(t, html, testcase.contains)
+			})
+		}
+	}
+}
+
+func TestCompileMarkdownWithOptions_Handlers(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := []string{}
+
+	html, err := CompileMarkdownWithOptions(
+		[]byte("![logo](logo.png) ![other](other.png)"),
+		lib,
+		CompileOptions{
+			Handlers: func(renderer *ConfluenceRenderer) {
+				renderer.Handle(bf.Image, func(
+					writer io.Writer,
+					node *bf.Node,
+					entering bool,
+				) (bf.WalkStatus, bool) {
+					calls = append(calls, "first")
+
+					if entering {
+						fmt.Fprintf(
+							writer,
+							`<ac:image><ri:attachment ri:filename="%s"/></ac:image>`,
+							node.LinkData.Destination,
+						)
+					}
+
+					return bf.SkipChildren, true
+				})
+
+				renderer.Handle(bf.Image, func(
+					writer io.Writer,
+					node *bf.Node,
+					entering bool,
+				) (bf.WalkStatus, bool) {
+					calls = append(calls, "second")
+
+					if string(node.LinkData.Destination) != "other.png" {
+						return bf.GoToNext, false
+					}
+
+					io.WriteString(writer, "<skipped/>")
+
+					return bf.SkipChildren, true
+				})
+			},
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		`<p><ac:image><ri:attachment ri:filename="logo.png"/></ac:image> <skipped/></p>`+NL,
+		html,
+	)
+
+	// last registered handler goes first, the first one is called only for
+	// the image which the last one skips
+	assert.Equal(t, []string{"second", "first", "second"}, calls)
+}
+
+type testLogger struct {
+	messages []string
+}
+
+func (logger *testLogger) log(level, format string, args ...interface{}) {
+	logger.messages = append(
+		logger.messages,
+		level+": "+fmt.Sprintf(format, args...),
+	)
+}
+
+func (logger *testLogger) Tracef(format string, args ...interface{}) {
+	logger.log("trace", format, args...)
+}
+
+func (logger *testLogger) Debugf(format string, args ...interface{}) {
+	logger.log("debug", format, args...)
+}
+
+func (logger *testLogger) Infof(format string, args ...interface{}) {
+	logger.log("info", format, args...)
+}
+
+func (logger *testLogger) Warningf(format string, args ...interface{}) {
+	logger.log("warning", format, args...)
+}
+
+func (logger *testLogger) Errorf(format string, args ...interface{}) {
+	logger.log("error", format, args...)
+}
+
+func TestCompileMarkdownWithOptions_Logger(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(`a <!--comment_id='a b'-->commented<!----> text`)
+
+	logger := &testLogger{}
+
+	_, err = CompileMarkdownWithOptions(
+		markdown,
+		lib,
+		CompileOptions{Logger: logger},
+	)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			`warning: line 1: invalid inline comment id "a b", dropping comment marker`,
+		},
+		logger.messages,
+	)
+
+	logger = &testLogger{}
+
+	_, err = CompileMarkdownWithOptions(
+		markdown,
+		lib,
+		CompileOptions{Logger: logger, Debug: true},
+	)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"trace: rendering markdown:\n" + string(markdown),
+			`warning: line 1: invalid inline comment id "a b", dropping comment marker`,
+			"trace: rendered markdown to html:\n<p>a commented text</p>\n",
+		},
+		logger.messages,
+	)
+}
+
+func BenchmarkCompileMarkdown(b *testing.B) {
+	small, err := ioutil.ReadFile("testdata/codes.md")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var large bytes.Buffer
+	for large.Len() < 2<<20 {
+		for _, name := range []string{"links", "lists", "table", "tags"} {
+			source, err := ioutil.ReadFile("testdata/" + name + ".md")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			large.Write(source)
+			large.WriteString("\r\n<ac:rich-text-body>text</ac:rich-text-body>\r\n\r\n")
 		}
-		actual := CompileMarkdown(markdown, lib)
-		test.EqualValues(string(html), actual, filename+" vs "+htmlname)
+	}
+
+	var codes bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&codes, "Block %d:\n\n```go\nfmt.Println(%d)\n```\n\n", i, i)
+	}
+
+	// every comment is followed by 2KB of text, so replacing markers by
+	// copying the whole document for each of them shows up here
+	var comments bytes.Buffer
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(
+			&comments,
+			"Paragraph with <!--comment_id='%08d-0000-4000-8000-000000000000'-->"+
+				"commented **text**<!----> in it.\n\n",
+			i,
+		)
+
+		for comments.Len() < (i+1)*2<<10 {
+			comments.WriteString("Another paragraph of plain text without comments.\n\n")
+		}
+	}
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, benchmark := range []struct {
+		name     string
+		markdown []byte
+	}{
+		{"small", small},
+		{"2MB", large.Bytes()},
+		{"1000 code blocks", codes.Bytes()},
+		{"500 inline comments 1MB", comments.Bytes()},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(benchmark.markdown)))
+
+			for i := 0; i < b.N; i++ {
+				err := CompileMarkdownTo(
+					ioutil.Discard,
+					benchmark.markdown,
+					lib,
+					CompileOptions{},
+				)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
 	}
 }
 
-func TestExtractDocumentLeadingH1(t *testing.T) {
-	filename := "testdata/header.md"
+func TestParseDocument(t *testing.T) {
+	document := ParseDocument(
+		[]byte("\xef\xbb\xbf# Title\r\n\r\n"+
+			"```go title Example\r\ncode\r\n```\r\n\r\n"+
+			"<ac:rich-text-body>\r\n"),
+		CompileOptions{TitleFromH1: TitleFromH1Drop},
+	)
+
+	nodes := []string{}
+	Walk(document, func(node *bf.Node) bf.WalkStatus {
+		switch node.Type {
+		case bf.Heading:
+			nodes = append(nodes, "heading")
+
+		case bf.CodeBlock:
+			nodes = append(
+				nodes,
+				"code "+ParseLanguage(string(node.Info))+": "+string(node.Literal),
+			)
+
+		case bf.HTMLBlock, bf.HTMLSpan:
+			nodes = append(nodes, "html: "+string(node.Literal))
+		}
+
+		return bf.GoToNext
+	})
 
-	markdown, err := ioutil.ReadFile(filename)
+	assert.Equal(
+		t,
+		[]string{"code go: code\n", "html: <ac:rich-text-body>"},
+		nodes,
+	)
+}
+
+func TestCompileMarkdownWithOptions_Context(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text("[first](a)", "", "second", "", "[third](b)"))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := CompileMarkdownWithOptions(markdown, lib, CompileOptions{
+			Engine:  engine,
+			Context: ctx,
+		})
+		assert.True(t, errors.Is(err, context.Canceled), engine)
+
+		ctx, cancel = context.WithCancel(context.Background())
+
+		var (
+			html     strings.Builder
+			resolved = []string{}
+		)
+
+		err = CompileMarkdownTo(&html, markdown, lib, CompileOptions{
+			Engine:  engine,
+			Context: ctx,
+			LinkResolver: func(destination string) (string, bool) {
+				resolved = append(resolved, destination)
+				cancel()
+
+				return destination, false
+			},
+		})
+		assert.Equal(t, context.Canceled, err, engine)
+		assert.NotContains(t, html.String(), "third", engine)
+
+		if engine == EngineBlackfriday {
+			assert.Equal(t, []string{"a"}, resolved)
+		}
+	}
+}
+
+func TestCompileMarkdown_Deterministic(t *testing.T) {
+	testcases, err := filepath.Glob("testdata/*.md")
 	if err != nil {
 		panic(err)
 	}
 
-	actual := ExtractDocumentLeadingH1(markdown)
+	// parameters of includes and macros are kept in maps
+	params := text(
+		`<!-- Macro: :status:`,
+		`     Template: ac:status`,
+		`     Title: DONE`,
+		`     Color: Green`,
+		`     Subtle: true -->`,
+		``,
+		`<!-- Include: params`,
+		`     a: 1`,
+		`     b: 2`,
+		`     c: 3`,
+		`     d: 4 -->`,
+		``,
+		`:status:`,
+		``,
+	)
+
+	compile := func(engine MarkdownEngine) map[string]string {
+		lib, err := stdlib.New(nil)
+		if err != nil {
+			panic(err)
+		}
+
+		lib.MustAddTemplate(
+			"params",
+			`{{ range $name, $value := . }}`+
+				`<ac:parameter ac:name="{{ $name }}">{{ $value }}</ac:parameter>`+
+				`{{ end }}`,
+		)
+
+		templates, markdown, _, err := includes.ProcessIncludes(
+			"",
+			[]byte(params),
+			lib.Templates,
+			nil,
+		)
+		if err != nil {
+			panic(err)
+		}
+
+		macros, markdown, err := macro.ExtractMacros("", markdown, templates, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, macro := range macros {
+			markdown, err = macro.Apply(markdown, nil)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		outputs := map[string]string{}
+
+		outputs["params"], err = CompileMarkdownWithOptions(
+			markdown,
+			lib,
+			CompileOptions{Engine: engine},
+		)
+		assert.NoError(t, err)
+
+		for _, filename := range testcases {
+			markdown, err := ioutil.ReadFile(filename)
+			if err != nil {
+				panic(err)
+			}
+
+			outputs[filename], err = CompileMarkdownWithOptions(
+				markdown,
+				lib,
+				CompileOptions{Engine: engine},
+			)
+			assert.NoError(t, err, filename)
+		}
+
+		return outputs
+	}
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		expected := compile(engine)
+
+		assert.Contains(
+			t,
+			expected["params"],
+			`<ac:parameter ac:name="a">1</ac:parameter>`+
+				`<ac:parameter ac:name="b">2</ac:parameter>`+
+				`<ac:parameter ac:name="c">3</ac:parameter>`+
+				`<ac:parameter ac:name="d">4</ac:parameter>`,
+			engine,
+		)
+
+		for run := 0; run < 5; run++ {
+			actual := compile(engine)
 
-	assert.Equal(t, "a", actual)
+			for name, html := range expected {
+				assert.Equal(t, html, actual[name], fmt.Sprintf("%s: %s", engine, name))
+			}
+		}
+	}
 }
@@ -0,0 +1,126 @@
+// Package marktest provides helpers for testing markdown compiled by mark
+// and extensions built on top of it.
+package marktest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// UpdateEnv is the environment variable, which makes RunGolden rewrite
+// golden files if it's set to true, e.g. MARK_UPDATE_GOLDEN=1.
+const UpdateEnv = "MARK_UPDATE_GOLDEN"
+
+// Update makes RunGolden rewrite golden files with compiled output instead
+// of comparing them, e.g. if it's set by the flag of the test binary:
+//
+//	var update = flag.Bool("update", false, "rewrite golden files")
+//
+//	func TestGolden(t *testing.T) {
+//		marktest.Update = *update
+//		marktest.RunGolden(t, "testdata", nil, mark.CompileOptions{})
+//	}
+var Update bool
+
+// RunGolden compiles every *.md fixture in dir with given options and
+// compares the output with the golden *.html file of the same name, each
+// fixture is run as a subtest named after the file. Differences are reported
+// as unified diffs. If lib is nil, templates of stdlib.New(nil) are used.
+//
+// Golden files are written, including missing ones, if Update is set or
+// tests are run with UpdateEnv set:
+//
+//	MARK_UPDATE_GOLDEN=1 go test ./... -run TestGolden
+func RunGolden(
+	t *testing.T,
+	dir string,
+	lib *stdlib.Lib,
+	opts mark.CompileOptions,
+) {
+	t.Helper()
+
+	fixtures, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fixtures) == 0 {
+		t.Fatalf("no *.md fixtures found in %s", dir)
+	}
+
+	if lib == nil {
+		lib, err = stdlib.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, fixture := range fixtures {
+		fixture := fixture
+		name := strings.TrimSuffix(filepath.Base(fixture), ".md")
+
+		t.Run(name, func(t *testing.T) {
+			runGolden(t, fixture, lib, opts)
+		})
+	}
+}
+
+func runGolden(
+	t *testing.T,
+	fixture string,
+	lib *stdlib.Lib,
+	opts mark.CompileOptions,
+) {
+	golden := strings.TrimSuffix(fixture, ".md") + ".html"
+
+	markdown, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := mark.CompileMarkdownWithOptions(markdown, lib, opts)
+	if err != nil {
+		t.Fatalf("unable to compile %s: %s", fixture, err)
+	}
+
+	if isUpdate() {
+		err = ioutil.WriteFile(golden, []byte(actual), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("golden file %s is not found, run tests with %s=1", golden, UpdateEnv)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, string(expected), actual, fixture+" vs "+golden)
+}
+
+// isUpdate returns true if golden files are rewritten, see Update and
+// UpdateEnv.
+func isUpdate() bool {
+	if Update {
+		return true
+	}
+
+	update, err := strconv.ParseBool(os.Getenv(UpdateEnv))
+
+	return err == nil && update
+}
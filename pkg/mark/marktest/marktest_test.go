@@ -0,0 +1,56 @@
+package marktest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunGolden_Update(t *testing.T) {
+	dir := t.TempDir()
+
+	err := ioutil.WriteFile(filepath.Join(dir, "page.md"), []byte("# Page\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(update bool) {
+		Update = update
+	}(Update)
+
+	Update = true
+
+	RunGolden(t, dir, nil, mark.CompileOptions{})
+
+	golden, err := ioutil.ReadFile(filepath.Join(dir, "page.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "<h1 id=\"page\">Page</h1>\n", string(golden))
+
+	Update = false
+
+	RunGolden(t, dir, nil, mark.CompileOptions{})
+}
+
+func TestRunGolden_UpdateEnv(t *testing.T) {
+	dir := t.TempDir()
+
+	err := ioutil.WriteFile(filepath.Join(dir, "page.md"), []byte("# Page\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(UpdateEnv, "1")
+
+	RunGolden(t, dir, nil, mark.CompileOptions{})
+
+	golden, err := ioutil.ReadFile(filepath.Join(dir, "page.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "<h1 id=\"page\">Page</h1>\n", string(golden))
+
+	t.Setenv(UpdateEnv, "false")
+
+	assert.False(t, isUpdate())
+}
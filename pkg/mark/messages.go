@@ -0,0 +1,134 @@
+package mark
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/reconquest/karma-go"
+)
+
+// DefaultLocale is the locale of messages, which are used unless
+// CompileOptions.Locale is set, and which messages missing in other
+// catalogs fall back to.
+const DefaultLocale = "en"
+
+// Message is the key of the text, which the compiler writes into pages
+// itself, e.g. the text of banners. Texts are Go templates, see Catalog.
+type Message string
+
+const (
+	// MessageGeneratedBanner is the text of the banner written by
+	// CompileOptions.GeneratedBanner, it's storage format. Source, RepoURL
+	// and Commit fields are given to it, they're escaped.
+	MessageGeneratedBanner Message = "generated-banner"
+
+	// MessageDraftBanner is the text of the banner written by
+	// CompileOptions.DraftBanner, it's storage format. The escaped Source
+	// field is given to it, which is empty if CompileOptions.File is not
+	// set.
+	MessageDraftBanner Message = "draft-banner"
+
+	// MessageExpand is the title of expand macros without titles in
+	// previews, see RenderPreview.
+	MessageExpand Message = "expand"
+
+	// MessageLinkPage, MessageLinkSpace, MessageLinkAttachment and
+	// MessageLinkUser are tooltips of links in previews, the Target field
+	// is given to them, e.g. the title of the page.
+	MessageLinkPage       Message = "link-page"
+	MessageLinkSpace      Message = "link-space"
+	MessageLinkAttachment Message = "link-attachment"
+	MessageLinkUser       Message = "link-user"
+)
+
+// Catalog maps messages to their texts in some language. Texts are Go
+// templates, which get fields listed by messages.
+type Catalog map[Message]string
+
+// Catalogs are catalogs of messages by locales, which are selected by
+// CompileOptions.Locale. Catalogs of more languages can be added, messages
+// missing in them are written in DefaultLocale.
+var Catalogs = map[string]Catalog{
+	"en": {
+		MessageGeneratedBanner: DefaultGeneratedBannerText,
+		MessageDraftBanner: `This page is a draft` +
+			`{{ with .Source }} generated from <code>{{ . }}</code>{{ end }}.`,
+		MessageExpand:         `Click here to expand...`,
+		MessageLinkPage:       `Page: {{ .Target }}`,
+		MessageLinkSpace:      `Space: {{ .Target }}`,
+		MessageLinkAttachment: `Attachment: {{ .Target }}`,
+		MessageLinkUser:       `User: {{ .Target }}`,
+	},
+	"de": {
+		MessageGeneratedBanner: `Diese Seite wird aus ` +
+			`<code>{{ .Source }}</code>` +
+			`{{ with .RepoURL }} von <a href="{{ . }}">{{ . }}</a>{{ end }}` +
+			`{{ with .Commit }} bei <code>{{ . }}</code>{{ end }} generiert, ` +
+			`bearbeiten Sie stattdessen die Quelle, Änderungen der Seite ` +
+			`werden überschrieben.`,
+		MessageDraftBanner: `Diese Seite ist ein Entwurf` +
+			`{{ with .Source }}, generiert aus <code>{{ . }}</code>{{ end }}.`,
+		MessageExpand:         `Zum Erweitern hier klicken...`,
+		MessageLinkPage:       `Seite: {{ .Target }}`,
+		MessageLinkSpace:      `Bereich: {{ .Target }}`,
+		MessageLinkAttachment: `Anhang: {{ .Target }}`,
+		MessageLinkUser:       `Benutzer: {{ .Target }}`,
+	},
+	"ja": {
+		MessageGeneratedBanner: `このページは` +
+			`{{ with .RepoURL }} <a href="{{ . }}">{{ . }}</a> の{{ end }}` +
+			` <code>{{ .Source }}</code> ` +
+			`{{ with .Commit }}(<code>{{ . }}</code>) {{ end }}から生成されています。` +
+			`ページへの変更は上書きされるため、ソースを編集してください。`,
+		MessageDraftBanner: `このページは` +
+			`{{ with .Source }} <code>{{ . }}</code> から生成された{{ end }}下書きです。`,
+		MessageExpand:         `クリックして展開...`,
+		MessageLinkPage:       `ページ: {{ .Target }}`,
+		MessageLinkSpace:      `スペース: {{ .Target }}`,
+		MessageLinkAttachment: `添付ファイル: {{ .Target }}`,
+		MessageLinkUser:       `ユーザー: {{ .Target }}`,
+	},
+}
+
+// getMessage returns the text of the message: CompileOptions.Messages go
+// first, then the catalog of CompileOptions.Locale or of its language, e.g.
+// de for de-AT, and the catalog of DefaultLocale at last.
+func (opts CompileOptions) getMessage(message Message) string {
+	if text, ok := opts.Messages[message]; ok {
+		return text
+	}
+
+	locale := strings.ToLower(strings.ReplaceAll(opts.Locale, "_", "-"))
+
+	for _, name := range []string{locale, strings.SplitN(locale, "-", 2)[0]} {
+		if text, ok := Catalogs[name][message]; ok {
+			return text
+		}
+	}
+
+	return Catalogs[DefaultLocale][message]
+}
+
+// formatMessage executes the template of the message with given fields.
+func (opts CompileOptions) formatMessage(
+	message Message,
+	fields map[string]string,
+) (string, error) {
+	return formatMessage(string(message), opts.getMessage(message), fields)
+}
+
+func formatMessage(name string, text string, fields map[string]string) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", karma.Format(err, "unable to parse message: %s", name)
+	}
+
+	var result strings.Builder
+
+	err = tmpl.Execute(&result, fields)
+	if err != nil {
+		return "", karma.Format(err, "unable to execute message: %s", name)
+	}
+
+	return result.String(), nil
+}
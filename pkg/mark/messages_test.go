@@ -0,0 +1,86 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_Locale(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`# Guide`,
+		``,
+		`Read [the plan](confluence:///OPS/Plan) first.`,
+		``,
+	))
+
+	compile := func(locale string, meta *Meta, messages Catalog) *CompileResult {
+		result, err := Compile(markdown, lib, CompileOptions{
+			File:            "docs/guide.md",
+			Meta:            meta,
+			Locale:          locale,
+			Messages:        messages,
+			DraftBanner:     true,
+			GeneratedBanner: &GeneratedBanner{Commit: "abc123"},
+			Preview:         true,
+		})
+		if !assert.NoError(t, err, locale) {
+			t.FailNow()
+		}
+
+		return result
+	}
+
+	var (
+		en = compile("en", nil, nil)
+		de = compile("de-AT", nil, nil)
+	)
+
+	// only generated texts differ
+	localize := strings.NewReplacer(
+		`This page is generated from <code>docs/guide.md</code> at <code>abc123</code>, `+
+			`edit the source instead, changes of the page are overwritten.`,
+		`Diese Seite wird aus <code>docs/guide.md</code> bei <code>abc123</code> generiert, `+
+			`bearbeiten Sie stattdessen die Quelle, Änderungen der Seite werden überschrieben.`,
+		`title="Page: OPS/Plan"`,
+		`title="Seite: OPS/Plan"`,
+	)
+
+	assert.NotEqual(t, en.HTML, de.HTML)
+	assert.Equal(t, localize.Replace(en.HTML), de.HTML)
+	assert.Equal(t, localize.Replace(en.Preview), de.Preview)
+
+	draft := &Meta{Status: StatusDraft}
+
+	assert.Contains(
+		t,
+		compile("ja_JP", draft, nil).HTML,
+		`<p>このページは <code>docs/guide.md</code> から生成された下書きです。</p>`,
+	)
+
+	// unknown locales and messages missing in catalogs are in English
+	assert.Equal(t, en.HTML, compile("fr", nil, nil).HTML)
+
+	defer delete(Catalogs, "xx")
+
+	Catalogs["xx"] = Catalog{MessageLinkPage: `Xx: {{ .Target }}`}
+
+	xx := compile("xx", nil, Catalog{MessageDraftBanner: `Draft!`})
+	assert.Equal(t, en.HTML, xx.HTML)
+	assert.Contains(t, xx.Preview, `title="Xx: OPS/Plan"`)
+	assert.Contains(t, compile("xx", draft, Catalog{MessageDraftBanner: `Draft!`}).HTML, `<p>Draft!</p>`)
+
+	_, err = Compile(markdown, lib, CompileOptions{
+		DraftBanner: true,
+		Meta:        draft,
+		Messages:    Catalog{MessageDraftBanner: `{{ .Unknown }}`},
+	})
+	assert.Error(t, err)
+}
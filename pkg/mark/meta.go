@@ -1,25 +1,34 @@
 package mark
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"regexp"
 	"strings"
 
-	"github.com/reconquest/pkg/log"
+	"github.com/reconquest/karma-go"
 )
 
 const (
-	HeaderParent     = `Parent`
-	HeaderSpace      = `Space`
-	HeaderType       = `Type`
-	HeaderTitle      = `Title`
-	HeaderLayout     = `Layout`
-	HeaderAttachment = `Attachment`
-	HeaderLabel      = `Label`
-	HeaderInclude    = `Include`
-	HeaderSidebar    = `Sidebar`
+	HeaderParent          = `Parent`
+	HeaderSpace           = `Space`
+	HeaderType            = `Type`
+	HeaderTitle           = `Title`
+	HeaderLayout          = `Layout`
+	HeaderAttachment      = `Attachment`
+	HeaderLabel           = `Label`
+	HeaderLabelsRemove    = `Labels-Remove`
+	HeaderInclude         = `Include`
+	HeaderSidebar         = `Sidebar`
+	HeaderTitleH1         = `Title-From-H1`
+	HeaderVar             = `Var`
+	HeaderVersionMessage  = `Version-Message`
+	HeaderMinorEdit       = `Minor-Edit`
+	HeaderAppearance      = `Appearance`
+	HeaderEmoji           = `Emoji`
+	HeaderStatus          = `Status`
+	HeaderGeneratedBanner = `Generated-Banner`
+	HeaderBoilerplate     = `Boilerplate`
+	HeaderOptions         = `Options`
 )
 
 type Meta struct {
@@ -30,7 +39,75 @@ type Meta struct {
 	Layout      string
 	Sidebar     string
 	Attachments []string
-	Labels      []string
+
+	// Labels are normalized and merged by MergeLabels.
+	Labels []string
+
+	// RemovedLabels are labels of Labels-Remove headers, which are removed
+	// from labels inherited from defaults, see MergeMeta, and from
+	// CompileOptions.Labels.
+	RemovedLabels []string
+
+	// TitleFromH1 overrides H1 handling policy for the document.
+	TitleFromH1 TitleFromH1
+
+	// Vars are variables defined by <!-- Var: name=value --> headers.
+	Vars map[string]string
+
+	// VersionMessage is the comment of the page version, which can contain
+	// placeholders, see CompileOptions.VersionData.
+	VersionMessage string
+
+	// MinorEdit defines whether the page is updated as minor edit, so
+	// watchers are not notified, see CompileOptions.MinorEdit.
+	MinorEdit bool
+
+	// Appearance is the content width of the page, which is set by the
+	// publisher using content properties, see Appearance.GetProperties.
+	// The width of the page is kept as is if it's not set.
+	Appearance Appearance
+
+	// Emoji is shown next to the title of the page, EmojiID is its code
+	// points as Confluence stores them, e.g. 1f680 for 🚀.
+	Emoji   string
+	EmojiID string
+
+	// NoGeneratedBanner suppresses the banner written by
+	// CompileOptions.GeneratedBanner, it's set by Generated-Banner: false
+	// header.
+	NoGeneratedBanner bool
+
+	// NoBoilerplate suppresses CompileOptions.HeaderTemplate and
+	// CompileOptions.FooterTemplate, it's set by Boilerplate: false header.
+	NoBoilerplate bool
+
+	// Status is the publishing status of the document, StatusPublished by
+	// default.
+	Status Status
+
+	// Options override options of compiling for the document, they're set
+	// by Options headers.
+	Options DocumentOptions
+
+	// headers are names of valid headers which are given, so defaults are
+	// merged only into headers which are not, see MergeMeta
+	headers map[string]bool
+
+	// unknown are headers which are ignored, compiling fails on them in
+	// strict mode
+	unknown []metaHeader
+
+	// invalid are headers which values are ignored, since they're not
+	// valid, compiling fails on them in strict mode
+	invalid []metaHeader
+}
+
+type metaHeader struct {
+	name string
+	line int
+
+	// err is the problem of the value of invalid header
+	err error
 }
 
 var (
@@ -42,17 +119,18 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 	var (
 		meta   *Meta
 		offset int
+		number int
 	)
 
-	scanner := bufio.NewScanner(bytes.NewBuffer(data))
-	for scanner.Scan() {
-		line := scanner.Text()
+	for offset < len(data) {
+		var raw []byte
 
-		if err := scanner.Err(); err != nil {
-			return nil, nil, err
-		}
+		number++
 
-		offset += len(line) + 1
+		// readLine handles both LF and CRLF line endings
+		raw, offset = readLine(data, offset)
+
+		line := string(raw)
 
 		matches := reHeaderPatternV2.FindStringSubmatch(line)
 		if matches == nil {
@@ -61,9 +139,10 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 				break
 			}
 
-			log.Warningf(
-				fmt.Errorf(`legacy header usage found: %s`, line),
-				"please use new header format: <!-- %s: %s -->",
+			DefaultLogger.Warningf(
+				"legacy header usage found: %s, "+
+					"please use new header format: <!-- %s: %s -->",
+				line,
 				matches[1],
 				matches[2],
 			)
@@ -72,13 +151,14 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 		if meta == nil {
 			meta = &Meta{}
 			meta.Type = "page" //Default if not specified
+			meta.Status = StatusPublished
 		}
 
 		header := strings.Title(matches[1])
 
 		var value string
 		if len(matches) > 1 {
-			value = strings.TrimSpace(matches[2])
+			value = unescapeMetaValue(strings.TrimSpace(matches[2]))
 		}
 
 		switch header {
@@ -88,6 +168,17 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 		case HeaderSpace:
 			meta.Space = strings.TrimSpace(value)
 
+			err := ValidateSpaceKey(meta.Space)
+			if err != nil {
+				return nil, nil, karma.Format(
+					err,
+					"invalid %s header on line %d: %#v",
+					HeaderSpace,
+					number,
+					line,
+				)
+			}
+
 		case HeaderType:
 			meta.Type = strings.TrimSpace(value)
 
@@ -107,25 +198,217 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 		case HeaderLabel:
 			meta.Labels = append(meta.Labels, value)
 
+		case HeaderLabelsRemove:
+			meta.RemovedLabels = append(meta.RemovedLabels, value)
+
+		case HeaderTitleH1:
+			mode, err := ParseTitleFromH1(value)
+			if err != nil {
+				return nil, nil, karma.Format(
+					err,
+					"invalid %s header: %#v",
+					HeaderTitleH1,
+					line,
+				)
+			}
+
+			meta.TitleFromH1 = mode
+
+		case HeaderVar:
+			name, value, ok := strings.Cut(value, "=")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, nil, fmt.Errorf(
+					"invalid %s header, name=value expected: %#v",
+					HeaderVar,
+					line,
+				)
+			}
+
+			if meta.Vars == nil {
+				meta.Vars = map[string]string{}
+			}
+
+			meta.Vars[strings.TrimSpace(name)] = strings.TrimSpace(value)
+
+		case HeaderVersionMessage:
+			meta.VersionMessage = strings.TrimSpace(value)
+
+		case HeaderMinorEdit:
+			minor, err := parseMetaBool(value)
+			if err != nil {
+				return nil, nil, karma.Format(
+					err,
+					"invalid %s header on line %d: %#v",
+					HeaderMinorEdit,
+					number,
+					line,
+				)
+			}
+
+			meta.MinorEdit = minor
+
+		case HeaderGeneratedBanner:
+			generated, err := parseMetaBool(value)
+			if err != nil {
+				return nil, nil, karma.Format(
+					err,
+					"invalid %s header on line %d: %#v",
+					HeaderGeneratedBanner,
+					number,
+					line,
+				)
+			}
+
+			meta.NoGeneratedBanner = !generated
+
+		case HeaderBoilerplate:
+			boilerplate, err := parseMetaBool(value)
+			if err != nil {
+				return nil, nil, karma.Format(
+					err,
+					"invalid %s header on line %d: %#v",
+					HeaderBoilerplate,
+					number,
+					line,
+				)
+			}
+
+			meta.NoBoilerplate = !boilerplate
+
+		case HeaderAppearance:
+			appearance, err := ParseAppearance(value)
+			if err != nil {
+				return nil, nil, karma.Format(
+					err,
+					"invalid %s header on line %d: %#v",
+					HeaderAppearance,
+					number,
+					line,
+				)
+			}
+
+			meta.Appearance = appearance
+
+		case HeaderEmoji:
+			emoji, id, err := ParseEmoji(value)
+			if err != nil {
+				return nil, nil, karma.Format(
+					err,
+					"invalid %s header on line %d: %#v",
+					HeaderEmoji,
+					number,
+					line,
+				)
+			}
+
+			meta.Emoji, meta.EmojiID = emoji, id
+
+		case HeaderStatus:
+			status, err := ParseStatus(value)
+			if err != nil {
+				DefaultLogger.Errorf(
+					`invalid %s header on line %d: %s`,
+					HeaderStatus,
+					number,
+					err,
+				)
+
+				meta.invalid = append(meta.invalid, metaHeader{
+					name: header,
+					line: number,
+					err:  err,
+				})
+
+				continue
+			}
+
+			meta.Status = status
+
+		case HeaderOptions:
+			errs := meta.Options.parse(value)
+			for _, err := range errs {
+				DefaultLogger.Errorf(
+					`invalid %s header on line %d: %s`,
+					HeaderOptions,
+					number,
+					err,
+				)
+
+				meta.invalid = append(meta.invalid, metaHeader{
+					name: header,
+					line: number,
+					err:  err,
+				})
+			}
+
 		case HeaderInclude:
 			// Includes are parsed by a different func
 			continue
 
 		default:
-			log.Errorf(
-				nil,
+			DefaultLogger.Errorf(
 				`encountered unknown header %q line: %#v`,
 				header,
 				line,
 			)
 
+			meta.unknown = append(meta.unknown, metaHeader{
+				name: header,
+				line: number,
+			})
+
 			continue
 		}
+
+		if meta.headers == nil {
+			meta.headers = map[string]bool{}
+		}
+
+		meta.headers[header] = true
 	}
 
 	if meta == nil {
 		return nil, data, nil
 	}
 
+	if len(meta.Labels) > 0 {
+		meta.Labels = MergeLabels(meta.Labels)
+	}
+
 	return meta, data[offset:], nil
 }
+
+// GetProperties returns content properties of the page, which set its
+// appearance and emoji, see Appearance.GetProperties. Properties of values
+// which are not set are omitted.
+func (meta *Meta) GetProperties() map[string]string {
+	properties := map[string]string{}
+
+	for key, value := range meta.Appearance.GetProperties() {
+		properties[key] = value
+	}
+
+	if meta.EmojiID != "" {
+		properties[PropertyEmojiDraft] = meta.EmojiID
+		properties[PropertyEmojiPublished] = meta.EmojiID
+	}
+
+	return properties
+}
+
+// parseMetaBool parses boolean header value, which is true, false, yes or
+// no.
+func parseMetaBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes":
+		return true, nil
+
+	case "false", "no":
+		return false, nil
+	}
+
+	return false, fmt.Errorf(
+		"unexpected boolean %q, expected one of: true, false, yes, no",
+		value,
+	)
+}
@@ -0,0 +1,131 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractMeta_CRLF(t *testing.T) {
+	meta, body, err := ExtractMeta(
+		[]byte("<!-- Space: ENG -->\r\n<!-- Title: Page -->\r\n\r\nbody\r\n"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "ENG", meta.Space)
+	assert.Equal(t, "Page", meta.Title)
+	assert.Equal(t, "body\r\n", string(body))
+}
+
+func TestExtractMeta_Vars(t *testing.T) {
+	meta, body, err := ExtractMeta([]byte(text(
+		`<!-- Title: Page -->`,
+		`<!-- Var: cluster = staging -->`,
+		`<!-- Var: url=https://example.com/?a=b -->`,
+		``,
+		`body`,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(
+		t,
+		map[string]string{
+			"cluster": "staging",
+			"url":     "https://example.com/?a=b",
+		},
+		meta.Vars,
+	)
+	assert.Equal(t, "body", string(body))
+
+	_, _, err = ExtractMeta([]byte("<!-- Var: cluster -->\n"))
+	assert.Error(t, err)
+}
+
+func TestExtractMeta_VersionMessage(t *testing.T) {
+	meta, body, err := ExtractMeta([]byte(text(
+		`<!-- Title: Page -->`,
+		`<!-- Version-Message: Updated for release {{ .Release }} -->`,
+		``,
+		`body`,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "Updated for release {{ .Release }}", meta.VersionMessage)
+	assert.Equal(t, "body", string(body))
+}
+
+func TestExtractMeta_MinorEdit(t *testing.T) {
+	for value, expected := range map[string]bool{
+		"true":  true,
+		"Yes":   true,
+		"false": false,
+		"no":    false,
+	} {
+		meta, _, err := ExtractMeta([]byte(text(
+			`<!-- Title: Page -->`,
+			`<!-- Minor-Edit: `+value+` -->`,
+			``,
+			`body`,
+		)))
+		if assert.NoError(t, err, value) {
+			assert.Equal(t, expected, meta.MinorEdit, value)
+		}
+	}
+
+	meta, _, err := ExtractMeta([]byte("<!-- Title: Page -->\n\nbody\n"))
+	assert.NoError(t, err)
+	assert.False(t, meta.MinorEdit)
+
+	_, _, err = ExtractMeta([]byte(text(
+		`<!-- Title: Page -->`,
+		`<!-- Minor-Edit: maybe -->`,
+	)))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid Minor-Edit header on line 2`)
+		assert.Contains(t, err.Error(), `unexpected boolean "maybe"`)
+	}
+}
+
+func TestExtractMeta_Appearance(t *testing.T) {
+	meta, _, err := ExtractMeta([]byte(text(
+		`<!-- Title: Page -->`,
+		`<!-- Appearance: Full-Width -->`,
+		``,
+		`body`,
+	)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, AppearanceFullWidth, meta.Appearance)
+		assert.Equal(
+			t,
+			map[string]string{
+				"content-appearance-draft":     "full-width",
+				"content-appearance-published": "full-width",
+			},
+			meta.Appearance.GetProperties(),
+		)
+	}
+
+	meta, _, err = ExtractMeta([]byte("<!-- Title: Page -->\n\nbody\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, meta.Appearance)
+	assert.Empty(t, meta.Appearance.GetProperties())
+
+	_, _, err = ExtractMeta([]byte(text(
+		`<!-- Title: Page -->`,
+		`<!-- Appearance: wide -->`,
+	)))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid Appearance header on line 2`)
+		assert.Contains(
+			t,
+			err.Error(),
+			`unexpected appearance "wide", expected one of: full-width, fixed-width`,
+		)
+	}
+}
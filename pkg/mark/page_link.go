@@ -0,0 +1,323 @@
+package mark
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+)
+
+// PageLinkScheme is the scheme of links to Confluence pages by their spaces
+// and titles, which are compiled into ac:link elements:
+//
+//	[security policy](<confluence://SEC/Security Policy#scope>)
+//	[security space](confluence://SEC)
+//
+// The space can be omitted, like confluence:///Page, to link to the page of
+// the document space. Links without title point to the space landing page.
+// Links without text, like [](confluence:///Page), are titled by the page.
+// LinkResolver can return links of this scheme as well.
+const PageLinkScheme = "confluence://"
+
+// pageLink is the target of the link of PageLinkScheme or of the link to
+// the page of Confluence by its URL, see CompileOptions.ConfluenceURL.
+type pageLink struct {
+	Space  string
+	Title  string
+	Anchor string
+
+	// ID is the content id of the page, which is linked by the id then,
+	// the title is the text of links without text only.
+	ID string
+}
+
+// parsePageLink parses the destination of PageLinkScheme, false is returned
+// for other destinations.
+func parsePageLink(destination string) (pageLink, bool) {
+	if !strings.HasPrefix(strings.ToLower(destination), PageLinkScheme) {
+		return pageLink{}, false
+	}
+
+	var link pageLink
+
+	rest := destination[len(PageLinkScheme):]
+
+	if index := strings.IndexByte(rest, '#'); index >= 0 {
+		rest, link.Anchor = rest[:index], rest[index+1:]
+	}
+
+	link.Space, link.Title = rest, ""
+	if index := strings.IndexByte(rest, '/'); index >= 0 {
+		link.Space, link.Title = rest[:index], rest[index+1:]
+	}
+
+	// titles can be escaped as URL paths, e.g. to be written without angle
+	// brackets, which are not always supported
+	if title, err := url.PathUnescape(link.Title); err == nil {
+		link.Title = title
+	}
+
+	link.Title = strings.TrimSpace(link.Title)
+
+	return link, true
+}
+
+// parseConfluenceURL parses the URL of the page of Confluence at given base
+// URL, both absolute URLs and paths starting with slash are parsed:
+//
+//	https://confluence.example.com/display/ENG/Deploy+Guide
+//	https://confluence.example.com/pages/viewpage.action?pageId=12345
+//	https://confluence.example.com/pages/viewpage.action?spaceKey=ENG&title=Deploy+Guide
+//	/wiki/spaces/ENG/pages/12345/Deploy+Guide
+//	/wiki/spaces/ENG/overview
+//
+// Other query parameters are ignored and the fragment is the anchor. False
+// is returned for other URLs, e.g. of attachments or blog posts.
+func parseConfluenceURL(destination string, base string) (pageLink, bool) {
+	root, err := url.Parse(base)
+	if err != nil || root.Host == "" {
+		return pageLink{}, false
+	}
+
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return pageLink{}, false
+	}
+
+	switch {
+	case parsed.Scheme == "" && parsed.Host == "":
+		if !strings.HasPrefix(parsed.Path, "/") {
+			return pageLink{}, false
+		}
+
+	case !strings.EqualFold(parsed.Host, root.Host),
+		parsed.Scheme != "http" && parsed.Scheme != "https":
+		return pageLink{}, false
+	}
+
+	prefix := strings.TrimSuffix(root.EscapedPath(), "/") + "/"
+
+	rest := parsed.EscapedPath()
+	if !strings.HasPrefix(rest, prefix) {
+		return pageLink{}, false
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(strings.Trim(rest[len(prefix):], "/"), "/") {
+		// titles are escaped as query values, spaces are pluses
+		segment, err := url.QueryUnescape(segment)
+		if err != nil {
+			return pageLink{}, false
+		}
+
+		segments = append(segments, segment)
+	}
+
+	var (
+		link  = pageLink{Anchor: parsed.Fragment}
+		query = parsed.Query()
+	)
+
+	switch {
+	case len(segments) == 2 && segments[0] == "display",
+		len(segments) == 2 && segments[0] == "spaces",
+		len(segments) == 3 && segments[0] == "spaces" && segments[2] == "overview":
+		link.Space = segments[1]
+
+	case len(segments) == 3 && segments[0] == "display":
+		link.Space, link.Title = segments[1], segments[2]
+
+	case (len(segments) == 4 || len(segments) == 5) &&
+		segments[0] == "spaces" && segments[2] == "pages":
+		link.Space, link.ID = segments[1], segments[3]
+		if len(segments) == 5 {
+			link.Title = segments[4]
+		}
+
+	case len(segments) == 2 && segments[0] == "pages" &&
+		segments[1] == "viewpage.action":
+		link.ID = query.Get("pageId")
+		if link.ID == "" {
+			link.Space, link.Title = query.Get("spaceKey"), query.Get("title")
+		}
+
+	default:
+		return pageLink{}, false
+	}
+
+	link.Title = strings.TrimSpace(link.Title)
+
+	switch {
+	case link.ID != "":
+		if strings.Trim(link.ID, "0123456789") != "" {
+			return pageLink{}, false
+		}
+
+	case link.Space == "":
+		return pageLink{}, false
+	}
+
+	if link.Space != "" && ValidateSpaceKey(link.Space) != nil {
+		return pageLink{}, false
+	}
+
+	return link, true
+}
+
+// getPageLink parses the destination of PageLinkScheme and URLs of pages of
+// Confluence at ConfluenceURL, if it's set.
+func (opts CompileOptions) getPageLink(destination string) (pageLink, bool) {
+	if link, ok := parsePageLink(destination); ok {
+		return link, true
+	}
+
+	if opts.ConfluenceURL == "" {
+		return pageLink{}, false
+	}
+
+	return parseConfluenceURL(destination, opts.ConfluenceURL)
+}
+
+// String returns the link of PageLinkScheme as it's written in markdown.
+func (link pageLink) String() string {
+	destination := PageLinkScheme + link.Space
+	if link.Title != "" {
+		destination += "/" + escapePageLink(link.Title)
+	}
+
+	if link.Anchor != "" {
+		destination += "#" + link.Anchor
+	}
+
+	return destination
+}
+
+// getIssue returns the problem of the link, which page is not found by
+// CompileOptions.PageExists.
+func (link pageLink) getIssue(opts CompileOptions) string {
+	if link.Title == "" || link.ID != "" || opts.PageExists == nil {
+		return ""
+	}
+
+	space := link.Space
+	if space == "" {
+		space = opts.getSpace()
+	}
+
+	if opts.PageExists(space, link.Title) {
+		return ""
+	}
+
+	return fmt.Sprintf("page %q is not found in space %q", link.Title, space)
+}
+
+// writeOpening writes the opening of ac:link element followed by the link
+// body, the space key is written only if it differs from the space of the
+// document, since Confluence Server resolves links without it relative to
+// the page.
+func (link pageLink) writeOpening(writer io.Writer, space string) {
+	io.WriteString(writer, `<ac:link`)
+
+	if link.Anchor != "" {
+		io.WriteString(writer, ` ac:anchor="`+html.EscapeString(link.Anchor)+`"`)
+	}
+
+	io.WriteString(writer, `>`)
+
+	switch {
+	case link.ID != "":
+		io.WriteString(writer, `<ri:content-entity ri:content-id="`+html.EscapeString(link.ID)+`"/>`)
+
+	case link.Title == "":
+		key := link.Space
+		if key == "" {
+			key = space
+		}
+
+		io.WriteString(writer, `<ri:space ri:space-key="`+html.EscapeString(key)+`"/>`)
+
+	case link.Space != "" && link.Space != space:
+		io.WriteString(writer, `<ri:page ri:space-key="`+html.EscapeString(link.Space)+
+			`" ri:content-title="`+html.EscapeString(link.Title)+`"/>`)
+
+	default:
+		io.WriteString(writer, `<ri:page ri:content-title="`+html.EscapeString(link.Title)+`"/>`)
+	}
+
+	io.WriteString(writer, `<ac:link-body>`)
+}
+
+// writeTitle writes the title of the page as the link body, it's written
+// for links without text, so they're titled by the page they point to.
+func (link pageLink) writeTitle(writer io.Writer) {
+	io.WriteString(writer, html.EscapeString(link.Title))
+}
+
+func (link pageLink) writeClosing(writer io.Writer) {
+	io.WriteString(writer, `</ac:link-body></ac:link>`)
+}
+
+// emptyLinkPlaceholder is the text of links without text while they're
+// parsed by blackfriday, which doesn't parse such links otherwise, so they
+// can be titled by pages they point to. It's never written to the output.
+const emptyLinkPlaceholder = `---bf-EMPTY-LINK---`
+
+var emptyLinkPlaceholderBytes = []byte(emptyLinkPlaceholder)
+
+// markEmptyLinks writes emptyLinkPlaceholder into links without text,
+// like [](page.md), outside of code. Images and escaped brackets are kept.
+func markEmptyLinks(markdown []byte) []byte {
+	if !bytes.Contains(markdown, []byte("[](")) {
+		return markdown
+	}
+
+	var (
+		result []byte
+		copied = 0
+	)
+
+	scanMarkdown(markdown, func(start, end, line int, code bool) {
+		if code {
+			return
+		}
+
+		for offset := start; offset < end; {
+			index := bytes.Index(markdown[offset:end], []byte("[](")) + offset
+			if index < offset {
+				break
+			}
+
+			offset = index + 1
+
+			if isBracketEscaped(markdown, index) {
+				continue
+			}
+
+			if result == nil {
+				result = make([]byte, 0, len(markdown)+len(emptyLinkPlaceholder))
+			}
+
+			result = append(result, markdown[copied:offset]...)
+			result = append(result, emptyLinkPlaceholder...)
+
+			copied = offset
+		}
+	})
+
+	if result == nil {
+		return markdown
+	}
+
+	return append(result, markdown[copied:]...)
+}
+
+// isEmptyLinkText returns true if the node is the text of the link, which
+// is marked by markEmptyLinks.
+func isEmptyLinkText(node *bf.Node) bool {
+	return node != nil && node.Type == bf.Text &&
+		bytes.Equal(node.Literal, emptyLinkPlaceholderBytes)
+}
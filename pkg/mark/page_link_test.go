@@ -0,0 +1,194 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_PageLinks(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := text(
+		`[policy](<confluence://SEC/Security Policy#scope>)`,
+		`[**own**](confluence://DOC/Own%20Page)`,
+		`[same](confluence:///Same)`,
+		`[security](confluence://SEC)`,
+		`[home](confluence://)`,
+		`[resolved](policy.md)`,
+		``,
+	)
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile([]byte(markdown), lib, CompileOptions{
+			Engine:   engine,
+			Meta:     &Meta{Space: "DOC"},
+			Validate: true,
+			LinkResolver: func(destination string) (string, bool) {
+				if destination != "policy.md" {
+					return "", false
+				}
+
+				return "confluence://SEC/Security Policy", true
+			},
+		})
+		assert.NoError(t, err, engine)
+		assert.Equal(
+			t,
+			text(
+				`<p><ac:link ac:anchor="scope"><ri:page ri:space-key="SEC" ri:content-title="Security Policy"/><ac:link-body>policy</ac:link-body></ac:link>`,
+				`<ac:link><ri:page ri:content-title="Own Page"/><ac:link-body><strong>own</strong></ac:link-body></ac:link>`,
+				`<ac:link><ri:page ri:content-title="Same"/><ac:link-body>same</ac:link-body></ac:link>`,
+				`<ac:link><ri:space ri:space-key="SEC"/><ac:link-body>security</ac:link-body></ac:link>`,
+				`<ac:link><ri:space ri:space-key="DOC"/><ac:link-body>home</ac:link-body></ac:link>`,
+				`<ac:link><ri:page ri:space-key="SEC" ri:content-title="Security Policy"/><ac:link-body>resolved</ac:link-body></ac:link></p>`,
+				``,
+			),
+			result.HTML,
+			engine,
+		)
+	}
+}
+
+func TestHtmlToMarkdown_PageLinks(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html := text(
+		`<p><ac:link ac:anchor="scope"><ri:page ri:space-key="SEC" ri:content-title="Security Policy"/><ac:link-body>policy</ac:link-body></ac:link></p>`,
+		`<p><ac:link><ri:space ri:space-key="SEC"/><ac:link-body>security</ac:link-body></ac:link></p>`,
+		`<p><ac:link><ri:page ri:space-key="DOC" ri:content-title="Own"/></ac:link></p>`,
+	)
+
+	markdown, err := HtmlToMarkdown(html, ConvertMeta(PageMeta{Space: "DOC", Title: "Page"}))
+	assert.NoError(t, err)
+	assert.Equal(t, text(
+		`<!-- Space: DOC -->`,
+		`<!-- Title: Page -->`,
+		``,
+		`[policy](<confluence://SEC/Security Policy#scope>)`,
+		``,
+		`[security](confluence://SEC)`,
+		``,
+		`[Own](Own)`,
+	), markdown)
+
+	// links to other spaces are compiled back as they were
+	meta, body, err := ExtractMeta([]byte(markdown))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(body, lib, CompileOptions{Meta: meta})
+	assert.NoError(t, err)
+	assert.Contains(t, result.HTML, `<ac:link ac:anchor="scope"><ri:page ri:space-key="SEC" ri:content-title="Security Policy"/>`)
+	assert.Contains(t, result.HTML, `<ac:link><ri:space ri:space-key="SEC"/>`)
+}
+
+func TestParseConfluenceURL(t *testing.T) {
+	test := func(base string, destination string, expected *pageLink) {
+		t.Helper()
+
+		link, ok := parseConfluenceURL(destination, base)
+		if expected == nil {
+			assert.False(t, ok, destination)
+		} else if assert.True(t, ok, destination) {
+			assert.Equal(t, *expected, link, destination)
+		}
+	}
+
+	server := "https://confluence.example.com"
+
+	test(server, "https://confluence.example.com/display/ENG/Deploy+Guide",
+		&pageLink{Space: "ENG", Title: "Deploy Guide"})
+	test(server, "http://Confluence.Example.com/display/ENG/R%26D%20Guide?src=tree#Rollback",
+		&pageLink{Space: "ENG", Title: "R&D Guide", Anchor: "Rollback"})
+	test(server, "/display/ENG", &pageLink{Space: "ENG"})
+	test(server, "https://confluence.example.com/pages/viewpage.action?pageId=12345",
+		&pageLink{ID: "12345"})
+	test(server, "https://confluence.example.com/pages/viewpage.action?spaceKey=ENG&title=Deploy+Guide",
+		&pageLink{Space: "ENG", Title: "Deploy Guide"})
+
+	cloud := "https://example.atlassian.net/wiki/"
+
+	test(cloud, "/wiki/spaces/ENG/pages/12345/Deploy+Guide#Steps",
+		&pageLink{Space: "ENG", ID: "12345", Title: "Deploy Guide", Anchor: "Steps"})
+	test(cloud, "https://example.atlassian.net/wiki/spaces/ENG/pages/12345",
+		&pageLink{Space: "ENG", ID: "12345"})
+	test(cloud, "https://example.atlassian.net/wiki/spaces/~jdoe/overview",
+		&pageLink{Space: "~jdoe"})
+
+	// anything else is kept as is
+	test(server, "https://other.example.com/display/ENG/Deploy", nil)
+	test(server, "ftp://confluence.example.com/display/ENG/Deploy", nil)
+	test(server, "display/ENG/Deploy", nil)
+	test(server, "/display/ENG/2023/01/01/Release", nil)
+	test(server, "/download/attachments/12345/logo.png", nil)
+	test(server, "/pages/viewpage.action", nil)
+	test(server, "/pages/viewpage.action?pageId=abc", nil)
+	test(server, "/display/E-NG/Deploy", nil)
+	test(cloud, "/spaces/ENG/pages/12345", nil)
+	test(cloud, "/wiki/spaces/ENG/pages/12345/Deploy/edit", nil)
+	test("", "/display/ENG/Deploy", nil)
+}
+
+func TestCompile_ConfluenceURLs(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`[guide](https://example.atlassian.net/wiki/spaces/DOC/pages/12345/Deploy+Guide#Steps),`,
+		`[runbook](/wiki/display/OPS/Run+Book), [external](https://example.com/display/OPS/Run+Book)`,
+		`and [missing](https://example.atlassian.net/wiki/display/DOC/Missing).`,
+		``,
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		opts := CompileOptions{
+			Engine:         engine,
+			Meta:           &Meta{Space: "DOC"},
+			Validate:       true,
+			ConfluenceURL:  "https://example.atlassian.net/wiki",
+			AbsolutePrefix: "https://docs.example.com",
+			PageExists: func(space string, title string) bool {
+				return title != "Missing"
+			},
+		}
+
+		result, err := Compile(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.Equal(t, text(
+				`<p><ac:link ac:anchor="Steps"><ri:content-entity ri:content-id="12345"/>`+
+					`<ac:link-body>guide</ac:link-body></ac:link>,`,
+				`<ac:link><ri:page ri:space-key="OPS" ri:content-title="Run Book"/>`+
+					`<ac:link-body>runbook</ac:link-body></ac:link>, `+
+					`<a href="https://example.com/display/OPS/Run+Book">external</a>`,
+				`and <ac:link><ri:page ri:content-title="Missing"/>`+
+					`<ac:link-body>missing</ac:link-body></ac:link>.</p>`,
+				``,
+			), result.HTML, engine)
+
+			assert.Equal(t, []Diagnostic{{
+				Line:     3,
+				Severity: SeverityWarning,
+				Message:  `page "Missing" is not found in space "DOC"`,
+			}}, result.Diagnostics, engine)
+		}
+
+		opts.ConfluenceURL = ""
+
+		html, err := CompileMarkdownWithOptions(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.NotContains(t, html, `ac:link`, engine)
+			assert.Contains(t, html, `href="https://docs.example.com/wiki/display/OPS/Run+Book"`, engine)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrCompilePanic is matched by errors returned when compiling panics, see
+// PanicError.
+var ErrCompilePanic = errors.New("panic while compiling markdown")
+
+// PanicError is returned instead of the panic occurred while compiling
+// markdown, e.g. in the markdown parser, handlers or LinkResolver, so
+// pathological input doesn't take down the whole process. It matches
+// ErrCompilePanic with errors.Is.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine at the moment of panic.
+	Stack []byte
+
+	// File is CompileOptions.File, it's empty if not set.
+	File string
+
+	// Source is file:line of the block being rendered, it's File if the
+	// panic occurred before rendering, e.g. while parsing.
+	Source string
+
+	// Size is the length of the markdown in bytes and Checksum is its
+	// SHA-256 in hex, so the input can be identified without logging it.
+	Size     int
+	Checksum string
+}
+
+func newPanicError(
+	value interface{},
+	markdown []byte,
+	opts CompileOptions,
+	state *compilation,
+) *PanicError {
+	checksum := sha256.Sum256(markdown)
+
+	err := &PanicError{
+		Value:    value,
+		Stack:    debug.Stack(),
+		File:     opts.File,
+		Size:     len(markdown),
+		Checksum: hex.EncodeToString(checksum[:]),
+	}
+
+	if state != nil && state.output != nil {
+		err.Source = state.position()
+	}
+
+	if err.Source == "" {
+		err.Source = err.File
+	}
+
+	return err
+}
+
+func (err *PanicError) Error() string {
+	if err.Source == "" {
+		return fmt.Sprintf("%s: %v", ErrCompilePanic, err.Value)
+	}
+
+	return fmt.Sprintf("%s: %s: %v", ErrCompilePanic, err.Source, err.Value)
+}
+
+func (err *PanicError) Is(target error) bool {
+	return target == ErrCompilePanic
+}
+
+// Unwrap returns the value passed to panic if it's an error, e.g. runtime
+// errors like index out of range.
+func (err *PanicError) Unwrap() error {
+	if reason, ok := err.Value.(error); ok {
+		return reason
+	}
+
+	return nil
+}
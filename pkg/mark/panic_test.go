@@ -0,0 +1,67 @@
+package mark
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_Panic(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"# Page",
+		"",
+		"See [other](other.md).",
+	))
+
+	// goldmark resolves links while transforming the tree, before rendering
+	sources := map[MarkdownEngine]string{
+		EngineBlackfriday: "page.md:3",
+		EngineGoldmark:    "page.md",
+	}
+
+	for engine, source := range sources {
+		result, err := Compile(markdown, lib, CompileOptions{
+			File:   "page.md",
+			Engine: engine,
+			LinkResolver: func(destination string) (string, bool) {
+				var links []string
+
+				return links[len(destination)], true
+			},
+		})
+		assert.True(t, errors.Is(err, ErrCompilePanic), engine)
+
+		var panicked *PanicError
+		if !assert.True(t, errors.As(err, &panicked), engine) {
+			continue
+		}
+
+		assert.Equal(t, "page.md", panicked.File)
+		assert.Equal(t, source, panicked.Source, engine)
+		assert.Equal(t, len(markdown), panicked.Size)
+		assert.Len(t, panicked.Checksum, 64)
+		assert.Contains(t, string(panicked.Stack), "TestCompile_Panic")
+		assert.Contains(t, err.Error(), source+": runtime error: index out of range")
+
+		var runtime interface{ RuntimeError() }
+		assert.True(t, errors.As(err, &runtime))
+
+		assert.Empty(t, result.HTML)
+		assert.Equal(
+			t,
+			[]Diagnostic{{
+				File:     "page.md",
+				Severity: SeverityError,
+				Message:  err.Error(),
+			}},
+			result.Diagnostics,
+		)
+	}
+}
@@ -0,0 +1,77 @@
+package mark
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPathOutsideRoot is matched by errors of references which resolve to
+// paths outside of CompileOptions.RootDir.
+var ErrPathOutsideRoot = errors.New("path is outside of root directory")
+
+// getBaseDir returns the directory relative references of the document are
+// resolved against.
+func (opts CompileOptions) getBaseDir() string {
+	if opts.BaseDir != "" {
+		return opts.BaseDir
+	}
+
+	if opts.File != "" {
+		return filepath.Dir(opts.File)
+	}
+
+	return ""
+}
+
+// resolveReference returns the path of the local file given reference, e.g.
+// the destination of the image or the included file, refers to. Relative
+// references are resolved against base, and references starting with slash
+// are resolved against CompileOptions.RootDir, false is returned for them if
+// RootDir is not set, since they're links to Confluence pages. Segments like
+// .. are normalized.
+//
+// The error matching ErrPathOutsideRoot, which describes both the reference
+// and the absolute path, is returned if the path is outside of RootDir,
+// unless AllowOutsideRoot is set.
+func resolveReference(
+	reference string,
+	base string,
+	opts CompileOptions,
+) (string, bool, error) {
+	var path string
+
+	if strings.HasPrefix(reference, "/") {
+		if opts.RootDir == "" {
+			return "", false, nil
+		}
+
+		path = filepath.Join(opts.RootDir, filepath.FromSlash(reference))
+	} else {
+		path = filepath.Join(base, filepath.FromSlash(reference))
+	}
+
+	if opts.RootDir == "" || opts.AllowOutsideRoot {
+		return path, true, nil
+	}
+
+	var (
+		absolute = getAbsolutePath(path)
+		root     = getAbsolutePath(opts.RootDir)
+	)
+
+	relative, err := filepath.Rel(root, absolute)
+	if err != nil || relative == ".." ||
+		strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return path, true, fmt.Errorf(
+			"%w: %q resolves to %q outside of %q",
+			ErrPathOutsideRoot,
+			reference,
+			absolute,
+			root,
+		)
+	}
+
+	return path, true, nil
+}
@@ -0,0 +1,172 @@
+package mark
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveReference(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	base := filepath.FromSlash("/repo/docs/guides")
+
+	type testcase struct {
+		reference string
+		opts      CompileOptions
+		path      string
+		ok        bool
+		outside   bool
+	}
+
+	tests := []testcase{
+		{reference: "logo.png", path: "/repo/docs/guides/logo.png", ok: true},
+		{reference: "./images/../logo.png", path: "/repo/docs/guides/logo.png", ok: true},
+		{reference: "../../../etc/passwd", path: "/etc/passwd", ok: true},
+		{reference: "/display/DOC/Page"},
+		{
+			reference: "/assets/logo.png",
+			opts:      CompileOptions{RootDir: root},
+			path:      "/repo/assets/logo.png",
+			ok:        true,
+		},
+		{
+			reference: "../../logo.png",
+			opts:      CompileOptions{RootDir: root},
+			path:      "/repo/logo.png",
+			ok:        true,
+		},
+		{
+			reference: "../../../etc/passwd",
+			opts:      CompileOptions{RootDir: root},
+			path:      "/etc/passwd",
+			ok:        true,
+			outside:   true,
+		},
+		{
+			reference: "/../repository/logo.png",
+			opts:      CompileOptions{RootDir: root},
+			path:      "/repository/logo.png",
+			ok:        true,
+			outside:   true,
+		},
+		{
+			reference: "../../../etc/passwd",
+			opts:      CompileOptions{RootDir: root, AllowOutsideRoot: true},
+			path:      "/etc/passwd",
+			ok:        true,
+		},
+	}
+
+	for _, test := range tests {
+		path, ok, err := resolveReference(test.reference, base, test.opts)
+
+		if test.outside {
+			assert.True(t, errors.Is(err, ErrPathOutsideRoot), test.reference)
+		} else {
+			assert.NoError(t, err, test.reference)
+		}
+
+		assert.Equal(t, test.ok, ok, test.reference)
+
+		if test.ok {
+			assert.Equal(t, filepath.FromSlash(test.path), path, test.reference)
+		}
+	}
+}
+
+func TestCompile_RootDir(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeMarkdownFiles(t, map[string]string{
+		"repo/docs/page.md": text(
+			`![logo](/assets/logo.png) ![icon](../assets/icon.png)`,
+			``,
+			`![secret](../../secret.png)`,
+			``,
+			`<!-- Include: /shared/footer.md -->`,
+			``,
+		),
+		"repo/shared/footer.md":  `![footer](footer.png)`,
+		"repo/docs/escape.md":    "Footer:\n\n<!-- Include: ../shared/footer.md -->\n",
+		"repo/shared/footer.png": "footer",
+		"repo/assets/logo.png":   "logo",
+		"repo/assets/icon.png":   "icon",
+		"secret.png":             "secret",
+	})
+
+	var (
+		root = filepath.Join(dir, "repo")
+		file = filepath.Join(root, "docs", "page.md")
+	)
+
+	opts := CompileOptions{
+		File:       file,
+		RootDir:    root,
+		FileExists: FileExistsOnDisk,
+	}
+
+	markdown, sources, _, err := readMarkdownFile(file, []string{}, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Sources = sources
+	opts.Logger = &testLogger{}
+
+	result, err := Compile(markdown, lib, opts)
+	assert.NoError(t, err)
+
+	if assert.Len(t, result.Attachments, 4) {
+		assert.Equal(t, filepath.Join(root, "assets", "logo.png"), result.Attachments[0].SourcePath)
+		assert.Equal(t, filepath.Join(root, "assets", "icon.png"), result.Attachments[1].SourcePath)
+		assert.Equal(t, filepath.Join(dir, "secret.png"), result.Attachments[2].SourcePath)
+		assert.Equal(t, filepath.Join(root, "shared", "footer.png"), result.Attachments[3].SourcePath)
+
+		assert.True(t, errors.Is(result.Attachments[2].Err, ErrPathOutsideRoot))
+
+		for _, attachment := range []AttachmentFile{
+			result.Attachments[0],
+			result.Attachments[1],
+			result.Attachments[3],
+		} {
+			assert.NoError(t, attachment.Err, attachment.SourcePath)
+		}
+	}
+
+	// diagnostic refers to both the reference and the path
+	if assert.Len(t, result.Diagnostics, 1) {
+		assert.Equal(t, 3, result.Diagnostics[0].Line)
+		assert.Equal(
+			t,
+			`path is outside of root directory: "../../secret.png" resolves to `+
+				`"`+filepath.Join(dir, "secret.png")+`" outside of "`+root+`"`,
+			result.Diagnostics[0].Message,
+		)
+	}
+
+	opts.AllowOutsideRoot = true
+
+	result, err = Compile(markdown, lib, opts)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Diagnostics)
+
+	// includes can't escape the root either
+	opts.AllowOutsideRoot = false
+	opts.RootDir = filepath.Join(root, "docs")
+
+	_, _, _, err = readMarkdownFile(
+		filepath.Join(root, "docs", "escape.md"),
+		[]string{},
+		false,
+		opts,
+	)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ErrPathOutsideRoot.Error())
+	}
+}
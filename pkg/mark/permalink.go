@@ -0,0 +1,251 @@
+package mark
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+	"github.com/yuin/goldmark/ast"
+)
+
+// DefaultPermalinkText is the text of permalinks, see Permalinks.Text.
+const DefaultPermalinkText = "¶"
+
+// Permalinks configures links written after the text of headings, which
+// point to anchors of headings themselves, so the link to the section can
+// be copied, e.g. for tickets:
+//
+//	<h2 id="usage">Usage <a href="#usage">¶</a></h2>
+//
+// Anchors are ids of headings, the same as links to headings like
+// [usage](#usage) point to, so CompileOptions.HeadingIDPrefix and
+// CompileOptions.HeadingIDSuffix are included. Permalinks are added while
+// rendering only, so they're never a part of the title of the page.
+type Permalinks struct {
+	// Levels are levels of headings which get permalinks, H2 and H3 by
+	// default.
+	Levels []int
+
+	// Text is the text of permalinks, DefaultPermalinkText by default.
+	Text string
+
+	// SkipExpands skips headings written inside expand macros, which are
+	// collapsed, so the anchor may be hidden.
+	SkipExpands bool
+}
+
+// reMacroTag matches opening and closing tags of macros and captures the
+// name of opened ones, colons of tags are replaced with colonPlaceholder
+// while parsing.
+var reMacroTag = regexp.MustCompile(
+	`<(/?)ac(?::|` + colonPlaceholder + `)structured-macro\b` +
+		`(?:[^>]*?\bac(?::|` + colonPlaceholder + `)name\s*=\s*["']([^"']*)["'])?` +
+		`[^>]*?(/?)>`,
+)
+
+// permalinks tracks headings, which get permalinks, and macros which are
+// open while the document is rendered.
+type permalinks struct {
+	opts Permalinks
+
+	// counts make heading ids unique the same way as blackfriday renderer
+	// does, ids are rendered ids of headings, which are being rendered
+	counts map[string]int
+	ids    map[*bf.Node]string
+
+	// macros are names of open macros, innermost is the last one
+	macros []string
+}
+
+func newPermalinks(opts *Permalinks) *permalinks {
+	if opts == nil {
+		return nil
+	}
+
+	return &permalinks{
+		opts:   *opts,
+		counts: map[string]int{},
+		ids:    map[*bf.Node]string{},
+	}
+}
+
+// track updates open macros and remembers ids of headings, the node must be
+// passed before it's rendered, since heading ids are made unique while
+// entering headings.
+func (links *permalinks) track(
+	node *bf.Node,
+	entering bool,
+	prefix string,
+	suffix string,
+) {
+	if links == nil || !entering {
+		return
+	}
+
+	switch node.Type {
+	case bf.HTMLBlock, bf.HTMLSpan:
+		links.update(node.Literal)
+
+	case bf.Heading:
+		if node.HeadingID == "" {
+			return
+		}
+
+		id := prefix + links.unique(node.HeadingID) + suffix
+
+		if links.accepts(node.Level) {
+			links.ids[node] = id
+		}
+	}
+}
+
+// write writes the permalink of the heading, which is being left.
+func (links *permalinks) write(writer io.Writer, node *bf.Node) {
+	if links == nil || node.Type != bf.Heading {
+		return
+	}
+
+	id, ok := links.ids[node]
+	if !ok {
+		return
+	}
+
+	delete(links.ids, node)
+
+	io.WriteString(writer, links.render(id))
+}
+
+func (links *permalinks) render(id string) string {
+	text := links.opts.Text
+	if text == "" {
+		text = DefaultPermalinkText
+	}
+
+	return ` <a href="#` + escape.EscapeAttr(id) + `">` + escape.EscapeText(text) + `</a>`
+}
+
+// accepts returns true if the heading of given level gets the permalink at
+// the current position of the document.
+func (links *permalinks) accepts(level int) bool {
+	levels := links.opts.Levels
+	if levels == nil {
+		levels = []int{2, 3}
+	}
+
+	found := false
+	for _, accepted := range levels {
+		if accepted == level {
+			found = true
+
+			break
+		}
+	}
+
+	if !found {
+		return false
+	}
+
+	if links.opts.SkipExpands {
+		for _, macro := range links.macros {
+			if macro == "expand" {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// update opens and closes macros written in HTML.
+func (links *permalinks) update(html []byte) {
+	for _, match := range reMacroTag.FindAllSubmatch(html, -1) {
+		switch {
+		case len(match[1]) > 0:
+			if len(links.macros) > 0 {
+				links.macros = links.macros[:len(links.macros)-1]
+			}
+
+		case len(match[3]) == 0:
+			links.macros = append(links.macros, string(match[2]))
+		}
+	}
+}
+
+// unique returns unique heading id the same way as blackfriday renderer
+// makes it.
+func (links *permalinks) unique(id string) string {
+	for count, found := links.counts[id]; found; count, found = links.counts[id] {
+		tmp := fmt.Sprintf("%s-%d", id, count+1)
+
+		if _, ok := links.counts[tmp]; !ok {
+			links.counts[id] = count + 1
+			id = tmp
+		} else {
+			id += "-1"
+		}
+	}
+
+	if _, found := links.counts[id]; !found {
+		links.counts[id] = 0
+	}
+
+	return id
+}
+
+// addGoldmarkPermalinks appends permalinks to headings of goldmark
+// documents, which ids are final.
+func addGoldmarkPermalinks(document ast.Node, source []byte, opts *Permalinks) {
+	links := newPermalinks(opts)
+	if links == nil {
+		return
+	}
+
+	headings := map[*ast.Heading]string{}
+
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch node := node.(type) {
+		case *ast.RawHTML:
+			links.update(getRawHTML(node, source))
+
+		case *ast.HTMLBlock:
+			lines := node.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				line := lines.At(i)
+				links.update(line.Value(source))
+			}
+
+			if node.HasClosure() {
+				closure := node.ClosureLine
+				links.update(closure.Value(source))
+			}
+
+		case *ast.Heading:
+			if !links.accepts(node.Level) {
+				break
+			}
+
+			if id, ok := node.AttributeString("id"); ok {
+				if id, ok := id.([]byte); ok {
+					headings[node] = string(id)
+				}
+			}
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	// tree can't be modified while walking through it
+	for heading, id := range headings {
+		// code strings are written as is, without escaping
+		link := ast.NewString([]byte(links.render(id)))
+		link.SetCode(true)
+
+		heading.AppendChild(heading, link)
+	}
+}
@@ -0,0 +1,89 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_Permalinks(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`# Title`,
+		``,
+		`## Usage`,
+		``,
+		`### Usage`,
+		``,
+		`#### Deep`,
+		``,
+		`## Usage`,
+		``,
+		`<ac:structured-macro ac:name="expand">`,
+		`<ac:rich-text-body>`,
+		``,
+		`## Hidden`,
+		``,
+		`</ac:rich-text-body>`,
+		`</ac:structured-macro>`,
+		``,
+		`## After`,
+		``,
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		opts := CompileOptions{
+			Engine:     engine,
+			Permalinks: &Permalinks{SkipExpands: true},
+		}
+
+		html, err := CompileMarkdownWithOptions(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.Contains(t, html, `<h1 id="title">Title</h1>`, engine)
+			assert.Contains(t, html, `<h2 id="usage">Usage <a href="#usage">¶</a></h2>`, engine)
+			assert.Contains(
+				t,
+				html,
+				`<h3 id="usage-1">Usage <a href="#usage-1">¶</a></h3>`,
+				engine,
+			)
+			assert.Contains(t, html, `<h4 id="deep">Deep</h4>`, engine)
+			assert.Contains(
+				t,
+				html,
+				`<h2 id="usage-2">Usage <a href="#usage-2">¶</a></h2>`,
+				engine,
+			)
+			assert.Contains(t, html, `<h2 id="hidden">Hidden</h2>`, engine)
+			assert.Contains(t, html, `<h2 id="after">After <a href="#after">¶</a></h2>`, engine)
+		}
+
+		opts.Permalinks = &Permalinks{Levels: []int{1, 2}, Text: "#"}
+		opts.HeadingIDPrefix = "doc-"
+		opts.TitleFromH1 = TitleFromH1KeepAndTitle
+
+		result, err := Compile(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.Equal(t, "Title", result.Title, engine)
+			assert.Contains(
+				t,
+				result.HTML,
+				`<h1 id="doc-title">Title <a href="#doc-title">#</a></h1>`,
+				engine,
+			)
+			assert.Contains(
+				t,
+				result.HTML,
+				`<h2 id="doc-hidden">Hidden <a href="#doc-hidden">#</a></h2>`,
+				engine,
+			)
+			assert.Contains(t, result.HTML, `<h3 id="doc-usage-1">Usage</h3>`, engine)
+			assert.Equal(t, 0, result.Stats.InternalLinks, engine)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package mark
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/kovetskiy/mark/pkg/mark/dialect"
+	"github.com/reconquest/karma-go"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// previewStyle is the style sheet of previews, which makes stand-ins of
+// Confluence constructs look roughly like they look in Confluence.
+const previewStyle = `
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; line-height: 1.5; }
+pre.code { background: #f4f5f7; border: 1px solid #dfe1e6; padding: 0.75em; overflow: auto; }
+.code-title { background: #dfe1e6; padding: 0.25em 0.75em; font-weight: bold; }
+.admonition { border-left: 4px solid; padding: 0.5em 1em; margin: 1em 0; }
+.admonition-info { background: #deebff; border-color: #0052cc; }
+.admonition-tip { background: #e3fcef; border-color: #00875a; }
+.admonition-note { background: #eae6ff; border-color: #5243aa; }
+.admonition-warning { background: #ffebe6; border-color: #de350b; }
+.page-link { color: #0052cc; text-decoration: underline dotted; cursor: help; }
+.status { border-radius: 3px; padding: 0 0.4em; font-size: 0.8em; font-weight: bold; text-transform: uppercase; background: #dfe1e6; }
+.status-green { background: #e3fcef; color: #006644; }
+.status-yellow { background: #fffae6; color: #ff8b00; }
+.status-red { background: #ffebe6; color: #bf2600; }
+.status-blue { background: #deebff; color: #0747a6; }
+.macro { border: 1px dashed #a5adba; padding: 0.5em; margin: 1em 0; color: #5e6c84; }
+.toc { list-style: none; padding-left: 0; }
+`
+
+// previewAdmonitions are macros of admonitions, which are previewed as
+// colored blocks.
+var previewAdmonitions = map[string]bool{
+	"info":    true,
+	"tip":     true,
+	"note":    true,
+	"warning": true,
+}
+
+// RenderPreview renders Confluence storage format, e.g. compiled by
+// CompileMarkdownWithOptions, into the HTML document which can be viewed in
+// a browser. Confluence constructs are replaced with stand-ins, which look
+// roughly like they look in Confluence:
+//
+//   - code macros are written as <pre> elements with the class of the
+//     language, e.g. language-go, which is used by syntax highlighters;
+//   - info, tip, note and warning macros are written as colored blocks;
+//   - expand macros are written as <details> elements;
+//   - tables of contents are generated from headings of the page;
+//   - links to pages, attachments and users are written as spans, which
+//     tooltips tell where they point to;
+//   - other macros are written as dashed blocks with their bodies.
+//
+// Since the preview is rendered from the storage format, the document is
+// parsed and pre-processed once and the preview can't diverge from the
+// page structurally. Texts of stand-ins are written in DefaultLocale, see
+// CompileOptions.Preview for previews in other languages.
+func RenderPreview(storage string) (string, error) {
+	return renderPreview(storage, CompileOptions{})
+}
+
+// renderPreview renders the preview with texts of CompileOptions.Locale
+// and CompileOptions.Messages, other options are ignored.
+func renderPreview(storage string, opts CompileOptions) (string, error) {
+	var (
+		cdata  = []string{}
+		reader = newStorageReader(strings.NewReader(storage), &cdata)
+	)
+
+	document, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return "", karma.Format(err, "unable to parse storage format")
+	}
+
+	body := document.Find("body")
+	if body.Length() == 0 {
+		return "", karma.Format(nil, "unable to parse storage format: no body")
+	}
+
+	preview := &preview{conversion: &conversion{cdata: cdata}, opts: opts}
+
+	preview.collectHeadings(body.Get(0))
+	preview.render(body.Get(0))
+
+	if preview.err != nil {
+		return "", preview.err
+	}
+
+	var output strings.Builder
+
+	output.WriteString("<!DOCTYPE html>\n<html>\n<head>\n")
+	output.WriteString(`<meta charset="utf-8">` + "\n")
+	output.WriteString("<style>" + previewStyle + "</style>\n")
+	output.WriteString("</head>\n<body>\n")
+
+	for child := body.Get(0).FirstChild; child != nil; child = child.NextSibling {
+		if err := html.Render(&output, child); err != nil {
+			return "", karma.Format(err, "unable to render preview")
+		}
+	}
+
+	output.WriteString("\n</body>\n</html>\n")
+
+	return output.String(), nil
+}
+
+// preview replaces Confluence constructs of the parsed storage format with
+// stand-ins, see RenderPreview.
+type preview struct {
+	// conversion gets texts and parameters of macros
+	conversion *conversion
+
+	headings []*html.Node
+
+	opts CompileOptions
+
+	// err is the first error occurred while formatting messages
+	err error
+}
+
+// formatMessage returns the text of the message, errors are kept until
+// rendering is done.
+func (preview *preview) formatMessage(
+	message Message,
+	fields map[string]string,
+) string {
+	text, err := preview.opts.formatMessage(message, fields)
+	if err != nil && preview.err == nil {
+		preview.err = err
+	}
+
+	return text
+}
+
+// collectHeadings collects headings of the page for tables of contents.
+func (preview *preview) collectHeadings(node *html.Node) {
+	if getPreviewHeadingLevel(node) > 0 {
+		preview.headings = append(preview.headings, node)
+
+		return
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		preview.collectHeadings(child)
+	}
+}
+
+// render replaces Confluence constructs among descendants of the node.
+func (preview *preview) render(node *html.Node) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+
+		preview.renderNode(child)
+
+		child = next
+	}
+}
+
+func (preview *preview) renderNode(node *html.Node) {
+	if node.Type != html.ElementNode {
+		return
+	}
+
+	var replacement *html.Node
+
+	switch node.Data {
+	case cdataTag:
+		replacement = newPreviewText(preview.conversion.getCDATA(node))
+
+	case "ac:structured-macro":
+		replacement = preview.renderMacro(node)
+
+	case "ac:link":
+		replacement = preview.renderLink(node)
+
+	case "ac:image":
+		replacement = preview.renderImage(node)
+
+	case "ac:emoticon":
+		replacement = renderPreviewEmoticon(node)
+
+	default:
+		if strings.HasPrefix(node.Data, "ac:") || strings.HasPrefix(node.Data, "ri:") {
+			// unknown elements are replaced with their content
+			preview.render(node)
+
+			unwrap(node)
+
+			return
+		}
+
+		preview.render(node)
+
+		return
+	}
+
+	if replacement != nil {
+		node.Parent.InsertBefore(replacement, node)
+	}
+
+	node.Parent.RemoveChild(node)
+}
+
+// renderMacro returns the stand-in of the structured macro.
+func (preview *preview) renderMacro(node *html.Node) *html.Node {
+	var (
+		selec = goquery.NewDocumentFromNode(node).Selection
+		name  = getMacroName(selec)
+		title = preview.conversion.getMacroParameter(selec, "title")
+	)
+
+	switch {
+	case name == "code" || name == "cloudscript-confluence-mermaid":
+		var (
+			language = preview.conversion.getMacroParameter(selec, "language")
+			text     = preview.conversion.getText(getStorageChild(selec, "ac:plain-text-body"))
+			block    = newPreviewElement(atom.Div, "class", "code-block")
+			code     = newPreviewElement(atom.Code)
+			pre      = newPreviewElement(atom.Pre, "class", "code")
+		)
+
+		if name != "code" {
+			language = "mermaid"
+		}
+
+		if language != "" {
+			code.Attr = append(code.Attr, html.Attribute{Key: "class", Val: "language-" + language})
+		}
+
+		if title != "" {
+			caption := newPreviewElement(atom.Div, "class", "code-title")
+			caption.AppendChild(newPreviewText(title))
+			block.AppendChild(caption)
+		}
+
+		code.AppendChild(newPreviewText(text))
+		pre.AppendChild(code)
+		block.AppendChild(pre)
+
+		return block
+
+	case previewAdmonitions[name]:
+		block := newPreviewElement(atom.Div, "class", "admonition admonition-"+name)
+
+		if title != "" {
+			heading := newPreviewElement(atom.P)
+			strong := newPreviewElement(atom.Strong)
+
+			strong.AppendChild(newPreviewText(title))
+			heading.AppendChild(strong)
+			block.AppendChild(heading)
+		}
+
+		preview.appendBody(block, selec)
+
+		return block
+
+	case name == "expand":
+		var (
+			details = newPreviewElement(atom.Details)
+			summary = newPreviewElement(atom.Summary)
+		)
+
+		if title == "" {
+			title = preview.formatMessage(MessageExpand, nil)
+		}
+
+		summary.AppendChild(newPreviewText(title))
+		details.AppendChild(summary)
+
+		preview.appendBody(details, selec)
+
+		return details
+
+	case name == "toc":
+		return preview.renderTOC(selec)
+
+	case name == "status":
+		var (
+			color  = strings.ToLower(preview.conversion.getMacroParameter(selec, "colour"))
+			status = newPreviewElement(atom.Span, "class", "status status-"+color)
+		)
+
+		status.AppendChild(newPreviewText(title))
+
+		return status
+
+	case name == "anchor":
+		return newPreviewElement(
+			atom.A,
+			"id",
+			preview.conversion.getMacroParameter(selec, ""),
+		)
+	}
+
+	block := newPreviewElement(atom.Div, "class", "macro", "title", name+" macro")
+
+	if getStorageChild(selec, "ac:rich-text-body").Length() > 0 {
+		preview.appendBody(block, selec)
+	} else {
+		block.AppendChild(newPreviewText("[" + name + "]"))
+	}
+
+	return block
+}
+
+// appendBody moves the rich text body of the macro into the stand-in and
+// renders it.
+func (preview *preview) appendBody(parent *html.Node, macro *goquery.Selection) {
+	body := getStorageChild(macro, "ac:rich-text-body")
+	if body.Length() == 0 {
+		return
+	}
+
+	node := body.Get(0)
+
+	for child := node.FirstChild; child != nil; child = node.FirstChild {
+		node.RemoveChild(child)
+		parent.AppendChild(child)
+	}
+
+	preview.render(parent)
+}
+
+// renderTOC returns the list of links to headings of the page, which levels
+// are between minLevel and maxLevel parameters of the toc macro.
+func (preview *preview) renderTOC(macro *goquery.Selection) *html.Node {
+	minLevel, err := strconv.Atoi(preview.conversion.getMacroParameter(macro, "minLevel"))
+	if err != nil {
+		minLevel = 1
+	}
+
+	maxLevel, err := strconv.Atoi(preview.conversion.getMacroParameter(macro, "maxLevel"))
+	if err != nil {
+		maxLevel = 7
+	}
+
+	list := newPreviewElement(atom.Ul, "class", "toc")
+
+	for _, heading := range preview.headings {
+		level := getPreviewHeadingLevel(heading)
+		if level < minLevel || level > maxLevel {
+			continue
+		}
+
+		var (
+			item = newPreviewElement(
+				atom.Li,
+				"style",
+				"margin-left: "+strconv.Itoa(level-minLevel)+"em",
+			)
+			text = strings.TrimSpace(goquery.NewDocumentFromNode(heading).Text())
+		)
+
+		if id := getPreviewAttr(heading, "id"); id != "" {
+			link := newPreviewElement(atom.A, "href", "#"+id)
+			link.AppendChild(newPreviewText(text))
+			item.AppendChild(link)
+		} else {
+			item.AppendChild(newPreviewText(text))
+		}
+
+		list.AppendChild(item)
+	}
+
+	return list
+}
+
+// renderLink returns the span, which text is the text of the link and which
+// tooltip tells where the link points to.
+func (preview *preview) renderLink(node *html.Node) *html.Node {
+	var (
+		selec   = goquery.NewDocumentFromNode(node).Selection
+		message Message
+		target  string
+		text    string
+	)
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+
+		switch child.Data {
+		case "ri:page":
+			text = getPreviewAttr(child, "ri:content-title")
+			message, target = MessageLinkPage, text
+
+			if space := getPreviewAttr(child, "ri:space-key"); space != "" {
+				target = space + ":" + text
+			}
+
+		case "ri:content-entity":
+			text = "#" + getPreviewAttr(child, "ri:content-id")
+			message, target = MessageLinkPage, text
+
+		case "ri:space":
+			text = getPreviewAttr(child, "ri:space-key")
+			message, target = MessageLinkSpace, text
+
+		case "ri:attachment":
+			text = getPreviewAttr(child, "ri:filename")
+			message, target = MessageLinkAttachment, text
+
+		case "ri:user":
+			text = getPreviewAttr(child, "ri:account-id")
+			if text == "" {
+				text = getPreviewAttr(child, "ri:userkey")
+			}
+
+			if text == "" {
+				text = getPreviewAttr(child, "ri:username")
+			}
+
+			message, target = MessageLinkUser, text
+		}
+	}
+
+	if anchor := getPreviewAttr(node, "ac:anchor"); anchor != "" {
+		target += "#" + anchor
+	}
+
+	if message != "" {
+		target = preview.formatMessage(message, map[string]string{"Target": target})
+	}
+
+	span := newPreviewElement(atom.Span, "class", "page-link", "title", target)
+
+	body := getStorageChild(selec, "ac:link-body")
+	if body.Length() == 0 {
+		body = getStorageChild(selec, "ac:plain-text-link-body")
+	}
+
+	if body.Length() > 0 {
+		node := body.Get(0)
+
+		for child := node.FirstChild; child != nil; child = node.FirstChild {
+			node.RemoveChild(child)
+			span.AppendChild(child)
+		}
+
+		preview.render(span)
+	}
+
+	if span.FirstChild == nil {
+		span.AppendChild(newPreviewText(text))
+	}
+
+	return span
+}
+
+// renderImage returns the image, which source is either the URL or the
+// name of the attached file.
+func (preview *preview) renderImage(node *html.Node) *html.Node {
+	image := newPreviewElement(
+		atom.Img,
+		"alt", getPreviewAttr(node, "ac:alt"),
+		"title", getPreviewAttr(node, "ac:title"),
+	)
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Data {
+		case "ri:url":
+			image.Attr = append(image.Attr, html.Attribute{Key: "src", Val: getPreviewAttr(child, "ri:value")})
+
+		case "ri:attachment":
+			image.Attr = append(image.Attr, html.Attribute{Key: "src", Val: getPreviewAttr(child, "ri:filename")})
+		}
+	}
+
+	return image
+}
+
+// renderPreviewEmoticon returns the emoji of the emoticon, or its name if
+// it's not known.
+func renderPreviewEmoticon(node *html.Node) *html.Node {
+	if fallback := getPreviewAttr(node, "ac:emoji-fallback"); fallback != "" {
+		return newPreviewText(fallback)
+	}
+
+	name := getPreviewAttr(node, "ac:name")
+
+	if emoticon, ok := dialect.GetEmoticon(name); ok && emoticon.Emoji != "" {
+		return newPreviewText(emoticon.Emoji)
+	}
+
+	return newPreviewText(":" + name + ":")
+}
+
+// getPreviewHeadingLevel returns the level of the heading, 0 if the node is
+// not a heading.
+func getPreviewHeadingLevel(node *html.Node) int {
+	if node.Type != html.ElementNode || len(node.Data) != 2 || node.Data[0] != 'h' {
+		return 0
+	}
+
+	level := int(node.Data[1] - '0')
+	if level < 1 || level > 6 {
+		return 0
+	}
+
+	return level
+}
+
+func getPreviewAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+// newPreviewElement returns the element with attributes given by pairs of
+// keys and values, attributes with empty values are skipped.
+func newPreviewElement(tag atom.Atom, attrs ...string) *html.Node {
+	node := &html.Node{Type: html.ElementNode, DataAtom: tag, Data: tag.String()}
+
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			node.Attr = append(node.Attr, html.Attribute{Key: attrs[i], Val: attrs[i+1]})
+		}
+	}
+
+	return node
+}
+
+func newPreviewText(text string) *html.Node {
+	return &html.Node{Type: html.TextNode, Data: text}
+}
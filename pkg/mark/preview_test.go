@@ -0,0 +1,110 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderPreview(t *testing.T) {
+	preview, err := RenderPreview(text(
+		`<ac:structured-macro ac:name="toc">`,
+		`<ac:parameter ac:name="maxLevel">2</ac:parameter>`,
+		`</ac:structured-macro>`,
+		`<h1 id="guide">Guide</h1>`,
+		`<h2 id="install">Install</h2>`,
+		`<h3 id="linux">Linux</h3>`,
+		`<p><ac:structured-macro ac:name="status">`,
+		`<ac:parameter ac:name="colour">Green</ac:parameter>`,
+		`<ac:parameter ac:name="title">Done</ac:parameter>`,
+		`</ac:structured-macro> <ac:emoticon ac:name="smile"/>`,
+		`<ac:link ac:anchor="top"><ri:page ri:space-key="OPS" ri:content-title="Plan"/></ac:link></p>`,
+		`<ac:structured-macro ac:name="expand">`,
+		`<ac:rich-text-body><p>Hidden</p></ac:rich-text-body>`,
+		`</ac:structured-macro>`,
+		`<ac:structured-macro ac:name="jira">`,
+		`<ac:parameter ac:name="key">OPS-1</ac:parameter>`,
+		`</ac:structured-macro>`,
+		`<ac:image ac:alt="Logo"><ri:attachment ri:filename="logo.png"/></ac:image>`,
+	))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Contains(
+		t,
+		preview,
+		text(
+			`<ul class="toc">`+
+				`<li style="margin-left: 0em"><a href="#guide">Guide</a></li>`+
+				`<li style="margin-left: 1em"><a href="#install">Install</a></li>`+
+				`</ul>`,
+			`<h1 id="guide">Guide</h1>`,
+			`<h2 id="install">Install</h2>`,
+			`<h3 id="linux">Linux</h3>`,
+			`<p><span class="status status-green">Done</span> 🙂`,
+			`<span class="page-link" title="Page: OPS:Plan#top">Plan</span></p>`,
+			`<details><summary>Click here to expand...</summary><p>Hidden</p></details>`,
+			`<div class="macro" title="jira macro">[jira]</div>`,
+			`<img alt="Logo" src="logo.png"/>`,
+		),
+	)
+	assert.NotContains(t, preview, "ac:")
+	assert.NotContains(t, preview, "ri:")
+}
+
+func TestCompile_Preview(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`# Install`,
+		``,
+		`> [!INFO] Careful`,
+		`> Read [the plan](confluence:///OPS/Plan) first.`,
+		``,
+		"```go collapse title main.go",
+		`func main() { if a < b {} }`,
+		"```",
+		``,
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile(markdown, lib, CompileOptions{Engine: engine})
+		if assert.NoError(t, err, engine) {
+			assert.Empty(t, result.Preview, engine)
+		}
+
+		result, err = Compile(markdown, lib, CompileOptions{Engine: engine, Preview: true})
+		if !assert.NoError(t, err, engine) {
+			continue
+		}
+
+		assert.Contains(t, result.HTML, `<ac:structured-macro ac:name="info">`, engine)
+		assert.Contains(t, result.Preview, "<!DOCTYPE html>", engine)
+		assert.Contains(t, result.Preview, `<h1 id="install">Install</h1>`, engine)
+		assert.Contains(
+			t,
+			result.Preview,
+			`<div class="admonition admonition-info"><p><strong>Careful</strong></p>`,
+			engine,
+		)
+		assert.Contains(
+			t,
+			result.Preview,
+			`<p>Read <span class="page-link" title="Page: OPS/Plan">the plan</span> first.</p>`,
+			engine,
+		)
+		assert.Contains(
+			t,
+			result.Preview,
+			`<details><summary>main.go</summary>`+"\n"+
+				`<div class="code-block"><div class="code-title">main.go</div>`+
+				`<pre class="code"><code class="language-go">func main() { if a &lt; b {} }</code></pre></div>`,
+			engine,
+		)
+	}
+}
@@ -0,0 +1,496 @@
+package mark
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/yuin/goldmark/ast"
+)
+
+// HTMLTagClass tells what Confluence does with the raw HTML tag written in
+// markdown, see ClassifyHTMLTag.
+type HTMLTagClass string
+
+const (
+	// HTMLTagSafe is kept by Confluence as is.
+	HTMLTagSafe HTMLTagClass = "safe"
+
+	// HTMLTagConverted is converted into Confluence constructs before
+	// saving, e.g. by handlers of the caller, see ConvertedHTMLTags.
+	HTMLTagConverted HTMLTagClass = "converted"
+
+	// HTMLTagStripped is silently removed by Confluence when the page is
+	// saved.
+	HTMLTagStripped HTMLTagClass = "stripped"
+)
+
+// SafeHTMLTags lists tags of the storage format which Confluence keeps when
+// the page is saved. Tags which are not listed in any of SafeHTMLTags,
+// ConvertedHTMLTags and StrippedHTMLTags, e.g. custom elements, are
+// stripped.
+var SafeHTMLTags = []string{
+	"a", "abbr", "b", "big", "blockquote", "br", "caption", "center", "cite",
+	"code", "col", "colgroup", "dd", "del", "dfn", "div", "dl", "dt", "em",
+	"font", "h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img", "ins",
+	"kbd", "li", "ol", "p", "pre", "q", "s", "samp", "small", "span",
+	"strike", "strong", "sub", "sup", "table", "tbody", "td", "tfoot", "th",
+	"thead", "time", "tr", "tt", "u", "ul", "var",
+}
+
+// ConvertedHTMLTags lists tags which are converted into Confluence
+// constructs before saving. The compiler converts details and summary into
+// the expand macro, see convertHTMLTags, other tags can be converted by
+// CompileOptions.Handlers.
+var ConvertedHTMLTags = []string{"details", "summary"}
+
+// StrippedHTMLTags lists tags which are stripped by Confluence even if
+// they're listed in SafeHTMLTags or ConvertedHTMLTags.
+var StrippedHTMLTags = []string{
+	"audio", "base", "button", "canvas", "embed", "form", "frame",
+	"frameset", "iframe", "input", "link", "meta", "noscript", "object",
+	"script", "select", "source", "style", "svg", "template", "textarea",
+	"video",
+}
+
+// rawTextHTMLTags are tags which content is stripped along with them, since
+// it's never shown as text.
+var rawTextHTMLTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"template": true,
+}
+
+// reHTMLTag matches opening, closing and self-closing tags, groups are the
+// slash of closing tags, the name and the rest of the name, which is a
+// colon or colonPlaceholder for tags of the storage format like ac:link.
+var reHTMLTag = regexp.MustCompile(
+	`<(/?)([A-Za-z][A-Za-z0-9]*(?:-[A-Za-z0-9]+)*)(:|` + colonPlaceholder + `)?(?:\s[^>]*)?/?>`,
+)
+
+// ClassifyHTMLTag returns what Confluence does with the tag of given name,
+// names are case insensitive. Tags of the storage format, like ac:link and
+// ri:page, are safe.
+func ClassifyHTMLTag(name string) HTMLTagClass {
+	name = strings.ToLower(name)
+
+	if strings.Contains(name, ":") {
+		return HTMLTagSafe
+	}
+
+	for _, tag := range StrippedHTMLTags {
+		if strings.EqualFold(tag, name) {
+			return HTMLTagStripped
+		}
+	}
+
+	for _, tag := range ConvertedHTMLTags {
+		if strings.EqualFold(tag, name) {
+			return HTMLTagConverted
+		}
+	}
+
+	for _, tag := range SafeHTMLTags {
+		if strings.EqualFold(tag, name) {
+			return HTMLTagSafe
+		}
+	}
+
+	return HTMLTagStripped
+}
+
+// htmlTag is the tag of raw HTML, Start and End are offsets of the tag in
+// the literal, including content of raw text tags like script. Names of
+// closing tags start with a slash.
+type htmlTag struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// findHTMLTags returns tags of raw HTML of given class, see
+// ClassifyHTMLTag. Tags inside HTML comments are ignored.
+func findHTMLTags(literal []byte, class HTMLTagClass) []htmlTag {
+	var (
+		tags   = []htmlTag{}
+		offset = 0
+	)
+
+	for offset < len(literal) {
+		rest := literal[offset:]
+
+		match := reHTMLTag.FindSubmatchIndex(rest)
+		if match == nil {
+			break
+		}
+
+		// tags in comments are not tags
+		if comment := bytes.Index(rest, []byte("<!--")); comment >= 0 &&
+			comment < match[0] {
+			end := bytes.Index(rest[comment+4:], []byte("-->"))
+			if end < 0 {
+				break
+			}
+
+			offset += comment + 4 + end + 3
+
+			continue
+		}
+
+		var (
+			closing = match[3] > match[2]
+			name    = string(rest[match[4]:match[5]])
+			start   = offset + match[0]
+			end     = offset + match[1]
+		)
+
+		offset = end
+
+		if match[7] > match[6] || ClassifyHTMLTag(name) != class {
+			continue
+		}
+
+		name = strings.ToLower(name)
+
+		if !closing && rawTextHTMLTags[name] {
+			closer := []byte("</" + name)
+
+			index := bytes.Index(bytes.ToLower(literal[end:]), closer)
+			if index >= 0 {
+				close := bytes.IndexByte(literal[end+index:], '>')
+				if close >= 0 {
+					end += index + close + 1
+				}
+			}
+
+			offset = end
+		}
+
+		if closing {
+			name = "/" + name
+		}
+
+		tags = append(tags, htmlTag{Name: name, Start: start, End: end})
+	}
+
+	return tags
+}
+
+// removeHTMLTags returns the literal without given tags.
+func removeHTMLTags(literal []byte, tags []htmlTag) []byte {
+	removed := make([]byte, 0, len(literal))
+
+	offset := 0
+	for _, tag := range tags {
+		removed = append(removed, literal[offset:tag.Start]...)
+		offset = tag.End
+	}
+
+	return append(removed, literal[offset:]...)
+}
+
+// expandHTMLTags are tags of the expand macro, which details and summary
+// tags are converted into.
+var expandHTMLTags = map[string]string{
+	"details":  `<ac:structured-macro ac:name="expand">`,
+	"summary":  `<ac:parameter ac:name="title">`,
+	"/summary": `</ac:parameter><ac:rich-text-body>`,
+	"/details": `</ac:rich-text-body></ac:structured-macro>`,
+}
+
+// convertHTMLTags converts details and summary tags of literals of raw HTML
+// into the expand macro, the summary is the title of the macro. Literals
+// are given in document order, since details and summary tags are usually
+// written in different literals, and are replaced in place. Nothing is
+// converted unless both tags are classified as converted, see
+// ClassifyHTMLTag.
+func convertHTMLTags(literals [][]byte) {
+	if ClassifyHTMLTag("details") != HTMLTagConverted ||
+		ClassifyHTMLTag("summary") != HTMLTagConverted {
+		return
+	}
+
+	type literalTag struct {
+		htmlTag
+		literal int
+	}
+
+	tags := []literalTag{}
+
+	for index, literal := range literals {
+		for _, tag := range findHTMLTags(literal, HTMLTagConverted) {
+			if _, ok := expandHTMLTags[tag.Name]; ok {
+				tags = append(tags, literalTag{htmlTag: tag, literal: index})
+			}
+		}
+	}
+
+	// tags are replaced from the end, so offsets of other tags of the same
+	// literal stay valid
+	for index := len(tags) - 1; index >= 0; index-- {
+		var (
+			tag         = tags[index]
+			replacement = expandHTMLTags[tag.Name]
+		)
+
+		// body of the macro follows the title if there is one
+		if tag.Name == "details" &&
+			(index+1 == len(tags) || tags[index+1].Name != "summary") {
+			replacement += `<ac:rich-text-body>`
+		}
+
+		literal := literals[tag.literal]
+
+		converted := make([]byte, 0, len(literal)+len(replacement))
+		converted = append(converted, literal[:tag.Start]...)
+		converted = append(converted, replacement...)
+		converted = append(converted, literal[tag.End:]...)
+
+		literals[tag.literal] = converted
+	}
+}
+
+// reportStrippedHTMLTags warns about tags which Confluence strips, line is
+// the line of the beginning of the literal. Literal is returned without such
+// tags if strip is true.
+func reportStrippedHTMLTags(
+	literal []byte,
+	strip bool,
+	line func(offset int) int,
+	warn func(line int, format string, args ...interface{}),
+) ([]byte, bool) {
+	tags := findHTMLTags(literal, HTMLTagStripped)
+	if len(tags) == 0 {
+		return literal, false
+	}
+
+	for _, tag := range tags {
+		// elements are reported once by opening tags, closing tags are
+		// removed along with them
+		if strings.HasPrefix(tag.Name, "/") {
+			continue
+		}
+
+		if strip {
+			warn(
+				line(tag.Start),
+				"raw HTML tag <%s> is stripped by Confluence, removing it",
+				tag.Name,
+			)
+		} else {
+			warn(
+				line(tag.Start),
+				"raw HTML tag <%s> is stripped by Confluence",
+				tag.Name,
+			)
+		}
+	}
+
+	if !strip {
+		return literal, false
+	}
+
+	return removeHTMLTags(literal, tags), true
+}
+
+// checkRawHTML converts tags of HTML blocks and spans, see convertHTMLTags,
+// and reports tags which Confluence strips when the page is saved, so the
+// stored page looks different from the compiled one. Such tags are removed
+// from literals of nodes if CompileOptions.StripHTML is set. Locate returns
+// the line of the node.
+func checkRawHTML(
+	document *bf.Node,
+	strip bool,
+	locate func(node *bf.Node) int,
+	warn func(line int, format string, args ...interface{}),
+) {
+	var (
+		nodes    = []*bf.Node{}
+		literals = [][]byte{}
+	)
+
+	Walk(document, func(node *bf.Node) bf.WalkStatus {
+		if node.Type == bf.HTMLBlock || node.Type == bf.HTMLSpan {
+			nodes = append(nodes, node)
+			literals = append(literals, node.Literal)
+		}
+
+		return bf.GoToNext
+	})
+
+	convertHTMLTags(literals)
+
+	paragraphs := []*bf.Node{}
+
+	for index, node := range nodes {
+		node := node
+
+		if node.Type == bf.HTMLSpan && node.Parent.Type == bf.Paragraph &&
+			!bytes.Equal(node.Literal, literals[index]) {
+			paragraphs = append(paragraphs, node.Parent)
+		}
+
+		// tags are located by lines of the block
+		start := -1
+
+		line := func(offset int) int {
+			if start < 0 {
+				start = locate(node)
+			}
+
+			if start == 0 {
+				return 0
+			}
+
+			return start + bytes.Count(literals[index][:offset], []byte("\n"))
+		}
+
+		literal, _ := reportStrippedHTMLTags(literals[index], strip, line, warn)
+
+		// nodes are located by literals written in markdown, so they're
+		// replaced only after reporting
+		node.Literal = literal
+	}
+
+	// blackfriday puts lines of raw HTML, which it doesn't know as block
+	// tags, into paragraphs, but the macro can't be within paragraph
+	for _, paragraph := range paragraphs {
+		if paragraph.Parent != nil {
+			unwrapHTMLParagraph(paragraph)
+		}
+	}
+}
+
+// unwrapHTMLParagraph replaces the paragraph with its children if it
+// starts and ends with raw HTML, e.g. the title of the expand macro.
+func unwrapHTMLParagraph(paragraph *bf.Node) {
+	isBlank := func(node *bf.Node) bool {
+		return node.Type == bf.Softbreak ||
+			node.Type == bf.Text && len(bytes.TrimSpace(node.Literal)) == 0
+	}
+
+	first := paragraph.FirstChild
+	for first != nil && isBlank(first) {
+		first = first.Next
+	}
+
+	last := paragraph.LastChild
+	for last != nil && isBlank(last) {
+		last = last.Prev
+	}
+
+	if first == nil || first.Type != bf.HTMLSpan || last.Type != bf.HTMLSpan {
+		return
+	}
+
+	for paragraph.FirstChild != nil {
+		child := paragraph.FirstChild
+
+		child.Unlink()
+		paragraph.InsertBefore(child)
+	}
+
+	paragraph.Unlink()
+}
+
+// checkRawHTML checks raw HTML of goldmark documents like checkRawHTML does
+// for blackfriday ones, converted literals and literals without stripped
+// tags are stored in the document.
+func (transformer *goldmarkTransformer) checkRawHTML(
+	document *ast.Document,
+	source []byte,
+) {
+	var (
+		strip = transformer.opts.StripHTML
+		state = transformer.state
+
+		nodes    = []ast.Node{}
+		literals = [][]byte{}
+		offsets  = [][]int{}
+	)
+
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch node := node.(type) {
+		case *ast.RawHTML:
+			var lines []int
+			if node.Segments.Len() > 0 {
+				lines = []int{node.Segments.At(0).Start}
+			}
+
+			nodes = append(nodes, node)
+			literals = append(literals, getRawHTML(node, source))
+			offsets = append(offsets, lines)
+
+		case *ast.HTMLBlock:
+			literal, lines := getGoldmarkHTMLBlock(node, source)
+
+			nodes = append(nodes, node)
+			literals = append(literals, literal)
+			offsets = append(offsets, lines)
+
+		default:
+			return ast.WalkContinue, nil
+		}
+
+		return ast.WalkSkipChildren, nil
+	})
+
+	converted := append([][]byte{}, literals...)
+
+	convertHTMLTags(converted)
+
+	for index, node := range nodes {
+		var (
+			literal = converted[index]
+			lines   = offsets[index]
+		)
+
+		// lines of the block are contiguous in the source, so offsets of
+		// lines are enough to locate tags
+		line := func(offset int) int {
+			if len(lines) == 0 {
+				return 0
+			}
+
+			index := bytes.Count(literal[:offset], []byte("\n"))
+			if index >= len(lines) {
+				index = len(lines) - 1
+			}
+
+			return state.line(lines[index])
+		}
+
+		stripped, ok := reportStrippedHTMLTags(literal, strip, line, state.warn)
+		if ok || !bytes.Equal(literal, literals[index]) {
+			transformer.document.html[node] = stripped
+		}
+	}
+}
+
+// getGoldmarkHTMLBlock returns the literal of the HTML block as goldmark
+// renders it and offsets of its lines in the source.
+func getGoldmarkHTMLBlock(node *ast.HTMLBlock, source []byte) ([]byte, []int) {
+	var (
+		literal []byte
+		offsets []int
+	)
+
+	for i := 0; i < node.Lines().Len(); i++ {
+		line := node.Lines().At(i)
+
+		literal = append(literal, line.Value(source)...)
+		offsets = append(offsets, line.Start)
+	}
+
+	if node.HasClosure() {
+		literal = append(literal, node.ClosureLine.Value(source)...)
+		offsets = append(offsets, node.ClosureLine.Start)
+	}
+
+	return literal, offsets
+}
@@ -0,0 +1,177 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyHTMLTag(t *testing.T) {
+	assert.Equal(t, HTMLTagSafe, ClassifyHTMLTag("table"))
+	assert.Equal(t, HTMLTagSafe, ClassifyHTMLTag("SPAN"))
+	assert.Equal(t, HTMLTagSafe, ClassifyHTMLTag("ac:link"))
+	assert.Equal(t, HTMLTagStripped, ClassifyHTMLTag("script"))
+	assert.Equal(t, HTMLTagStripped, ClassifyHTMLTag("my-widget"))
+	assert.Equal(t, HTMLTagConverted, ClassifyHTMLTag("details"))
+
+	defer func(safe, converted []string) {
+		SafeHTMLTags = safe
+		ConvertedHTMLTags = converted
+	}(SafeHTMLTags, ConvertedHTMLTags)
+
+	ConvertedHTMLTags = []string{"my-widget"}
+	SafeHTMLTags = append([]string{"iframe"}, SafeHTMLTags...)
+
+	assert.Equal(t, HTMLTagConverted, ClassifyHTMLTag("my-widget"))
+	assert.Equal(t, HTMLTagStripped, ClassifyHTMLTag("details"))
+
+	// denied tags are stripped anyway
+	assert.Equal(t, HTMLTagStripped, ClassifyHTMLTag("iframe"))
+}
+
+func TestCompile_RawHTML(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`# Page`,
+		``,
+		`<div class="note">`,
+		`<style>.note { color: red; }</style>`,
+		`<my-widget>Widget</my-widget>`,
+		`<!-- <iframe> -->`,
+		`</div>`,
+		``,
+		`Text with <b>bold</b> and <blink>blinking</blink> words`,
+		`and <ac:emoticon ac:name="smile" />.`,
+		``,
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile(markdown, lib, CompileOptions{Engine: engine})
+		if assert.NoError(t, err, engine) {
+			assert.Contains(t, result.HTML, `<style>.note { color: red; }</style>`, engine)
+			assert.Equal(
+				t,
+				[]Diagnostic{
+					{Line: 4, Severity: SeverityWarning, Message: "raw HTML tag <style> is stripped by Confluence"},
+					{Line: 5, Severity: SeverityWarning, Message: "raw HTML tag <my-widget> is stripped by Confluence"},
+					{Line: 9, Severity: SeverityWarning, Message: "raw HTML tag <blink> is stripped by Confluence"},
+				},
+				result.Diagnostics,
+				engine,
+			)
+		}
+
+		result, err = Compile(markdown, lib, CompileOptions{Engine: engine, StripHTML: true})
+		if assert.NoError(t, err, engine) {
+			assert.Contains(
+				t,
+				result.HTML,
+				text(
+					`<div class="note">`,
+					``,
+					`Widget`,
+					`<!-- <iframe> -->`,
+					`</div>`,
+				),
+				engine,
+			)
+			assert.Contains(
+				t,
+				result.HTML,
+				`<p>Text with <b>bold</b> and blinking words`+"\n"+
+					`and <ac:emoticon ac:name="smile" />.</p>`,
+				engine,
+			)
+			assert.Len(t, result.Diagnostics, 3, engine)
+			assert.Empty(t, ValidateStorage(result.HTML), engine)
+		}
+
+		_, err = Compile(markdown, lib, CompileOptions{Engine: engine, Strict: true})
+		assert.Error(t, err, engine)
+	}
+}
+
+func TestCompile_Details(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`<details><summary>More</summary>`,
+		``,
+		`Hidden **text**`,
+		``,
+		`</details>`,
+		``,
+		`<details>`,
+		``,
+		`No title`,
+		``,
+		`</details>`,
+		``,
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile(markdown, lib, CompileOptions{Engine: engine})
+		if !assert.NoError(t, err, engine) {
+			continue
+		}
+
+		assert.Contains(
+			t,
+			result.HTML,
+			`<ac:structured-macro ac:name="expand">`+
+				`<ac:parameter ac:name="title">More</ac:parameter>`+
+				`<ac:rich-text-body>`,
+			engine,
+		)
+		assert.Contains(
+			t,
+			result.HTML,
+			`<ac:structured-macro ac:name="expand"><ac:rich-text-body>`,
+			engine,
+		)
+		assert.Contains(t, result.HTML, `<p>Hidden <strong>text</strong></p>`, engine)
+		assert.Contains(t, result.HTML, `</ac:rich-text-body></ac:structured-macro>`, engine)
+		assert.NotContains(t, result.HTML, `details`, engine)
+		assert.Empty(t, result.Diagnostics, engine)
+		assert.Empty(t, ValidateStorage(result.HTML), engine)
+	}
+}
+
+func TestCompile_DetailsNotConverted(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(converted []string) {
+		ConvertedHTMLTags = converted
+	}(ConvertedHTMLTags)
+
+	ConvertedHTMLTags = []string{"summary"}
+
+	result, err := Compile(
+		[]byte("<details><summary>More</summary>Hidden</details>\n"),
+		lib,
+		CompileOptions{},
+	)
+	if assert.NoError(t, err) {
+		assert.Contains(t, result.HTML, `<details><summary>More</summary>`)
+		assert.Equal(
+			t,
+			[]Diagnostic{{
+				Line:     1,
+				Severity: SeverityWarning,
+				Message:  "raw HTML tag <details> is stripped by Confluence",
+			}},
+			result.Diagnostics,
+		)
+	}
+}
@@ -0,0 +1,135 @@
+package mark
+
+import (
+	"errors"
+	"strings"
+	"text/template"
+
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/reconquest/karma-go"
+)
+
+// DefaultRedirectMessage is the template of the message of redirect stubs,
+// see RedirectStub.Message.
+const DefaultRedirectMessage = `This page has moved to {{ .Link }}, ` +
+	`update your links and bookmarks.`
+
+// RedirectStub configures the stub page, which is kept at the old title of
+// the renamed page, see CompileRedirectStub.
+type RedirectStub struct {
+	// Message is Go template of the message of the stub, which is storage
+	// format written as the paragraph of info macro, DefaultRedirectMessage
+	// by default. OldTitle and Title fields are escaped titles of the page
+	// before and after renaming, Link is ac:link element pointing to the
+	// renamed page.
+	Message string
+
+	// Redirect writes ac:redirect template after the message, so readers
+	// are redirected to the renamed page automatically. The macro is
+	// provided by the app, which must be installed.
+	Redirect bool
+}
+
+// CompileRedirectStub returns storage format of the stub page, which is kept
+// at the title of the page before it's renamed, so links to the page from
+// outside of Confluence, which have titles in URLs, still lead to it. Old
+// and renamed are metadata of the page before and after renaming, e.g. with
+// titles given by ResolveTitle. The space of the renamed page is written in
+// links only if it differs from the old one.
+func CompileRedirectStub(
+	old *Meta,
+	renamed *Meta,
+	stdlib *stdlib.Lib,
+	stub RedirectStub,
+) (string, error) {
+	if old == nil || old.Title == "" {
+		return "", errors.New("title of the page before renaming is not set")
+	}
+
+	if renamed == nil || renamed.Title == "" {
+		return "", errors.New("title of the renamed page is not set")
+	}
+
+	space := renamed.Space
+	if space == old.Space {
+		space = ""
+	}
+
+	if space == "" && renamed.Title == old.Title {
+		return "", errors.New("title of the page is not changed")
+	}
+
+	link := pageLink{Space: space, Title: renamed.Title}
+
+	var target strings.Builder
+
+	link.writeOpening(&target, old.Space)
+	target.WriteString(escape.EscapeText(renamed.Title))
+	link.writeClosing(&target)
+
+	message := stub.Message
+	if message == "" {
+		message = DefaultRedirectMessage
+	}
+
+	tmpl, err := template.New("redirect").Option("missingkey=error").Parse(message)
+	if err != nil {
+		return "", karma.Format(err, "unable to parse redirect message template")
+	}
+
+	var body strings.Builder
+
+	body.WriteString("<p>")
+
+	err = tmpl.Execute(&body, map[string]string{
+		"OldTitle": escape.EscapeAttr(old.Title),
+		"Title":    escape.EscapeAttr(renamed.Title),
+		"Link":     target.String(),
+	})
+	if err != nil {
+		return "", karma.Format(err, "unable to execute redirect message template")
+	}
+
+	body.WriteString("</p>\n")
+
+	var output strings.Builder
+
+	err = stdlib.Templates.ExecuteTemplate(
+		&output,
+		"ac:box",
+		struct {
+			Name  string
+			Icon  string
+			Title string
+			Body  string
+		}{
+			"info",
+			"true",
+			"",
+			body.String(),
+		},
+	)
+	if err != nil {
+		return "", karma.Format(err, "unable to render redirect stub")
+	}
+
+	if stub.Redirect {
+		err = stdlib.Templates.ExecuteTemplate(
+			&output,
+			"ac:redirect",
+			struct {
+				Page  string
+				Space string
+			}{
+				escape.EscapeAttr(renamed.Title),
+				escape.EscapeAttr(space),
+			},
+		)
+		if err != nil {
+			return "", karma.Format(err, "unable to render redirect macro")
+		}
+	}
+
+	return output.String(), nil
+}
@@ -0,0 +1,59 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileRedirectStub(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := &Meta{Space: "DOC", Title: "R&D Notes"}
+	renamed := &Meta{Space: "DOC", Title: `R&D <Notes> "v2"`}
+
+	html, err := CompileRedirectStub(old, renamed, lib, RedirectStub{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, text(
+			`<ac:structured-macro ac:name="info">`,
+			`<ac:parameter ac:name="icon">true</ac:parameter>`,
+			`<ac:rich-text-body><p>This page has moved to `+
+				`<ac:link><ri:page ri:content-title="R&amp;D &lt;Notes&gt; &#34;v2&#34;"/>`+
+				`<ac:link-body>R&amp;D &lt;Notes&gt; "v2"</ac:link-body></ac:link>, `+
+				`update your links and bookmarks.</p>`,
+			`</ac:rich-text-body>`,
+			`</ac:structured-macro>`,
+			``,
+		), html)
+		assert.Empty(t, ValidateStorage(html))
+	}
+
+	renamed.Space = "ARCHIVE"
+
+	html, err = CompileRedirectStub(old, renamed, lib, RedirectStub{
+		Message:  `{{ .OldTitle }} is now {{ .Link }}.`,
+		Redirect: true,
+	})
+	if assert.NoError(t, err) {
+		assert.Contains(t, html, `<p>R&amp;D Notes is now <ac:link>`+
+			`<ri:page ri:space-key="ARCHIVE" ri:content-title="R&amp;D &lt;Notes&gt; &#34;v2&#34;"/>`)
+		assert.Contains(t, html, text(
+			`<ac:structured-macro ac:name="redirect">`,
+			`<ac:parameter ac:name="location"><ac:link>`+
+				`<ri:page ri:space-key="ARCHIVE" ri:content-title="R&amp;D &lt;Notes&gt; &quot;v2&quot;"/>`+
+				`</ac:link></ac:parameter>`,
+			`</ac:structured-macro>`,
+		))
+		assert.Empty(t, ValidateStorage(html))
+	}
+
+	_, err = CompileRedirectStub(old, &Meta{Space: "DOC", Title: old.Title}, lib, RedirectStub{})
+	assert.EqualError(t, err, "title of the page is not changed")
+
+	_, err = CompileRedirectStub(old, renamed, lib, RedirectStub{Message: `{{ .Page }}`})
+	assert.Error(t, err)
+}
@@ -0,0 +1,256 @@
+// Package sourcemap maps lines of markdown changed by pre-processing, e.g.
+// includes and macros, back to lines of the original file.
+package sourcemap
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// Map maps lines of the current contents to lines of the original file.
+// Lines produced by replacements are mapped to the line where the replaced
+// text, e.g. include directive, starts. Lines spliced from other files by
+// Include are mapped to lines of that files.
+//
+// Methods of nil Map are no-op, lines are mapped as is.
+type Map struct {
+	// lines are 1-based original lines of each line of the contents
+	lines []int
+
+	// files are files of each line of the contents, empty for lines of the
+	// original file; it's nil if nothing is included
+	files []string
+}
+
+// New creates the map of contents which are not changed yet.
+func New(contents []byte) *Map {
+	lines := make([]int, bytes.Count(contents, []byte("\n"))+1)
+	for index := range lines {
+		lines[index] = index + 1
+	}
+
+	return &Map{lines: lines}
+}
+
+// Clone returns a copy of the map, which can be changed independently.
+func (sources *Map) Clone() *Map {
+	if sources == nil {
+		return nil
+	}
+
+	clone := &Map{lines: append([]int{}, sources.lines...)}
+	if sources.files != nil {
+		clone.files = append([]string{}, sources.files...)
+	}
+
+	return clone
+}
+
+// Line returns the original line of the given 1-based line of contents.
+func (sources *Map) Line(line int) int {
+	if sources == nil || line < 1 || len(sources.lines) == 0 {
+		return line
+	}
+
+	return sources.lines[sources.index(line)]
+}
+
+// File returns the file which the given 1-based line of contents is
+// included from, it's empty for lines of the original file.
+func (sources *Map) File(line int) string {
+	if sources == nil || line < 1 || sources.files == nil {
+		return ""
+	}
+
+	return sources.files[sources.index(line)]
+}
+
+func (sources *Map) index(line int) int {
+	if line > len(sources.lines) {
+		return len(sources.lines) - 1
+	}
+
+	return line - 1
+}
+
+// Replace updates the map for contents where bytes from start to end are
+// replaced with the replacement. Offsets are offsets in contents before the
+// replacement.
+func (sources *Map) Replace(
+	contents []byte,
+	start int,
+	end int,
+	replacement []byte,
+) {
+	if sources == nil {
+		return
+	}
+
+	first := bytes.Count(contents[:start], []byte("\n"))
+	if first >= len(sources.lines) {
+		return
+	}
+
+	line, file := sources.lines[first], sources.file(first)
+
+	sources.splice(contents, start, end, replacement, func(int) (int, string) {
+		return line, file
+	})
+}
+
+// Include updates the map for contents where bytes from start to end are
+// replaced with the replacement, which is the contents of the file mapped
+// by included. Lines of the replacement are mapped to lines of the file,
+// lines of included which are not included from other files get the file.
+func (sources *Map) Include(
+	contents []byte,
+	start int,
+	end int,
+	replacement []byte,
+	included *Map,
+	file string,
+) {
+	if sources == nil {
+		return
+	}
+
+	sources.splice(contents, start, end, replacement, func(index int) (int, string) {
+		if from := included.File(index + 1); from != "" {
+			return included.Line(index + 1), from
+		}
+
+		return included.Line(index + 1), file
+	})
+}
+
+// splice replaces lines of the replaced bytes with lines of the replacement,
+// which are mapped by origin from 0-based line of the replacement.
+func (sources *Map) splice(
+	contents []byte,
+	start int,
+	end int,
+	replacement []byte,
+	origin func(index int) (int, string),
+) {
+	var (
+		first = bytes.Count(contents[:start], []byte("\n"))
+		last  = first + bytes.Count(contents[start:end], []byte("\n"))
+		count = bytes.Count(replacement, []byte("\n"))
+	)
+
+	if last >= len(sources.lines) {
+		return
+	}
+
+	var (
+		size  = len(sources.lines) - (last - first) + count
+		lines = make([]int, 0, size)
+
+		// files are allocated only if some lines are included
+		files []string
+	)
+
+	add := func(line int, file string) {
+		if file != "" && files == nil {
+			files = make([]string, len(lines), size)
+		}
+
+		lines = append(lines, line)
+
+		if files != nil {
+			files = append(files, file)
+		}
+	}
+
+	keep := func(from, to int) {
+		if sources.files != nil && files == nil {
+			files = make([]string, len(lines), size)
+		}
+
+		lines = append(lines, sources.lines[from:to]...)
+
+		switch {
+		case sources.files != nil:
+			files = append(files, sources.files[from:to]...)
+		case files != nil:
+			files = append(files, make([]string, to-from)...)
+		}
+	}
+
+	keep(0, first)
+
+	for index := 0; index < count; index++ {
+		add(origin(index))
+	}
+
+	// the line after the replacement consists of the rest of the last
+	// replaced line only if nothing is left before it
+	switch {
+	case len(replacement) > 0 && replacement[len(replacement)-1] == '\n',
+		len(replacement) == 0 && (start == 0 || contents[start-1] == '\n'):
+		keep(last, last+1)
+
+	case len(replacement) > 0:
+		add(origin(count))
+
+	default:
+		add(sources.lines[first], sources.file(first))
+	}
+
+	keep(last+1, len(sources.lines))
+
+	sources.lines = lines
+	sources.files = files
+}
+
+// file returns the file of the given 0-based line.
+func (sources *Map) file(index int) string {
+	if sources.files == nil {
+		return ""
+	}
+
+	return sources.files[index]
+}
+
+// ReplaceAllFunc works like regexp.ReplaceAllFunc and updates the map for
+// the result.
+func ReplaceAllFunc(
+	re *regexp.Regexp,
+	contents []byte,
+	sources *Map,
+	replace func([]byte) []byte,
+) []byte {
+	if sources == nil {
+		return re.ReplaceAllFunc(contents, replace)
+	}
+
+	var (
+		matches      = re.FindAllIndex(contents, -1)
+		replacements = make([][]byte, len(matches))
+		result       = make([]byte, 0, len(contents))
+		offset       = 0
+	)
+
+	for index, match := range matches {
+		replacements[index] = replace(contents[match[0]:match[1]])
+
+		result = append(result, contents[offset:match[0]]...)
+		result = append(result, replacements[index]...)
+
+		offset = match[1]
+	}
+
+	result = append(result, contents[offset:]...)
+
+	// offsets of the following matches are kept by replacing from the end
+	for index := len(matches) - 1; index >= 0; index-- {
+		sources.Replace(
+			contents,
+			matches[index][0],
+			matches[index][1],
+			replacements[index],
+		)
+	}
+
+	return result
+}
@@ -0,0 +1,118 @@
+package sourcemap
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lines(sources *Map, count int) []int {
+	result := []int{}
+	for line := 1; line <= count; line++ {
+		result = append(result, sources.Line(line))
+	}
+
+	return result
+}
+
+func TestReplaceAllFunc(t *testing.T) {
+	var (
+		re       = regexp.MustCompile(`(?s)<!-- Include: (\w+)(\n.*?)? -->`)
+		contents = []byte(strings.Join([]string{
+			"a",
+			"<!-- Include: foo",
+			"     Key: value -->",
+			"b",
+			"c <!-- Include: bar --> d",
+			"e",
+		}, "\n"))
+		sources = New(contents)
+	)
+
+	result := ReplaceAllFunc(re, contents, sources, func(match []byte) []byte {
+		if strings.Contains(string(match), "foo") {
+			return []byte("1\n2\n3")
+		}
+
+		return []byte("x\ny")
+	})
+
+	assert.Equal(t, strings.Join([]string{
+		"a",
+		"1",
+		"2",
+		"3",
+		"b",
+		"c x",
+		"y d",
+		"e",
+	}, "\n"), string(result))
+	assert.Equal(t, []int{1, 2, 2, 2, 4, 5, 5, 6}, lines(sources, 8))
+}
+
+func TestReplace(t *testing.T) {
+	contents := []byte("<!-- Title: a -->\n<!-- Space: b -->\n\nc\nd")
+
+	sources := New(contents)
+	sources.Replace(contents, 0, strings.Index(string(contents), "\nc")+1, nil)
+
+	assert.Equal(t, []int{4, 5}, lines(sources, 2))
+
+	sources = New(contents)
+	sources.Replace(contents, 0, len(contents), []byte("x\n"))
+
+	assert.Equal(t, []int{1, 5}, lines(sources, 2))
+}
+
+func TestMap_Nil(t *testing.T) {
+	var sources *Map
+
+	sources.Replace([]byte("a\nb"), 0, 1, []byte("c\nd"))
+
+	assert.Nil(t, sources.Clone())
+	assert.Equal(t, 3, sources.Line(3))
+}
+
+func TestInclude(t *testing.T) {
+	var (
+		included = []byte("<!-- Include: bar.md -->\nb\nc\n")
+		nested   = []byte("x\ny\n")
+		contents = []byte("a\n\n<!-- Include: foo.md -->\nd")
+	)
+
+	sources := New(included)
+	sources.Include(included, 0, len("<!-- Include: bar.md -->"), nested, New(nested), "bar.md")
+
+	included = append(append([]byte{}, nested...), included[len("<!-- Include: bar.md -->"):]...)
+	assert.Equal(t, "x\ny\n\nb\nc\n", string(included))
+
+	root := New(contents)
+	start := strings.Index(string(contents), "<!--")
+	end := strings.Index(string(contents), "\nd")
+	root.Include(contents, start, end, included, sources, "foo.md")
+
+	// a, "", x, y, "", b, c, "", d
+	assert.Equal(t, []int{1, 2, 1, 2, 1, 2, 3, 3, 4}, lines(root, 9))
+
+	files := []string{}
+	for line := 1; line <= 9; line++ {
+		files = append(files, root.File(line))
+	}
+
+	assert.Equal(
+		t,
+		[]string{"", "", "bar.md", "bar.md", "foo.md", "foo.md", "foo.md", "", ""},
+		files,
+	)
+
+	// files are not shared with clones
+	clone := root.Clone()
+	clone.Replace(contents, 0, 2, nil)
+	assert.Equal(t, "bar.md", root.File(3))
+	assert.Equal(t, "bar.md", clone.File(2))
+
+	assert.Equal(t, "", New(contents).File(1))
+	assert.Equal(t, "", (*Map)(nil).File(1))
+}
@@ -0,0 +1,79 @@
+package mark
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// MaxSpaceKeyLength is the maximum length of space keys.
+const MaxSpaceKeyLength = 255
+
+// ErrInvalidSpaceKey is matched by errors of ValidateSpaceKey.
+var ErrInvalidSpaceKey = errors.New("invalid space key")
+
+var (
+	reSpaceKey = regexp.MustCompile(`^[A-Z0-9]+$`)
+
+	// personal spaces are keyed by user names or account ids
+	rePersonalSpaceKey = regexp.MustCompile(`^~[A-Za-z0-9._@-]+$`)
+)
+
+// ValidateSpaceKey checks that the key is a valid Confluence space key:
+// uppercase letters and digits, e.g. ENG, or a key of personal space, which
+// is the user name prefixed with tilde, e.g. ~jdoe. Keys can't be longer
+// than MaxSpaceKeyLength.
+func ValidateSpaceKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("%w: key is empty", ErrInvalidSpaceKey)
+
+	case len(key) > MaxSpaceKeyLength:
+		return fmt.Errorf(
+			"%w: key is longer than %d characters",
+			ErrInvalidSpaceKey,
+			MaxSpaceKeyLength,
+		)
+
+	case !reSpaceKey.MatchString(key) && !rePersonalSpaceKey.MatchString(key):
+		return fmt.Errorf(
+			"%w %q: only uppercase letters and digits are allowed",
+			ErrInvalidSpaceKey,
+			key,
+		)
+	}
+
+	return nil
+}
+
+// getSpace returns the space the document is published to: Meta.Space or
+// DefaultSpace if the document doesn't specify it.
+func (opts CompileOptions) getSpace() string {
+	if opts.Meta != nil && opts.Meta.Space != "" {
+		return opts.Meta.Space
+	}
+
+	return opts.DefaultSpace
+}
+
+// reportSpace reports the invalid space key, and the missing one in strict
+// mode.
+func (state *compilation) reportSpace(opts CompileOptions) {
+	space := opts.getSpace()
+
+	if space == "" {
+		if state.strict {
+			state.report(
+				0,
+				SeverityError,
+				"space is not set, neither Space header nor default space is given",
+			)
+		}
+
+		return
+	}
+
+	if err := ValidateSpaceKey(space); err != nil {
+		state.warn(0, "%s", err)
+	}
+}
@@ -0,0 +1,116 @@
+package mark
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSpaceKey(t *testing.T) {
+	for _, key := range []string{"ENG", "DOC2", "~jdoe", "~jane.doe@example.com"} {
+		assert.NoError(t, ValidateSpaceKey(key), key)
+	}
+
+	for _, key := range []string{
+		"",
+		"eng",
+		"ENG DOC",
+		"ENG-1",
+		"~",
+		strings.Repeat("A", MaxSpaceKeyLength+1),
+	} {
+		err := ValidateSpaceKey(key)
+		assert.True(t, errors.Is(err, ErrInvalidSpaceKey), key)
+	}
+
+	_, _, err := ExtractMeta([]byte(text(
+		`<!-- Title: Page -->`,
+		`<!-- Space: eng -->`,
+	)))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid Space header on line 2`)
+		assert.Contains(t, err.Error(), `invalid space key "eng"`)
+	}
+}
+
+func TestCompile_Space(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`Published to ${space}, ${unknown} is kept.`,
+		``,
+		`<!-- if: space == "ENG" -->`,
+		`Engineering only.`,
+		`<!-- endif -->`,
+	))
+
+	compile := func(opts CompileOptions) (*CompileResult, error) {
+		opts.Logger = &testLogger{}
+
+		return Compile(markdown, lib, opts)
+	}
+
+	result, err := compile(CompileOptions{DefaultSpace: "ENG"})
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		text(
+			`<p>Published to ENG, ${unknown} is kept.</p>`,
+			``,
+			`<p>Engineering only.</p>`,
+			``,
+		),
+		result.HTML,
+	)
+
+	// the header takes precedence over the default space
+	result, err = compile(CompileOptions{
+		Meta:         &Meta{Space: "DOC"},
+		DefaultSpace: "ENG",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "<p>Published to DOC, ${unknown} is kept.</p>\n", result.HTML)
+
+	// and the variable over the space
+	result, err = compile(CompileOptions{
+		DefaultSpace:      "ENG",
+		Vars:              map[string]string{"space": "Engineering"},
+		KeepUndefinedVars: true,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, result.HTML, "Published to Engineering,")
+
+	// undefined variables are an error once variables are defined
+	_, err = compile(CompileOptions{
+		DefaultSpace: "ENG",
+		Vars:         map[string]string{"cluster": "staging"},
+	})
+	assert.Error(t, err)
+
+	result, err = compile(CompileOptions{DefaultSpace: "eng"})
+	assert.NoError(t, err)
+	if assert.Len(t, result.Diagnostics, 1) {
+		assert.Equal(t, SeverityWarning, result.Diagnostics[0].Severity)
+		assert.Contains(t, result.Diagnostics[0].Message, `invalid space key "eng"`)
+	}
+
+	_, err = compile(CompileOptions{})
+	assert.NoError(t, err)
+
+	result, err = compile(CompileOptions{Strict: true})
+	assert.Error(t, err)
+	assert.Equal(
+		t,
+		[]Diagnostic{{
+			Severity: SeverityError,
+			Message:  "space is not set, neither Space header nor default space is given",
+		}},
+		result.Diagnostics,
+	)
+}
@@ -0,0 +1,322 @@
+package mark
+
+import (
+	"bytes"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+)
+
+// SubDocument is the part of markdown split by SplitDocument, which is
+// published as a separate page.
+type SubDocument struct {
+	// Title is the text of the heading the sub-document starts with, or the
+	// title of the whole document for the parent one.
+	Title string
+
+	// Markdown is the sub-document with its own metadata header, which can
+	// be compiled as any other document.
+	Markdown []byte
+
+	// Line is the 1-based line of the original markdown the body of the
+	// sub-document starts at.
+	Line int
+}
+
+// SplitDocument splits markdown into the parent document and sub-documents
+// starting with headings of given level, e.g. a handbook into the page tree.
+// The parent document is returned first and consists of the metadata header
+// and content before the first such heading. Headings in code and HTML
+// blocks and setext headings are not used for splitting.
+//
+// Every sub-document inherits the metadata header of the parent document,
+// except for Title, which is the text of the heading, and Include headers.
+// Parent header with the title of the parent document, taken from its Title
+// header or leading H1 heading, is added, so sub-documents become children
+// of the parent page. Headings of sub-documents are re-leveled, so the
+// heading they start with becomes H1.
+//
+// Links to headings of other sub-documents, like [usage](#usage), are
+// rewritten into links to their pages, like /display/SPACE/Usage, which are
+// expanded into absolute links by CompileOptions.AbsolutePrefix. Links are
+// kept as is if the space is not known.
+//
+// The whole markdown is returned as the only document if there are no such
+// headings or the level is not in range 1-6.
+func SplitDocument(markdown []byte, level int) []SubDocument {
+	header, body := splitMetaHeader(markdown)
+
+	offsets := []int{}
+	if level >= 1 && level <= 6 {
+		offsets = getSplitOffsets(body, level)
+	}
+
+	title, space := getMetaHeaderTitle(header)
+	if len(offsets) == 0 {
+		return []SubDocument{{Title: title, Markdown: markdown, Line: 1}}
+	}
+
+	if title == "" {
+		title = ExtractDocumentLeadingH1(body[:offsets[0]])
+	}
+
+	line := bytes.Count(header, []byte("\n")) + 1
+
+	docs := []SubDocument{{
+		Title:    title,
+		Markdown: append(append([]byte{}, header...), body[:offsets[0]]...),
+		Line:     line,
+	}}
+
+	inherited := getInheritedHeader(header, title)
+
+	for i, start := range offsets {
+		end := len(body)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+
+		section := body[start:end]
+
+		heading, _ := readLine(section, 0)
+
+		doc := SubDocument{
+			Title: getHeadingPlainText(getATXHeadingText(heading)),
+			Line:  line + bytes.Count(body[:start], []byte("\n")),
+		}
+
+		doc.Markdown = append(append([]byte{}, inherited...), []byte(
+			"<!-- "+HeaderTitle+": "+escapeMetaValue(doc.Title)+" -->\n\n",
+		)...)
+		doc.Markdown = append(doc.Markdown, relevelHeadings(section, level-1)...)
+
+		docs = append(docs, doc)
+	}
+
+	if space != "" {
+		resolveSplitLinks(docs, space)
+	}
+
+	return docs
+}
+
+// splitMetaHeader returns leading lines of metadata headers and the rest of
+// markdown. Unlike ExtractMeta it doesn't parse headers, so they can be
+// copied as they're written.
+func splitMetaHeader(markdown []byte) ([]byte, []byte) {
+	offset := 0
+
+	for offset < len(markdown) {
+		line, next := readLine(markdown, offset)
+		if !reHeaderPatternV2.Match(line) && !reHeaderPatternV1.Match(line) {
+			break
+		}
+
+		offset = next
+	}
+
+	return markdown[:offset], markdown[offset:]
+}
+
+// getMetaHeaderTitle returns values of Title and Space headers.
+func getMetaHeaderTitle(header []byte) (title, space string) {
+	for offset := 0; offset < len(header); {
+		line, next := readLine(header, offset)
+		offset = next
+
+		name, value := parseMetaHeaderLine(line)
+
+		switch name {
+		case HeaderTitle:
+			title = value
+
+		case HeaderSpace:
+			space = value
+		}
+	}
+
+	return title, space
+}
+
+// getInheritedHeader returns the header of sub-documents: the header of the
+// parent document without Title and Include headers and with Parent header
+// of the parent document.
+func getInheritedHeader(header []byte, parent string) []byte {
+	inherited := []byte{}
+
+	for offset := 0; offset < len(header); {
+		line, next := readLine(header, offset)
+		offset = next
+
+		switch name, _ := parseMetaHeaderLine(line); name {
+		case HeaderTitle, HeaderInclude:
+			continue
+		}
+
+		inherited = append(inherited, line...)
+		inherited = append(inherited, '\n')
+	}
+
+	if parent != "" {
+		inherited = append(inherited, []byte(
+			"<!-- "+HeaderParent+": "+escapeMetaValue(parent)+" -->\n",
+		)...)
+	}
+
+	return inherited
+}
+
+func parseMetaHeaderLine(line []byte) (string, string) {
+	matches := reHeaderPatternV2.FindSubmatch(line)
+	if matches == nil {
+		matches = reHeaderPatternV1.FindSubmatch(line)
+	}
+
+	if matches == nil {
+		return "", ""
+	}
+
+	return strings.Title(string(matches[1])),
+		unescapeMetaValue(strings.TrimSpace(string(matches[2])))
+}
+
+// getSplitOffsets returns offsets of headings of given level outside of
+// code and HTML blocks.
+func getSplitOffsets(markdown []byte, level int) []int {
+	var (
+		offsets = []int{}
+		scanner = blockScanner{}
+	)
+
+	for offset := 0; offset < len(markdown); {
+		line, next := readLine(markdown, offset)
+
+		if scanner.outside(line) && getHeadingLevel(line) == level {
+			offsets = append(offsets, offset)
+		}
+
+		offset = next
+	}
+
+	return offsets
+}
+
+// getHeadingLevel returns the level of ATX heading or zero if the line is
+// not a heading.
+func getHeadingLevel(line []byte) int {
+	if !reHeadingLine.Match(line) {
+		return 0
+	}
+
+	return len(line) - len(bytes.TrimLeft(line, "#"))
+}
+
+// getATXHeadingText returns the text of ATX heading without opening and
+// closing sequences.
+func getATXHeadingText(line []byte) string {
+	text := strings.TrimSpace(string(bytes.TrimLeft(line, "#")))
+
+	if trimmed := strings.TrimRight(text, "#"); trimmed == "" ||
+		strings.HasSuffix(trimmed, " ") || strings.HasSuffix(trimmed, "\t") {
+		text = strings.TrimSpace(trimmed)
+	}
+
+	return text
+}
+
+// relevelHeadings decreases levels of ATX headings outside of code and HTML
+// blocks by shift, headings don't get higher than H1.
+func relevelHeadings(markdown []byte, shift int) []byte {
+	if shift <= 0 {
+		return markdown
+	}
+
+	var (
+		result  = make([]byte, 0, len(markdown))
+		scanner = blockScanner{}
+	)
+
+	for offset := 0; offset < len(markdown); {
+		line, next := readLine(markdown, offset)
+
+		// line break is kept as is
+		end := markdown[offset+len(line) : next]
+
+		if level := getHeadingLevel(line); scanner.outside(line) && level > 0 {
+			target := level - shift
+			if target < 1 {
+				target = 1
+			}
+
+			line = line[level-target:]
+		}
+
+		result = append(result, line...)
+		result = append(result, end...)
+
+		offset = next
+	}
+
+	return result
+}
+
+// resolveSplitLinks rewrites links to headings of other sub-documents into
+// links to their pages, links to headings sub-documents start with point
+// to the pages themselves.
+func resolveSplitLinks(docs []SubDocument, space string) {
+	var (
+		owners  = map[string]int{}
+		targets = map[string]string{}
+	)
+
+	for i, doc := range docs {
+		_, body := splitMetaHeader(doc.Markdown)
+
+		document := bf.New(bf.WithExtensions(DefaultExtensions)).Parse(body)
+
+		// sub-documents start with the heading they're split by
+		first := i > 0
+
+		document.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+			if !entering || node.Type != bf.Heading {
+				return bf.GoToNext
+			}
+
+			target := getPageLinkPath(space, doc.Title)
+			if !first {
+				target += "#" + node.HeadingID
+			}
+
+			first = false
+
+			// headings with the same id in different sub-documents are resolved
+			// to the first one
+			if _, ok := owners[node.HeadingID]; ok || node.HeadingID == "" {
+				return bf.GoToNext
+			}
+
+			owners[node.HeadingID] = i
+			targets[node.HeadingID] = target
+
+			return bf.GoToNext
+		})
+	}
+
+	for i := range docs {
+		links := []LinkSubstitution{}
+
+		for _, link := range parseLinks(string(docs[i].Markdown)) {
+			owner, ok := owners[link.hash]
+			if link.filename != "" || !ok || owner == i {
+				continue
+			}
+
+			links = append(links, LinkSubstitution{
+				From: link.full,
+				To:   targets[link.hash],
+			})
+		}
+
+		docs[i].Markdown = SubstituteLinks(docs[i].Markdown, links)
+	}
+}
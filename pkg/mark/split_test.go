@@ -0,0 +1,189 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitDocument(t *testing.T) {
+	markdown := text(
+		`<!-- Space: DOC -->`,
+		`<!-- Parent: Handbook Root -->`,
+		`<!-- Title: Handbook -->`,
+		`<!-- Label: handbook -->`,
+		``,
+		`# Handbook`,
+		``,
+		`See [onboarding](#onboarding), [tools](#tools) and [remote](#remote-work).`,
+		``,
+		`## Onboarding ##`,
+		``,
+		`Welcome, read [about tools](#tools) first.`,
+		``,
+		`### Tools`,
+		``,
+		"```markdown",
+		`## Not a section`,
+		"```",
+		``,
+		`## Remote _work_`,
+		``,
+		`#### Equipment`,
+		``,
+		`Back to [the handbook](#handbook), [onboarding](#onboarding).`,
+		``,
+	)
+
+	docs := SplitDocument([]byte(markdown), 2)
+
+	if !assert.Len(t, docs, 3) {
+		return
+	}
+
+	assert.Equal(
+		t,
+		SubDocument{
+			Title: "Handbook",
+			Markdown: []byte(text(
+				`<!-- Space: DOC -->`,
+				`<!-- Parent: Handbook Root -->`,
+				`<!-- Title: Handbook -->`,
+				`<!-- Label: handbook -->`,
+				``,
+				`# Handbook`,
+				``,
+				`See [onboarding](/display/DOC/Onboarding), `+
+					`[tools](/display/DOC/Onboarding#tools) and `+
+					`[remote](/display/DOC/Remote+work).`,
+				``,
+				``,
+			)),
+			Line: 5,
+		},
+		docs[0],
+	)
+
+	assert.Equal(
+		t,
+		SubDocument{
+			Title: "Onboarding",
+			Markdown: []byte(text(
+				`<!-- Space: DOC -->`,
+				`<!-- Parent: Handbook Root -->`,
+				`<!-- Label: handbook -->`,
+				`<!-- Parent: Handbook -->`,
+				`<!-- Title: Onboarding -->`,
+				``,
+				`# Onboarding ##`,
+				``,
+				`Welcome, read [about tools](#tools) first.`,
+				``,
+				`## Tools`,
+				``,
+				"```markdown",
+				`## Not a section`,
+				"```",
+				``,
+				``,
+			)),
+			Line: 10,
+		},
+		docs[1],
+	)
+
+	assert.Equal(
+		t,
+		SubDocument{
+			Title: "Remote work",
+			Markdown: []byte(text(
+				`<!-- Space: DOC -->`,
+				`<!-- Parent: Handbook Root -->`,
+				`<!-- Label: handbook -->`,
+				`<!-- Parent: Handbook -->`,
+				`<!-- Title: Remote work -->`,
+				``,
+				`# Remote _work_`,
+				``,
+				`### Equipment`,
+				``,
+				`Back to [the handbook](/display/DOC/Handbook#handbook), `+
+					`[onboarding](/display/DOC/Onboarding).`,
+				``,
+			)),
+			Line: 20,
+		},
+		docs[2],
+	)
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sub-documents are compiled as any other documents
+	for _, doc := range docs[1:] {
+		meta, body, err := ExtractMeta(doc.Markdown)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		assert.Equal(t, doc.Title, meta.Title)
+		assert.Equal(t, []string{"Handbook Root", "Handbook"}, meta.Parents)
+		assert.Equal(t, []string{"handbook"}, meta.Labels)
+
+		_, err = Compile(body, lib, CompileOptions{Meta: meta, Strict: true})
+		assert.NoError(t, err)
+	}
+}
+
+func TestSplitDocument_Whole(t *testing.T) {
+	markdown := []byte(text(
+		`<!-- Title: Page -->`,
+		``,
+		`## Section`,
+		``,
+	))
+
+	for _, level := range []int{0, 1, 3, 7} {
+		assert.Equal(
+			t,
+			[]SubDocument{{Title: "Page", Markdown: markdown, Line: 1}},
+			SplitDocument(markdown, level),
+			level,
+		)
+	}
+}
+
+func TestSplitDocument_NoSpace(t *testing.T) {
+	docs := SplitDocument([]byte(text(
+		`Intro, see [install](#install).`,
+		``,
+		`# Install`,
+		``,
+		`# Usage`,
+		``,
+		`Back to [install](#install).`,
+	)), 1)
+
+	if !assert.Len(t, docs, 3) {
+		return
+	}
+
+	// links to pages need the space, sub-documents don't get Parent header
+	// without the title of the parent document
+	assert.Equal(t, "", docs[0].Title)
+	assert.Equal(t, text(`Intro, see [install](#install).`, ``, ``), string(docs[0].Markdown))
+	assert.Equal(
+		t,
+		text(
+			`<!-- Title: Usage -->`,
+			``,
+			`# Usage`,
+			``,
+			`Back to [install](#install).`,
+		),
+		string(docs[2].Markdown),
+	)
+}
@@ -0,0 +1,224 @@
+package mark
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/yuin/goldmark/ast"
+)
+
+// reStructuredMacro matches opening tags of structured macros in the output.
+var (
+	reStructuredMacro = regexp.MustCompile(
+		`<ac:structured-macro\s[^>]*?ac:name="([^"]*)"[^>]*>`,
+	)
+
+	structuredMacroTag = []byte("<ac:structured-macro")
+)
+
+// Stats are statistics of the compiled document, which are collected while
+// rendering it.
+type Stats struct {
+	// Headings is the number of headings by level.
+	Headings map[int]int
+
+	// CodeBlocks is the number of code blocks by language, blocks without
+	// language are counted by empty one.
+	CodeBlocks map[string]int
+
+	// Images is the number of images, Attachments is the number of files in
+	// CompileResult.Attachments.
+	Images      int
+	Attachments int
+
+	// ExternalLinks is the number of links with URL scheme or host, e.g.
+	// https: and mailto: links, InternalLinks is the number of the rest,
+	// e.g. relative links to other documents and anchors. Links are counted
+	// as they're written, before LinkResolver rewrites them.
+	ExternalLinks int
+	InternalLinks int
+
+	// Words is the number of words in text, not counting code, HTML and
+	// alternative text of images.
+	Words int
+
+	// Templates is the number of times templates are executed by name while
+	// rendering, e.g. ac:code for code blocks. Templates included before
+	// compiling are not counted.
+	Templates map[string]int
+
+	// Macros is the number of structured macros in the output by name,
+	// whether they're rendered by templates or handlers, or written in
+	// markdown as HTML.
+	Macros map[string]int
+}
+
+func newStats() Stats {
+	return Stats{
+		Headings:   map[int]int{},
+		CodeBlocks: map[string]int{},
+		Templates:  map[string]int{},
+		Macros:     map[string]int{},
+	}
+}
+
+// countBlackfriday counts the node entered by the renderer.
+func (state *compilation) countBlackfriday(node *bf.Node) {
+	if state == nil {
+		return
+	}
+
+	switch node.Type {
+	case bf.Heading:
+		state.stats.Headings[node.HeadingData.Level]++
+
+	case bf.CodeBlock:
+		state.stats.CodeBlocks[ParseLanguage(string(node.Info))]++
+
+	case bf.Link:
+		// footnote references are links as well
+		if node.LinkData.NoteID == 0 {
+			state.countLink(string(node.LinkData.Destination), false)
+		}
+
+	case bf.Image:
+		state.countLink(string(node.LinkData.Destination), true)
+
+	case bf.Text:
+		if node.Parent == nil || node.Parent.Type != bf.Image {
+			state.stats.Words += countWords(node.Literal)
+		}
+	}
+}
+
+// countGoldmark counts the node of goldmark document.
+func (state *compilation) countGoldmark(node ast.Node, source []byte) {
+	switch node := node.(type) {
+	case *ast.Heading:
+		state.stats.Headings[node.Level]++
+
+	case *ast.CodeBlock:
+		state.stats.CodeBlocks[""]++
+
+	case *ast.FencedCodeBlock:
+		var lang string
+		if node.Info != nil {
+			lang = string(node.Info.Text(source))
+		}
+
+		state.stats.CodeBlocks[ParseLanguage(lang)]++
+
+	case *ast.Link:
+		state.countLink(string(node.Destination), false)
+
+	case *ast.AutoLink:
+		state.countLink(string(node.URL(source)), false)
+
+		// blackfriday keeps text of autolinks as text nodes
+		state.stats.Words += countWords(node.Label(source))
+
+	case *ast.Image:
+		state.countLink(string(node.Destination), true)
+
+	case *ast.Text:
+		switch node.Parent().Kind() {
+		case ast.KindImage, ast.KindCodeSpan:
+		default:
+			state.stats.Words += countWords(node.Segment.Value(source))
+		}
+	}
+}
+
+// countWords counts words which contain letters or digits, so punctuation
+// between inline nodes is not counted.
+func countWords(text []byte) int {
+	var (
+		words   = 0
+		counted = false
+	)
+
+	for offset := 0; offset < len(text); {
+		r, size := utf8.DecodeRune(text[offset:])
+		offset += size
+
+		switch {
+		case unicode.IsSpace(r):
+			counted = false
+
+		case !counted && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			counted = true
+			words++
+		}
+	}
+
+	return words
+}
+
+func (state *compilation) countLink(destination string, image bool) {
+	parsed, err := url.Parse(destination)
+
+	external := err == nil && (parsed.Scheme != "" || parsed.Host != "")
+
+	switch {
+	case !image && external:
+		state.stats.ExternalLinks++
+
+	case !image:
+		state.stats.InternalLinks++
+
+	default:
+		state.stats.Images++
+	}
+}
+
+// countTemplate counts the template executed by the renderer.
+func (state *compilation) countTemplate(name string) {
+	if state != nil {
+		state.stats.Templates[name]++
+	}
+}
+
+// macroWriter counts structured macros written into the writer. Tags split
+// between writes are held until the next write to be counted.
+type macroWriter struct {
+	writer io.Writer
+	stats  *Stats
+
+	// pending is the beginning of the tag written last
+	pending []byte
+}
+
+func (writer *macroWriter) Write(data []byte) (int, error) {
+	written, err := writer.writer.Write(data)
+
+	scan := data[:written]
+	if len(writer.pending) > 0 {
+		scan = append(writer.pending, scan...)
+	}
+
+	if bytes.Contains(scan, structuredMacroTag) {
+		for _, groups := range reStructuredMacro.FindAllSubmatch(scan, -1) {
+			writer.stats.Macros[string(groups[1])]++
+		}
+	}
+
+	writer.pending = writer.pending[:0]
+
+	// tags are not nested into each other, so only the last one can be
+	// incomplete
+	if start := bytes.LastIndexByte(scan, '<'); start >= 0 &&
+		bytes.IndexByte(scan[start:], '>') < 0 &&
+		len(scan)-start <= maxMacroTagSize {
+		writer.pending = append(writer.pending, scan[start:]...)
+	}
+
+	return written, err
+}
+
+// maxMacroTagSize limits the length of opening tags held by macroWriter.
+const maxMacroTagSize = 4096
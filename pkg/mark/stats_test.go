@@ -0,0 +1,145 @@
+package mark
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_Stats(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"# Guide",
+		"",
+		"Read [the intro](intro.md), [the API](https://example.com/api) and",
+		"[below](#usage), or <https://example.org> and [mail](mailto:a@example.com).",
+		"",
+		"## Usage",
+		"",
+		"![logo](images/logo.png) ![logo again](images/logo.png)",
+		"![badge](https://example.com/badge.svg) ![diagram](diagram.png)",
+		"",
+		"```go",
+		"fmt.Println(`not words`)",
+		"```",
+		"",
+		"```",
+		"plain",
+		"```",
+		"",
+		"    indented",
+		"",
+		"```bash collapse",
+		"echo",
+		"```",
+		"",
+		"> [!NOTE]",
+		"> Note with `code span` and **bold** text.",
+		"",
+		"### Details",
+		"",
+		`<ac:structured-macro ac:name="toc"/>`,
+		"",
+		`<ac:structured-macro ac:name="expand"><ac:rich-text-body>`,
+		"",
+		"Hidden <b>words</b> here.",
+		"",
+		`</ac:rich-text-body></ac:structured-macro>`,
+		"",
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile(markdown, lib, CompileOptions{Engine: engine})
+		assert.NoError(t, err, engine)
+		assert.Equal(
+			t,
+			Stats{
+				Headings:      map[int]int{1: 1, 2: 1, 3: 1},
+				CodeBlocks:    map[string]int{"go": 1, "": 2, "bash": 1},
+				Images:        4,
+				Attachments:   2,
+				ExternalLinks: 3,
+				InternalLinks: 2,
+				Words:         22,
+				Templates:     map[string]int{"ac:code": 4, "ac:box": 1},
+				Macros: map[string]int{
+					"code":   4,
+					"expand": 2,
+					"info":   1,
+					"toc":    1,
+				},
+			},
+			result.Stats,
+			engine,
+		)
+	}
+}
+
+func TestCompile_StatsHandlers(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(
+		[]byte("# Title\n\n## Section\n"),
+		lib,
+		CompileOptions{
+			Handlers: func(renderer *ConfluenceRenderer) {
+				renderer.Handle(bf.Heading, func(
+					writer io.Writer,
+					node *bf.Node,
+					entering bool,
+				) (bf.WalkStatus, bool) {
+					if entering {
+						io.WriteString(writer, `<ac:structured-macro ac:name="anchor"/>`)
+					}
+
+					return bf.SkipChildren, true
+				})
+			},
+		},
+	)
+	assert.NoError(t, err)
+
+	// headings are counted as headings even if handlers render them
+	// differently, while their text is not rendered
+	assert.Equal(t, map[int]int{1: 1, 2: 1}, result.Stats.Headings)
+	assert.Equal(t, map[string]int{"anchor": 2}, result.Stats.Macros)
+	assert.Equal(t, 0, result.Stats.Words)
+}
+
+func TestMacroWriter(t *testing.T) {
+	var (
+		output strings.Builder
+		stats  = newStats()
+		writer = &macroWriter{writer: &output, stats: &stats}
+	)
+
+	for _, data := range []string{
+		`<p><ac:structured-macro ac:na`,
+		`me="toc"/> <ac:structured`,
+		`-macro ac:name="code"><ac:parameter>`,
+		`</ac:parameter></ac:structured-macro> < <ac:structured-macro`,
+		` ac:name="toc">`,
+	} {
+		writer.Write([]byte(data))
+	}
+
+	assert.Equal(t, map[string]int{"toc": 2, "code": 1}, stats.Macros)
+	assert.Equal(
+		t,
+		`<p><ac:structured-macro ac:name="toc"/> `+
+			`<ac:structured-macro ac:name="code"><ac:parameter></ac:parameter>`+
+			`</ac:structured-macro> < <ac:structured-macro ac:name="toc">`,
+		output.String(),
+	)
+}
@@ -0,0 +1,54 @@
+package mark
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+)
+
+// Status is the publishing status of the document set by Status header.
+type Status string
+
+const (
+	// StatusPublished is the status of documents, which are published as
+	// usual. This is the default.
+	StatusPublished Status = "published"
+
+	// StatusDraft is the status of documents, which are not ready yet, so
+	// publishers can skip them or publish them separately, see
+	// CompileOptions.DraftBanner.
+	StatusDraft Status = "draft"
+)
+
+// ParseStatus parses the value of Status header.
+func ParseStatus(value string) (Status, error) {
+	switch status := Status(strings.ToLower(strings.TrimSpace(value))); status {
+	case StatusPublished, StatusDraft:
+		return status, nil
+	}
+
+	return "", fmt.Errorf(
+		"unexpected status %q, expected one of: %s, %s",
+		value,
+		StatusDraft,
+		StatusPublished,
+	)
+}
+
+// getDraftBannerBody returns the body of the note macro, which warns that
+// the document is a draft, see CompileOptions.DraftBanner.
+func (opts CompileOptions) getDraftBannerBody() (string, error) {
+	text, err := opts.formatMessage(
+		MessageDraftBanner,
+		map[string]string{
+			"Source": escape.EscapeText(filepath.ToSlash(opts.File)),
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return "<p>" + text + "</p>\n", nil
+}
@@ -0,0 +1,135 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractMeta_Status(t *testing.T) {
+	meta, _, err := ExtractMeta([]byte("<!-- Title: Page -->\n\nbody\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, StatusPublished, meta.Status)
+	}
+
+	meta, _, err = ExtractMeta([]byte(text(
+		`<!-- Title: Page -->`,
+		`<!-- Status: Draft -->`,
+		``,
+		`body`,
+	)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, StatusDraft, meta.Status)
+	}
+
+	meta, markdown, err := ExtractMeta([]byte(text(
+		`<!-- Title: Page -->`,
+		`<!-- Space: DOC -->`,
+		`<!-- Status: ready -->`,
+		``,
+		`body`,
+	)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, StatusPublished, meta.Status)
+	}
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Compile(markdown, lib, CompileOptions{Meta: meta, Logger: &testLogger{}})
+	assert.NoError(t, err)
+
+	result, err := Compile(markdown, lib, CompileOptions{
+		Meta:   meta,
+		Strict: true,
+		Logger: &testLogger{},
+	})
+	assert.Error(t, err)
+	assert.Equal(
+		t,
+		[]Diagnostic{
+			{
+				Line:     3,
+				Severity: SeverityError,
+				Message: `invalid Status header: unexpected status "ready", ` +
+					`expected one of: draft, published`,
+			},
+		},
+		result.Diagnostics,
+	)
+}
+
+func TestCompile_DraftBanner(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		`# Section`,
+		``,
+		`Body.`,
+		``,
+		`# Another section`,
+		``,
+		`Body.`,
+		``,
+	))
+
+	banner := `This page is a draft generated from <code>docs/page.md</code>.`
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		for _, opts := range []CompileOptions{
+			{},
+			{ChunkSize: 1},
+			{Validate: true},
+			{ContentHash: true},
+		} {
+			opts.Engine = engine
+			opts.File = "docs/page.md"
+			opts.Meta = &Meta{Status: StatusDraft}
+			opts.DraftBanner = true
+
+			result, err := Compile(markdown, lib, opts)
+			if !assert.NoError(t, err, engine) {
+				continue
+			}
+
+			assert.Equal(t, 1, strings.Count(result.HTML, banner), engine)
+			assert.Equal(t, 1, strings.Count(result.HTML, `ac:name="note"`), engine)
+			assert.Equal(t, 1, result.Stats.Templates["ac:box"], engine)
+			assert.Less(
+				t,
+				strings.Index(result.HTML, banner),
+				strings.Index(result.HTML, "Section"),
+				engine,
+			)
+		}
+
+		html, err := CompileMarkdownWithOptions(markdown, lib, CompileOptions{
+			Engine:      engine,
+			Meta:        &Meta{Status: StatusDraft},
+			DraftBanner: true,
+		})
+		if assert.NoError(t, err, engine) {
+			assert.Contains(t, html, `<p>This page is a draft.</p>`, engine)
+		}
+
+		for _, opts := range []CompileOptions{
+			{Meta: &Meta{Status: StatusPublished}, DraftBanner: true},
+			{Meta: &Meta{Status: StatusDraft}},
+			{DraftBanner: true},
+		} {
+			opts.Engine = engine
+
+			html, err := CompileMarkdownWithOptions(markdown, lib, opts)
+			if assert.NoError(t, err, engine) {
+				assert.NotContains(t, html, "draft", engine)
+			}
+		}
+	}
+}
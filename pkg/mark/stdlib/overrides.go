@@ -0,0 +1,320 @@
+package stdlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/reconquest/karma-go"
+)
+
+// Template describes template of the library.
+type Template struct {
+	Name string
+
+	// Source is the path of the file which overrides built-in template or
+	// defines the new one, SourceRuntime for templates registered by
+	// AddTemplate and empty for built-in templates.
+	Source string
+}
+
+// ListTemplates returns effective set of templates sorted by name.
+func (lib *Lib) ListTemplates() []Template {
+	lib.mutex.RLock()
+	defer lib.mutex.RUnlock()
+
+	list := []Template{}
+
+	for _, template := range lib.Templates.Templates() {
+		if template.Name() == "stdlib" || template.Tree == nil {
+			continue
+		}
+
+		list = append(list, Template{
+			Name:   template.Name(),
+			Source: lib.sources[template.Name()],
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// Checksum returns SHA-256 of definitions of all templates in hex, so it
+// changes whenever templates are overridden or registered, and the dialect
+// templates are written in. Functions available in templates are not taken
+// into account.
+func (lib *Lib) Checksum() string {
+	lib.mutex.RLock()
+	defer lib.mutex.RUnlock()
+
+	templates := lib.Templates.Templates()
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name() < templates[j].Name()
+	})
+
+	hash := sha256.New()
+
+	hash.Write([]byte(lib.dialect))
+	hash.Write([]byte{0})
+
+	for _, template := range templates {
+		if template.Tree == nil || template.Tree.Root == nil {
+			continue
+		}
+
+		// names and bodies are separated, so moving text between them
+		// changes the checksum
+		hash.Write([]byte(template.Name()))
+		hash.Write([]byte{0})
+		hash.Write([]byte(template.Tree.Root.String()))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (lib *Lib) loadOverrides(fsys fs.FS) error {
+	paths, err := fs.Glob(fsys, "*.tmpl")
+	if err != nil {
+		return karma.Format(err, "unable to list override templates")
+	}
+
+	for _, filename := range paths {
+		err := lib.loadOverride(fsys, filename)
+		if err != nil {
+			return karma.Describe("path", filename).Reason(err)
+		}
+	}
+
+	return nil
+}
+
+func (lib *Lib) loadOverride(fsys fs.FS, filename string) error {
+	body, err := fs.ReadFile(fsys, filename)
+	if err != nil {
+		return karma.Format(err, "unable to read override template")
+	}
+
+	name := strings.TrimSuffix(path.Base(filename), ".tmpl")
+
+	return lib.addTemplate(name, string(body), filename, true)
+}
+
+// TemplateFlag changes how AddTemplate registers the template.
+type TemplateFlag int
+
+const (
+	// Override allows AddTemplate to replace existing template.
+	Override TemplateFlag = iota + 1
+)
+
+// SourceRuntime is the source of templates registered by AddTemplate.
+const SourceRuntime = "runtime"
+
+// AddTemplate registers template with given name, templates defined in the
+// body using {{ define }} are registered as well. It's an error to register
+// template which already exists unless Override flag is passed, template
+// replacing built-in one can use only fields used by the built-in one.
+//
+// Templates can be registered after the library is used for compiling:
+// templates are looked up by name when they are executed, so documents
+// compiled afterwards use registered template, while compiling which is
+// running at the same time may use either the old or the new one.
+func (lib *Lib) AddTemplate(name, body string, flags ...TemplateFlag) error {
+	override := false
+	for _, flag := range flags {
+		if flag == Override {
+			override = true
+		}
+	}
+
+	return lib.addTemplate(name, body, SourceRuntime, override)
+}
+
+// MustAddTemplate is like AddTemplate but panics if the template can't be
+// registered.
+func (lib *Lib) MustAddTemplate(name, body string, flags ...TemplateFlag) {
+	err := lib.AddTemplate(name, body, flags...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (lib *Lib) addTemplate(
+	name string,
+	body string,
+	source string,
+	override bool,
+) error {
+	lib.mutex.Lock()
+	defer lib.mutex.Unlock()
+
+	parsed, err := template.New(name).Funcs(lib.funcs).Parse(body)
+	if err != nil {
+		return karma.Format(err, "unable to parse template %q", name)
+	}
+
+	templates := []*template.Template{}
+	for _, template := range parsed.Templates() {
+		if template.Tree == nil || parse.IsEmptyTree(template.Tree.Root) {
+			continue
+		}
+
+		existing := lib.Templates.Lookup(template.Name())
+		if existing != nil && existing.Tree != nil && !override {
+			return karma.Format(
+				nil,
+				"template %q already exists",
+				template.Name(),
+			)
+		}
+
+		err := lib.checkFields(template)
+		if err != nil {
+			return err
+		}
+
+		templates = append(templates, template)
+	}
+
+	if lib.sources == nil {
+		lib.sources = map[string]string{}
+	}
+
+	for _, template := range templates {
+		_, err := lib.Templates.AddParseTree(template.Name(), template.Tree)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to add template %q",
+				template.Name(),
+			)
+		}
+
+		lib.sources[template.Name()] = source
+	}
+
+	return nil
+}
+
+// builtinFields lists fields which overrides can use, while built-in
+// templates don't reference them, e.g. fields of the user returned by user
+// function, which built-in template passes to mention function as a whole.
+var builtinFields = map[string][]string{
+	"ac:link:user": {"AccountID", "UserKey", "Username"},
+}
+
+// checkFields ensures that template overriding built-in one uses only
+// fields which built-in template uses, since the rest fields don't exist in
+// data passed to the template. New templates are not checked.
+func (lib *Lib) checkFields(override *template.Template) error {
+	builtin := lib.Templates.Lookup(override.Name())
+	if builtin == nil || builtin.Tree == nil {
+		return nil
+	}
+
+	known := map[string]bool{}
+	for _, field := range getFields(builtin.Tree.Root) {
+		known[field] = true
+	}
+
+	for _, field := range builtinFields[override.Name()] {
+		known[field] = true
+	}
+
+	for _, field := range getFields(override.Tree.Root) {
+		if !known[field] {
+			return karma.
+				Describe("known", strings.Join(getFields(builtin.Tree.Root), ", ")).
+				Format(
+					nil,
+					"template %q references unknown field %q",
+					override.Name(),
+					field,
+				)
+		}
+	}
+
+	return nil
+}
+
+// getFields returns names of fields referenced by the template, like Title
+// in {{ .Title }} or {{ .Title.Text }}, in order of their appearance.
+func getFields(node parse.Node) []string {
+	var (
+		fields = []string{}
+		seen   = map[string]bool{}
+		walk   func(node parse.Node)
+	)
+
+	walk = func(node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			if node == nil {
+				return
+			}
+
+			for _, node := range node.Nodes {
+				walk(node)
+			}
+
+		case *parse.ActionNode:
+			walk(node.Pipe)
+
+		case *parse.IfNode:
+			walk(node.Pipe)
+			walk(node.List)
+			walk(node.ElseList)
+
+		case *parse.RangeNode:
+			walk(node.Pipe)
+			walk(node.List)
+			walk(node.ElseList)
+
+		case *parse.WithNode:
+			walk(node.Pipe)
+			walk(node.List)
+			walk(node.ElseList)
+
+		case *parse.TemplateNode:
+			walk(node.Pipe)
+
+		case *parse.PipeNode:
+			if node == nil {
+				return
+			}
+
+			for _, command := range node.Cmds {
+				walk(command)
+			}
+
+		case *parse.CommandNode:
+			for _, arg := range node.Args {
+				walk(arg)
+			}
+
+		case *parse.ChainNode:
+			walk(node.Node)
+
+		case *parse.FieldNode:
+			if !seen[node.Ident[0]] {
+				seen[node.Ident[0]] = true
+				fields = append(fields, node.Ident[0])
+			}
+		}
+	}
+
+	walk(node)
+
+	return fields
+}
@@ -1,32 +1,138 @@
 package stdlib
 
 import (
+	"crypto/rand"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/mark/dialect"
+	"github.com/kovetskiy/mark/pkg/mark/escape"
 	"github.com/kovetskiy/mark/pkg/mark/macro"
-	"github.com/reconquest/pkg/log"
 
 	"github.com/reconquest/karma-go"
 )
 
+// Lib is a set of templates and macros used for compiling documents. Lib
+// is safe for concurrent use: templates are only read while documents are
+// compiled, and AddTemplate can be called at the same time.
 type Lib struct {
 	Macros    []macro.Macro
 	Templates *template.Template
+
+	// mutex guards sources and makes registering templates atomic
+	mutex sync.RWMutex
+
+	// sources maps names of templates to paths of override files, built-in
+	// templates are not listed
+	sources map[string]string
+	funcs   template.FuncMap
+	dialect dialect.Dialect
+}
+
+// Option configures Lib created by New.
+type Option func(*options)
+
+type options struct {
+	overrides     []fs.FS
+	funcs         template.FuncMap
+	deterministic bool
+	dialect       dialect.Dialect
+	logger        Logger
+}
+
+// Logger receives errors of template functions which don't fail templates,
+// e.g. of looking up users. It's satisfied by mark.Logger.
+type Logger interface {
+	Errorf(format string, args ...interface{})
+}
+
+// WithOverrides loads templates from *.tmpl files in the root of given file
+// system, e.g. os.DirFS or embed.FS. File name without extension is the
+// name of the template, e.g. ac:code.tmpl, and files can define other
+// templates using {{ define }} as well. Template which has the same name as
+// built-in one replaces it and can use only fields used by the built-in one.
+func WithOverrides(fsys fs.FS) Option {
+	return func(opts *options) {
+		opts.overrides = append(opts.overrides, fsys)
+	}
 }
 
-func New(api *confluence.API) (*Lib, error) {
+// WithFuncs adds functions to ones available in all templates, functions
+// with the same names as built-in ones replace them.
+func WithFuncs(funcs template.FuncMap) Option {
+	return func(opts *options) {
+		if opts.funcs == nil {
+			opts.funcs = template.FuncMap{}
+		}
+
+		for name, fn := range funcs {
+			opts.funcs[name] = fn
+		}
+	}
+}
+
+// WithDeterministicOutput makes built-in now and uuid functions fail, so
+// templates produce the same output for the same input.
+func WithDeterministicOutput() Option {
+	return func(opts *options) {
+		opts.deterministic = true
+	}
+}
+
+// WithDialect makes templates write storage format for given edition of
+// Confluence, dialect.Auto by default. Templates call functions emoticon,
+// mention and section, which write markup of the dialect, and dialect
+// function returns its name, so overrides can depend on it as well.
+func WithDialect(value dialect.Dialect) Option {
+	return func(opts *options) {
+		opts.dialect = value
+	}
+}
+
+// WithLogger sets the logger of template functions, errors are discarded by
+// default.
+func WithLogger(logger Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+func New(api *confluence.API, opts ...Option) (*Lib, error) {
 	var (
 		lib Lib
 		err error
+
+		config options
 	)
 
-	lib.Templates, err = templates(api)
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	lib.dialect = config.dialect
+	lib.funcs = funcs(api, config)
+
+	lib.Templates, err = templates(lib.funcs)
 	if err != nil {
 		return nil, err
 	}
 
+	lib.sources = map[string]string{}
+
+	for _, fsys := range config.overrides {
+		err = lib.loadOverrides(fsys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	lib.Macros, err = macros(lib.Templates)
 	if err != nil {
 		return nil, err
@@ -51,6 +157,7 @@ func macros(templates *template.Template) ([]macro.Macro, error) {
 		)),
 
 		templates,
+		nil,
 	)
 	if err != nil {
 		return nil, err
@@ -59,33 +166,117 @@ func macros(templates *template.Template) ([]macro.Macro, error) {
 	return macros, nil
 }
 
-func templates(api *confluence.API) (*template.Template, error) {
+// funcs returns functions available in all templates.
+func funcs(api *confluence.API, config options) template.FuncMap {
+	funcs := template.FuncMap{
+		// users are not looked up without API, e.g. for previews
+		"user": func(name string) *confluence.User {
+			if api == nil {
+				return nil
+			}
+
+			user, err := api.GetUserByName(name)
+			if err != nil && config.logger != nil {
+				config.logger.Errorf("%s", err)
+			}
+
+			return user
+		},
+
+		// escape values for storage format, see package escape
+		"text":  escape.EscapeText,
+		"attr":  escape.EscapeAttr,
+		"cdata": escape.EscapeCDATA,
+
+		"now": func() (string, error) {
+			return time.Now().Format(time.RFC3339), nil
+		},
+		"uuid":    getUUID,
+		"env":     os.Getenv,
+		"trim":    strings.TrimSpace,
+		"upper":   strings.ToUpper,
+		"default": getDefault,
+
+		// storage format which differs between Confluence editions, see
+		// package dialect
+		"dialect":  config.dialect.String,
+		"emoticon": config.dialect.Emoticon,
+		"mention":  config.dialect.User,
+		"section":  config.dialect.LayoutSection,
+	}
+
+	if config.deterministic {
+		for _, name := range []string{"now", "uuid"} {
+			name := name
+
+			funcs[name] = func() (string, error) {
+				return "", fmt.Errorf(
+					"%s is not available when output must be deterministic",
+					name,
+				)
+			}
+		}
+	}
+
+	for name, fn := range config.funcs {
+		funcs[name] = fn
+	}
+
+	return funcs
+}
+
+// getUUID returns random UUID version 4.
+func getUUID() (string, error) {
+	var uuid [16]byte
+
+	_, err := rand.Read(uuid[:])
+	if err != nil {
+		return "", karma.Format(err, "unable to generate uuid")
+	}
+
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	return fmt.Sprintf(
+		"%x-%x-%x-%x-%x",
+		uuid[0:4],
+		uuid[4:6],
+		uuid[6:8],
+		uuid[8:10],
+		uuid[10:],
+	), nil
+}
+
+// getDefault returns the value if it's set and not empty, and fallback
+// otherwise, so it can be used in pipelines: {{ .Title | default "none" }}.
+func getDefault(fallback interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || value[0] == nil {
+		return fallback
+	}
+
+	reflected := reflect.ValueOf(value[0])
+
+	switch reflected.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if reflected.Len() == 0 {
+			return fallback
+		}
+
+	default:
+		if reflected.IsZero() {
+			return fallback
+		}
+	}
+
+	return value[0]
+}
+
+func templates(funcs template.FuncMap) (*template.Template, error) {
 	text := func(line ...string) string {
 		return strings.Join(line, ``)
 	}
 
-	templates := template.New(`stdlib`).Funcs(
-		template.FuncMap{
-			"user": func(name string) *confluence.User {
-				user, err := api.GetUserByName(name)
-				if err != nil {
-					log.Error(err)
-				}
-
-				return user
-			},
-
-			// The only way to escape CDATA end marker ']]>' is to split it
-			// into two CDATA sections.
-			"cdata": func(data string) string {
-				return strings.ReplaceAll(
-					data,
-					"]]>",
-					"]]><![CDATA[]]]]><![CDATA[>",
-				)
-			},
-		},
-	)
+	templates := template.New(`stdlib`).Funcs(funcs)
 
 	var err error
 
@@ -94,7 +285,7 @@ func templates(api *confluence.API) (*template.Template, error) {
 		`ac:layout`: text(
 			`{{ if eq .Layout "article" }}`,
 			/**/ `<ac:layout>`,
-			/**/ `<ac:layout-section ac:type="two_right_sidebar">`,
+			/**/ `{{ section "two_right_sidebar" }}`,
 			/**/ `<ac:layout-cell>{{ .Body }}</ac:layout-cell>`,
 			/**/ `<ac:layout-cell>{{ .Sidebar }}</ac:layout-cell>`,
 			/**/ `</ac:layout-section>`,
@@ -107,14 +298,15 @@ func templates(api *confluence.API) (*template.Template, error) {
 		// This template is used for rendering code in ```
 		`ac:code`: text(
 			`{{ if .Collapse }}<ac:structured-macro ac:name="expand">{{printf "\n"}}`,
-			`{{ if .Title }}<ac:parameter ac:name="title">{{ .Title }}</ac:parameter>{{printf "\n"}}{{ end }}`,
+			`{{ if .Title }}<ac:parameter ac:name="title">{{ .Title | text }}</ac:parameter>{{printf "\n"}}{{ end }}`,
 			`<ac:rich-text-body>{{printf "\n"}}{{ end }}`,
 
 			`<ac:structured-macro ac:name="{{ if eq .Language "mermaid" }}cloudscript-confluence-mermaid{{ else }}code{{ end }}">{{printf "\n"}}`,
 			/**/ `{{ if eq .Language "mermaid" }}<ac:parameter ac:name="showSource">true</ac:parameter>{{printf "\n"}}{{ else }}`,
-			/**/ `<ac:parameter ac:name="language">{{ .Language }}</ac:parameter>{{printf "\n"}}{{ end }}`,
+			/**/ `<ac:parameter ac:name="language">{{ .Language | text }}</ac:parameter>{{printf "\n"}}{{ end }}`,
 			/**/ `<ac:parameter ac:name="collapse">{{ .Collapse }}</ac:parameter>{{printf "\n"}}`,
-			/**/ `{{ if .Title }}<ac:parameter ac:name="title">{{ .Title }}</ac:parameter>{{printf "\n"}}{{ end }}`,
+			/**/ `{{ if .LineNumbers }}<ac:parameter ac:name="linenumbers">true</ac:parameter>{{printf "\n"}}{{ end }}`,
+			/**/ `{{ if .Title }}<ac:parameter ac:name="title">{{ .Title | text }}</ac:parameter>{{printf "\n"}}{{ end }}`,
 			/**/ `<ac:plain-text-body><![CDATA[{{ .Text | cdata }}]]></ac:plain-text-body>{{printf "\n"}}`,
 			`</ac:structured-macro>{{printf "\n"}}`,
 
@@ -130,10 +322,16 @@ func templates(api *confluence.API) (*template.Template, error) {
 			`</ac:structured-macro>`,
 		),
 
+		`ac:anchor`: text(
+			`<ac:structured-macro ac:name="anchor">`,
+			`<ac:parameter ac:name="">{{ .Name }}</ac:parameter>`,
+			`</ac:structured-macro>`,
+		),
+
 		`ac:link:user`: text(
 			`{{ with .Name | user }}`,
 			/**/ `<ac:link>`,
-			/**/ `<ri:user ri:account-id="{{ .AccountID }}"/>`,
+			/**/ `{{ mention . }}`,
 			/**/ `</ac:link>`,
 			`{{ else }}`,
 			/**/ `{{ .Name }}`,
@@ -193,10 +391,22 @@ func templates(api *confluence.API) (*template.Template, error) {
 			`</ac:structured-macro>{{printf "\n"}}`,
 		),
 
+		/* https://marketplace.atlassian.com/apps/1212494/redirection-plugin-for-confluence */
+
+		`ac:redirect`: text(
+			`<ac:structured-macro ac:name="redirect">{{printf "\n"}}`,
+			`<ac:parameter ac:name="location">`,
+			/**/ `<ac:link>`,
+			/**/ `<ri:page {{ if .Space }}ri:space-key="{{ .Space }}" {{ end }}ri:content-title="{{ .Page }}"/>`,
+			/**/ `</ac:link>`,
+			`</ac:parameter>{{printf "\n"}}`,
+			`</ac:structured-macro>{{printf "\n"}}`,
+		),
+
 		/* https://confluence.atlassian.com/doc/confluence-storage-format-790796544.html */
 
 		`ac:emoticon`: text(
-			`<ac:emoticon ac:name="{{ .Name }}"/>`,
+			`{{ emoticon .Name }}`,
 		),
 
 		/* https://confluence.atlassian.com/doc/widget-connector-macro-171180449.html#WidgetConnectorMacro-YouTube */
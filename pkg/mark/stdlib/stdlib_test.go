@@ -0,0 +1,334 @@
+package stdlib
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/mark/dialect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_WithOverrides(t *testing.T) {
+	lib, err := New(nil, WithOverrides(fstest.MapFS{
+		"ac:code.tmpl": &fstest.MapFile{
+			Data: []byte(`<code theme="dark">{{ .Text | cdata }}</code>`),
+		},
+		"custom.tmpl": &fstest.MapFile{
+			Data: []byte(`{{ define "custom:inner" }}{{ .Any }}{{ end }}`),
+		},
+		"README.md": &fstest.MapFile{Data: []byte(`{{ .Broken`)},
+	}))
+	assert.NoError(t, err)
+
+	var html strings.Builder
+
+	err = lib.Templates.ExecuteTemplate(
+		&html,
+		"ac:code",
+		struct{ Text string }{"a]]>b"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		`<code theme="dark">a]]]]><![CDATA[>b</code>`,
+		html.String(),
+	)
+
+	templates := map[string]string{}
+	for _, template := range lib.ListTemplates() {
+		templates[template.Name] = template.Source
+	}
+
+	assert.Equal(t, "ac:code.tmpl", templates["ac:code"])
+	assert.Equal(t, "custom.tmpl", templates["custom:inner"])
+	assert.Contains(t, templates, "ac:box")
+	assert.Equal(t, "", templates["ac:box"])
+	assert.NotContains(t, templates, "custom")
+}
+
+func TestNew_WithOverridesUnknownField(t *testing.T) {
+	_, err := New(nil, WithOverrides(fstest.MapFS{
+		"ac:code.tmpl": &fstest.MapFile{
+			Data: []byte(`{{ if .Collapse }}{{ .Theme }}{{ end }}{{ .Text }}`),
+		},
+	}))
+	assert.Error(t, err)
+	assert.Contains(
+		t,
+		err.Error(),
+		`template "ac:code" references unknown field "Theme"`,
+	)
+}
+
+func TestNew_WithOverridesParseError(t *testing.T) {
+	_, err := New(nil, WithOverrides(fstest.MapFS{
+		"ac:box.tmpl": &fstest.MapFile{Data: []byte(`{{ .Body`)},
+	}))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unable to parse template "ac:box"`)
+}
+
+func TestLib_AddTemplate(t *testing.T) {
+	lib, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, lib.AddTemplate("shortcode:note", `<p>{{ .Text }}</p>`))
+
+	err = lib.AddTemplate("shortcode:note", `{{ .Text }}`)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `template "shortcode:note" already exists`)
+
+	err = lib.AddTemplate("ac:box", `{{ .Body }}`)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `template "ac:box" already exists`)
+
+	err = lib.AddTemplate("ac:box", `{{ .Color }}`, Override)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `references unknown field "Color"`)
+
+	assert.NoError(t, lib.AddTemplate("ac:box", `<b>{{ .Body }}</b>`, Override))
+
+	var html strings.Builder
+
+	err = lib.Templates.ExecuteTemplate(
+		&html,
+		"ac:box",
+		struct{ Body string }{"body"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, `<b>body</b>`, html.String())
+
+	sources := map[string]string{}
+	for _, template := range lib.ListTemplates() {
+		sources[template.Name] = template.Source
+	}
+
+	assert.Equal(t, SourceRuntime, sources["ac:box"])
+	assert.Equal(t, SourceRuntime, sources["shortcode:note"])
+
+	assert.Panics(t, func() {
+		lib.MustAddTemplate("shortcode:note", `{{ .Text }}`)
+	})
+}
+
+func TestNew_Funcs(t *testing.T) {
+	t.Setenv("MARK_TEST_CLUSTER", "staging")
+
+	lib, err := New(nil, WithFuncs(template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": func(value string) string { return "UPPER " + value },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	execute := func(body string, data interface{}) (string, error) {
+		var html strings.Builder
+
+		template, err := lib.Templates.New("test").Parse(body)
+		if err != nil {
+			return "", err
+		}
+
+		err = template.Execute(&html, data)
+
+		return html.String(), err
+	}
+
+	html, err := execute(
+		`{{ env "MARK_TEST_CLUSTER" }}|{{ .Title | trim }}|{{ .Title | lower }}|`+
+			`{{ upper "a" }}|{{ .Empty | default "none" }}|{{ .Title | default "none" }}`,
+		map[string]interface{}{"Title": " Title ", "Empty": ""},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "staging|Title| title |UPPER a|none| Title ", html)
+
+	html, err = execute(`{{ .Missing | default 1 }}`, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", html)
+
+	html, err = execute(`{{ now }}`, nil)
+	assert.NoError(t, err)
+
+	_, err = time.Parse(time.RFC3339, html)
+	assert.NoError(t, err)
+
+	first, err := execute(`{{ uuid }}`, nil)
+	assert.NoError(t, err)
+	assert.Regexp(
+		t,
+		`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		first,
+	)
+
+	second, err := execute(`{{ uuid }}`, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	// overrides and templates included by documents can use functions too
+	_, err = New(nil, WithOverrides(fstest.MapFS{
+		"ac:box.tmpl": &fstest.MapFile{Data: []byte(`{{ .Body | trim }}`)},
+	}))
+	assert.NoError(t, err)
+}
+
+func TestNew_WithDeterministicOutput(t *testing.T) {
+	lib, err := New(nil, WithDeterministicOutput())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"now", "uuid"} {
+		template, err := lib.Templates.New("test").Parse(`{{ ` + name + ` }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = template.Execute(&strings.Builder{}, nil)
+		assert.Error(t, err)
+		assert.Contains(
+			t,
+			err.Error(),
+			name+" is not available when output must be deterministic",
+		)
+	}
+
+	// explicitly provided functions are kept
+	lib, err = New(
+		nil,
+		WithDeterministicOutput(),
+		WithFuncs(template.FuncMap{"now": func() string { return "today" }}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := lib.Templates.New("test").Parse(`{{ now }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var html strings.Builder
+
+	assert.NoError(t, template.Execute(&html, nil))
+	assert.Equal(t, "today", html.String())
+}
+
+func TestLib_Checksum(t *testing.T) {
+	lib, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := New(nil, WithDeterministicOutput())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksum := lib.Checksum()
+	assert.Len(t, checksum, 64)
+	assert.Equal(t, checksum, other.Checksum())
+
+	assert.NoError(t, lib.AddTemplate("custom", `{{ .Text }}`))
+	assert.NotEqual(t, checksum, lib.Checksum())
+	assert.NoError(t, other.AddTemplate("custom", `{{ .Text }}`))
+	assert.Equal(t, lib.Checksum(), other.Checksum())
+
+	assert.NoError(t, other.AddTemplate("custom", `<b>{{ .Text }}</b>`, Override))
+	assert.NotEqual(t, lib.Checksum(), other.Checksum())
+}
+
+func TestNew_WithDialect(t *testing.T) {
+	user := WithFuncs(template.FuncMap{
+		"user": func(name string) *confluence.User {
+			return &confluence.User{AccountID: "557058:f3d1", UserKey: "8a7f808a"}
+		},
+	})
+
+	type testcase struct {
+		template string
+		data     interface{}
+		auto     string
+		cloud    string
+		server   string
+	}
+
+	tests := []testcase{
+		{
+			template: "ac:emoticon",
+			data:     map[string]string{"Name": "tick"},
+			auto:     `<ac:emoticon ac:name="tick"/>`,
+			cloud:    `<ac:emoticon ac:name="tick" ac:emoji-shortname=":white_check_mark:" ac:emoji-id="2705" ac:emoji-fallback="✅"/>`,
+			server:   `<ac:emoticon ac:name="tick"/>`,
+		},
+		{
+			template: "ac:link:user",
+			data:     map[string]string{"Name": "John Doe"},
+			auto:     `<ac:link><ri:user ri:account-id="557058:f3d1"/></ac:link>`,
+			cloud:    `<ac:link><ri:user ri:account-id="557058:f3d1"/></ac:link>`,
+			server:   `<ac:link><ri:user ri:userkey="8a7f808a"/></ac:link>`,
+		},
+		{
+			template: "ac:layout",
+			data:     map[string]string{"Layout": "article", "Body": "body", "Sidebar": "side"},
+			auto: `<ac:layout><ac:layout-section ac:type="two_right_sidebar">` +
+				`<ac:layout-cell>body</ac:layout-cell><ac:layout-cell>side</ac:layout-cell>` +
+				`</ac:layout-section></ac:layout>`,
+			cloud: `<ac:layout><ac:layout-section ac:type="two_right_sidebar" ac:breakout-mode="default">` +
+				`<ac:layout-cell>body</ac:layout-cell><ac:layout-cell>side</ac:layout-cell>` +
+				`</ac:layout-section></ac:layout>`,
+			server: `<ac:layout><ac:layout-section ac:type="two_right_sidebar">` +
+				`<ac:layout-cell>body</ac:layout-cell><ac:layout-cell>side</ac:layout-cell>` +
+				`</ac:layout-section></ac:layout>`,
+		},
+	}
+
+	checksums := map[string]bool{}
+
+	for _, value := range []dialect.Dialect{dialect.Auto, dialect.Cloud, dialect.Server} {
+		lib, err := New(nil, user, WithDialect(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checksums[lib.Checksum()] = true
+
+		for _, test := range tests {
+			expected := map[dialect.Dialect]string{
+				dialect.Auto:   test.auto,
+				dialect.Cloud:  test.cloud,
+				dialect.Server: test.server,
+			}[value]
+
+			var html strings.Builder
+
+			err := lib.Templates.ExecuteTemplate(&html, test.template, test.data)
+			assert.NoError(t, err, test.template)
+			assert.Equal(t, expected, html.String(), "%s: %s", value, test.template)
+		}
+	}
+
+	assert.Len(t, checksums, 3)
+
+	// overrides can branch on the dialect and use fields of the user
+	lib, err := New(nil, user, WithDialect(dialect.Server), WithOverrides(fstest.MapFS{
+		"ac:link:user.tmpl": &fstest.MapFile{
+			Data: []byte(`{{ dialect }}:{{ with .Name | user }}{{ .UserKey }}{{ end }}`),
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var html strings.Builder
+
+	err = lib.Templates.ExecuteTemplate(&html, "ac:link:user", map[string]string{"Name": "John Doe"})
+	assert.NoError(t, err)
+	assert.Equal(t, "server:8a7f808a", html.String())
+}
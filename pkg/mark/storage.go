@@ -0,0 +1,255 @@
+package mark
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+const (
+	cdataStart = "<![CDATA["
+	cdataEnd   = "]]>"
+
+	// cdataTag replaces CDATA sections in the page body, since HTML parser
+	// treats them as bogus comments.
+	cdataTag = "mark-cdata"
+)
+
+// storageReader rewrites parts of Confluence storage format which HTML
+// parser doesn't understand while the page body is read:
+//
+//   - self-closing tags like <ri:attachment/> and <time/> are expanded into
+//     opening and closing tags, otherwise HTML parser would treat all
+//     following content as children of such tag;
+//   - CDATA sections are cut out and replaced with <mark-cdata index="N">
+//     elements, which content is available as cdata[N].
+type storageReader struct {
+	reader *bufio.Reader
+	buffer bytes.Buffer
+	cdata  *[]string
+	err    error
+}
+
+func newStorageReader(reader io.Reader, cdata *[]string) *storageReader {
+	return &storageReader{
+		reader: bufio.NewReader(reader),
+		cdata:  cdata,
+	}
+}
+
+func (reader *storageReader) Read(data []byte) (int, error) {
+	for reader.buffer.Len() == 0 && reader.err == nil {
+		reader.err = reader.next()
+	}
+
+	if reader.buffer.Len() == 0 {
+		return 0, reader.err
+	}
+
+	return reader.buffer.Read(data)
+}
+
+// next reads page body up to the next tag and rewrites that tag if needed.
+func (reader *storageReader) next() error {
+	chunk, err := reader.reader.ReadSlice('<')
+	if err != nil {
+		reader.buffer.Write(chunk)
+
+		if err == bufio.ErrBufferFull {
+			return nil
+		}
+
+		return err
+	}
+
+	reader.buffer.Write(chunk[:len(chunk)-1])
+
+	prefix, _ := reader.reader.Peek(len(cdataStart) - 1)
+
+	switch {
+	case bytes.Equal(prefix, []byte(cdataStart[1:])):
+		_, _ = reader.reader.Discard(len(prefix))
+
+		return reader.readCDATA()
+
+	case bytes.HasPrefix(prefix, []byte("ac:")),
+		bytes.HasPrefix(prefix, []byte("ri:")),
+		bytes.HasPrefix(prefix, []byte("time")):
+		return reader.readTag()
+	}
+
+	reader.buffer.WriteByte('<')
+
+	return nil
+}
+
+func (reader *storageReader) readCDATA() error {
+	var (
+		body []byte
+		err  error
+	)
+
+	for !bytes.HasSuffix(body, []byte(cdataEnd)) {
+		var chunk []byte
+
+		chunk, err = reader.reader.ReadSlice('>')
+		body = append(body, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			err = nil
+			continue
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	*reader.cdata = append(
+		*reader.cdata,
+		string(bytes.TrimSuffix(body, []byte(cdataEnd))),
+	)
+
+	reader.buffer.WriteString(
+		`<` + cdataTag + ` index="` + strconv.Itoa(len(*reader.cdata)-1) + `">` +
+			`</` + cdataTag + `>`,
+	)
+
+	return err
+}
+
+func (reader *storageReader) readTag() error {
+	var (
+		tag   = []byte{'<'}
+		quote byte
+	)
+
+	for {
+		char, err := reader.reader.ReadByte()
+		if err != nil {
+			reader.buffer.Write(tag)
+
+			return err
+		}
+
+		tag = append(tag, char)
+
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+
+		case char == '"' || char == '\'':
+			quote = char
+
+		case char == '>':
+			if !bytes.HasSuffix(tag, []byte("/>")) {
+				reader.buffer.Write(tag)
+
+				return nil
+			}
+
+			name := tag[1 : len(tag)-2]
+			if end := bytes.IndexAny(name, " \t\r\n"); end >= 0 {
+				name = name[:end]
+			}
+
+			reader.buffer.Write(tag[:len(tag)-2])
+			reader.buffer.WriteString(">")
+			reader.buffer.WriteString("</" + string(name) + ">")
+
+			return nil
+		}
+	}
+}
+
+// getStorageChild returns first direct child element with given name, e.g.
+// ac:rich-text-body of the macro.
+func getStorageChild(selec *goquery.Selection, name string) *goquery.Selection {
+	return selec.ChildrenFiltered("*").FilterFunction(
+		func(_ int, child *goquery.Selection) bool {
+			return goquery.NodeName(child) == name
+		},
+	).First()
+}
+
+// getMacroName returns name of the structured macro.
+func getMacroName(selec *goquery.Selection) string {
+	return strings.ToLower(selec.AttrOr("ac:name", ""))
+}
+
+// getMacroParameter returns value of the structured macro parameter.
+func (conversion *conversion) getMacroParameter(
+	selec *goquery.Selection,
+	name string,
+) string {
+	var value string
+
+	selec.ChildrenFiltered("*").EachWithBreak(
+		func(_ int, child *goquery.Selection) bool {
+			if goquery.NodeName(child) != "ac:parameter" ||
+				!strings.EqualFold(child.AttrOr("ac:name", ""), name) {
+				return true
+			}
+
+			value = strings.TrimSpace(conversion.getText(child))
+
+			return false
+		},
+	)
+
+	return value
+}
+
+// getText returns text content of the selection with CDATA sections put
+// back in place.
+func (conversion *conversion) getText(selec *goquery.Selection) string {
+	var buffer strings.Builder
+
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		switch {
+		case node.Type == html.TextNode:
+			buffer.WriteString(node.Data)
+
+		case node.Type == html.ElementNode && node.Data == cdataTag:
+			buffer.WriteString(conversion.getCDATA(node))
+
+		default:
+			for child := node.FirstChild; child != nil; child = child.NextSibling {
+				walk(child)
+			}
+		}
+	}
+
+	for _, node := range selec.Nodes {
+		walk(node)
+	}
+
+	return buffer.String()
+}
+
+func (conversion *conversion) getCDATA(node *html.Node) string {
+	for _, attr := range node.Attr {
+		if attr.Key != "index" {
+			continue
+		}
+
+		index, err := strconv.Atoi(attr.Val)
+		if err == nil && index >= 0 && index < len(conversion.cdata) {
+			return conversion.cdata[index]
+		}
+	}
+
+	return ""
+}
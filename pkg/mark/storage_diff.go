@@ -0,0 +1,180 @@
+package mark
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/mark/escape"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// StorageEqual returns true if both documents in Confluence storage format
+// are the same after normalizing them, so the page doesn't need to be
+// updated. See StorageDiff for details of normalizing.
+func StorageEqual(a, b string) bool {
+	return normalizeStorage(a) == normalizeStorage(b)
+}
+
+// StorageDiff returns unified diff of normalized documents in Confluence
+// storage format, it's empty if documents are equal.
+//
+// Documents are normalized to one element or line of text per line, so
+// following differences are ignored:
+//
+//   - attributes listed in SanitizedAttributes, like ac:macro-id;
+//   - order of attributes and quotes around their values;
+//   - whitespace between elements, i.e. text which consists of whitespace
+//     and line breaks only;
+//   - self-closing tags and empty elements, like <br/> and <br></br>;
+//   - entities and CDATA sections, which are decoded into the same text.
+//
+// Documents which are not valid XML are compared and diffed as is.
+func StorageDiff(a, b string) string {
+	return getStorageDiff(a, b, "a", "b")
+}
+
+// getStorageDiff is StorageDiff with given names of documents in the diff
+// header.
+func getStorageDiff(a, b, fromFile, toFile string) string {
+	var (
+		from = normalizeStorage(a)
+		to   = normalizeStorage(b)
+	)
+
+	if from == to {
+		return ""
+	}
+
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+
+	return diff
+}
+
+func normalizeStorage(html string) string {
+	normalizer := &storageNormalizer{
+		sanitized: map[string]bool{},
+	}
+
+	for _, name := range SanitizedAttributes {
+		normalizer.sanitized[strings.ToLower(name)] = true
+	}
+
+	decoder := newStorageDecoder(html)
+
+	// the first token is the root element declaring namespaces
+	_, err := decoder.Token()
+
+	for err == nil {
+		var token xml.Token
+
+		token, err = decoder.Token()
+		if err == nil {
+			normalizer.write(token)
+		}
+	}
+
+	if !errors.Is(err, io.EOF) {
+		return html
+	}
+
+	return strings.Join(normalizer.lines, "\n")
+}
+
+// storageNormalizer writes tokens of the storage format one per line,
+// indented by their depth.
+type storageNormalizer struct {
+	sanitized map[string]bool
+
+	lines []string
+	depth int
+
+	// pending is the start tag without closing bracket, which is written
+	// as self-closing tag if the element is empty
+	pending string
+}
+
+func (normalizer *storageNormalizer) write(token xml.Token) {
+	switch token := token.(type) {
+	case xml.StartElement:
+		normalizer.flush()
+
+		attrs := []string{}
+		for _, attr := range token.Attr {
+			name := getStorageName(attr.Name)
+			if normalizer.sanitized[strings.ToLower(name)] {
+				continue
+			}
+
+			attrs = append(
+				attrs,
+				name+`="`+escape.EscapeAttr(attr.Value)+`"`,
+			)
+		}
+
+		sort.Strings(attrs)
+
+		normalizer.pending = "<" + getStorageName(token.Name)
+		if len(attrs) > 0 {
+			normalizer.pending += " " + strings.Join(attrs, " ")
+		}
+
+	case xml.EndElement:
+		if normalizer.pending != "" {
+			normalizer.add(normalizer.pending + "/>")
+			normalizer.pending = ""
+
+			return
+		}
+
+		// end of the root element
+		if normalizer.depth == 0 {
+			return
+		}
+
+		normalizer.depth--
+		normalizer.add("</" + getStorageName(token.Name) + ">")
+
+	case xml.CharData:
+		text := string(token)
+		if strings.TrimSpace(text) == "" && strings.Contains(text, "\n") {
+			return
+		}
+
+		normalizer.flush()
+
+		for _, line := range strings.Split(escape.EscapeText(text), "\n") {
+			normalizer.add(line)
+		}
+
+	case xml.Comment:
+		normalizer.flush()
+		normalizer.add("<!--" + string(token) + "-->")
+	}
+}
+
+// flush writes pending start tag of the element which has content.
+func (normalizer *storageNormalizer) flush() {
+	if normalizer.pending == "" {
+		return
+	}
+
+	normalizer.add(normalizer.pending + ">")
+	normalizer.pending = ""
+	normalizer.depth++
+}
+
+func (normalizer *storageNormalizer) add(line string) {
+	normalizer.lines = append(
+		normalizer.lines,
+		strings.Repeat("  ", normalizer.depth)+line,
+	)
+}
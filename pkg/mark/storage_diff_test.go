@@ -0,0 +1,135 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageEqual(t *testing.T) {
+	testcases := []struct {
+		name  string
+		a     string
+		b     string
+		equal bool
+	}{
+		{
+			name:  "same",
+			a:     `<p>text</p>`,
+			b:     `<p>text</p>`,
+			equal: true,
+		},
+		{
+			name: "macro id and attributes order",
+			a: `<ac:structured-macro ac:name="info" ac:schema-version="1" ac:macro-id="a1">` +
+				`<ac:parameter ac:name="title">x</ac:parameter></ac:structured-macro>`,
+			b: `<ac:structured-macro ac:macro-id='b2' ac:name='info'>` +
+				`<ac:parameter ac:name="title">x</ac:parameter></ac:structured-macro>`,
+			equal: true,
+		},
+		{
+			name: "whitespace between blocks",
+			a:    `<h1>a</h1><p>b</p><ul><li>c</li></ul>`,
+			b: text(
+				`<h1>a</h1>`,
+				``,
+				`<p>b</p>`,
+				`<ul>`,
+				`  <li>c</li>`,
+				`</ul>`,
+				``,
+			),
+			equal: true,
+		},
+		{
+			name:  "self-closing tags",
+			a:     `<p>a<br/></p><ac:link><ri:page ri:content-title="x"/></ac:link>`,
+			b:     `<p>a<br></br></p><ac:link><ri:page ri:content-title="x"></ri:page></ac:link>`,
+			equal: true,
+		},
+		{
+			name:  "entities and cdata",
+			a:     `<p>&ldquo;a&rdquo; &amp; b</p><ac:plain-text-body><![CDATA[<x>]]></ac:plain-text-body>`,
+			b:     `<p>“a” &#38; b</p><ac:plain-text-body>&lt;x&gt;</ac:plain-text-body>`,
+			equal: true,
+		},
+		{
+			name: "whitespace in text",
+			a:    `<p><strong>a</strong> <em>b</em></p>`,
+			b:    `<p><strong>a</strong><em>b</em></p>`,
+		},
+		{
+			name: "text",
+			a:    `<p>a</p>`,
+			b:    `<p>b</p>`,
+		},
+		{
+			name: "attribute",
+			a:    `<ac:structured-macro ac:name="info"></ac:structured-macro>`,
+			b:    `<ac:structured-macro ac:name="tip"></ac:structured-macro>`,
+		},
+		{
+			name: "invalid",
+			a:    `<p>a`,
+			b:    `<p>a</p>`,
+		},
+		{
+			name:  "invalid same",
+			a:     `<p>a`,
+			b:     `<p>a`,
+			equal: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		assert.Equal(
+			t,
+			testcase.equal,
+			StorageEqual(testcase.a, testcase.b),
+			testcase.name,
+		)
+
+		diff := StorageDiff(testcase.a, testcase.b)
+		if testcase.equal {
+			assert.Empty(t, diff, testcase.name)
+		} else {
+			assert.NotEmpty(t, diff, testcase.name)
+		}
+	}
+}
+
+func TestStorageDiff(t *testing.T) {
+	diff := StorageDiff(
+		`<h1>Title</h1><p>first <strong>bold</strong></p>`+
+			`<ac:structured-macro ac:name="info" ac:macro-id="1"><ac:rich-text-body><p>note</p></ac:rich-text-body></ac:structured-macro>`,
+		text(
+			`<h1>Title</h1>`,
+			`<p>first <em>bold</em></p>`,
+			`<ac:structured-macro ac:macro-id="2" ac:name="info">`,
+			`<ac:rich-text-body><p>note</p></ac:rich-text-body>`,
+			`</ac:structured-macro>`,
+		),
+	)
+
+	assert.Equal(
+		t,
+		text(
+			`--- a`,
+			`+++ b`,
+			`@@ -3,9 +3,9 @@`,
+			` </h1>`,
+			` <p>`,
+			`   first `,
+			`-  <strong>`,
+			`+  <em>`,
+			`     bold`,
+			`-  </strong>`,
+			`+  </em>`,
+			` </p>`,
+			` <ac:structured-macro ac:name="info">`,
+			`   <ac:rich-text-body>`,
+			``,
+		),
+		diff,
+	)
+}
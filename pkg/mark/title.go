@@ -0,0 +1,408 @@
+package mark
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	bf "github.com/kovetskiy/blackfriday/v2"
+)
+
+// TitleFromH1 defines how the leading H1 heading of the document is handled.
+type TitleFromH1 string
+
+const (
+	// TitleFromH1Keep keeps the leading H1 heading in the page body and
+	// doesn't use it as page title. The body is kept the same way if no
+	// policy is given, but ResolveTitle still falls back to the H1 then.
+	TitleFromH1Keep TitleFromH1 = "keep"
+
+	// TitleFromH1Drop uses the leading H1 heading as page title and drops it
+	// from the page body.
+	TitleFromH1Drop TitleFromH1 = "drop"
+
+	// TitleFromH1KeepAndTitle uses the leading H1 heading as page title and
+	// keeps it in the page body, which is useful for themes hiding page title.
+	TitleFromH1KeepAndTitle TitleFromH1 = "keep-and-title"
+)
+
+// ParseTitleFromH1 parses H1 handling policy from its string representation.
+func ParseTitleFromH1(value string) (TitleFromH1, error) {
+	switch mode := TitleFromH1(strings.ToLower(strings.TrimSpace(value))); mode {
+	case TitleFromH1Keep, TitleFromH1Drop, TitleFromH1KeepAndTitle:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf(
+		"unexpected H1 policy %q, expected one of: %s, %s, %s",
+		value,
+		TitleFromH1Keep,
+		TitleFromH1Drop,
+		TitleFromH1KeepAndTitle,
+	)
+}
+
+// ApplyTitleFromH1 returns page title and page body according to the given
+// policy. Title is empty if the policy doesn't take title from the H1
+// heading or there is no leading H1 heading.
+func ApplyTitleFromH1(markdown []byte, mode TitleFromH1) (string, []byte) {
+	switch mode {
+	case TitleFromH1Drop:
+		return SplitDocumentTitle(markdown)
+
+	case TitleFromH1KeepAndTitle:
+		return ExtractDocumentLeadingH1(markdown), markdown
+	}
+
+	return "", markdown
+}
+
+// ResolveTitle returns the title of the page, which is the first one found
+// of:
+//
+//   - Title header, i.e. CompileOptions.Meta.Title;
+//   - plain text of the leading H1 heading without leading emoji, see
+//     ExtractDocumentLeadingH1 and SplitTitleEmoji, unless TitleFromH1Keep
+//     is set in CompileOptions or Meta;
+//   - name of CompileOptions.File without extension.
+//
+// CompileOptions.TitlePrefix and TitleSuffix are added to the title found.
+// Empty string is returned if there is no title.
+func ResolveTitle(markdown []byte, opts CompileOptions) string {
+	var title string
+
+	if opts.Meta != nil {
+		title = opts.Meta.Title
+	}
+
+	// the leading emoji of the heading is the emoji of the page rather
+	// than the part of the title, see ResolveEmoji
+	if title == "" && !isTitleFromH1Kept(opts) {
+		_, title = SplitTitleEmoji(ExtractDocumentLeadingH1(markdown))
+	}
+
+	if title == "" && opts.File != "" {
+		name := filepath.Base(opts.File)
+
+		title = strings.TrimSpace(strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+
+	if title == "" {
+		return ""
+	}
+
+	return opts.TitlePrefix + title + opts.TitleSuffix
+}
+
+// isTitleFromH1Kept returns true if TitleFromH1Keep is set explicitly rather
+// than being the default policy.
+func isTitleFromH1Kept(opts CompileOptions) bool {
+	if opts.Meta != nil && opts.Meta.TitleFromH1 != "" {
+		return opts.Meta.TitleFromH1 == TitleFromH1Keep
+	}
+
+	return opts.TitleFromH1 == TitleFromH1Keep
+}
+
+// utf8BOM is the byte order mark some editors put at the beginning of files.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// documentTitle describes location of the leading H1 heading in the document.
+type documentTitle struct {
+	// Start and End are byte offsets of the heading lines, including
+	// trailing line break.
+	Start int
+	End   int
+
+	// Text is the heading text as written in the source.
+	Text string
+}
+
+// findDocumentLeadingH1 looks for the H1 heading (both ATX and setext styles)
+// which goes before any other content of the document. Blank lines, BOM,
+// HTML comments (e.g. metadata headers) and YAML front matter are allowed
+// before the heading.
+func findDocumentLeadingH1(markdown []byte) (documentTitle, bool) {
+	offset := 0
+	if bytes.HasPrefix(markdown, utf8BOM) {
+		offset = len(utf8BOM)
+	}
+
+	if line, next := readLine(markdown, offset); isFrontMatterDelimiter(line) {
+		end, ok := skipFrontMatter(markdown, next)
+		if !ok {
+			return documentTitle{}, false
+		}
+
+		offset = end
+	}
+
+	for offset < len(markdown) {
+		line, next := readLine(markdown, offset)
+
+		trimmed := bytes.TrimSpace(line)
+
+		switch {
+		case len(trimmed) == 0:
+			offset = next
+
+		case bytes.HasPrefix(trimmed, []byte("<!--")):
+			end := bytes.Index(markdown[offset:], []byte("-->"))
+			if end < 0 {
+				return documentTitle{}, false
+			}
+
+			rest, next := readLine(markdown, offset+end+len("-->"))
+			if len(bytes.TrimSpace(rest)) > 0 {
+				return documentTitle{}, false
+			}
+
+			offset = next
+
+		case len(line) > 1 && line[0] == '#' && line[1] != '#':
+			return documentTitle{
+				Start: offset,
+				End:   next,
+				Text:  string(bytes.TrimSpace(line[1:])),
+			}, true
+
+		default:
+			underline, after := readLine(markdown, next)
+			if isSetextH1Underline(underline) {
+				return documentTitle{
+					Start: offset,
+					End:   after,
+					Text:  string(trimmed),
+				}, true
+			}
+
+			return documentTitle{}, false
+		}
+	}
+
+	return documentTitle{}, false
+}
+
+// isSetextH1Underline checks that line is the underline of the setext-style
+// H1 heading:
+//
+//	Title
+//	=====
+func isSetextH1Underline(line []byte) bool {
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 || len(line)-len(bytes.TrimLeft(line, " ")) > 3 {
+		return false
+	}
+
+	return len(bytes.Trim(line, " =")) == 0
+}
+
+// readLine returns line starting at given offset without line break (either
+// LF or CRLF) and offset of the next line.
+func readLine(markdown []byte, offset int) ([]byte, int) {
+	if offset >= len(markdown) {
+		return nil, len(markdown)
+	}
+
+	end := bytes.IndexByte(markdown[offset:], '\n')
+	if end < 0 {
+		return bytes.TrimSuffix(markdown[offset:], []byte("\r")), len(markdown)
+	}
+
+	line := bytes.TrimSuffix(markdown[offset:offset+end], []byte("\r"))
+
+	return line, offset + end + 1
+}
+
+func isFrontMatterDelimiter(line []byte) bool {
+	return bytes.Equal(bytes.TrimRight(line, " \t"), []byte("---"))
+}
+
+// skipFrontMatter returns offset right after the closing front matter
+// delimiter. False is returned if front matter is not closed.
+func skipFrontMatter(markdown []byte, offset int) (int, bool) {
+	for offset < len(markdown) {
+		var line []byte
+
+		line, offset = readLine(markdown, offset)
+
+		trimmed := bytes.TrimRight(line, " \t")
+		if isFrontMatterDelimiter(trimmed) || bytes.Equal(trimmed, []byte("...")) {
+			return offset, true
+		}
+	}
+
+	return offset, false
+}
+
+// SplitDocumentTitle returns plain text of the leading H1 heading along with
+// the rest of the document without that heading. Title is empty and the
+// document is returned as is if there is no leading H1 heading.
+func SplitDocumentTitle(markdown []byte) (string, []byte) {
+	title, ok := findDocumentLeadingH1(markdown)
+	if !ok {
+		return "", markdown
+	}
+
+	return getHeadingPlainText(title.Text), dropDocumentTitle(markdown, title)
+}
+
+func dropDocumentTitle(markdown []byte, title documentTitle) []byte {
+	result := make([]byte, 0, len(markdown)-(title.End-title.Start))
+	result = append(result, markdown[:title.Start]...)
+	result = append(result, markdown[title.End:]...)
+
+	return result
+}
+
+// DropDocumentLeadingH1 will drop leading H1 headings to prevent
+// duplication of or visual conflict with page titles.
+// NOTE: This is intended only to operate on the whole markdown document.
+// Operating on individual lines will clear them if the begin with `#`.
+//
+// Deprecated: use SplitDocumentTitle, which returns the document without
+// the heading along with the title.
+func DropDocumentLeadingH1(
+	markdown []byte,
+) []byte {
+	title, ok := findDocumentLeadingH1(markdown)
+	if !ok {
+		return markdown
+	}
+
+	return dropDocumentTitle(markdown, title)
+}
+
+// ExtractDocumentLeadingH1 will extract leading H1 heading as plain text
+// suitable for the page title: inline formatting, trailing closing hashes and
+// HTML comments are stripped and whitespace is collapsed.
+//
+// Use SplitDocumentTitle if both title and the rest of the document are
+// needed.
+func ExtractDocumentLeadingH1(markdown []byte) string {
+	title, ok := findDocumentLeadingH1(markdown)
+	if !ok {
+		return ""
+	}
+
+	return getHeadingPlainText(title.Text)
+}
+
+// ExtractDocumentLeadingH1Raw will extract leading H1 heading text as it's
+// written in the source.
+func ExtractDocumentLeadingH1Raw(markdown []byte) string {
+	title, ok := findDocumentLeadingH1(markdown)
+	if !ok {
+		return ""
+	}
+
+	return title.Text
+}
+
+// getHeadingPlainText parses given heading text as markdown and returns its
+// text content only.
+func getHeadingPlainText(heading string) string {
+	document := bf.New(bf.WithExtensions(DefaultExtensions)).Parse(
+		[]byte("# " + heading + "\n"),
+	)
+
+	return getPlainText(document)
+}
+
+// getPlainText returns text content of given node, which includes text and
+// code spans, but not HTML and images.
+func getPlainText(node *bf.Node) string {
+	var buffer strings.Builder
+
+	node.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if !entering {
+			return bf.GoToNext
+		}
+
+		switch node.Type {
+		case bf.Text, bf.Code:
+			buffer.Write(node.Literal)
+
+		case bf.Softbreak, bf.Hardbreak:
+			buffer.WriteString(" ")
+
+		case bf.Image:
+			return bf.SkipChildren
+		}
+
+		return bf.GoToNext
+	})
+
+	return strings.Join(strings.Fields(buffer.String()), " ")
+}
+
+// ExtractDocumentSummary returns plain text of the first paragraph following
+// the leading H1 heading, skipping metadata comments and paragraphs consisting
+// of images only (e.g. badges). Empty string is returned if the first content
+// of the document is not a paragraph, e.g. a heading, a list or a code block.
+func ExtractDocumentSummary(markdown []byte) string {
+	_, markdown = SplitDocumentTitle(markdown)
+
+	markdown = bytes.TrimPrefix(markdown, utf8BOM)
+	if line, next := readLine(markdown, 0); isFrontMatterDelimiter(line) {
+		if end, ok := skipFrontMatter(markdown, next); ok {
+			markdown = markdown[end:]
+		}
+	}
+
+	document := bf.New(bf.WithExtensions(DefaultExtensions)).Parse(markdown)
+
+	for node := document.FirstChild; node != nil; node = node.Next {
+		switch node.Type {
+		case bf.HTMLBlock:
+			continue
+
+		case bf.Paragraph:
+			if isImageParagraph(node) {
+				continue
+			}
+
+			return getPlainText(node)
+		}
+
+		return ""
+	}
+
+	return ""
+}
+
+// isImageParagraph checks that paragraph consists of images, possibly
+// wrapped into links, only.
+func isImageParagraph(paragraph *bf.Node) bool {
+	images := 0
+
+	var check func(node *bf.Node) bool
+	check = func(node *bf.Node) bool {
+		for node := node.FirstChild; node != nil; node = node.Next {
+			switch node.Type {
+			case bf.Image:
+				images++
+
+			case bf.Link:
+				if !check(node) {
+					return false
+				}
+
+			case bf.Softbreak, bf.Hardbreak, bf.HTMLSpan:
+
+			case bf.Text:
+				if len(bytes.TrimSpace(node.Literal)) > 0 {
+					return false
+				}
+
+			default:
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return check(paragraph) && images > 0
+}
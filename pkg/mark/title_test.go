@@ -0,0 +1,400 @@
+package mark
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractDocumentLeadingH1(t *testing.T) {
+	filename := "testdata/header.md"
+
+	markdown, err := ioutil.ReadFile(filename)
+	if err != nil {
+		panic(err)
+	}
+
+	actual := ExtractDocumentLeadingH1(markdown)
+
+	assert.Equal(t, "a", actual)
+}
+
+func TestDocumentLeadingH1(t *testing.T) {
+	testcases := []struct {
+		name     string
+		markdown string
+		title    string
+		dropped  string
+	}{
+		{
+			name:     "first line",
+			markdown: text("# Title", "body"),
+			title:    "Title",
+			dropped:  text("body"),
+		},
+		{
+			name:     "no trailing newline",
+			markdown: "# Title",
+			title:    "Title",
+			dropped:  "",
+		},
+		{
+			name:     "leading blank lines",
+			markdown: text("", "  ", "# Title", "body"),
+			title:    "Title",
+			dropped:  text("", "  ", "body"),
+		},
+		{
+			name:     "byte order mark",
+			markdown: "\xef\xbb\xbf" + text("# Title", "body"),
+			title:    "Title",
+			dropped:  "\xef\xbb\xbf" + text("body"),
+		},
+		{
+			name: "metadata comments",
+			markdown: text(
+				"<!-- Space: ENG -->",
+				"<!-- Attachment:",
+				"     image.png -->",
+				"",
+				"# Title",
+				"body",
+			),
+			title: "Title",
+			dropped: text(
+				"<!-- Space: ENG -->",
+				"<!-- Attachment:",
+				"     image.png -->",
+				"",
+				"body",
+			),
+		},
+		{
+			name:     "front matter",
+			markdown: text("---", "title: x", "---", "# Title", "body"),
+			title:    "Title",
+			dropped:  text("---", "title: x", "---", "body"),
+		},
+		{
+			name:     "unclosed front matter",
+			markdown: text("---", "# Title", "body"),
+			title:    "",
+			dropped:  text("---", "# Title", "body"),
+		},
+		{
+			name:     "content before heading",
+			markdown: text("intro", "", "# Title", "body"),
+			title:    "",
+			dropped:  text("intro", "", "# Title", "body"),
+		},
+		{
+			name:     "comment followed by content",
+			markdown: text("<!-- note --> intro", "# Title"),
+			title:    "",
+			dropped:  text("<!-- note --> intro", "# Title"),
+		},
+		{
+			name:     "setext",
+			markdown: text("Title", "=====", "body"),
+			title:    "Title",
+			dropped:  text("body"),
+		},
+		{
+			name:     "setext with trailing whitespace",
+			markdown: text("", "Some Title  ", "===  \t", "body"),
+			title:    "Some Title",
+			dropped:  text("", "body"),
+		},
+		{
+			name:     "setext h2 is not a title",
+			markdown: text("Section", "-------", "body"),
+			title:    "",
+			dropped:  text("Section", "-------", "body"),
+		},
+		{
+			name:     "setext after content",
+			markdown: text("intro", "", "Title", "=====", "body"),
+			title:    "",
+			dropped:  text("intro", "", "Title", "=====", "body"),
+		},
+		{
+			name:     "crlf",
+			markdown: "# Title\r\nbody\r\n",
+			title:    "Title",
+			dropped:  "body\r\n",
+		},
+		{
+			name:     "crlf with bom and metadata",
+			markdown: "\xef\xbb\xbf<!-- Space: ENG -->\r\n\r\n# Title\r\nbody",
+			title:    "Title",
+			dropped:  "\xef\xbb\xbf<!-- Space: ENG -->\r\n\r\nbody",
+		},
+		{
+			name:     "crlf setext",
+			markdown: "Title \r\n=====\r\nbody",
+			title:    "Title",
+			dropped:  "body",
+		},
+		{
+			name:     "h2 is not a title",
+			markdown: text("## Section", "body"),
+			title:    "",
+			dropped:  text("## Section", "body"),
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			assert.Equal(
+				t,
+				testcase.title,
+				ExtractDocumentLeadingH1([]byte(testcase.markdown)),
+			)
+
+			assert.Equal(
+				t,
+				testcase.dropped,
+				string(DropDocumentLeadingH1([]byte(testcase.markdown))),
+			)
+
+			title, rest := SplitDocumentTitle([]byte(testcase.markdown))
+			assert.Equal(t, testcase.title, title)
+			assert.Equal(t, testcase.dropped, string(rest))
+		})
+	}
+}
+
+func TestExtractDocumentLeadingH1_PlainText(t *testing.T) {
+	testcases := map[string]string{
+		"# `mark` — *quick start* <!-- draft -->":   "mark — quick start",
+		"# Closing hashes ##":                       "Closing hashes",
+		"# [Linked](https://example.com) __title__": "Linked title",
+		"#   lots   of\tspaces  ":                   "lots of spaces",
+		"Setext **title**\n===":                     "Setext title",
+		"# Escaped \\*stars\\*":                     "Escaped *stars*",
+	}
+
+	for markdown, title := range testcases {
+		assert.Equal(t, title, ExtractDocumentLeadingH1([]byte(markdown)), markdown)
+	}
+
+	assert.Equal(
+		t,
+		"`mark` — *quick start* <!-- draft -->",
+		ExtractDocumentLeadingH1Raw(
+			[]byte("# `mark` — *quick start* <!-- draft -->\nbody"),
+		),
+	)
+}
+
+func TestCompileMarkdownWithOptions_TitleFromH1(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text("# Title", "", "body"))
+
+	compile := func(opts CompileOptions) string {
+		html, err := CompileMarkdownWithOptions(markdown, lib, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return html
+	}
+
+	kept := `<h1 id="title">Title</h1>` + NL + NL + `<p>body</p>` + NL
+
+	assert.Equal(t, kept, compile(CompileOptions{}))
+	assert.Equal(t, kept, compile(CompileOptions{TitleFromH1: TitleFromH1Keep}))
+	assert.Equal(
+		t,
+		kept,
+		compile(CompileOptions{TitleFromH1: TitleFromH1KeepAndTitle}),
+	)
+	assert.Equal(
+		t,
+		`<p>body</p>`+NL,
+		compile(CompileOptions{TitleFromH1: TitleFromH1Drop}),
+	)
+	assert.Equal(
+		t,
+		kept,
+		compile(CompileOptions{
+			TitleFromH1: TitleFromH1Drop,
+			Meta:        &Meta{TitleFromH1: TitleFromH1Keep},
+		}),
+	)
+}
+
+func TestExtractMeta_TitleFromH1(t *testing.T) {
+	meta, _, err := ExtractMeta([]byte("<!-- Title-From-H1: keep-and-title -->\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, TitleFromH1KeepAndTitle, meta.TitleFromH1)
+
+	_, _, err = ExtractMeta([]byte("<!-- Title-From-H1: maybe -->\n"))
+	assert.Error(t, err)
+}
+
+func TestExtractDocumentSummary(t *testing.T) {
+	testcases := []struct {
+		name     string
+		markdown string
+		summary  string
+	}{
+		{
+			name: "paragraph after title",
+			markdown: text(
+				"# Title",
+				"",
+				"The *quick* `summary`",
+				"of [the page](https://example.com).",
+				"",
+				"Second paragraph.",
+			),
+			summary: "The quick summary of the page.",
+		},
+		{
+			name: "metadata and badges",
+			markdown: text(
+				"<!-- Space: ENG -->",
+				"",
+				"# Title",
+				"",
+				"<!-- comment -->",
+				"",
+				"[![build](https://example.com/b.svg)](https://example.com) ![coverage](c.svg)",
+				"",
+				"Summary.",
+			),
+			summary: "Summary.",
+		},
+		{
+			name:     "without title",
+			markdown: text("Summary.", "", "# Title"),
+			summary:  "Summary.",
+		},
+		{
+			name:     "heading first",
+			markdown: text("# Title", "", "## Section", "", "text"),
+			summary:  "",
+		},
+		{
+			name:     "list first",
+			markdown: text("# Title", "", "* item", "", "text"),
+			summary:  "",
+		},
+		{
+			name:     "code first",
+			markdown: text("# Title", "", "```", "code", "```", "", "text"),
+			summary:  "",
+		},
+		{
+			name:     "empty",
+			markdown: text("# Title"),
+			summary:  "",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			assert.Equal(
+				t,
+				testcase.summary,
+				ExtractDocumentSummary([]byte(testcase.markdown)),
+			)
+		})
+	}
+}
+
+func TestResolveTitle(t *testing.T) {
+	markdown := []byte("# The *Runbook*\n\nbody\n")
+
+	for _, testcase := range []struct {
+		markdown []byte
+		opts     CompileOptions
+		expected string
+	}{
+		{
+			markdown,
+			CompileOptions{Meta: &Meta{Title: "Explicit"}, File: "docs/page.md"},
+			"Explicit",
+		},
+		{
+			markdown,
+			CompileOptions{Meta: &Meta{}, File: "docs/page.md"},
+			"The Runbook",
+		},
+		{
+			[]byte("body\n"),
+			CompileOptions{File: "docs/deploy guide.md"},
+			"deploy guide",
+		},
+		{
+			markdown,
+			CompileOptions{TitleFromH1: TitleFromH1Keep, File: "docs/page.md"},
+			"page",
+		},
+		{
+			markdown,
+			CompileOptions{TitleFromH1: TitleFromH1Keep},
+			"",
+		},
+		{
+			markdown,
+			CompileOptions{
+				TitleFromH1: TitleFromH1Keep,
+				Meta:        &Meta{TitleFromH1: TitleFromH1KeepAndTitle},
+			},
+			"The Runbook",
+		},
+		{
+			markdown,
+			CompileOptions{
+				TitleFromH1: TitleFromH1Drop,
+				Meta:        &Meta{TitleFromH1: TitleFromH1Keep},
+			},
+			"",
+		},
+		{
+			[]byte("body\n"),
+			CompileOptions{TitlePrefix: "[Runbook] "},
+			"",
+		},
+		{
+			markdown,
+			CompileOptions{TitlePrefix: "[Runbook] ", TitleSuffix: " (draft)"},
+			"[Runbook] The Runbook (draft)",
+		},
+		{
+			[]byte("body\n"),
+			CompileOptions{
+				Meta:        &Meta{Title: "Explicit"},
+				TitlePrefix: "[Runbook] ",
+			},
+			"[Runbook] Explicit",
+		},
+	} {
+		assert.Equal(
+			t,
+			testcase.expected,
+			ResolveTitle(testcase.markdown, testcase.opts),
+			testcase.opts,
+		)
+	}
+
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Compile(markdown, lib, CompileOptions{TitlePrefix: "[Runbook] "})
+	assert.NoError(t, err)
+	assert.Equal(t, "[Runbook] The Runbook", result.Title)
+}
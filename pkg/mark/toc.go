@@ -0,0 +1,227 @@
+package mark
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/reconquest/karma-go"
+)
+
+// InlineTOC defines how tables of contents written in documents, e.g. for
+// readers of the repository on GitHub, are handled.
+type InlineTOC string
+
+const (
+	// InlineTOCKeep keeps tables of contents as they're written. This is
+	// the default.
+	InlineTOCKeep InlineTOC = "keep"
+
+	// InlineTOCStrip removes tables of contents, e.g. if pages have the toc
+	// macro already.
+	InlineTOCStrip InlineTOC = "strip"
+
+	// InlineTOCMacro replaces tables of contents with the toc macro, which
+	// is written by ac:toc template.
+	InlineTOCMacro InlineTOC = "macro"
+)
+
+// ParseInlineTOC parses the policy of tables of contents from its string
+// representation.
+func ParseInlineTOC(value string) (InlineTOC, error) {
+	switch mode := InlineTOC(strings.ToLower(strings.TrimSpace(value))); mode {
+	case InlineTOCKeep, InlineTOCStrip, InlineTOCMacro:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf(
+		"unexpected table of contents policy %q, expected one of: %s, %s, %s",
+		value,
+		InlineTOCKeep,
+		InlineTOCStrip,
+		InlineTOCMacro,
+	)
+}
+
+var (
+	// reDoctocStart and reDoctocEnd match markers doctoc writes around
+	// tables of contents it generates.
+	reDoctocStart = regexp.MustCompile(`^<!--\s*START doctoc\b.*-->$`)
+	reDoctocEnd   = regexp.MustCompile(`^<!--\s*END doctoc\b.*-->$`)
+
+	// reTOCHeading matches the heading of hand-written table of contents.
+	reTOCHeading = regexp.MustCompile(`(?i)^#{1,6}[ \t]+table of contents[ \t]*#*$`)
+
+	// reTOCItem matches list items of table of contents, which are nothing
+	// but links to headings.
+	reTOCItem = regexp.MustCompile(
+		`^[ \t]*(?:[-*+]|\d{1,9}[.)])[ \t]+\[[^\]]+\]\(#[^)\s]*\)[ \t]*$`,
+	)
+)
+
+// findInlineTOCs returns byte offsets of tables of contents, which are
+// either regions between doctoc markers or "Table of Contents" headings
+// followed by lists of links to headings. Regions are found only if they
+// clearly match, e.g. the heading followed by anything but such list is
+// kept. Offsets include trailing line breaks.
+func findInlineTOCs(markdown []byte) [][2]int {
+	var (
+		regions = [][2]int{}
+		fence   []byte
+
+		// start is the offset of the opened doctoc marker, -1 if there is
+		// none
+		start = -1
+	)
+
+	for offset := 0; offset < len(markdown); {
+		line, next := readLine(markdown, offset)
+
+		trimmed := bytes.TrimSpace(line)
+
+		switch {
+		case fence != nil:
+			if bytes.HasPrefix(trimmed, fence) {
+				fence = nil
+			}
+
+		case start >= 0:
+			if reDoctocEnd.Match(trimmed) {
+				regions = append(regions, [2]int{start, next})
+				start = -1
+			}
+
+		case getCodeFence(line) != nil:
+			fence = getCodeFence(line)
+
+		case reDoctocStart.Match(trimmed):
+			start = offset
+
+		case reTOCHeading.Match(line):
+			if end, ok := findTOCList(markdown, next); ok {
+				regions = append(regions, [2]int{offset, end})
+				next = end
+			}
+		}
+
+		offset = next
+	}
+
+	return regions
+}
+
+// findTOCList returns the offset after the list of links to headings,
+// which follows the heading at given offset, preceded by blank lines only.
+// False is returned if there is no list or it's followed by other content
+// without blank line, e.g. by lazy continuation of the last item.
+func findTOCList(markdown []byte, offset int) (int, bool) {
+	var (
+		line []byte
+		next int
+	)
+
+	for offset < len(markdown) {
+		line, next = readLine(markdown, offset)
+		if len(bytes.TrimSpace(line)) > 0 {
+			break
+		}
+
+		offset = next
+	}
+
+	end := offset
+
+	for end < len(markdown) {
+		line, next = readLine(markdown, end)
+		if !reTOCItem.Match(line) {
+			break
+		}
+
+		end = next
+	}
+
+	if end == offset || (end < len(markdown) && len(bytes.TrimSpace(line)) > 0) {
+		return 0, false
+	}
+
+	return end, true
+}
+
+// replaceInlineTOCs removes tables of contents according to the policy, the
+// first one is replaced with toc, which is the toc macro prepared for
+// parsing, the rest are removed. Removed lines are dropped from sources.
+func replaceInlineTOCs(
+	markdown []byte,
+	sources *sourcemap.Map,
+	mode InlineTOC,
+	toc []byte,
+) ([]byte, *sourcemap.Map) {
+	if mode != InlineTOCStrip && mode != InlineTOCMacro {
+		return markdown, sources
+	}
+
+	regions := findInlineTOCs(markdown)
+	if len(regions) == 0 {
+		return markdown, sources
+	}
+
+	if mode != InlineTOCMacro {
+		toc = nil
+	}
+
+	sources = sources.Clone()
+	if sources == nil {
+		sources = sourcemap.New(markdown)
+	}
+
+	for index := len(regions) - 1; index >= 0; index-- {
+		var replacement []byte
+		if index == 0 {
+			replacement = toc
+		}
+
+		sources.Replace(markdown, regions[index][0], regions[index][1], replacement)
+	}
+
+	var (
+		result = make([]byte, 0, len(markdown)+len(toc))
+		copied = 0
+	)
+
+	for index, region := range regions {
+		result = append(result, markdown[copied:region[0]]...)
+		if index == 0 {
+			result = append(result, toc...)
+		}
+
+		copied = region[1]
+	}
+
+	return append(result, markdown[copied:]...), sources
+}
+
+// renderInlineTOCMacro renders the toc macro, which replaces tables of
+// contents, by ac:toc template with default parameters. The macro is
+// surrounded by blank lines and prepared for parsing, so it's parsed as
+// HTML block. Nil is returned if tables of contents are not replaced.
+func renderInlineTOCMacro(stdlib *stdlib.Lib, opts CompileOptions) ([]byte, error) {
+	if opts.getInlineTOC() != InlineTOCMacro {
+		return nil, nil
+	}
+
+	var toc bytes.Buffer
+
+	toc.WriteString("\n")
+
+	err := stdlib.Templates.ExecuteTemplate(&toc, "ac:toc", map[string]string{})
+	if err != nil {
+		return nil, karma.Format(err, "unable to render table of contents")
+	}
+
+	toc.WriteString("\n")
+
+	return prepareMarkdown(toc.Bytes()), nil
+}
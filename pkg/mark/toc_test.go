@@ -0,0 +1,97 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindInlineTOCs(t *testing.T) {
+	test := func(markdown string, expected ...string) {
+		t.Helper()
+
+		found := []string{}
+		for _, region := range findInlineTOCs([]byte(markdown)) {
+			found = append(found, markdown[region[0]:region[1]])
+		}
+
+		if expected == nil {
+			expected = []string{}
+		}
+
+		assert.Equal(t, expected, found, markdown)
+	}
+
+	test(
+		"# A\n\n## Table of Contents\n\n- [B](#b)\n  - [C](#c)\n\n## B\n",
+		"## Table of Contents\n\n- [B](#b)\n  - [C](#c)\n",
+	)
+	test(
+		"## table of contents ##\n1. [B](#b)",
+		"## table of contents ##\n1. [B](#b)",
+	)
+	test(
+		"<!-- START doctoc -->\nanything\n<!-- END doctoc -->\nText.\n",
+		"<!-- START doctoc -->\nanything\n<!-- END doctoc -->\n",
+	)
+
+	// anything but the list of links to headings is kept
+	test("## Table of Contents\n\nText.\n\n- [B](#b)\n")
+	test("## Table of Contents\n\n- [B](b.md)\n")
+	test("## Table of Contents\n\n- [B](#b) and more\n")
+	test("## Table of Contents\n\n- [B](#b)\nlazy continuation\n")
+	test("## Contents\n\n- [B](#b)\n")
+	test("Table of Contents\n---\n\n- [B](#b)\n")
+	test("<!-- START doctoc -->\n- [B](#b)\n")
+	test("```\n## Table of Contents\n\n- [B](#b)\n```\n")
+}
+
+func TestCompile_InlineTOC(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"# Guide",
+		"",
+		"## Table of Contents",
+		"",
+		"- [Usage](#usage)",
+		"",
+		"## Usage",
+		"",
+		"[link](missing.md)",
+		"",
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		result, err := Compile(markdown, lib, CompileOptions{
+			Engine:    engine,
+			InlineTOC: InlineTOCStrip,
+		})
+		if assert.NoError(t, err, engine) {
+			assert.NotContains(t, result.HTML, "Table of Contents", engine)
+			assert.NotContains(t, result.HTML, `ac:name="toc"`, engine)
+			assert.Contains(t, result.HTML, `<h2 id="usage">Usage</h2>`, engine)
+
+			// lines of the rest of the document are kept
+			if assert.Len(t, result.Diagnostics, 1, engine) {
+				assert.Equal(t, 9, result.Diagnostics[0].Line, engine)
+			}
+		}
+
+		result, err = Compile(markdown, lib, CompileOptions{Engine: engine})
+		if assert.NoError(t, err, engine) {
+			assert.Contains(t, result.HTML, "Table of Contents", engine)
+		}
+	}
+
+	_, err = ParseInlineTOC("remove")
+	assert.EqualError(
+		t,
+		err,
+		`unexpected table of contents policy "remove", expected one of: keep, strip, macro`,
+	)
+}
@@ -0,0 +1,189 @@
+package mark
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// storageNamespaces lists namespaces of Confluence storage format, which
+// are declared implicitly by Confluence.
+var storageNamespaces = map[string]string{
+	"ac": "http://atlassian.com/content",
+	"ri": "http://atlassian.com/resource/identifier",
+	"at": "http://atlassian.com/template",
+}
+
+// storagePrefixes maps storageNamespaces back to prefixes, since decoder
+// keeps undeclared prefixes as namespaces.
+var storagePrefixes = func() map[string]string {
+	prefixes := map[string]string{}
+	for prefix, url := range storageNamespaces {
+		prefixes[url] = prefix
+	}
+
+	return prefixes
+}()
+
+// storageRoot declares storageNamespaces for the document being validated.
+const storageRoot = `<storage` +
+	` xmlns:ac="http://atlassian.com/content"` +
+	` xmlns:ri="http://atlassian.com/resource/identifier"` +
+	` xmlns:at="http://atlassian.com/template">`
+
+// ValidationIssue describes the problem found in storage format.
+type ValidationIssue struct {
+	// Line is 1-based line number of the storage format.
+	Line int
+
+	// Element is the name of the offending element, e.g. ac:link, or the
+	// element which contains offending text. It's empty if the problem is
+	// in the top level text.
+	Element string
+
+	Message string
+}
+
+func (issue ValidationIssue) String() string {
+	if issue.Element == "" {
+		return fmt.Sprintf("line %d: %s", issue.Line, issue.Message)
+	}
+
+	return fmt.Sprintf(
+		"line %d: <%s>: %s",
+		issue.Line,
+		issue.Element,
+		issue.Message,
+	)
+}
+
+// StorageError is returned by CompileMarkdownWithOptions when generated
+// storage format is not valid and CompileOptions.Validate is set.
+type StorageError struct {
+	Issues []ValidationIssue
+}
+
+func (err *StorageError) Error() string {
+	issues := make([]string, len(err.Issues))
+	for i, issue := range err.Issues {
+		issues[i] = issue.String()
+	}
+
+	return "invalid storage format: " + strings.Join(issues, "; ")
+}
+
+// ValidateStorage checks that html is well-formed XML which uses only ac:,
+// ri: and at: namespace prefixes and HTML entities, so Confluence accepts
+// it. Storage format can't be parsed past the first syntax error, so at
+// most one such issue is returned, after issues found before it.
+func ValidateStorage(html string) []ValidationIssue {
+	var (
+		issues = []ValidationIssue{}
+		stack  = []string{}
+	)
+
+	decoder := newStorageDecoder(html)
+
+	line := func() int {
+		line, _ := decoder.InputPos()
+		return line
+	}
+
+	element := func() string {
+		// the root element is not a part of the storage format
+		if len(stack) < 2 {
+			return ""
+		}
+
+		return stack[len(stack)-1]
+	}
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return issues
+		}
+
+		if err != nil {
+			issue := ValidationIssue{
+				Line:    line(),
+				Element: element(),
+				Message: err.Error(),
+			}
+
+			var syntax *xml.SyntaxError
+			if errors.As(err, &syntax) {
+				issue.Line = syntax.Line
+				issue.Message = syntax.Msg
+			}
+
+			return append(issues, issue)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, getStorageName(token.Name))
+
+			if _, ok := storagePrefixes[token.Name.Space]; !ok && token.Name.Space != "" {
+				issues = append(issues, ValidationIssue{
+					Line:    line(),
+					Element: element(),
+					Message: fmt.Sprintf(
+						"undeclared namespace prefix %q",
+						token.Name.Space,
+					),
+				})
+			}
+
+			for _, attr := range token.Attr {
+				_, ok := storagePrefixes[attr.Name.Space]
+				if ok || attr.Name.Space == "" || attr.Name.Space == "xmlns" {
+					continue
+				}
+
+				issues = append(issues, ValidationIssue{
+					Line:    line(),
+					Element: element(),
+					Message: fmt.Sprintf(
+						"undeclared namespace prefix %q of attribute %q",
+						attr.Name.Space,
+						attr.Name.Local,
+					),
+				})
+			}
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// newStorageDecoder returns decoder of the storage format, which declares
+// storageNamespaces and HTML entities.
+func newStorageDecoder(html string) *xml.Decoder {
+	// namespaces are declared on the same line, so line numbers of the
+	// wrapped document are the same
+	decoder := xml.NewDecoder(
+		strings.NewReader(storageRoot + html + "</storage>"),
+	)
+	decoder.Strict = true
+	decoder.Entity = xml.HTMLEntity
+
+	return decoder
+}
+
+// getStorageName returns prefixed name of the element or attribute, like
+// ac:link.
+func getStorageName(name xml.Name) string {
+	if prefix, ok := storagePrefixes[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+
+	return name.Local
+}
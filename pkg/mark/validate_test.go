@@ -0,0 +1,132 @@
+package mark
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStorage(t *testing.T) {
+	testcases := []struct {
+		name   string
+		html   string
+		issues []ValidationIssue
+	}{
+		{
+			name: "valid",
+			html: text(
+				`<p>&ldquo;a&rdquo;&nbsp;<ac:link><ri:page ri:content-title="x"/></ac:link></p>`,
+				`<ac:structured-macro ac:name="code"><ac:plain-text-body><![CDATA[a]]></ac:plain-text-body></ac:structured-macro>`,
+			),
+			issues: []ValidationIssue{},
+		},
+		{
+			name: "unbalanced tags",
+			html: text(
+				`<p>text</p>`,
+				`<ac:rich-text-body><p>body</ac:rich-text-body>`,
+			),
+			issues: []ValidationIssue{{
+				Line:    2,
+				Element: "p",
+				Message: "element <p> closed by </rich-text-body>",
+			}},
+		},
+		{
+			name: "stray cdata terminator",
+			html: `<p>a ]]> b</p>`,
+			issues: []ValidationIssue{{
+				Line:    1,
+				Element: "p",
+				Message: "unescaped ]]> not in CDATA section",
+			}},
+		},
+		{
+			name: "undeclared prefix",
+			html: text(
+				`<p><xx:macro xx:name="a"/></p>`,
+				`<p ab:id="x">a</p>`,
+				`<br>`,
+			),
+			issues: []ValidationIssue{
+				{
+					Line:    1,
+					Element: "xx:macro",
+					Message: `undeclared namespace prefix "xx"`,
+				},
+				{
+					Line:    1,
+					Element: "xx:macro",
+					Message: `undeclared namespace prefix "xx" of attribute "name"`,
+				},
+				{
+					Line:    2,
+					Element: "p",
+					Message: `undeclared namespace prefix "ab" of attribute "id"`,
+				},
+				{
+					Line:    3,
+					Element: "br",
+					Message: "element <br> closed by </storage>",
+				},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			assert.Equal(t, testcase.issues, ValidateStorage(testcase.html))
+		})
+	}
+}
+
+func TestCompileMarkdownWithOptions_Validate(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases, err := filepath.Glob("testdata/*.md")
+	if err != nil {
+		panic(err)
+	}
+
+	// every fixture must produce valid storage format
+	for _, filename := range testcases {
+		markdown, err := ioutil.ReadFile(filename)
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = CompileMarkdownWithOptions(
+			markdown,
+			lib,
+			CompileOptions{Validate: true},
+		)
+		assert.NoError(t, err, filename)
+	}
+
+	var html strings.Builder
+
+	err = CompileMarkdownTo(
+		&html,
+		[]byte(text("text", "", "<ac:rich-text-body>", "", "more")),
+		lib,
+		CompileOptions{Validate: true},
+	)
+
+	var storage *StorageError
+	assert.True(t, errors.As(err, &storage))
+	assert.Equal(t, "", html.String())
+	assert.Equal(
+		t,
+		"invalid storage format: line 3: <ac:rich-text-body>: "+
+			"element <rich-text-body> closed by </p>",
+		err.Error(),
+	)
+}
@@ -0,0 +1,202 @@
+package mark
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/kovetskiy/mark/pkg/mark/sourcemap"
+)
+
+// reVar matches variable tokens: ${name}, ${!name}, which is substituted in
+// code as well, and escaped $${name}.
+var reVar = regexp.MustCompile(`\$?\$\{(!?)([A-Za-z_][A-Za-z0-9_.-]*)\}`)
+
+// getVars returns effective variables, metadata headers override Vars
+// option. It's nil if no variables are defined, so nothing is substituted.
+func (opts CompileOptions) getVars() map[string]string {
+	if opts.Meta == nil || len(opts.Meta.Vars) == 0 {
+		return opts.Vars
+	}
+
+	vars := map[string]string{}
+	for name, value := range opts.Vars {
+		vars[name] = value
+	}
+
+	for name, value := range opts.Meta.Vars {
+		vars[name] = value
+	}
+
+	return vars
+}
+
+// getSubstitutedVars returns variables substituted before parsing, which are
+// effective variables and space of the document as ${space}, and whether
+// tokens of undefined variables are kept. The space alone doesn't make
+// undefined variables an error.
+func (opts CompileOptions) getSubstitutedVars() (map[string]string, bool) {
+	vars := opts.getVars()
+
+	space := opts.getSpace()
+	if _, ok := vars["space"]; ok || space == "" {
+		return vars, opts.KeepUndefinedVars
+	}
+
+	keep := opts.KeepUndefinedVars || vars == nil
+
+	substituted := map[string]string{"space": space}
+	for name, value := range vars {
+		substituted[name] = value
+	}
+
+	return substituted, keep
+}
+
+// substituteVars replaces ${name} tokens with values of vars, skipping
+// fenced code blocks and code spans unless the token is forced as ${!name}.
+// Escaped tokens like $${name} are replaced with literal ${name}. Tokens of
+// undefined variables are kept and returned as issues.
+func substituteVars(
+	markdown []byte,
+	sources *sourcemap.Map,
+	vars map[string]string,
+) ([]byte, *sourcemap.Map, []sourceIssue) {
+	if !bytes.Contains(markdown, []byte("${")) {
+		return markdown, sources, nil
+	}
+
+	type replace struct {
+		start int
+		end   int
+		value []byte
+	}
+
+	var (
+		result    = make([]byte, 0, len(markdown))
+		replaces  = []replace{}
+		undefined = []sourceIssue{}
+		copied    = 0
+	)
+
+	scanMarkdown(markdown, func(start, end, line int, code bool) {
+		for _, match := range reVar.FindAllSubmatchIndex(markdown[start:end], -1) {
+			var (
+				from   = start + match[0]
+				to     = start + match[1]
+				forced = match[3] > match[2]
+				name   = string(markdown[start+match[4] : start+match[5]])
+			)
+
+			if code && !forced {
+				continue
+			}
+
+			result = append(result, markdown[copied:from]...)
+			copied = to
+
+			if markdown[from+1] == '$' {
+				result = append(result, markdown[from+1:to]...)
+				continue
+			}
+
+			value, ok := vars[name]
+			if !ok {
+				undefined = append(undefined, sourceIssue{
+					line:    line,
+					message: fmt.Sprintf("undefined variable %q", name),
+				})
+				result = append(result, markdown[from:to]...)
+
+				continue
+			}
+
+			result = append(result, value...)
+
+			// line breaks of the value shift following lines
+			if bytes.Contains([]byte(value), []byte("\n")) {
+				replaces = append(replaces, replace{from, to, []byte(value)})
+			}
+		}
+	})
+
+	result = append(result, markdown[copied:]...)
+
+	if len(replaces) > 0 {
+		sources = sources.Clone()
+		if sources == nil {
+			sources = sourcemap.New(markdown)
+		}
+
+		for index := len(replaces) - 1; index >= 0; index-- {
+			sources.Replace(
+				markdown,
+				replaces[index].start,
+				replaces[index].end,
+				replaces[index].value,
+			)
+		}
+	}
+
+	return result, sources, undefined
+}
+
+// scanMarkdown calls scan for every part of markdown lines with 1-based line
+// number, code is true for fenced code blocks, including fences, and code
+// spans.
+func scanMarkdown(markdown []byte, scan func(start, end, line int, code bool)) {
+	var (
+		fence []byte
+		line  = 0
+	)
+
+	for offset := 0; offset < len(markdown); {
+		line++
+
+		end := bytes.IndexByte(markdown[offset:], '\n')
+		if end < 0 {
+			end = len(markdown)
+		} else {
+			end += offset
+		}
+
+		content := markdown[offset:end]
+
+		switch {
+		case fence != nil:
+			if bytes.HasPrefix(bytes.TrimSpace(content), fence) {
+				fence = nil
+			}
+
+			scan(offset, end, line, true)
+
+		case getCodeFence(content) != nil:
+			fence = getCodeFence(content)
+
+			scan(offset, end, line, true)
+
+		default:
+			for i := 0; i < len(content); {
+				if content[i] == '`' {
+					size := skipCodeSpan(content[i:])
+					scan(offset+i, offset+i+size, line, true)
+
+					i += size
+
+					continue
+				}
+
+				size := bytes.IndexByte(content[i:], '`')
+				if size < 0 {
+					size = len(content) - i
+				}
+
+				scan(offset+i, offset+i+size, line, false)
+
+				i += size
+			}
+		}
+
+		offset = end + 1
+	}
+}
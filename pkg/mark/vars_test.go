@@ -0,0 +1,134 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileMarkdownWithOptions_Vars(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"Cluster ${cluster} at ${url}, literal $${cluster}.",
+		"",
+		"Code `${cluster}` and `${!cluster}`.",
+		"",
+		"```bash",
+		"echo ${cluster} ${!cluster} $${!cluster}",
+		"```",
+		"",
+	))
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		html, err := CompileMarkdownWithOptions(markdown, lib, CompileOptions{
+			Engine: engine,
+			Vars: map[string]string{
+				"cluster": "staging",
+				"url":     "https://staging.example.com",
+			},
+			Meta: &Meta{
+				Vars: map[string]string{"cluster": "production"},
+			},
+		})
+		assert.NoError(t, err, engine)
+		assert.Contains(
+			t,
+			html,
+			`<p>Cluster production at <a href="https://staging.example.com">https://staging.example.com</a>, literal ${cluster}.</p>`,
+			engine,
+		)
+		assert.Contains(
+			t,
+			html,
+			`<p>Code <code>${cluster}</code> and <code>production</code>.</p>`,
+			engine,
+		)
+		assert.Contains(
+			t,
+			html,
+			`<![CDATA[echo ${cluster} production ${!cluster}]]>`,
+			engine,
+		)
+	}
+}
+
+func TestCompileMarkdownWithOptions_UndefinedVars(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"# Title",
+		"",
+		"Cluster ${cluster}",
+		"",
+		"Region ${region}, `${zone}`",
+		"",
+	))
+
+	result, err := Compile(markdown, lib, CompileOptions{
+		File: "page.md",
+		Vars: map[string]string{"cluster": "staging"},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `undefined variable "region"`)
+	assert.Contains(t, err.Error(), "source: page.md:5")
+	assert.Equal(
+		t,
+		[]Diagnostic{{
+			File:     "page.md",
+			Line:     5,
+			Severity: SeverityError,
+			Message:  `undefined variable "region"`,
+		}},
+		result.Diagnostics,
+	)
+
+	html, err := CompileMarkdownWithOptions(markdown, lib, CompileOptions{
+		Vars:              map[string]string{"cluster": "staging"},
+		KeepUndefinedVars: true,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, html, "<p>Region ${region}, <code>${zone}</code></p>")
+
+	// nothing is substituted if no variables are defined
+	html, err = CompileMarkdownWithOptions(markdown, lib, CompileOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, html, "<p>Cluster ${cluster}</p>")
+}
+
+func TestCompileMarkdownWithOptions_MultilineVars(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := &testLogger{}
+
+	_, err = CompileMarkdownWithOptions(
+		[]byte(text(
+			"${footer}",
+			"",
+			"a <!--comment_id='a b'-->commented<!----> text",
+		)),
+		lib,
+		CompileOptions{
+			Logger: logger,
+			Vars:   map[string]string{"footer": "first\n\nsecond"},
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			`warning: line 3: invalid inline comment id "a b", dropping comment marker`,
+		},
+		logger.messages,
+	)
+}
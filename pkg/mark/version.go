@@ -0,0 +1,67 @@
+package mark
+
+import (
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// MaxVersionMessageLength is the maximum length of version messages in
+// characters, longer messages are truncated.
+const MaxVersionMessageLength = 255
+
+// getVersionMessage fills placeholders of the version message, the message
+// is kept as is if they can't be filled.
+func (state *compilation) getVersionMessage(
+	meta *Meta,
+	data map[string]string,
+) string {
+	if meta == nil || meta.VersionMessage == "" {
+		return ""
+	}
+
+	message := meta.VersionMessage
+
+	if strings.Contains(message, "{{") {
+		filled, err := fillVersionMessage(message, data)
+		if err != nil {
+			state.warn(0, "unable to fill version message: %s", err)
+		} else {
+			message = filled
+		}
+	}
+
+	if utf8.RuneCountInString(message) > MaxVersionMessageLength {
+		message = string([]rune(message)[:MaxVersionMessageLength])
+
+		state.warn(
+			0,
+			"version message is truncated to %d characters",
+			MaxVersionMessageLength,
+		)
+	}
+
+	return message
+}
+
+func fillVersionMessage(message string, data map[string]string) (string, error) {
+	tmpl, err := template.New(HeaderVersionMessage).
+		Option("missingkey=error").
+		Parse(message)
+	if err != nil {
+		return "", err
+	}
+
+	if data == nil {
+		data = map[string]string{}
+	}
+
+	var filled strings.Builder
+
+	err = tmpl.Execute(&filled, data)
+	if err != nil {
+		return "", err
+	}
+
+	return filled.String(), nil
+}
@@ -0,0 +1,94 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile_VersionMessage(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compile := func(message string, data map[string]string) *CompileResult {
+		result, err := Compile([]byte("body\n"), lib, CompileOptions{
+			Meta:        &Meta{VersionMessage: message},
+			VersionData: data,
+			Logger:      &testLogger{},
+		})
+		assert.NoError(t, err)
+
+		return result
+	}
+
+	result := compile(
+		"Updated for {{ .Release }} at {{ .GitCommit }}",
+		map[string]string{"Release": "2.4", "GitCommit": "1a2b3c"},
+	)
+	assert.Equal(t, "Updated for 2.4 at 1a2b3c", result.VersionMessage)
+	assert.Empty(t, result.Diagnostics)
+
+	// the message is kept as is if placeholders can't be filled
+	result = compile("Updated at {{ .GitCommit }}", nil)
+	assert.Equal(t, "Updated at {{ .GitCommit }}", result.VersionMessage)
+	if assert.Len(t, result.Diagnostics, 1) {
+		assert.Equal(t, SeverityWarning, result.Diagnostics[0].Severity)
+		assert.Contains(
+			t,
+			result.Diagnostics[0].Message,
+			"unable to fill version message",
+		)
+	}
+
+	result = compile(strings.Repeat("ä", MaxVersionMessageLength+1), nil)
+	assert.Equal(
+		t,
+		strings.Repeat("ä", MaxVersionMessageLength),
+		result.VersionMessage,
+	)
+	if assert.Len(t, result.Diagnostics, 1) {
+		assert.Equal(
+			t,
+			"version message is truncated to 255 characters",
+			result.Diagnostics[0].Message,
+		)
+	}
+
+	assert.Equal(t, "", compile("", nil).VersionMessage)
+}
+
+func TestCompile_MinorEdit(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		yes = true
+		no  = false
+	)
+
+	for _, testcase := range []struct {
+		meta     *Meta
+		option   *bool
+		expected bool
+	}{
+		{nil, nil, false},
+		{&Meta{}, nil, false},
+		{&Meta{MinorEdit: true}, nil, true},
+		{&Meta{MinorEdit: true}, &no, false},
+		{&Meta{}, &yes, true},
+		{nil, &yes, true},
+	} {
+		result, err := Compile([]byte("body\n"), lib, CompileOptions{
+			Meta:      testcase.meta,
+			MinorEdit: testcase.option,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, testcase.expected, result.MinorEdit, testcase)
+	}
+}
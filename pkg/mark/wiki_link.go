@@ -0,0 +1,120 @@
+package mark
+
+import (
+	"regexp"
+	"strings"
+)
+
+// reWikiLink matches wiki links to pages by their titles:
+//
+//	[[Deployment Guide]]
+//	[[Deployment Guide|how we deploy]]
+//	[[OPS:Deployment Guide]]
+var reWikiLink = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]*))?\]\]`)
+
+// reWikiLinkText matches characters of titles which are escaped when titles
+// are written as link text, so they're not parsed as markdown.
+var reWikiLinkText = regexp.MustCompile("[\\\\`*_\\[\\]<]")
+
+// reWikiLinkSpace matches the space key prefix of the wiki link title.
+var reWikiLinkSpace = regexp.MustCompile(`^(~?[A-Za-z0-9]+):(.+)$`)
+
+// replaceWikiLinks replaces wiki links with markdown links of PageLinkScheme,
+// so they're compiled into ac:link elements the same way as resolved links
+// to markdown files are. Links in code blocks and code spans, links with
+// escaped brackets, like \[\[Title]], and links which are parts of other
+// markdown, e.g. [[text]](url), are kept. Links are replaced within their
+// lines, so lines are not changed.
+func replaceWikiLinks(markdown []byte) []byte {
+	if !reWikiLink.Match(markdown) {
+		return markdown
+	}
+
+	var (
+		result []byte
+		copied = 0
+	)
+
+	scanMarkdown(markdown, func(start, end, line int, code bool) {
+		if code {
+			return
+		}
+
+		for _, match := range reWikiLink.FindAllSubmatchIndex(markdown[start:end], -1) {
+			var (
+				from = start + match[0]
+				to   = start + match[1]
+			)
+
+			if isBracketEscaped(markdown, from) ||
+				(to < len(markdown) && (markdown[to] == '(' || markdown[to] == '[')) {
+				continue
+			}
+
+			var text string
+			if match[4] >= 0 {
+				text = string(markdown[start+match[4] : start+match[5]])
+			}
+
+			link, ok := getWikiLink(string(markdown[start+match[2]:start+match[3]]), text)
+			if !ok {
+				continue
+			}
+
+			if result == nil {
+				result = make([]byte, 0, len(markdown))
+			}
+
+			result = append(result, markdown[copied:from]...)
+			result = append(result, link...)
+
+			copied = to
+		}
+	})
+
+	if result == nil {
+		return markdown
+	}
+
+	return append(result, markdown[copied:]...)
+}
+
+// isBracketEscaped returns true if the bracket at given offset is escaped
+// or opens the image, like ![[Title]].
+func isBracketEscaped(markdown []byte, offset int) bool {
+	if offset > 0 && markdown[offset-1] == '!' {
+		return true
+	}
+
+	backslashes := 0
+	for index := offset - 1; index >= 0 && markdown[index] == '\\'; index-- {
+		backslashes++
+	}
+
+	return backslashes%2 == 1
+}
+
+// getWikiLink returns the markdown link to the page, title is prefixed with
+// the space key of the page if it's in another space. The title is the text
+// of the link if text is empty, e.g. [[Title|]].
+func getWikiLink(title string, text string) (string, bool) {
+	var link pageLink
+
+	link.Title = strings.TrimSpace(title)
+
+	if matches := reWikiLinkSpace.FindStringSubmatch(link.Title); matches != nil &&
+		ValidateSpaceKey(matches[1]) == nil {
+		link.Space, link.Title = matches[1], strings.TrimSpace(matches[2])
+	}
+
+	if link.Title == "" {
+		return "", false
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		text = reWikiLinkText.ReplaceAllString(link.Title, `\$0`)
+	}
+
+	return "[" + text + "](" + getLinkDestination(link.String()) + ")", true
+}
@@ -0,0 +1,107 @@
+package mark
+
+import (
+	"testing"
+
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceWikiLinks(t *testing.T) {
+	test := func(markdown string, expected string) {
+		t.Helper()
+
+		assert.Equal(t, expected, string(replaceWikiLinks([]byte(markdown))), markdown)
+	}
+
+	test("See [[Deployment Guide]].", "See [Deployment Guide](<confluence:///Deployment Guide>).")
+	test("[[Guide|how *we* deploy]]", "[how *we* deploy](confluence:///Guide)")
+	test("[[OPS:Run Book]]", "[Run Book](<confluence://OPS/Run Book>)")
+	test("[[~jdoe:Notes]]", "[Notes](confluence://~jdoe/Notes)")
+	test("[[Re: Notes]]", "[Re: Notes](<confluence:///Re: Notes>)")
+	test("[[C (v2) #1]]", `[C (v2) #1](<confluence:///C \(v2\) %231>)`)
+	test("[[a_b <c>]]", `[a\_b \<c>](<confluence:///a_b %3Cc%3E>)`)
+
+	test("`[[Guide]]` and [[Guide]]", "`[[Guide]]` and [Guide](confluence:///Guide)")
+	test("```\n[[Guide]]\n```\n", "```\n[[Guide]]\n```\n")
+	test(`\[\[Guide]] and \[[Guide]]`, `\[\[Guide]] and \[[Guide]]`)
+	test(`\\[[Guide]]`, `\\[Guide](confluence:///Guide)`)
+	test("[[Guide]](https://example.com) [[Guide]][ref] ![[Guide]]", "[[Guide]](https://example.com) [[Guide]][ref] ![[Guide]]")
+	test("[[Guide|]]", "[Guide](confluence:///Guide)")
+	test("[[ ]] [[|text]]", "[[ ]] [[|text]]")
+}
+
+func TestCompile_WikiLinks(t *testing.T) {
+	lib, err := stdlib.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := []byte(text(
+		"# Links",
+		"",
+		"See [[Deployment Guide]], [[OPS:Run Book|the run book]] and",
+		"[[Missing & <Page>]], but not `[[Code]]`.",
+		"",
+	))
+
+	exists := func(space string, title string) bool {
+		return title != "Missing & <Page>"
+	}
+
+	for _, engine := range []MarkdownEngine{EngineBlackfriday, EngineGoldmark} {
+		opts := CompileOptions{
+			Engine:     engine,
+			Meta:       &Meta{Space: "DOC"},
+			WikiLinks:  true,
+			PageExists: exists,
+		}
+
+		result, err := Compile(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.Contains(
+				t,
+				result.HTML,
+				`<ac:link><ri:page ri:content-title="Deployment Guide"/>`+
+					`<ac:link-body>Deployment Guide</ac:link-body></ac:link>`,
+				engine,
+			)
+			assert.Contains(
+				t,
+				result.HTML,
+				`<ac:link><ri:page ri:space-key="OPS" ri:content-title="Run Book"/>`+
+					`<ac:link-body>the run book</ac:link-body></ac:link>`,
+				engine,
+			)
+			assert.Contains(
+				t,
+				result.HTML,
+				`<ri:page ri:content-title="Missing &amp; &lt;Page&gt;"/>`,
+				engine,
+			)
+			assert.Contains(t, result.HTML, `<code>[[Code]]</code>`, engine)
+			assert.Empty(t, ValidateStorage(result.HTML), engine)
+
+			assert.Equal(t, []Diagnostic{{
+				Line:     4,
+				Severity: SeverityWarning,
+				Message:  `page "Missing & <Page>" is not found in space "DOC"`,
+			}}, result.Diagnostics, engine)
+		}
+
+		opts.Strict = true
+
+		_, err = Compile(markdown, lib, opts)
+		assert.Error(t, err, engine)
+
+		// <Page> is the raw HTML tag which Confluence strips without wiki
+		// links
+		opts.Strict = false
+		opts.WikiLinks = false
+
+		html, err := CompileMarkdownWithOptions(markdown, lib, opts)
+		if assert.NoError(t, err, engine) {
+			assert.Contains(t, html, `[[Deployment Guide]]`, engine)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package mark
+
+import (
+	"context"
+	"sync"
+
+	"github.com/reconquest/karma-go"
+)
+
+// runWorkers calls run for every index from 0 to count concurrently using
+// given number of workers, at least one. Indexes are not fed to workers
+// once the context is done, skip is called for every index which is not run
+// then with the error of the context, which is returned.
+func runWorkers(
+	ctx context.Context,
+	count int,
+	workers int,
+	run func(index int),
+	skip func(index int, err error),
+) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		done  = make([]bool, count)
+		queue = make(chan int)
+		group sync.WaitGroup
+	)
+
+	for i := 0; i < workers; i++ {
+		group.Add(1)
+
+		go func() {
+			defer group.Done()
+
+			for index := range queue {
+				run(index)
+				done[index] = true
+			}
+		}()
+	}
+
+feed:
+	for index := 0; index < count; index++ {
+		select {
+		case queue <- index:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(queue)
+	group.Wait()
+
+	err := ctx.Err()
+	if err != nil {
+		for index := range done {
+			if !done[index] {
+				skip(index, err)
+			}
+		}
+	}
+
+	return err
+}
+
+// pushWorkerErrors returns errors of items run by runWorkers together, get
+// returns the name and the error of the item, kind describes items in
+// messages, e.g. "document".
+func pushWorkerErrors(
+	message string,
+	kind string,
+	count int,
+	get func(index int) (string, error),
+) error {
+	reasons := []karma.Reason{}
+	for index := 0; index < count; index++ {
+		if name, err := get(index); err != nil {
+			reasons = append(reasons, karma.Format(err, "%s: %s", kind, name))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return karma.Push(message, reasons...)
+	}
+
+	return nil
+}